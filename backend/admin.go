@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+)
+
+// newAdminRouter builds the handler served on the admin listener
+func newAdminRouter(c Config) *router {
+	rt := newRouter()
+	rt.handleFunc(http.MethodPost, "/admin/replay", replayHandler)
+	if c.AdminDeleteEnabled {
+		rt.handleFunc(http.MethodPost, "/admin/delete", deleteHandler)
+	}
+	if c.AdminMetricsReset {
+		rt.handleFunc(http.MethodPost, "/admin/metrics/reset", metricsResetHandler)
+	}
+	if c.EnablePprof {
+		rt.handleFunc(http.MethodGet, "/debug/pprof/", pprof.Index)
+		rt.handleFunc(http.MethodGet, "/debug/pprof/cmdline", pprof.Cmdline)
+		rt.handleFunc(http.MethodGet, "/debug/pprof/profile", pprof.Profile)
+		rt.handleFunc(http.MethodPost, "/debug/pprof/symbol", pprof.Symbol)
+		rt.handleFunc(http.MethodGet, "/debug/pprof/symbol", pprof.Symbol)
+		rt.handleFunc(http.MethodGet, "/debug/pprof/trace", pprof.Trace)
+	}
+	return rt
+}
+
+// adminNets holds the parsed ADMIN_ALLOWED_CIDRS; empty leaves the
+// endpoints open
+var adminNets []netip.Prefix
+
+// adminAccessMiddleware answers 403 to requests from outside
+// ADMIN_ALLOWED_CIDRS, restricting the admin listener and the monitoring
+// endpoints of the public one to the monitoring network
+func adminAccessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(adminNets) > 0 {
+			if addr, ok := clientAddr(r); !ok || !inPrefixes(addr, adminNets) {
+				writeJSONError(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAdminRouterPprof(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		enabled bool
+		want    int
+	}{
+		{"enabled", true, http.StatusOK},
+		{"disabled", false, http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := defaultConfig()
+			c.EnablePprof = tc.enabled
+			srv := httptest.NewServer(newAdminRouter(c))
+			defer srv.Close()
+
+			res, err := http.Get(srv.URL + "/debug/pprof/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tc.want {
+				t.Errorf("GET /debug/pprof/ = %d, want %d", res.StatusCode, tc.want)
+			}
+		})
+	}
+}
+
+func TestPprofDisabledByDefault(t *testing.T) {
+	if defaultConfig().EnablePprof {
+		t.Error("pprof is enabled by default")
+	}
+	rec := httptest.NewRecorder()
+	newPublicRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("public GET /debug/pprof/ = %d, want 404", rec.Code)
+	}
+}
+
+// postMetricsReset calls /admin/metrics/reset on an admin router built from cfg
+func postMetricsReset(t *testing.T) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	newAdminRouter(cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/metrics/reset", nil))
+	return rec.Code
+}
+
+func TestMetricsReset(t *testing.T) {
+	withOpenSearch(t, func(c *Config) {
+		c.AdminMetricsReset = true
+		c.FieldDenylist = []string{"debug"}
+	})
+	postJSON("/logs", `{"message":"hi","debug":"dump"}`)
+	postJSON("/logs", `not json`)
+	if testutil.ToFloat64(requestCount.WithLabelValues("/logs")) == 0 || testutil.ToFloat64(fieldsStripped) == 0 ||
+		testutil.ToFloat64(logsDropped.WithLabelValues(dropInvalid)) == 0 || ingestedTotal.Load() == 0 {
+		t.Fatal("metrics did not count the requests")
+	}
+
+	if code := postMetricsReset(t); code != http.StatusOK {
+		t.Fatalf("reset status %d", code)
+	}
+	for name, v := range map[string]float64{
+		"http_requests_total":       testutil.ToFloat64(requestCount.WithLabelValues("/logs")),
+		"fields_stripped_total":     testutil.ToFloat64(fieldsStripped),
+		"logs_dropped_total":        testutil.ToFloat64(logsDropped.WithLabelValues(dropInvalid)),
+		"/stats ingested":           float64(ingestedTotal.Load()),
+		"http_request_body_samples": float64(sampleCount(t, requestBodySize.WithLabelValues("/logs"))),
+	} {
+		if v != 0 {
+			t.Errorf("%s = %v after reset, want 0", name, v)
+		}
+	}
+	// Drop reasons stay exported at zero
+	if n := testutil.CollectAndCount(logsDropped); n != len(dropReasons) {
+		t.Errorf("%d logs_dropped_total series after reset, want %d", n, len(dropReasons))
+	}
+
+	postJSON("/logs", `{"message":"hi","debug":"dump"}`)
+	if got := testutil.ToFloat64(fieldsStripped); got != 1 {
+		t.Errorf("fields_stripped_total = %v after reset and one log, want 1", got)
+	}
+	if got := testutil.ToFloat64(requestCount.WithLabelValues("/logs")); got != 1 {
+		t.Errorf("http_requests_total = %v after reset and one request, want 1", got)
+	}
+}
+
+func TestMetricsResetConcurrent(t *testing.T) {
+	withConfig(t, func(c *Config) { c.AdminMetricsReset = true })
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				fieldsStripped.Inc()
+				bulkSplits.Inc()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				resetMetrics()
+			}
+		}()
+	}
+	wg.Wait()
+	resetMetrics()
+	fieldsStripped.Inc()
+	if got := testutil.ToFloat64(fieldsStripped); got != 1 {
+		t.Errorf("fields_stripped_total = %v, want 1", got)
+	}
+}
+
+func TestMetricsResetDisabledByDefault(t *testing.T) {
+	withConfig(t, nil)
+	if code := postMetricsReset(t); code != http.StatusNotFound {
+		t.Errorf("reset status %d, want 404", code)
+	}
+}
+
+func TestAdminAllowedCIDRs(t *testing.T) {
+	withOpenSearch(t, func(c *Config) {
+		c.AdminAllowedCIDRs = []string{"10.1.0.0/16", "192.168.5.7"}
+		c.TrustedProxies = []string{"172.16.0.0/12"}
+	})
+	for _, tc := range []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      int
+	}{
+		{"inside the range", "10.1.2.3:5000", "", http.StatusOK},
+		{"outside the range", "10.2.0.1:5000", "", http.StatusForbidden},
+		{"single address", "192.168.5.7:5000", "", http.StatusOK},
+		{"IPv4-mapped", "[::ffff:10.1.2.3]:5000", "", http.StatusOK},
+		{"behind a trusted proxy", "172.16.0.5:5000", "10.1.2.3", http.StatusOK},
+		{"outside behind a trusted proxy", "172.16.0.5:5000", "10.1.2.3, 203.0.113.9", http.StatusForbidden},
+		{"forwarded by an untrusted peer", "203.0.113.9:5000", "10.1.2.3", http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, path := range []string{"/metrics", "/health"} {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				req.RemoteAddr = tc.remote
+				if tc.forwarded != "" {
+					req.Header.Set("X-Forwarded-For", tc.forwarded)
+				}
+				if rec := do(req); rec.Code != tc.want {
+					t.Errorf("GET %s: status %d, want %d", path, rec.Code, tc.want)
+				}
+			}
+		})
+	}
+
+	// Ingestion stays open to everyone
+	if rec := postFrom("203.0.113.9"); rec.Code != http.StatusCreated {
+		t.Errorf("POST /logs from outside the admin ranges: status %d, want 201", rec.Code)
+	}
+	// The admin listener is wrapped the same way
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "203.0.113.9:5000"
+	rec := httptest.NewRecorder()
+	adminAccessMiddleware(newAdminRouter(cfg)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("admin listener from outside the ranges: status %d, want 403", rec.Code)
+	}
+}
+
+func TestAdminAllowedCIDRsUnsetAllowsAll(t *testing.T) {
+	withOpenSearch(t, nil)
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "203.0.113.9:5000"
+	if rec := do(req); rec.Code != http.StatusOK {
+		t.Errorf("status %d, want 200", rec.Code)
+	}
+}
+
+func TestAdminAllowedCIDRsValidated(t *testing.T) {
+	for _, mutate := range []func(*Config){
+		func(c *Config) { c.AdminAllowedCIDRs = []string{"10.0.0.0/33"} },
+		func(c *Config) { c.TrustedProxies = []string{"proxy.internal"} },
+	} {
+		c := defaultConfig()
+		mutate(&c)
+		if err := c.validate(); err == nil {
+			t.Errorf("%v / %v passed validation", c.AdminAllowedCIDRs, c.TrustedProxies)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errQueueFull = errors.New("ingest queue is full")
+
+var asyncQueueWait = newResettableHistogram(
+	prometheus.HistogramOpts{
+		Name:    "async_queue_wait_seconds",
+		Help:    "Time logs spent in the async queue before being flushed",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	},
+)
+
+// queuedDoc is a buffered log with the time it entered the queue and the
+// span of the request that ingested it
+type queuedDoc struct {
+	source   map[string]interface{}
+	enqueued time.Time
+	origin   trace.SpanContext
+}
+
+// ingestQueue buffers accepted logs in async mode and bulk-indexes them in
+// the background. With a WAL configured every log is on disk before it is
+// acknowledged.
+type ingestQueue struct {
+	mu       sync.Mutex
+	docs     []queuedDoc
+	capacity int
+	wal      *writeAheadLog
+	wake     chan struct{}
+
+	// flushMu serialises flushes, which also own WAL rotation
+	flushMu sync.Mutex
+}
+
+// asyncQueue is nil unless INGEST_ASYNC is enabled
+var asyncQueue *ingestQueue
+
+func newIngestQueue(capacity int, wal *writeAheadLog, pending []map[string]interface{}) *ingestQueue {
+	// Logs recovered from the WAL are timed from startup
+	now := time.Now()
+	docs := make([]queuedDoc, len(pending))
+	for i, d := range pending {
+		docs[i] = queuedDoc{source: d, enqueued: now}
+	}
+	return &ingestQueue{
+		docs:     docs,
+		capacity: capacity,
+		wal:      wal,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// enqueue accepts logs for indexing, all or none. The span in ctx is kept so
+// the flush can link back to it.
+func (q *ingestQueue) enqueue(ctx context.Context, docs ...map[string]interface{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.docs)+len(docs) > q.capacity {
+		return errQueueFull
+	}
+	if q.wal != nil {
+		if err := q.wal.append(docs...); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	origin := trace.SpanContextFromContext(ctx)
+	for _, d := range docs {
+		q.docs = append(q.docs, queuedDoc{source: d, enqueued: now, origin: origin})
+	}
+	if len(q.docs) >= cfg.AsyncBatchSize {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// depth returns the number of buffered logs
+func (q *ingestQueue) depth() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.docs)
+}
+
+// flush indexes everything buffered so far. When OpenSearch cannot be
+// reached the logs go back to the front of the queue; logs it rejects go to
+// the dead-letter file. The flush runs in a trace of its own, linked to the
+// spans that ingested its logs so the trace UI connects both sides of the
+// queue.
+func (q *ingestQueue) flush(ctx context.Context) {
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	q.mu.Lock()
+	batch := q.docs
+	q.docs = nil
+	// Without a rotated file the batch stays in the current one, which is
+	// then kept after the flush: replaying it twice beats losing it
+	rotated := false
+	if len(batch) > 0 && q.wal != nil {
+		if err := q.wal.rotate(); err != nil {
+			log.Printf("Failed to rotate WAL: %v", err)
+		} else {
+			rotated = true
+		}
+	}
+	q.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	flushed := time.Now()
+	docs := make([]bulkDoc, len(batch))
+	var links []trace.Link
+	linked := map[trace.SpanID]bool{}
+	for i, d := range batch {
+		docs[i] = bulkDoc{pos: i, source: d.source}
+		if d.origin.IsValid() && !linked[d.origin.SpanID()] {
+			linked[d.origin.SpanID()] = true
+			links = append(links, trace.Link{SpanContext: d.origin})
+		}
+	}
+	ctx, span := otel.Tracer("telyx-backend").Start(ctx, "async.flush",
+		trace.WithNewRoot(),
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("async.batch_size", len(batch))),
+	)
+	defer span.End()
+	res := bulkResult{keepUnsent: true}
+	bulkIndex(ctx, docs, &res)
+	deadLetters.write(res.rejected)
+
+	// Logs going back to the queue are observed once they finally leave it
+	requeued := make(map[int]bool, len(res.unsent))
+	for _, d := range res.unsent {
+		requeued[d.pos] = true
+	}
+	for i, d := range batch {
+		if !requeued[i] {
+			asyncQueueWait.Observe(flushed.Sub(d.enqueued).Seconds())
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(res.unsent) > 0 {
+		log.Printf("Async flush could not send %d logs, keeping them queued: %v", len(res.unsent), res.sendErr)
+		unsent := make([]map[string]interface{}, len(res.unsent))
+		kept := make([]queuedDoc, len(res.unsent))
+		for i, d := range res.unsent {
+			unsent[i] = d.source
+			kept[i] = batch[d.pos]
+		}
+		q.docs = append(kept, q.docs...)
+		// The rotated file stays until the logs are back in the current one;
+		// if they cannot be, the next rotation adds to it
+		if rotated {
+			if err := q.wal.append(unsent...); err != nil {
+				log.Printf("Failed to requeue logs in WAL: %v", err)
+				return
+			}
+		}
+	}
+	if rotated {
+		if err := q.wal.flushed(); err != nil {
+			log.Printf("Failed to truncate WAL: %v", err)
+		}
+	}
+}
+
+// closeWAL closes the WAL after the final flush; logs enqueued later are
+// refused instead of being acknowledged without reaching the disk
+func (q *ingestQueue) closeWAL() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.wal != nil {
+		if err := q.wal.close(); err != nil {
+			log.Printf("Failed to close WAL: %v", err)
+		}
+	}
+}
+
+// run flushes on every ASYNC_FLUSH_INTERVAL or once a batch fills up, and
+// flushes a final time when ctx is cancelled
+func (q *ingestQueue) run(ctx context.Context) {
+	ticker := time.NewTicker(cfg.AsyncFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			q.flush(context.WithoutCancel(ctx))
+			q.closeWAL()
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+		q.flush(ctx)
+	}
+}
+
+// writeEnqueueError answers 503 when the queue is full so clients back off,
+// and 500 when the WAL could not be written
+func writeEnqueueError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errQueueFull) {
+		w.Header().Set("Retry-After", "1")
+		writeJSONError(w, http.StatusServiceUnavailable, "Ingest queue is full")
+		return
+	}
+	log.Printf("Failed to queue logs: %v", err)
+	writeJSONError(w, http.StatusInternalServerError, "Failed to queue logs")
+}
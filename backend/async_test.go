@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// histogramOf returns the state of the single histogram c collects
+func histogramOf(t *testing.T, c prometheus.Collector) *dto.Histogram {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 1)
+	c.Collect(ch)
+	var m dto.Metric
+	if err := (<-ch).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram()
+}
+
+// startAsync enables INGEST_ASYNC with a queue nobody flushes in the
+// background, so the test decides when logs leave it
+func startAsync(t *testing.T) {
+	t.Helper()
+	asyncQueue = newIngestQueue(cfg.AsyncQueueSize, nil, nil)
+	t.Cleanup(func() { asyncQueue = nil })
+}
+
+func TestAsyncQueueWaitObserved(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.IngestAsync = true })
+	startAsync(t)
+	before := histogramOf(t, asyncQueueWait)
+
+	for i := 0; i < 2; i++ {
+		if rec := postJSON("/logs", `{"message":"hi"}`); rec.Code != http.StatusAccepted {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	// The flusher falls behind
+	const delay = 100 * time.Millisecond
+	time.Sleep(delay)
+	asyncQueue.flush(context.Background())
+
+	after := histogramOf(t, asyncQueueWait)
+	if n := after.GetSampleCount() - before.GetSampleCount(); n != 2 {
+		t.Fatalf("%d waits observed, want 2", n)
+	}
+	if waited := after.GetSampleSum() - before.GetSampleSum(); waited < 2*delay.Seconds() {
+		t.Errorf("observed %.3fs of waiting, want at least %.3fs", waited, 2*delay.Seconds())
+	}
+	if got := len(fake.docs(t)); got != 2 {
+		t.Errorf("%d logs indexed, want 2", got)
+	}
+}
+
+func TestAsyncQueueWaitCoversRequeues(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.IngestAsync = true })
+	startAsync(t)
+	postJSON("/logs", `{"message":"hi"}`)
+	before := histogramOf(t, asyncQueueWait).GetSampleCount()
+
+	// Unreachable OpenSearch sends the log back to the queue unobserved
+	down := newFakeOpenSearch(t)
+	down.Close()
+	cfg.OpenSearchURL = down.URL
+	asyncQueue.flush(context.Background())
+	if n := histogramOf(t, asyncQueueWait).GetSampleCount() - before; n != 0 {
+		t.Fatalf("%d waits observed for a requeued log, want 0", n)
+	}
+
+	cfg.OpenSearchURL = fake.URL
+	asyncQueue.flush(context.Background())
+	if n := histogramOf(t, asyncQueueWait).GetSampleCount() - before; n != 1 {
+		t.Errorf("%d waits observed once the log left the queue, want 1", n)
+	}
+}
+
+func TestAsyncFlushLinksIngestSpans(t *testing.T) {
+	withOpenSearch(t, func(c *Config) { c.IngestAsync = true })
+	startAsync(t)
+	rec := recordSpans(t)
+
+	postJSON("/logs", `{"message":"single"}`)
+	postJSON("/logs/bulk", `[{"message":"one"},{"message":"two"}]`)
+	asyncQueue.flush(context.Background())
+
+	flush := endedSpan(t, rec, "async.flush")
+	ingest := []trace.SpanContext{
+		endedSpan(t, rec, "logHandler").SpanContext(),
+		endedSpan(t, rec, "bulkHandler").SpanContext(),
+	}
+	links := flush.Links()
+	// Both logs of the bulk request share one link
+	if len(links) != len(ingest) {
+		t.Fatalf("async.flush has %d links, want %d: %v", len(links), len(ingest), links)
+	}
+	for i, sc := range ingest {
+		if !links[i].SpanContext.Equal(sc) {
+			t.Errorf("link %d points at span %s, want %s", i, links[i].SpanContext.SpanID(), sc.SpanID())
+		}
+		if flush.SpanContext().TraceID() == sc.TraceID() {
+			t.Errorf("async.flush joined the trace of ingest span %s instead of starting its own", sc.SpanID())
+		}
+	}
+}
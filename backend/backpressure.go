@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var inflightBytesGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "http_inflight_request_bytes",
+		Help: "Total size of request bodies currently being processed",
+	},
+)
+
+var bulkActiveGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "bulk_active_operations",
+		Help: "Number of bulk requests currently being processed",
+	},
+)
+
+// bulkSlots bounds concurrent bulk requests to BULK_MAX_CONCURRENCY, nil
+// when unlimited
+var bulkSlots chan struct{}
+
+// inflightBytes is the sum of the request bodies currently held in memory
+var inflightBytes atomic.Int64
+
+var (
+	errInflightExceeded = errors.New("in-flight byte limit exceeded")
+	// errInflightTooLarge is a body that alone exceeds MAX_INFLIGHT_BYTES
+	errInflightTooLarge = errors.New("body exceeds the in-flight byte limit")
+)
+
+// reserveInflight accounts for n more bytes, failing without side effects
+// when that would exceed MAX_INFLIGHT_BYTES
+func reserveInflight(n int64) bool {
+	if cfg.MaxInflightBytes <= 0 {
+		inflightBytes.Add(n)
+		inflightBytesGauge.Add(float64(n))
+		return true
+	}
+	for {
+		cur := inflightBytes.Load()
+		if cur+n > cfg.MaxInflightBytes {
+			return false
+		}
+		if inflightBytes.CompareAndSwap(cur, cur+n) {
+			inflightBytesGauge.Add(float64(n))
+			return true
+		}
+	}
+}
+
+func releaseInflight(n int64) {
+	inflightBytes.Add(-n)
+	inflightBytesGauge.Sub(float64(n))
+}
+
+// countingReader reserves in-flight bytes as the body is read
+type countingReader struct {
+	r    io.Reader
+	read int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		if c.read+int64(n) > cfg.MaxInflightBytes {
+			return n, errInflightTooLarge
+		}
+		if !reserveInflight(int64(n)) {
+			return n, errInflightExceeded
+		}
+		c.read += int64(n)
+	}
+	return n, err
+}
+
+// inflightBytesMiddleware sheds requests with 503 while the bodies already
+// being processed exceed MAX_INFLIGHT_BYTES, protecting against OOM from
+// concurrent large uploads
+func inflightBytesMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.MaxInflightBytes <= 0 {
+			next(w, r)
+			return
+		}
+		if r.ContentLength > cfg.MaxInflightBytes {
+			// Retrying could never succeed, so this is not a busy signal
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		if r.ContentLength >= 0 {
+			if !reserveInflight(r.ContentLength) {
+				shedInflight(w)
+				return
+			}
+			defer releaseInflight(r.ContentLength)
+			next(w, r)
+			return
+		}
+
+		// Without a Content-Length the size is only known once read, so the
+		// body is buffered here while being counted
+		cr := &countingReader{r: r.Body}
+		defer func() { releaseInflight(cr.read) }()
+		body, err := io.ReadAll(cr)
+		r.Body.Close()
+		if errors.Is(err, errInflightExceeded) {
+			shedInflight(w)
+			return
+		}
+		if bodyTooLarge(err) || errors.Is(err, errInflightTooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		if err != nil {
+			http.Error(w, `{"error": "Failed to read request body"}`, http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+func shedInflight(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	writeJSONError(w, http.StatusServiceUnavailable, "Server is busy, retry later")
+}
+
+// bulkConcurrencyMiddleware sheds bulk requests with 503 while
+// BULK_MAX_CONCURRENCY of them are already being processed. Bulk requests
+// cost far more than single writes, so they get their own cap and cannot
+// crowd out POST /logs. It runs before the body is read so a shed request
+// costs nothing.
+func bulkConcurrencyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if bulkSlots != nil {
+			select {
+			case bulkSlots <- struct{}{}:
+				defer func() { <-bulkSlots }()
+			default:
+				shedInflight(w)
+				return
+			}
+		}
+		bulkActiveGauge.Inc()
+		defer bulkActiveGauge.Dec()
+		next(w, r)
+	}
+}
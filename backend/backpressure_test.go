@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// holdingHandler keeps admitted requests in flight until release is closed
+type holdingHandler struct {
+	admitted chan []byte
+	release  chan struct{}
+}
+
+func newHoldingHandler() *holdingHandler {
+	return &holdingHandler{admitted: make(chan []byte, 16), release: make(chan struct{})}
+}
+
+func (h *holdingHandler) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	h.admitted <- body
+	<-h.release
+}
+
+func TestInflightBytesShedsConcurrentBodies(t *testing.T) {
+	withConfig(t, func(c *Config) { c.MaxInflightBytes = 1000 })
+	h := newHoldingHandler()
+	mw := inflightBytesMiddleware(h.serve)
+
+	const requests = 5
+	codes := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			mw(rec, httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(strings.Repeat("x", 400))))
+			codes <- rec.Code
+		}()
+	}
+	// Two 400-byte bodies fit under the limit, the other three are shed
+	for i := 0; i < 2; i++ {
+		<-h.admitted
+	}
+	for i := 0; i < requests-2; i++ {
+		if code := <-codes; code != http.StatusServiceUnavailable {
+			t.Errorf("excess request: status %d, want 503", code)
+		}
+	}
+	close(h.release)
+	wg.Wait()
+	if n := inflightBytes.Load(); n != 0 {
+		t.Errorf("%d bytes still counted in flight", n)
+	}
+}
+
+func TestInflightBytesRejectsOversizedBody(t *testing.T) {
+	withConfig(t, func(c *Config) { c.MaxInflightBytes = 100 })
+	for name, length := range map[string]int64{"sized": 200, "chunked": -1} {
+		req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(strings.Repeat("x", 200)))
+		req.ContentLength = length
+		rec := httptest.NewRecorder()
+		inflightBytesMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("%s body reached the handler", name)
+		})(rec, req)
+		if rec.Code != http.StatusRequestEntityTooLarge || rec.Header().Get("Retry-After") != "" {
+			t.Errorf("%s body: status %d, Retry-After %q, want 413 without it", name, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+	if n := inflightBytes.Load(); n != 0 {
+		t.Errorf("%d bytes still counted in flight", n)
+	}
+}
+
+func TestInflightBytesDisabledPassesBodyThrough(t *testing.T) {
+	withConfig(t, nil)
+	req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader("{}"))
+	req.ContentLength = -1
+	body := req.Body
+	inflightBytesMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != body {
+			t.Error("body was buffered with MAX_INFLIGHT_BYTES off")
+		}
+	})(httptest.NewRecorder(), req)
+}
+
+func TestBulkConcurrencyCapped(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.BulkMaxConcurrency = 2 })
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(unblock)
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		if strings.HasSuffix(c.Path, "/_bulk") {
+			<-release
+		}
+		return false
+	})
+	bulkCalls := func() int {
+		n := 0
+		for _, c := range fake.requests() {
+			if strings.HasSuffix(c.Path, "/_bulk") {
+				n++
+			}
+		}
+		return n
+	}
+
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() { codes <- postJSON("/logs/bulk", `[{"message":"held"}]`).Code }()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for bulkCalls() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("bulk requests never reached OpenSearch")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := testutil.ToFloat64(bulkActiveGauge); got != 2 {
+		t.Errorf("bulk_active_requests = %v, want 2", got)
+	}
+
+	rec := postJSON("/logs/bulk", `[{"message":"shed"}]`)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("third bulk: status %d, Retry-After %q, want 503 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// Single writes have no share in the bulk cap
+	if rec := postJSON("/logs", `{"message":"single"}`); rec.Code != http.StatusCreated {
+		t.Errorf("single write while bulks are capped: status %d", rec.Code)
+	}
+
+	unblock()
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("held bulk: status %d", code)
+		}
+	}
+	if got := testutil.ToFloat64(bulkActiveGauge); got != 0 {
+		t.Errorf("bulk_active_requests = %v after they finished, want 0", got)
+	}
+	if rec := postJSON("/logs/bulk", `[{"message":"again"}]`); rec.Code != http.StatusOK {
+		t.Errorf("bulk after the others finished: status %d", rec.Code)
+	}
+}
+
+func TestBulkConcurrencyValidated(t *testing.T) {
+	c := defaultConfig()
+	c.BulkMaxConcurrency = -1
+	if err := c.validate(); err == nil {
+		t.Error("negative BULK_MAX_CONCURRENCY passed validation")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var requestBodySize = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "http_request_body_bytes",
+		Help:    "Size of request bodies actually read on ingest routes",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+	},
+	[]string{"path"},
+)
+
+// sizeReader counts the bytes read through it
+type sizeReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (s *sizeReader) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	s.n += int64(n)
+	return n, err
+}
+
+// routeBodyLimits holds the ROUTE_MAX_BODY_BYTES overrides by route template
+var routeBodyLimits map[string]int64
+
+// newRouteBodyLimits parses ROUTE_MAX_BODY_BYTES, which validate has checked
+func newRouteBodyLimits(c Config) map[string]int64 {
+	limits := make(map[string]int64, len(c.RouteMaxBodyBytes))
+	for route, limit := range c.RouteMaxBodyBytes {
+		limits[route], _ = strconv.ParseInt(limit, 10, 64)
+	}
+	return limits
+}
+
+// bodyLimit returns the body limit of the route r matched, or def when the
+// route has no override. Zero means unlimited.
+func bodyLimit(r *http.Request, def int64) int64 {
+	if limit, ok := routeBodyLimits[routeLabel(r)]; ok {
+		return limit
+	}
+	return def
+}
+
+// bodyLimitMiddleware caps request bodies at MAX_BODY_BYTES, or the
+// ROUTE_MAX_BODY_BYTES override of the route, with http.MaxBytesReader,
+// which also covers chunked uploads that carry no Content-Length, and
+// records how many bytes were really read
+func bodyLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limit := bodyLimit(r, cfg.MaxBodyBytes); limit > 0 {
+			if r.ContentLength > limit {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		body := &sizeReader{ReadCloser: r.Body}
+		r.Body = body
+		defer func() { requestBodySize.WithLabelValues(routeLabel(r)).Observe(float64(body.n)) }()
+		next(w, r)
+	}
+}
+
+// bodyTooLarge reports whether reading the body failed on MAX_BODY_BYTES
+func bodyTooLarge(err error) bool {
+	var mbe *http.MaxBytesError
+	return errors.As(err, &mbe)
+}
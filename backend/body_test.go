@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// chunkedPost posts body to srv without a Content-Length, so the client
+// sends it with chunked transfer encoding
+func chunkedPost(t *testing.T, srv *httptest.Server, path, body string) *http.Response {
+	t.Helper()
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte(body))
+		pw.Close()
+	}()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+path, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// bodySizeSum returns the bytes recorded by http_request_body_bytes for path
+func bodySizeSum(t *testing.T, path string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := requestBodySize.WithLabelValues(path).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleSum()
+}
+
+func TestChunkedBodyLimit(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.MaxBodyBytes = 1024 })
+	var lengths []int64
+	h := newPublicHandler()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lengths = append(lengths, r.ContentLength)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name string
+		body string
+		want int
+	}{
+		{"within the limit", `{"message":"hi"}`, http.StatusCreated},
+		{"over the limit", `{"message":"` + strings.Repeat("a", 4096) + `"}`, http.StatusRequestEntityTooLarge},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			before := len(fake.writes())
+			resp := chunkedPost(t, srv, "/logs", tc.body)
+			if resp.StatusCode != tc.want {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tc.want)
+			}
+			if n := lengths[len(lengths)-1]; n != -1 {
+				t.Fatalf("request arrived with Content-Length %d, want none", n)
+			}
+			if indexed := len(fake.writes()) > before; indexed != (tc.want == http.StatusCreated) {
+				t.Errorf("indexed = %v", indexed)
+			}
+		})
+	}
+}
+
+func TestBodySizeMetricCountsBytesRead(t *testing.T) {
+	withOpenSearch(t, nil)
+	srv := httptest.NewServer(newPublicHandler())
+	defer srv.Close()
+	const body = `{"message":"streamed"}`
+	sum := bodySizeSum(t, "/logs")
+
+	if resp := chunkedPost(t, srv, "/logs", body); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if got := bodySizeSum(t, "/logs") - sum; got != float64(len(body)) {
+		t.Errorf("http_request_body_bytes grew by %v, want %d", got, len(body))
+	}
+}
+
+func TestRouteBodyLimits(t *testing.T) {
+	withOpenSearch(t, func(c *Config) {
+		c.MaxBodyBytes = 1000
+		c.RouteMaxBodyBytes = map[string]string{"/logs": "100", "/logs/upload": "5000"}
+	})
+	message := strings.Repeat("x", 200)
+	log := `{"message":"` + message + `"}`
+
+	if rec := postJSON("/logs", log); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST /logs over its route limit: status %d, want 413", rec.Code)
+	}
+	// Routes are keyed by template, so /logs/{tenant} keeps the global limit
+	if rec := postJSON("/logs/acme", log); rec.Code != http.StatusCreated {
+		t.Errorf("POST /logs/acme under the global limit: status %d, want 201", rec.Code)
+	}
+	if rec := postJSON("/logs/bulk", "["+log+"]"); rec.Code != http.StatusOK {
+		t.Errorf("POST /logs/bulk under the global limit: status %d, want 200", rec.Code)
+	}
+	if rec := postJSON("/logs/bulk", "["+strings.Repeat(log+",", 5)+log+"]"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST /logs/bulk over the global limit: status %d, want 413", rec.Code)
+	}
+
+	// Well over the global limit, within the one of /logs/upload
+	file := strings.Repeat(log+"\n", 10)
+	rec, progress := postUpload(t, []byte(file))
+	if rec.Code != http.StatusOK || len(progress) == 0 || !progress[len(progress)-1].Done {
+		t.Fatalf("upload within its route limit: status %d, progress %+v", rec.Code, progress)
+	}
+	if got := progress[len(progress)-1].Indexed; got != 10 {
+		t.Errorf("uploaded %d logs, want 10", got)
+	}
+	// The upload is streamed, so going over the limit ends the progress
+	// stream with an error rather than changing the status
+	_, progress = postUpload(t, []byte(strings.Repeat(file, 3)))
+	if last := progress[len(progress)-1]; last.Done || last.Error != "Upload exceeds 5000 bytes" {
+		t.Errorf("upload over its route limit: summary %+v, want the size error", last)
+	}
+}
+
+func TestRouteBodyLimitsValidated(t *testing.T) {
+	for _, limits := range []map[string]string{
+		{"/logs": "lots"},
+		{"/logs": "-1"},
+		{"logs": "100"},
+	} {
+		c := defaultConfig()
+		c.RouteMaxBodyBytes = limits
+		if err := c.validate(); err == nil {
+			t.Errorf("ROUTE_MAX_BODY_BYTES %v passed validation", limits)
+		}
+	}
+}
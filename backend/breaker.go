@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var opensearchRetries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "opensearch_retries_total",
+		Help: "Total number of OpenSearch retry attempts, by final outcome of the call",
+	},
+	[]string{"outcome"},
+)
+
+var opensearchCircuitTransitions = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "opensearch_circuit_transitions_total",
+		Help: "Total number of OpenSearch circuit breaker state changes",
+	},
+	[]string{"from", "to"},
+)
+
+// Circuit breaker states
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half_open"
+)
+
+// errCircuitOpen is returned without contacting OpenSearch while the breaker is open
+var errCircuitOpen = errors.New("opensearch circuit breaker is open")
+
+// circuitBreaker stops calling OpenSearch after a run of consecutive
+// failures and lets a single probe through once the cooldown has passed
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	// node is the OpenSearch node guarded, empty for the whole cluster
+	node string
+
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, state: circuitClosed}
+}
+
+var osBreaker = newCircuitBreaker(0, 30*time.Second)
+
+// allow reports whether a call may be made; a nil breaker or a zero
+// threshold never blocks
+func (b *circuitBreaker) allow() bool {
+	if b == nil || b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.setStateLocked(circuitHalfOpen)
+		b.probing = true
+		return true
+	case circuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// record feeds the outcome of a call back into the breaker
+func (b *circuitBreaker) record(ok bool) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if ok {
+		b.failures = 0
+		if b.state != circuitClosed {
+			b.setStateLocked(circuitClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		if b.state != circuitOpen {
+			b.setStateLocked(circuitOpen)
+		}
+	}
+}
+
+// release ends a call whose outcome says nothing about OpenSearch, such as
+// one its caller cancelled, freeing the half-open probe without counting it
+func (b *circuitBreaker) release() {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// currentState returns the breaker state for reporting
+func (b *circuitBreaker) currentState() string {
+	if b == nil || b.threshold <= 0 {
+		return circuitClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *circuitBreaker) setStateLocked(to string) {
+	opensearchCircuitTransitions.WithLabelValues(b.state, to).Inc()
+	if b.node != "" {
+		opensearchNodeCircuitState.WithLabelValues(b.node).Set(circuitStateValues[to])
+		log.Printf("OpenSearch circuit breaker for %s %s -> %s", b.node, b.state, to)
+	} else {
+		log.Printf("OpenSearch circuit breaker %s -> %s", b.state, to)
+	}
+	b.state = to
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// respondSequence answers successive calls with statuses, repeating the last
+func respondSequence(statuses ...int) func(w http.ResponseWriter, c osCall) bool {
+	n := 0
+	return func(w http.ResponseWriter, c osCall) bool {
+		status := statuses[min(n, len(statuses)-1)]
+		n++
+		w.WriteHeader(status)
+		w.Write([]byte(`{}`))
+		return true
+	}
+}
+
+func retriesTotal(outcome string) float64 {
+	return testutil.ToFloat64(opensearchRetries.WithLabelValues(outcome))
+}
+
+func TestOpenSearchRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		method   string
+		path     []string
+		statuses []int
+		calls    int
+		outcome  string
+		retries  float64
+	}{
+		{"retried then succeeded", http.MethodGet, []string{"_search"}, []int{503, 200}, 2, "succeeded", 1},
+		{"exhausted", http.MethodGet, []string{"_search"}, []int{503}, 3, "exhausted", 2},
+		{"retried then rejected", http.MethodGet, []string{"_search"}, []int{503, 400}, 2, "failed", 1},
+		{"write with generated ID not retried", http.MethodPost, []string{"logs", "_doc"}, []int{503, 201}, 1, "", 0},
+		{"rejected write with generated ID retried", http.MethodPost, []string{"logs", "_doc"}, []int{429, 201}, 2, "succeeded", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) { c.OpenSearchMaxRetries = 2 })
+			fake.setRespond(respondSequence(tc.statuses...))
+			before := map[string]float64{}
+			for _, o := range []string{"succeeded", "exhausted", "failed"} {
+				before[o] = retriesTotal(o)
+			}
+
+			osRequest(context.Background(), "test", tc.method, osURL(tc.path...), []byte(`{}`))
+			if n := len(fake.requests()); n != tc.calls {
+				t.Errorf("%d calls, want %d", n, tc.calls)
+			}
+			for o, b := range before {
+				want := 0.0
+				if o == tc.outcome {
+					want = tc.retries
+				}
+				if got := retriesTotal(o) - b; got != want {
+					t.Errorf("opensearch_retries_total{outcome=%s} grew by %v, want %v", o, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIdempotentRequest(t *testing.T) {
+	for _, tc := range []struct {
+		method, url, body string
+		want              bool
+	}{
+		{http.MethodGet, "http://os/logs/_search", "", true},
+		{http.MethodPut, "http://os/logs/_doc/abc", "{}", true},
+		{http.MethodPost, "http://os/logs/_search", "{}", true},
+		{http.MethodPost, "http://os/logs/_doc", "{}", false},
+		{http.MethodPost, "http://os/logs/_doc?refresh=true", "{}", false},
+		{http.MethodPost, "http://os/_bulk", "{\"index\":{\"_id\":\"a\"}}\n{}\n{\"create\":{\"_id\":\"b\"}}\n{}\n", true},
+		{http.MethodPost, "http://os/_bulk", "{\"index\":{\"_id\":\"a\"}}\n{}\n{\"index\":{}}\n{}\n", false},
+	} {
+		if got := idempotentRequest(tc.method, tc.url, []byte(tc.body)); got != tc.want {
+			t.Errorf("%s %s %q: idempotent = %v, want %v", tc.method, tc.url, tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	transitions := func(from, to string) float64 {
+		return testutil.ToFloat64(opensearchCircuitTransitions.WithLabelValues(from, to))
+	}
+	opened := transitions(circuitClosed, circuitOpen)
+	halfOpened := transitions(circuitOpen, circuitHalfOpen)
+	closed := transitions(circuitHalfOpen, circuitClosed)
+
+	b := newCircuitBreaker(2, 20*time.Millisecond)
+	b.record(false)
+	if b.currentState() != circuitClosed {
+		t.Fatalf("opened below the threshold")
+	}
+	b.record(false)
+	if b.currentState() != circuitOpen || b.allow() {
+		t.Fatalf("state %s after reaching the threshold, want a blocking open breaker", b.currentState())
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow() || b.currentState() != circuitHalfOpen {
+		t.Fatalf("state %s after the cooldown, want a half-open probe", b.currentState())
+	}
+	if b.allow() {
+		t.Error("second call let through while probing")
+	}
+	b.record(true)
+
+	for _, tc := range []struct {
+		from, to string
+		before   float64
+	}{
+		{circuitClosed, circuitOpen, opened},
+		{circuitOpen, circuitHalfOpen, halfOpened},
+		{circuitHalfOpen, circuitClosed, closed},
+	} {
+		if got := transitions(tc.from, tc.to) - tc.before; got != 1 {
+			t.Errorf("transitions{from=%s,to=%s} grew by %v, want 1", tc.from, tc.to, got)
+		}
+	}
+}
+
+func TestCancelledCallsNotCountedByBreaker(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.OpenSearchBreakerThreshold = 1
+		c.OpenSearchBreakerCooldown = 20 * time.Millisecond
+	})
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		time.Sleep(50 * time.Millisecond)
+		return false
+	})
+	opened := testutil.ToFloat64(opensearchCircuitTransitions.WithLabelValues(circuitClosed, circuitOpen))
+	cancelled := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+		if _, _, err := osRequest(ctx, "test", http.MethodGet, osURL("_search"), nil); err == nil {
+			t.Fatal("call outlived its context")
+		}
+	}
+
+	cancelled()
+	if state := osBreaker.currentState(); state != circuitClosed {
+		t.Errorf("state %s after a cancelled call, want closed", state)
+	}
+	if got := testutil.ToFloat64(opensearchCircuitTransitions.WithLabelValues(circuitClosed, circuitOpen)) - opened; got != 0 {
+		t.Errorf("circuit opened %v times by a cancelled call", got)
+	}
+
+	// A cancelled half-open probe frees the probe for the next call
+	osBreaker.record(false)
+	time.Sleep(30 * time.Millisecond)
+	cancelled()
+	if state := osBreaker.currentState(); state != circuitHalfOpen || !osBreaker.allow() {
+		t.Errorf("state %s after a cancelled probe, want half-open letting the next probe in", state)
+	}
+}
+
+func TestNodeBreakerEjectsFailingNode(t *testing.T) {
+	bad := newFakeOpenSearch(t)
+	bad.setRespond(respondSequence(http.StatusServiceUnavailable))
+	good := withOpenSearch(t, func(c *Config) {
+		c.OpenSearchNodes = []string{bad.URL, c.OpenSearchURL}
+		c.OpenSearchBreakerThreshold = 2
+		c.OpenSearchBreakerCooldown = time.Hour
+		c.OpenSearchMaxRetries = 1
+	})
+
+	for i := 0; i < 6; i++ {
+		status, _, err := osRequest(context.Background(), "test", http.MethodGet, osURL("logs", "_search"), nil)
+		if err != nil || status != http.StatusOK {
+			t.Fatalf("search %d: status %d, err %v", i, status, err)
+		}
+	}
+	if n := len(bad.requests()); n != 2 {
+		t.Errorf("failing node got %d calls, want 2 before it was ejected", n)
+	}
+	if n := len(good.requests()); n != 6 {
+		t.Errorf("healthy node got %d calls, want 6", n)
+	}
+
+	for node, want := range map[string]float64{bad.URL: 2, good.URL: 0} {
+		if got := testutil.ToFloat64(opensearchNodeCircuitState.WithLabelValues(node)); got != want {
+			t.Errorf("opensearch_node_circuit_state{node=%s} = %v, want %v", node, got, want)
+		}
+	}
+	circuits, _ := detailedHealth(t)["node_circuits"].(map[string]interface{})
+	if circuits[bad.URL] != circuitOpen || circuits[good.URL] != circuitClosed {
+		t.Errorf("node_circuits = %v", circuits)
+	}
+	if osBreaker.currentState() != circuitClosed {
+		t.Errorf("cluster breaker %s, want it untouched by node failures", osBreaker.currentState())
+	}
+}
+
+func TestNodeBreakerAllEjected(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.OpenSearchNodes = []string{c.OpenSearchURL}
+		c.OpenSearchBreakerThreshold = 1
+		c.OpenSearchBreakerCooldown = time.Hour
+	})
+	fake.setRespond(respondSequence(http.StatusServiceUnavailable))
+	osRequest(context.Background(), "test", http.MethodGet, osURL("logs", "_search"), nil)
+
+	if _, _, err := osRequest(context.Background(), "test", http.MethodGet, osURL("logs", "_search"), nil); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("err = %v with every node ejected, want errCircuitOpen", err)
+	}
+	if n := len(fake.requests()); n != 1 {
+		t.Errorf("%d calls, want 1: none after the only node was ejected", n)
+	}
+}
+
+func TestOpenSearchNodesValidated(t *testing.T) {
+	c := defaultConfig()
+	c.OpenSearchNodes = []string{"http://os-1:9200", "os-2:9200"}
+	if err := c.validate(); err == nil {
+		t.Error("node URL without a scheme passed validation")
+	}
+}
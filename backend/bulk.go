@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+var bulkSplits = newResettableCounter(
+	prometheus.CounterOpts{
+		Name: "opensearch_bulk_splits_total",
+		Help: "Total number of bulk payloads split after OpenSearch answered 413",
+	},
+)
+
+// Outcomes of single _bulk items; the set is fixed to keep the label bounded
+const (
+	bulkItemCreated  = "created"
+	bulkItemUpdated  = "updated"
+	bulkItemConflict = "conflict"
+	bulkItemRejected = "rejected"
+	bulkItemFailed   = "error"
+)
+
+var bulkItemOutcomes = []string{bulkItemCreated, bulkItemUpdated, bulkItemConflict, bulkItemRejected, bulkItemFailed}
+
+var bulkItems = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "opensearch_bulk_items_total",
+		Help: "Total number of _bulk items by outcome reported by OpenSearch",
+	},
+	[]string{"outcome"},
+)
+
+func init() {
+	for _, outcome := range bulkItemOutcomes {
+		bulkItems.WithLabelValues(outcome)
+	}
+}
+
+// bulkItemOutcome classifies one item of a _bulk response. Rejected items
+// were refused by a full write queue (429) and may succeed when retried,
+// unlike errors.
+func bulkItemOutcome(status int, result string) string {
+	switch {
+	case status == http.StatusConflict:
+		return bulkItemConflict
+	case status == http.StatusTooManyRequests:
+		return bulkItemRejected
+	case status >= 300:
+		return bulkItemFailed
+	case result == "updated":
+		return bulkItemUpdated
+	}
+	return bulkItemCreated
+}
+
+// bulkDoc is a log queued for a bulk request, pos being its position in the
+// client payload so errors can be reported against it
+type bulkDoc struct {
+	pos    int
+	source map[string]interface{}
+	meta   docMeta
+}
+
+// bulkItemError describes why a single log of a bulk request was not indexed
+type bulkItemError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// bulkResult summarises the outcome of a bulk request
+type bulkResult struct {
+	Indexed  int             `json:"indexed"`
+	Filtered int             `json:"filtered"`
+	Failed   int             `json:"failed"`
+	Queued   int             `json:"queued,omitempty"`
+	Errors   []bulkItemError `json:"errors,omitempty"`
+
+	// rejected holds the logs OpenSearch did not accept, for the dead-letter file
+	rejected []deadLetterEntry
+	// sendErr is the last error sending a bulk request, if any
+	sendErr error
+	// keepUnsent collects logs that could not be sent in unsent instead of
+	// failing them, for callers that retry later
+	keepUnsent bool
+	unsent     []bulkDoc
+	// conflicted holds the positions of logs refused for an outdated _version
+	conflicted []int
+}
+
+func (b *bulkResult) fail(pos int, msg string) {
+	b.Failed++
+	b.Errors = append(b.Errors, bulkItemError{Index: pos, Error: msg})
+}
+
+// indexFailed records a log that OpenSearch did not accept
+func (b *bulkResult) indexFailed(d bulkDoc, msg string) {
+	recordDrop(dropIndexFailed, 1)
+	b.fail(d.pos, msg)
+	b.rejected = append(b.rejected, newDeadLetterEntry(d.source, d.meta, msg))
+}
+
+// bulkIndex writes documents with the _bulk API, recording the per-document
+// outcome in res. Each action line names the index resolveIndex picks for
+// its document, so one request can span tenants and log types. Encoding the
+// payload and parsing the response get spans of their own next to the
+// opensearch.bulk round-trip, so a slow bulk shows which phase took the time.
+func bulkIndex(ctx context.Context, docs []bulkDoc, res *bulkResult) {
+	if len(docs) == 0 {
+		return
+	}
+	sent := docs[:0:0]
+	indices := make([]string, 0, len(docs))
+	ensured := map[string]bool{}
+	for _, d := range docs {
+		index, err := resolveIndex(d.source)
+		if err != nil {
+			recordDrop(dropValidation, 1)
+			res.fail(d.pos, err.Error())
+			continue
+		}
+		if !ensured[index] {
+			ensured[index] = true
+			if err := knownIndices.ensureIndex(ctx, index); err != nil {
+				log.Printf("Failed to bootstrap index %s, relying on auto-creation: %v", index, err)
+			}
+		}
+		sent = append(sent, d)
+		indices = append(indices, index)
+	}
+
+	_, encodeSpan := otel.Tracer("telyx-backend").Start(ctx, "bulk.encode")
+	var body bytes.Buffer
+	encoded := sent[:0:0]
+	for i, d := range sent {
+		source, err := json.Marshal(d.source)
+		if err != nil {
+			res.indexFailed(d, "failed to encode log")
+			continue
+		}
+		action, _ := json.Marshal(map[string]interface{}{bulkAction(): d.meta.action(indices[i])})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(source)
+		body.WriteByte('\n')
+		encoded = append(encoded, d)
+	}
+	encodeSpan.SetAttributes(
+		attribute.Int("bulk.docs", len(encoded)),
+		attribute.Int("bulk.bytes", body.Len()),
+	)
+	encodeSpan.End()
+	docs = encoded
+	if len(docs) == 0 {
+		return
+	}
+
+	status, resBody, err := osRequest(ctx, "bulk", http.MethodPost, osURL("_bulk")+refreshQuery(ctx, url.Values{}), body.Bytes())
+	if err != nil {
+		res.sendErr = err
+		if res.keepUnsent {
+			res.unsent = append(res.unsent, docs...)
+			return
+		}
+		failAll(res, docs, "failed to send logs to OpenSearch")
+		return
+	}
+
+	// The payload is over http.max_content_length: halve it until it fits
+	if status == http.StatusRequestEntityTooLarge {
+		if len(docs) == 1 {
+			res.indexFailed(docs[0], "log exceeds the OpenSearch maximum content length")
+			return
+		}
+		bulkSplits.Inc()
+		mid := len(docs) / 2
+		bulkIndex(ctx, docs[:mid], res)
+		bulkIndex(ctx, docs[mid:], res)
+		return
+	}
+	if status >= 400 {
+		failAll(res, docs, fmt.Sprintf("OpenSearch returned status %d", status))
+		return
+	}
+
+	_, parseSpan := otel.Tracer("telyx-backend").Start(ctx, "bulk.parse_response")
+	defer parseSpan.End()
+	parseSpan.SetAttributes(attribute.Int("bulk.response_bytes", len(resBody)))
+	var parsed struct {
+		Items []map[string]struct {
+			Status int             `json:"status"`
+			Result string          `json:"result"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(resBody, &parsed); err != nil || len(parsed.Items) != len(docs) {
+		if err != nil {
+			parseSpan.RecordError(err)
+		}
+		failAll(res, docs, "unexpected bulk response from OpenSearch")
+		return
+	}
+	for i, item := range parsed.Items {
+		for _, outcome := range item {
+			bulkItems.WithLabelValues(bulkItemOutcome(outcome.Status, outcome.Result)).Inc()
+			if outcome.Status == http.StatusConflict {
+				recordDrop(dropConflict, 1)
+				res.conflicted = append(res.conflicted, docs[i].pos)
+				res.fail(docs[i].pos, string(outcome.Error))
+			} else if outcome.Status >= 300 {
+				res.indexFailed(docs[i], string(outcome.Error))
+			} else {
+				res.Indexed++
+				recordIngested(1)
+			}
+		}
+	}
+}
+
+func failAll(res *bulkResult, docs []bulkDoc, msg string) {
+	for _, d := range docs {
+		res.indexFailed(d, msg)
+	}
+}
+
+// decodeBulkBody accepts either a JSON array of logs or NDJSON
+func decodeBulkBody(r io.Reader) ([]map[string]interface{}, error) {
+	data, err := io.ReadAll(limitDepth(r))
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	var logs []map[string]interface{}
+	if len(data) > 0 && data[0] == '[' {
+		err := unmarshalLog(data, &logs)
+		return logs, err
+	}
+	dec := newLogDecoder(bytes.NewReader(data))
+	for {
+		var logData map[string]interface{}
+		if err := dec.Decode(&logData); errors.Is(err, io.EOF) {
+			return logs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		logs = append(logs, logData)
+	}
+}
+
+// bulkHandler ingests several logs in a single OpenSearch _bulk call
+func bulkHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("telyx-backend").Start(r.Context(), "bulkHandler")
+	defer span.End()
+
+	w.Header().Set("Content-Type", "application/json")
+	defer r.Body.Close()
+
+	ctx, ok := withRefresh(ctx, r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "X-Refresh must be false, wait_for or true")
+		return
+	}
+	ctx, ok = withLogTimestamp(ctx, r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, cfg.LogTimestampHeader+" must be an RFC 3339 date")
+		return
+	}
+
+	body, prefix := recordRaw(r.Body)
+	logs, err := decodeBulkBody(body)
+	if bodyTooLarge(err) {
+		recordDrop(dropInvalid, 1)
+		errorCapture.capture(r, "Request body too large", prefix)
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+	if errors.Is(err, errTooDeep) {
+		recordDrop(dropValidation, 1)
+		errorCapture.capture(r, err.Error(), prefix)
+		writeValidationError(w, err)
+		return
+	}
+	if err != nil || len(logs) == 0 {
+		recordDrop(dropInvalid, 1)
+		errorCapture.capture(r, "Invalid log format", prefix)
+		http.Error(w, `{"error": "Invalid log format"}`, http.StatusBadRequest)
+		span.RecordError(err)
+		span.SetAttributes(semconv.ExceptionMessageKey.String("Invalid log format"))
+		return
+	}
+
+	var res bulkResult
+	docs := make([]bulkDoc, 0, len(logs))
+	for i, logData := range logs {
+		if logData == nil {
+			recordDrop(dropInvalid, 1)
+			res.fail(i, "Invalid log format")
+			continue
+		}
+		meta, err := takeDocMeta(logData)
+		if err != nil {
+			recordDrop(dropValidation, 1)
+			errorCapture.captureItem(r, i, err.Error(), logData)
+			res.fail(i, err.Error())
+			continue
+		}
+		keep, err := prepareLog(ctx, logData)
+		if err != nil {
+			errorCapture.captureItem(r, i, err.Error(), logData)
+			res.fail(i, err.Error())
+			continue
+		}
+		if !keep {
+			res.Filtered++
+			continue
+		}
+		docs = append(docs, bulkDoc{pos: i, source: logData, meta: meta})
+	}
+
+	if asyncQueue != nil {
+		sources := make([]map[string]interface{}, len(docs))
+		for i, d := range docs {
+			sources[i] = d.source
+		}
+		if err := asyncQueue.enqueue(ctx, sources...); err != nil {
+			span.RecordError(err)
+			writeEnqueueError(w, err)
+			return
+		}
+		res.Queued = len(docs)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	bulkIndex(ctx, docs, &res)
+	deadLetters.write(res.rejected)
+	span.SetAttributes(
+		attribute.Int("bulk.indexed", res.Indexed),
+		attribute.Int("bulk.failed", res.Failed),
+	)
+
+	if res.Indexed == 0 && res.sendErr != nil {
+		writeOpenSearchError(w, span, "bulk", res.sendErr, "Failed to send logs to OpenSearch")
+		return
+	}
+	status := http.StatusOK
+	if res.Indexed == 0 && len(docs) > 0 {
+		status = http.StatusBadGateway
+		if len(res.conflicted) == len(docs) {
+			status = http.StatusConflict
+		}
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
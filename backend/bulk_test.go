@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// decodeBulkResult reads the body of a /logs/bulk response
+func decodeBulkResult(t *testing.T, body string) bulkResult {
+	t.Helper()
+	var res bulkResult
+	if err := json.Unmarshal([]byte(body), &res); err != nil {
+		t.Fatalf("decode %s: %v", body, err)
+	}
+	return res
+}
+
+func TestBulkSplitsOnPayloadTooLarge(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	// Only payloads of at most two logs fit
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		if strings.HasSuffix(c.Path, "/_bulk") && strings.Count(string(c.Body), "\n") > 4 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return true
+		}
+		return false
+	})
+	splits := testutil.ToFloat64(bulkSplits)
+
+	rec := postJSON("/logs/bulk", `[{"n":1},{"n":2},{"n":3},{"n":4},{"n":5}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if res := decodeBulkResult(t, rec.Body.String()); res.Indexed != 5 || res.Failed != 0 {
+		t.Errorf("indexed %d, failed %d, want all 5 indexed", res.Indexed, res.Failed)
+	}
+	if got := testutil.ToFloat64(bulkSplits) - splits; got < 2 {
+		t.Errorf("bulk split %v times, want at least 2", got)
+	}
+	seen := map[float64]bool{}
+	for _, c := range fake.writes() {
+		if strings.Count(string(c.Body), "\n") > 4 {
+			continue
+		}
+		_, sources := bulkLines(c.Body)
+		for _, s := range sources {
+			seen[decodeDoc(t, s)["n"].(float64)] = true
+		}
+	}
+	if len(seen) != 5 {
+		t.Errorf("accepted payloads carried logs %v, want all 5", seen)
+	}
+}
+
+func TestBulkSingleLogTooLargeFails(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		if strings.HasSuffix(c.Path, "/_bulk") {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return true
+		}
+		return false
+	})
+	rec := postJSON("/logs/bulk", `[{"n":1},{"n":2}]`)
+	if res := decodeBulkResult(t, rec.Body.String()); res.Failed != 2 {
+		t.Errorf("failed %d, want 2: %s", res.Failed, rec.Body)
+	}
+	if got := len(fake.writes()); got != 3 {
+		t.Errorf("%d bulk calls, want 3 (the pair, then each log)", got)
+	}
+}
+
+func TestBulkPerDocumentIndex(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.OpenSearchIndex = "logs"
+		c.IndexPrefix = "telyx-"
+		c.IndexTypes = []string{"nginx"}
+		c.IndexPerTenant = true
+		c.IndexDenylist = []string{".*", "telyx-nginx-blocked"}
+	})
+	rec := postJSON("/logs/bulk", `[
+		{"n":1,"tenant":"acme","log_type":"nginx"},
+		{"n":2,"tenant":"globex"},
+		{"n":3,"tenant":"blocked","log_type":"nginx"},
+		{"n":4,"tenant":"Acme"}
+	]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	res := decodeBulkResult(t, rec.Body.String())
+	if res.Indexed != 3 || res.Failed != 1 {
+		t.Errorf("indexed %d, failed %d, want 3 and 1: %s", res.Indexed, res.Failed, rec.Body)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].Index != 2 {
+		t.Errorf("errors %+v, want the denied log at position 2", res.Errors)
+	}
+
+	writes := fake.writes()
+	if len(writes) != 1 {
+		t.Fatalf("%d bulk calls, want 1", len(writes))
+	}
+	actions, sources := bulkLines(writes[0].Body)
+	want := map[float64]string{1: "telyx-nginx-acme", 2: "logs-globex", 4: "logs-acme"}
+	if len(actions) != len(want) {
+		t.Fatalf("%d actions, want %d", len(actions), len(want))
+	}
+	for i, line := range actions {
+		var action map[string]map[string]interface{}
+		if err := json.Unmarshal(line, &action); err != nil {
+			t.Fatal(err)
+		}
+		n := decodeDoc(t, sources[i])["n"].(float64)
+		if got := action["index"]["_index"]; got != want[n] {
+			t.Errorf("log %v: _index = %v, want %s", n, got, want[n])
+		}
+	}
+}
+
+func TestBulkLatencyBreakdownSpans(t *testing.T) {
+	withOpenSearch(t, nil)
+	rec := recordSpans(t)
+	if res := postJSON("/logs/bulk", `[{"n":1},{"n":2},{"n":3}]`); res.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", res.Code, res.Body)
+	}
+
+	handler := endedSpan(t, rec, "bulkHandler")
+	encode := endedSpan(t, rec, "bulk.encode")
+	send := endedSpan(t, rec, "opensearch.bulk")
+	parse := endedSpan(t, rec, "bulk.parse_response")
+	for _, s := range []sdktrace.ReadOnlySpan{encode, send, parse} {
+		if s.Parent().SpanID() != handler.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of bulkHandler", s.Name())
+		}
+	}
+	if encode.EndTime().After(send.StartTime()) || send.EndTime().After(parse.StartTime()) {
+		t.Error("encode, round-trip and parse spans overlap")
+	}
+	for _, tc := range []struct {
+		span sdktrace.ReadOnlySpan
+		key  attribute.Key
+	}{
+		{encode, "bulk.docs"},
+		{encode, "bulk.bytes"},
+		{parse, "bulk.response_bytes"},
+	} {
+		v, ok := spanAttr(tc.span, tc.key)
+		if !ok || v.AsInt64() <= 0 {
+			t.Errorf("%s: %s = %v, want a positive count", tc.span.Name(), tc.key, v.Emit())
+		}
+	}
+	if v, _ := spanAttr(encode, "bulk.docs"); v.AsInt64() != 3 {
+		t.Errorf("bulk.docs = %d, want 3", v.AsInt64())
+	}
+}
+
+func TestBulkItemOutcomesCounted(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	items := []map[string]interface{}{
+		{"status": 201, "result": "created"},
+		{"status": 200, "result": "updated"},
+		{"status": 409, "error": map[string]string{"type": "version_conflict_engine_exception"}},
+		{"status": 429, "error": map[string]string{"type": "es_rejected_execution_exception"}},
+		{"status": 400, "error": map[string]string{"type": "mapper_parsing_exception"}},
+		{"status": 201, "result": "created"},
+	}
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		if !strings.HasSuffix(c.Path, "/_bulk") {
+			return false
+		}
+		wrapped := make([]interface{}, len(items))
+		for i, item := range items {
+			wrapped[i] = map[string]interface{}{"index": item}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": true, "items": wrapped})
+		return true
+	})
+	before := map[string]float64{}
+	for _, outcome := range bulkItemOutcomes {
+		before[outcome] = testutil.ToFloat64(bulkItems.WithLabelValues(outcome))
+	}
+
+	postJSON("/logs/bulk", `[{"n":0},{"n":1},{"n":2},{"n":3},{"n":4},{"n":5}]`)
+	for outcome, want := range map[string]float64{
+		bulkItemCreated:  2,
+		bulkItemUpdated:  1,
+		bulkItemConflict: 1,
+		bulkItemRejected: 1,
+		bulkItemFailed:   1,
+	} {
+		if got := testutil.ToFloat64(bulkItems.WithLabelValues(outcome)) - before[outcome]; got != want {
+			t.Errorf("opensearch_bulk_items_total{outcome=%s} grew by %v, want %v", outcome, got, want)
+		}
+	}
+}
+
+func TestBulkItemsNotCountedWithoutItems(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		w.Write([]byte(`{"unexpected":true}`))
+		return true
+	})
+	total := func() (sum float64) {
+		for _, outcome := range bulkItemOutcomes {
+			sum += testutil.ToFloat64(bulkItems.WithLabelValues(outcome))
+		}
+		return sum
+	}
+	before := total()
+	postJSON("/logs/bulk", `[{"n":0},{"n":1}]`)
+	if got := total() - before; got != 0 {
+		t.Errorf("%v items counted from a response without items", got)
+	}
+}
+
+func TestBulkItemOutcomeLabelsBounded(t *testing.T) {
+	resetMetrics()
+	if n := testutil.CollectAndCount(bulkItems); n != len(bulkItemOutcomes) {
+		t.Errorf("%d outcome series after a reset, want the %d known outcomes", n, len(bulkItemOutcomes))
+	}
+	for _, tc := range []struct {
+		status int
+		result string
+	}{{201, "created"}, {200, "updated"}, {200, "noop"}, {409, ""}, {429, ""}, {500, ""}, {404, "not_found"}} {
+		if outcome := bulkItemOutcome(tc.status, tc.result); !slices.Contains(bulkItemOutcomes, outcome) {
+			t.Errorf("bulkItemOutcome(%d, %q) = %q, not a known outcome", tc.status, tc.result, outcome)
+		}
+	}
+}
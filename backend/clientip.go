@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies holds the parsed TRUSTED_PROXIES
+var trustedProxies []netip.Prefix
+
+// parsePrefixes parses CIDR ranges, taking a bare address as a single host
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func inPrefixes(addr netip.Addr, prefixes []netip.Prefix) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client behind a request. Forwarding
+// headers are only believed when the peer is in TRUSTED_PROXIES: the
+// rightmost X-Forwarded-For hop outside them is the client, since a client
+// can prepend entries but not strip the ones its proxies append. X-Real-IP
+// is used when the proxy sends no X-Forwarded-For.
+func clientAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	if !inPrefixes(addr, trustedProxies) {
+		return addr, true
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		if real := r.Header.Get("X-Real-IP"); real != "" {
+			forwarded = []string{real}
+		}
+	}
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		next, err := netip.ParseAddr(hop)
+		if err != nil {
+			// Past a malformed hop nothing can be trusted; use the last good one
+			break
+		}
+		addr = next.Unmap()
+		if !inPrefixes(addr, trustedProxies) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// clientIP returns the client address used for per-IP rate limiting
+func clientIP(r *http.Request) string {
+	if addr, ok := clientAddr(r); ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	withConfig(t, func(c *Config) { c.TrustedProxies = []string{"10.0.0.0/8", "192.0.2.1"} })
+	for _, tc := range []struct {
+		name      string
+		remote    string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{"direct client", "203.0.113.7:4000", nil, "", "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:4000", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"trusted proxy chain", "10.0.0.2:4000", []string{"203.0.113.7, 192.0.2.1, 10.0.0.9"}, "", "203.0.113.7"},
+		{"chain over several headers", "10.0.0.2:4000", []string{"203.0.113.7", "10.0.0.9"}, "", "203.0.113.7"},
+		{"client prepends a spoofed hop", "10.0.0.2:4000", []string{"1.2.3.4, 203.0.113.7"}, "", "203.0.113.7"},
+		{"untrusted peer spoofing", "198.51.100.3:4000", []string{"203.0.113.7"}, "203.0.113.8", "198.51.100.3"},
+		{"X-Real-IP from a trusted proxy", "10.0.0.2:4000", nil, "203.0.113.7", "203.0.113.7"},
+		{"X-Forwarded-For wins over X-Real-IP", "10.0.0.2:4000", []string{"203.0.113.7"}, "203.0.113.8", "203.0.113.7"},
+		{"malformed hop", "10.0.0.2:4000", []string{"203.0.113.7, garbage, 10.0.0.9"}, "", "10.0.0.9"},
+		{"only proxies", "10.0.0.2:4000", []string{"10.0.0.9"}, "", "10.0.0.9"},
+		{"IPv4-mapped peer", "[::ffff:10.0.0.2]:4000", []string{"203.0.113.7"}, "", "203.0.113.7"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remote
+			for _, v := range tc.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if tc.realIP != "" {
+				req.Header.Set("X-Real-IP", tc.realIP)
+			}
+			if got := clientIP(req); got != tc.want {
+				t.Errorf("clientIP = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitBehindTrustedProxy(t *testing.T) {
+	withOpenSearch(t, func(c *Config) {
+		c.RateLimitRPS = 0.001
+		c.RateLimitBurst = 1
+		c.TrustedProxies = []string{"10.0.0.0/8"}
+	})
+	post := func(remote, forwarded string) int {
+		req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+		req.RemoteAddr = remote
+		req.Header.Set("X-Forwarded-For", forwarded)
+		return do(req).Code
+	}
+
+	// Clients behind the same load balancer get a budget each
+	for _, client := range []string{"203.0.113.7", "203.0.113.8"} {
+		if code := post("10.0.0.2:4000", client); code != http.StatusCreated {
+			t.Errorf("first request of %s: status %d, want 201", client, code)
+		}
+	}
+	if code := post("10.0.0.3:4000", "203.0.113.7"); code != http.StatusTooManyRequests {
+		t.Errorf("second request of 203.0.113.7 through another proxy: status %d, want 429", code)
+	}
+	// A direct client cannot escape its budget with a forged header
+	if code := post("198.51.100.3:4000", "203.0.113.50"); code != http.StatusCreated {
+		t.Errorf("first direct request: status %d, want 201", code)
+	}
+	if code := post("198.51.100.3:4000", "203.0.113.51"); code != http.StatusTooManyRequests {
+		t.Errorf("forged X-Forwarded-For from an untrusted peer: status %d, want 429", code)
+	}
+}
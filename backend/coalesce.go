@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var coalescedBatchSize = newResettableHistogram(
+	prometheus.HistogramOpts{
+		Name:    "ingest_coalesced_batch_size",
+		Help:    "Number of single-log requests written by each coalesced _bulk call",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	},
+)
+
+// coalescedOutcome is what became of one coalesced log
+type coalescedOutcome struct {
+	indexed  bool
+	conflict bool
+	// sendErr is set when the _bulk request itself failed
+	sendErr error
+}
+
+type coalescedDoc struct {
+	doc    bulkDoc
+	origin trace.SpanContext
+	// deadline is when the request stops waiting, zero if it has none
+	deadline time.Time
+	done     chan coalescedOutcome
+}
+
+// coalescer gathers the logs of concurrent POST /logs requests for up to
+// INGEST_COALESCE_WINDOW, or until INGEST_COALESCE_MAX_BATCH are waiting, and
+// writes them with one _bulk call. Each request is only answered once its own
+// log is confirmed, so clients keep synchronous semantics and pay at most the
+// window in added latency.
+type coalescer struct {
+	mu       sync.Mutex
+	pending  []coalescedDoc
+	timer    *time.Timer
+	window   time.Duration
+	maxBatch int
+}
+
+// ingestCoalescer is nil unless INGEST_COALESCE_WINDOW is set
+var ingestCoalescer *coalescer
+
+func newCoalescer(window time.Duration, maxBatch int) *coalescer {
+	return &coalescer{window: window, maxBatch: maxBatch}
+}
+
+// coalescable reports whether the log of a request may wait for a shared
+// _bulk call. Writes asking for a refresh or their own OpenSearch timeout
+// are sent on their own, so one client's options do not apply to everyone
+// batched with it.
+func coalescable(ctx context.Context) bool {
+	_, refresh := ctx.Value(refreshKey{}).(string)
+	_, timeout := ctx.Value(osTimeoutKey{}).(time.Duration)
+	return !refresh && !timeout
+}
+
+// submit queues a log for the next _bulk call and waits for its outcome.
+// A request that gives up first is taken out of the batch if it was not
+// sent yet; otherwise its log may still be indexed, as with a direct write
+// that times out.
+func (c *coalescer) submit(ctx context.Context, doc bulkDoc) coalescedOutcome {
+	done := make(chan coalescedOutcome, 1)
+	deadline, _ := ctx.Deadline()
+	c.mu.Lock()
+	c.pending = append(c.pending, coalescedDoc{
+		doc:      doc,
+		origin:   trace.SpanContextFromContext(ctx),
+		deadline: deadline,
+		done:     done,
+	})
+	switch {
+	case len(c.pending) >= c.maxBatch:
+		batch := c.takeLocked()
+		go c.flush(batch)
+	case len(c.pending) == 1:
+		c.timer = time.AfterFunc(c.window, c.flushPending)
+	}
+	c.mu.Unlock()
+	select {
+	case out := <-done:
+		return out
+	case <-ctx.Done():
+		c.mu.Lock()
+		c.removeLocked(done)
+		c.mu.Unlock()
+		return coalescedOutcome{sendErr: ctx.Err()}
+	}
+}
+
+// removeLocked drops the waiting log answered on done, if still waiting
+func (c *coalescer) removeLocked(done chan coalescedOutcome) {
+	for i, d := range c.pending {
+		if d.done == done {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			break
+		}
+	}
+	if len(c.pending) == 0 && c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+// takeLocked hands over the waiting logs and stops their window timer
+func (c *coalescer) takeLocked() []coalescedDoc {
+	batch := c.pending
+	c.pending = nil
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	return batch
+}
+
+// flushPending flushes once the window of the oldest waiting log is over
+func (c *coalescer) flushPending() {
+	c.mu.Lock()
+	batch := c.takeLocked()
+	c.mu.Unlock()
+	c.flush(batch)
+}
+
+// flush writes a batch with one _bulk call in a trace of its own, linked to
+// the requests whose logs it carries, and answers each of them. The call
+// gets the tightest deadline among those requests, since nobody waits for
+// the ones that expire first.
+func (c *coalescer) flush(batch []coalescedDoc) {
+	if len(batch) == 0 {
+		return
+	}
+	docs := make([]bulkDoc, len(batch))
+	var (
+		links    []trace.Link
+		deadline time.Time
+	)
+	linked := map[trace.SpanID]bool{}
+	for i, d := range batch {
+		docs[i] = d.doc
+		docs[i].pos = i
+		if !d.deadline.IsZero() && (deadline.IsZero() || d.deadline.Before(deadline)) {
+			deadline = d.deadline
+		}
+		if d.origin.IsValid() && !linked[d.origin.SpanID()] {
+			linked[d.origin.SpanID()] = true
+			links = append(links, trace.Link{SpanContext: d.origin})
+		}
+	}
+	ctx, span := otel.Tracer("telyx-backend").Start(context.Background(), "coalesce.flush",
+		trace.WithNewRoot(),
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("coalesce.batch_size", len(batch))),
+	)
+	defer span.End()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	coalescedBatchSize.Observe(float64(len(batch)))
+
+	var res bulkResult
+	bulkIndex(ctx, docs, &res)
+	deadLetters.write(res.rejected)
+
+	failed := make(map[int]bool, len(res.Errors))
+	for _, e := range res.Errors {
+		failed[e.Index] = true
+	}
+	conflicted := make(map[int]bool, len(res.conflicted))
+	for _, pos := range res.conflicted {
+		conflicted[pos] = true
+	}
+	for i, d := range batch {
+		var out coalescedOutcome
+		switch {
+		case conflicted[i]:
+			out.conflict = true
+		case failed[i]:
+			out.sendErr = res.sendErr
+		default:
+			out.indexed = true
+		}
+		d.done <- out
+	}
+}
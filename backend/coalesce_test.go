@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// postConcurrently posts bodies to /logs at once and returns their statuses
+// in the same order
+func postConcurrently(bodies ...string) []int {
+	codes := make([]int, len(bodies))
+	var wg sync.WaitGroup
+	for i, body := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = postJSON("/logs", body).Code
+		}()
+	}
+	wg.Wait()
+	return codes
+}
+
+func TestCoalescedSingleWrites(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.IngestCoalesceWindow = 200 * time.Millisecond })
+	before := histogramOf(t, coalescedBatchSize)
+
+	bodies := make([]string, 5)
+	for i := range bodies {
+		bodies[i] = `{"message":"log ` + strconv.Itoa(i) + `"}`
+	}
+	for i, code := range postConcurrently(bodies...) {
+		if code != http.StatusCreated {
+			t.Errorf("request %d: status %d, want 201", i, code)
+		}
+	}
+	writes := fake.writes()
+	if len(writes) != 1 || !strings.HasSuffix(writes[0].Path, "/_bulk") {
+		t.Fatalf("%d writes, want one _bulk call", len(writes))
+	}
+	if n := len(fake.docs(t)); n != len(bodies) {
+		t.Errorf("bulk call carried %d logs, want %d", n, len(bodies))
+	}
+	after := histogramOf(t, coalescedBatchSize)
+	if n, sum := after.GetSampleCount()-before.GetSampleCount(), after.GetSampleSum()-before.GetSampleSum(); n != 1 || sum != 5 {
+		t.Errorf("ingest_coalesced_batch_size observed %d batches totalling %v, want one of 5", n, sum)
+	}
+}
+
+func TestCoalescedBatchFlushedWhenFull(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.IngestCoalesceWindow = time.Hour
+		c.IngestCoalesceMaxBatch = 3
+	})
+	start := time.Now()
+	for i, code := range postConcurrently(`{"n":1}`, `{"n":2}`, `{"n":3}`) {
+		if code != http.StatusCreated {
+			t.Errorf("request %d: status %d, want 201", i, code)
+		}
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Errorf("full batch waited %v for the window", waited)
+	}
+	if n := len(fake.writes()); n != 1 {
+		t.Errorf("%d writes, want 1", n)
+	}
+}
+
+func TestCoalescedOutcomePerRequest(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.IngestCoalesceWindow = time.Hour
+		c.IngestCoalesceMaxBatch = 3
+	})
+	fake.setRespond(rejectBulkItems(func(doc map[string]interface{}) bool { return doc["n"] == 2.0 }))
+	codes := postConcurrently(`{"n":1}`, `{"n":2}`, `{"n":3}`)
+	if want := []int{http.StatusCreated, http.StatusBadGateway, http.StatusCreated}; !slices.Equal(codes, want) {
+		t.Errorf("statuses %v, want %v", codes, want)
+	}
+}
+
+func TestCoalescedWriteWithOptionsSentAlone(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.IngestCoalesceWindow = time.Hour })
+	req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Refresh", "true")
+	if rec := do(req); rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	writes := fake.writes()
+	if len(writes) != 1 || !strings.HasSuffix(writes[0].Path, "/_doc") {
+		t.Errorf("writes %v, want one _doc call", writes)
+	}
+}
+
+func TestCoalescerDropsCancelledRequest(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	c := newCoalescer(100*time.Millisecond, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	out := c.submit(ctx, bulkDoc{source: map[string]interface{}{"message": "abandoned"}})
+	if !errors.Is(out.sendErr, context.Canceled) || out.indexed {
+		t.Fatalf("outcome %+v, want the cancellation", out)
+	}
+	c.mu.Lock()
+	pending, timer := len(c.pending), c.timer
+	c.mu.Unlock()
+	if pending != 0 || timer != nil {
+		t.Errorf("%d logs still waiting, timer %v after the only request left", pending, timer)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if n := len(fake.writes()); n != 0 {
+		t.Errorf("%d writes for an abandoned log, want 0", n)
+	}
+}
+
+func TestCoalescedCallBoundedByDeadline(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		<-release
+		return false
+	})
+	c := newCoalescer(10*time.Millisecond, 10)
+
+	patient := make(chan coalescedOutcome, 1)
+	go func() {
+		patient <- c.submit(context.Background(), bulkDoc{source: map[string]interface{}{"n": 1}})
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	c.submit(ctx, bulkDoc{source: map[string]interface{}{"n": 2}})
+
+	select {
+	case out := <-patient:
+		if out.indexed || out.sendErr == nil {
+			t.Errorf("outcome %+v, want the deadline of the shared call", out)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request without a deadline still waits on a call its batch gave up on")
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("shared call ran %v past the 100ms deadline", waited)
+	}
+}
+
+func TestCoalesceConfigValidated(t *testing.T) {
+	for name, mutate := range map[string]func(*Config){
+		"negative window": func(c *Config) { c.IngestCoalesceWindow = -time.Millisecond },
+		"empty batch":     func(c *Config) { c.IngestCoalesceMaxBatch = 0 },
+	} {
+		c := defaultConfig()
+		mutate(&c)
+		if err := c.validate(); err == nil {
+			t.Errorf("%s: passed validation", name)
+		}
+	}
+}
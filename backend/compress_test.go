@@ -0,0 +1,117 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// searchHits answers _search with n hits carrying a message of size bytes
+func searchHits(n, size int) func(w http.ResponseWriter, c osCall) bool {
+	return func(w http.ResponseWriter, c osCall) bool {
+		if !strings.HasSuffix(c.Path, "/_search") {
+			return false
+		}
+		hits := make([]map[string]interface{}, n)
+		for i := range hits {
+			hits[i] = map[string]interface{}{"_source": map[string]interface{}{
+				"message": strings.Repeat("x", size),
+				"seq":     i,
+			}}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"hits": map[string]interface{}{"hits": hits, "total": map[string]int{"value": n}},
+		})
+		return true
+	}
+}
+
+func TestSearchResponseCompression(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		hits           int
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"large accepted", 20, "gzip, deflate", true},
+		{"small", 1, "gzip", false},
+		{"large not accepted", 20, "", false},
+		{"large refused", 20, "gzip;q=0", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) { c.CompressionMinSize = 1024 })
+			fake.setRespond(searchHits(tc.hits, 100))
+
+			req := httptest.NewRequest(http.MethodGet, "/logs/search", nil)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			rec := do(req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			gzipped := rec.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tc.wantGzip {
+				t.Fatalf("gzipped = %v, want %v", gzipped, tc.wantGzip)
+			}
+			var body io.Reader = rec.Body
+			if gzipped {
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = gz
+			}
+			var res struct {
+				Logs []map[string]interface{} `json:"logs"`
+			}
+			if err := json.NewDecoder(body).Decode(&res); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if len(res.Logs) != tc.hits {
+				t.Errorf("%d logs, want %d", len(res.Logs), tc.hits)
+			}
+		})
+	}
+}
+
+func TestCompressionKeepsStreaming(t *testing.T) {
+	withConfig(t, func(c *Config) { c.CompressionMinSize = 1 << 20 })
+	flushed := make(chan struct{})
+	srv := httptest.NewServer(gzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "first")
+		w.(http.Flusher).Flush()
+		<-flushed
+		fmt.Fprintln(w, "second")
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("streamed response not compressed: %v", res.Header)
+	}
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := make([]byte, len("first\n"))
+	if _, err := io.ReadFull(gz, first); err != nil || string(first) != "first\n" {
+		t.Fatalf("read %q before the handler finished: %v", first, err)
+	}
+	close(flushed)
+	rest, _ := io.ReadAll(gz)
+	if string(rest) != "second\n" {
+		t.Errorf("rest = %q", rest)
+	}
+}
@@ -0,0 +1,1187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the runtime settings of the backend
+type Config struct {
+	// Addr is the public listener address (ADDR)
+	Addr string `yaml:"addr"`
+	// ListenSocket is a Unix socket path serving the public routes next to
+	// Addr, empty disables it (LISTEN_SOCKET)
+	ListenSocket string `yaml:"listen_socket"`
+	// ListenSocketMode is the octal file mode of the socket (LISTEN_SOCKET_MODE)
+	ListenSocketMode string `yaml:"listen_socket_mode"`
+	// AdminAddr is the admin listener address, empty disables it (ADMIN_ADDR)
+	AdminAddr string `yaml:"admin_addr"`
+	// AdminDeleteEnabled exposes /admin/delete; off by default since the
+	// admin listener has no authentication (ADMIN_DELETE_ENABLED)
+	AdminDeleteEnabled bool `yaml:"admin_delete_enabled"`
+	// AdminDeleteMaxDocs caps how many logs one /admin/delete call removes (ADMIN_DELETE_MAX_DOCS)
+	AdminDeleteMaxDocs int `yaml:"admin_delete_max_docs"`
+	// EnablePprof exposes net/http/pprof on the admin listener (ENABLE_PPROF)
+	EnablePprof bool `yaml:"enable_pprof"`
+	// AdminMetricsReset exposes /admin/metrics/reset, for load tests only
+	// (ADMIN_METRICS_RESET)
+	AdminMetricsReset bool `yaml:"admin_metrics_reset"`
+	// AdminAllowedCIDRs restricts the admin listener, /metrics, /health,
+	// /health/detailed and /stats to these networks; empty allows any
+	// source (ADMIN_ALLOWED_CIDRS)
+	AdminAllowedCIDRs []string `yaml:"admin_allowed_cidrs"`
+	// TrustedProxies are the load balancer networks whose X-Forwarded-For
+	// and X-Real-IP are believed when resolving the client address for rate
+	// limiting and ADMIN_ALLOWED_CIDRS (TRUSTED_PROXIES)
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// OpenSearchURL is the base URL of the OpenSearch cluster (OPENSEARCH_URL)
+	OpenSearchURL string `yaml:"opensearch_url"`
+	// OpenSearchNodes are node base URLs used round-robin instead of
+	// OPENSEARCH_URL, each behind its own circuit breaker (OPENSEARCH_NODES)
+	OpenSearchNodes []string `yaml:"opensearch_nodes"`
+	// OpenSearchIndex is the concrete index logs are stored in (OPENSEARCH_INDEX)
+	OpenSearchIndex string `yaml:"opensearch_index"`
+	// OpenSearchWriteAlias, when set, receives writes instead of the index and
+	// is created at startup if missing (OPENSEARCH_WRITE_ALIAS)
+	OpenSearchWriteAlias string `yaml:"opensearch_write_alias"`
+	// OpenSearchTimeout bounds each OpenSearch call, zero means no limit (OPENSEARCH_TIMEOUT)
+	OpenSearchTimeout time.Duration `yaml:"opensearch_timeout"`
+	// OpenSearchTimeoutMax caps the per-request X-OpenSearch-Timeout
+	// override (OPENSEARCH_TIMEOUT_MAX)
+	OpenSearchTimeoutMax time.Duration `yaml:"opensearch_timeout_max"`
+	// OpenSearchRefresh is the refresh parameter sent with writes: "false",
+	// "wait_for" or "true"; empty leaves it to OpenSearch (OPENSEARCH_REFRESH)
+	OpenSearchRefresh string `yaml:"opensearch_refresh"`
+	// OpenSearchWaitForActiveShards is how many shard copies must be active
+	// before a write proceeds: a count or "all", empty leaves the index
+	// setting in charge (OPENSEARCH_WAIT_FOR_ACTIVE_SHARDS)
+	OpenSearchWaitForActiveShards string `yaml:"opensearch_wait_for_active_shards"`
+	// OpenSearchMaxRetries is how often transient failures are retried (OPENSEARCH_MAX_RETRIES)
+	OpenSearchMaxRetries int `yaml:"opensearch_max_retries"`
+	// OpenSearchRetryBackoff is the first retry delay, doubled on each attempt (OPENSEARCH_RETRY_BACKOFF)
+	OpenSearchRetryBackoff time.Duration `yaml:"opensearch_retry_backoff"`
+	// OpenSearchBreakerThreshold opens the circuit after this many consecutive
+	// failures, zero disables the breaker (OPENSEARCH_BREAKER_THRESHOLD)
+	OpenSearchBreakerThreshold int `yaml:"opensearch_breaker_threshold"`
+	// OpenSearchBreakerCooldown is how long the circuit stays open (OPENSEARCH_BREAKER_COOLDOWN)
+	OpenSearchBreakerCooldown time.Duration `yaml:"opensearch_breaker_cooldown"`
+	// OpenSearchUseDataStream writes to a data stream named after
+	// OPENSEARCH_INDEX, created at startup with its index template
+	// (OPENSEARCH_USE_DATA_STREAM)
+	OpenSearchUseDataStream bool `yaml:"opensearch_use_data_stream"`
+	// IndexTypeField is the log field selecting the index (LOG_INDEX_FIELD)
+	IndexTypeField string `yaml:"log_index_field"`
+	// IndexPrefix is prepended to the type to form the index name (LOG_INDEX_PREFIX)
+	IndexPrefix string `yaml:"log_index_prefix"`
+	// IndexTypes are the types routed to their own index; other logs go to
+	// the default target, and an empty list disables routing (LOG_INDEX_TYPES)
+	IndexTypes []string `yaml:"log_index_types"`
+	// IndexExistsCacheTTL is how long a routed index is known to exist before
+	// it is checked again (INDEX_EXISTS_CACHE_TTL)
+	IndexExistsCacheTTL time.Duration `yaml:"index_exists_cache_ttl"`
+	// IndexPerTenant appends the tenant of /logs/{tenant} to the index name
+	// (LOG_INDEX_PER_TENANT)
+	IndexPerTenant bool `yaml:"log_index_per_tenant"`
+	// IndexAllowlist restricts routed indices to these glob patterns, empty
+	// allows any (INDEX_ALLOWLIST)
+	IndexAllowlist []string `yaml:"index_allowlist"`
+	// IndexDenylist lists glob patterns of indices logs may never be routed
+	// to (INDEX_DENYLIST)
+	IndexDenylist []string `yaml:"index_denylist"`
+	// IndexMappingsPath is a YAML or JSON file of per-field mappings installed
+	// as an index template at startup (INDEX_MAPPINGS_PATH)
+	IndexMappingsPath string `yaml:"index_mappings_path"`
+	// OpenSearchHeaders are static headers sent with every OpenSearch request,
+	// as comma-separated key=value pairs (OPENSEARCH_HEADERS)
+	OpenSearchHeaders map[string]string `yaml:"opensearch_headers"`
+	// OpenSearchUserAgent is the User-Agent of OpenSearch requests, so they
+	// can be told apart in cluster audit logs (OPENSEARCH_USER_AGENT)
+	OpenSearchUserAgent string `yaml:"opensearch_user_agent"`
+
+	// IndexFieldCheckInterval is how often the mapped field count of the
+	// target index is checked, zero disables it (INDEX_FIELD_CHECK_INTERVAL)
+	IndexFieldCheckInterval time.Duration `yaml:"index_field_check_interval"`
+	// IndexTotalFieldsLimit mirrors index.mapping.total_fields.limit (INDEX_TOTAL_FIELDS_LIMIT)
+	IndexTotalFieldsLimit int `yaml:"index_total_fields_limit"`
+	// IndexFieldWarnRatio is the fraction of the limit that triggers a
+	// warning (INDEX_FIELD_WARN_RATIO)
+	IndexFieldWarnRatio float64 `yaml:"index_field_warn_ratio"`
+	// MappingCacheTTL is how long GET /logs/mapping serves a fetched
+	// mapping (MAPPING_CACHE_TTL)
+	MappingCacheTTL time.Duration `yaml:"mapping_cache_ttl"`
+	// FieldTypeSampleRate is the fraction of logs whose field types are
+	// tracked to warn about type drift, zero disables it (FIELD_TYPE_SAMPLE_RATE)
+	FieldTypeSampleRate float64 `yaml:"field_type_sample_rate"`
+	// FieldTypeMaxFields caps how many fields the drift check tracks (FIELD_TYPE_MAX_FIELDS)
+	FieldTypeMaxFields int `yaml:"field_type_max_fields"`
+
+	// ReadyCacheTTL is how long a readiness result is served from cache (READY_CACHE_TTL)
+	ReadyCacheTTL time.Duration `yaml:"ready_cache_ttl"`
+	// ReadyStaleFor keeps the last good readiness result when checks fail (READY_STALE_FOR)
+	ReadyStaleFor time.Duration `yaml:"ready_stale_for"`
+	// ReadyWarmup keeps /ready failing for this long after startup (READY_WARMUP)
+	ReadyWarmup time.Duration `yaml:"ready_warmup"`
+	// ReadyWarmupConns is how many OpenSearch connections to pre-open (READY_WARMUP_CONNS)
+	ReadyWarmupConns int `yaml:"ready_warmup_conns"`
+	// HealthErrorWindow is the sliding window of the error rate reported by
+	// /health/detailed (HEALTH_ERROR_WINDOW)
+	HealthErrorWindow time.Duration `yaml:"health_error_window"`
+	// HealthDegradedErrorRate is the 5xx ratio above which /health/detailed
+	// reports degraded (HEALTH_DEGRADED_ERROR_RATE)
+	HealthDegradedErrorRate float64 `yaml:"health_degraded_error_rate"`
+	// HealthErrorMinRequests is how many requests the window needs before
+	// the error rate is judged (HEALTH_ERROR_MIN_REQUESTS)
+	HealthErrorMinRequests int `yaml:"health_error_min_requests"`
+
+	// MaxBodyBytes rejects ingest request bodies larger than this with 413,
+	// whether or not they carry a Content-Length (MAX_BODY_BYTES)
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// UploadMaxBytes caps /logs/upload files, counted both as sent and once
+	// decompressed (UPLOAD_MAX_BYTES)
+	UploadMaxBytes int64 `yaml:"upload_max_bytes"`
+	// RouteMaxBodyBytes overrides the body limit of single routes, as
+	// comma-separated route=bytes pairs keyed by route template such as
+	// /logs/{tenant}; zero lifts the limit of that route (ROUTE_MAX_BODY_BYTES)
+	RouteMaxBodyBytes map[string]string `yaml:"route_max_body_bytes"`
+	// UploadBatchSize is how many uploaded logs go in each _bulk request (UPLOAD_BATCH_SIZE)
+	UploadBatchSize int `yaml:"upload_batch_size"`
+	// EchoMaxBytes caps the stored document returned by POST /logs?echo=true;
+	// larger documents are left out of the response (ECHO_MAX_BYTES)
+	EchoMaxBytes int `yaml:"echo_max_bytes"`
+	// MaxInflightBytes sheds requests with 503 once the bodies being processed
+	// add up to this many bytes, zero disables the limit (MAX_INFLIGHT_BYTES)
+	MaxInflightBytes int64 `yaml:"max_inflight_bytes"`
+	// BulkMaxConcurrency sheds bulk requests with 503 while this many are
+	// being processed, zero disables it (BULK_MAX_CONCURRENCY)
+	BulkMaxConcurrency int `yaml:"bulk_max_concurrency"`
+	// AdaptiveSampling drops a growing share of logs as ingest buffers fill
+	// instead of shedding whole requests (ADAPTIVE_SAMPLING)
+	AdaptiveSampling bool `yaml:"adaptive_sampling"`
+	// AdaptiveSamplingLow is the buffer load at which sampling starts (ADAPTIVE_SAMPLING_LOW)
+	AdaptiveSamplingLow float64 `yaml:"adaptive_sampling_low"`
+	// AdaptiveSamplingHigh is the buffer load at which the minimum rate applies (ADAPTIVE_SAMPLING_HIGH)
+	AdaptiveSamplingHigh float64 `yaml:"adaptive_sampling_high"`
+	// AdaptiveSamplingMinRate is the smallest fraction of logs kept (ADAPTIVE_SAMPLING_MIN_RATE)
+	AdaptiveSamplingMinRate float64 `yaml:"adaptive_sampling_min_rate"`
+
+	// DeadLetterPath is the NDJSON file receiving logs OpenSearch rejected,
+	// empty disables it (DEAD_LETTER_PATH)
+	DeadLetterPath string `yaml:"dead_letter_path"`
+	// ErrorIndex receives a record of each payload failing parsing or
+	// validation on /logs and /logs/bulk, empty disables it (ERROR_INDEX)
+	ErrorIndex string `yaml:"error_index"`
+	// ErrorRawMaxBytes is how much of a failed payload its record keeps (ERROR_RAW_MAX_BYTES)
+	ErrorRawMaxBytes int `yaml:"error_raw_max_bytes"`
+	// MinFreeDiskBytes refuses ingestion with 507 while the dead-letter or
+	// WAL directory has less space free, zero disables it (MIN_FREE_DISK_BYTES)
+	MinFreeDiskBytes int64 `yaml:"min_free_disk_bytes"`
+	// DiskCheckInterval is how often free space is checked (DISK_CHECK_INTERVAL)
+	DiskCheckInterval time.Duration `yaml:"disk_check_interval"`
+
+	// TraceSampleRatio is the fraction of root spans sampled (TRACE_SAMPLE_RATIO)
+	TraceSampleRatio float64 `yaml:"trace_sample_ratio"`
+	// TraceExporters fan spans out to several OTLP endpoints; empty uses the
+	// standard OTEL_EXPORTER_OTLP_* settings (OTEL_EXPORTER_<n>_ENDPOINT,
+	// OTEL_EXPORTER_<n>_HEADERS, OTEL_EXPORTER_<n>_PROTOCOL)
+	TraceExporters []TraceExporter `yaml:"trace_exporters"`
+	// LogInjectTraceID adds trace_id and span_id of the ingesting request to
+	// stored logs (LOG_INJECT_TRACE_ID)
+	LogInjectTraceID bool `yaml:"log_inject_trace_id"`
+	// LogBaggageKeys are the OpenTelemetry baggage members copied onto logs;
+	// anything not listed is ignored (LOG_BAGGAGE_KEYS)
+	LogBaggageKeys []string `yaml:"log_baggage_keys"`
+
+	// RedactKeys are field and span attribute names whose values are masked
+	// entirely, case-insensitively (REDACT_KEYS)
+	RedactKeys []string `yaml:"redact_keys"`
+	// RedactPatterns are regular expressions masked wherever they match in
+	// log and span strings, as a JSON array (REDACT_PATTERNS)
+	RedactPatterns []string `yaml:"redact_patterns"`
+	// RedactMask replaces redacted data (REDACT_MASK)
+	RedactMask string `yaml:"redact_mask"`
+	// TraceAllowForce lets clients force sampling with X-Force-Trace, keep it
+	// off in production (TRACE_ALLOW_FORCE)
+	TraceAllowForce bool `yaml:"trace_allow_force"`
+	// TraceResponseHeaders reports the sampling decision in X-Trace-Sampled
+	// and the trace ID in X-Trace-Id, keep it off in production (TRACE_RESPONSE_HEADERS)
+	TraceResponseHeaders bool `yaml:"trace_response_headers"`
+	// TraceSpanNameField names ingest spans after this log field, such as
+	// event_type, instead of logHandler (TRACE_SPAN_NAME_FIELD)
+	TraceSpanNameField string `yaml:"trace_span_name_field"`
+	// TraceSpanNameValues are the field values used as span names; others
+	// fall back to the route, keeping span names bounded (TRACE_SPAN_NAME_VALUES)
+	TraceSpanNameValues []string `yaml:"trace_span_name_values"`
+
+	// RequestIDFormat is how generated request IDs look: "hex", "uuid" or
+	// "ulid" (REQUEST_ID_FORMAT)
+	RequestIDFormat string `yaml:"request_id_format"`
+	// LogRequestIDField is the log field receiving the request ID, empty
+	// disables it (LOG_REQUEST_ID_FIELD)
+	LogRequestIDField string `yaml:"log_request_id_field"`
+	// SlowRequestThreshold logs a warning for requests taking longer, zero
+	// disables it (SLOW_REQUEST_THRESHOLD)
+	SlowRequestThreshold time.Duration `yaml:"slow_request_threshold"`
+	// RequestDeadlineMax caps how far away a client X-Request-Deadline may
+	// be (REQUEST_DEADLINE_MAX)
+	RequestDeadlineMax time.Duration `yaml:"request_deadline_max"`
+
+	// RateLimitRPS is the per-client-IP request rate on ingest routes, zero
+	// disables limiting (RATE_LIMIT_RPS)
+	RateLimitRPS float64 `yaml:"rate_limit_rps"`
+	// RateLimitBurst is the per-client-IP burst size (RATE_LIMIT_BURST)
+	RateLimitBurst int `yaml:"rate_limit_burst"`
+	// RateLimitMaxClients bounds how many client limiters are kept, least
+	// recently used ones being evicted (RATE_LIMIT_MAX_CLIENTS)
+	RateLimitMaxClients int `yaml:"rate_limit_max_clients"`
+	// GlobalRateLimitRPS is the combined request rate of all clients on
+	// ingest routes, zero disables it (GLOBAL_RATE_LIMIT_RPS)
+	GlobalRateLimitRPS float64 `yaml:"global_rate_limit_rps"`
+	// GlobalRateLimitBurst is the combined burst size (GLOBAL_RATE_LIMIT_BURST)
+	GlobalRateLimitBurst int `yaml:"global_rate_limit_burst"`
+	// TenantAPIKeys maps API keys sent in X-API-Key to tenants, as
+	// comma-separated key=tenant pairs (TENANT_API_KEYS)
+	TenantAPIKeys map[string]string `yaml:"tenant_api_keys"`
+	// TenantRateLimitRPS is the request rate of each tenant on ingest
+	// routes, zero disables it (TENANT_RATE_LIMIT_RPS)
+	TenantRateLimitRPS float64 `yaml:"tenant_rate_limit_rps"`
+	// TenantRateLimitBurst is the burst size of each tenant (TENANT_RATE_LIMIT_BURST)
+	TenantRateLimitBurst int `yaml:"tenant_rate_limit_burst"`
+	// TenantRateLimits overrides the rate of single tenants, as
+	// comma-separated tenant=rps:burst pairs (TENANT_RATE_LIMITS)
+	TenantRateLimits map[string]string `yaml:"tenant_rate_limits"`
+
+	// NonceTTL is how long an X-Nonce is remembered to reject replays of the
+	// request, zero disables the check (NONCE_TTL)
+	NonceTTL time.Duration `yaml:"nonce_ttl"`
+	// NonceCacheSize bounds how many nonces are remembered (NONCE_CACHE_SIZE)
+	NonceCacheSize int `yaml:"nonce_cache_size"`
+	// NonceRequired rejects ingest requests without X-Nonce (NONCE_REQUIRED)
+	NonceRequired bool `yaml:"nonce_required"`
+
+	// CompressionMinSize is the response size from which read endpoints are
+	// gzip-compressed, zero disables compression (COMPRESSION_MIN_SIZE)
+	CompressionMinSize int `yaml:"compression_min_size"`
+
+	// LogPreserveNumbers keeps JSON numbers exactly as sent instead of
+	// converting them to float64 (LOG_PRESERVE_NUMBERS)
+	LogPreserveNumbers bool `yaml:"log_preserve_numbers"`
+	// LogMaxDepth rejects logs nesting objects and arrays deeper than this,
+	// zero disables the check (LOG_MAX_DEPTH)
+	LogMaxDepth int `yaml:"log_max_depth"`
+	// LogTimestampSources orders where the event time of a log comes from:
+	// "client", "header" and "ingest" (LOG_TIMESTAMP_SOURCES)
+	LogTimestampSources []string `yaml:"log_timestamp_sources"`
+	// LogTimestampHeader is the request header of the "header" timestamp
+	// source (LOG_TIMESTAMP_HEADER)
+	LogTimestampHeader string `yaml:"log_timestamp_header"`
+	// LogMaxAge is how old the event time of a log may be, zero disables
+	// the check (LOG_MAX_AGE)
+	LogMaxAge time.Duration `yaml:"log_max_age"`
+	// LogMaxAgeAction is what happens to older logs: "reject", "route" to
+	// LOG_COLD_INDEX or "tag" them as stale (LOG_MAX_AGE_ACTION)
+	LogMaxAgeAction string `yaml:"log_max_age_action"`
+	// LogColdIndex receives logs older than LOG_MAX_AGE with the route
+	// action (LOG_COLD_INDEX)
+	LogColdIndex string `yaml:"log_cold_index"`
+	// LogSanitizeFieldNames rewrites dotted and underscore-prefixed field
+	// names before indexing (LOG_SANITIZE_FIELD_NAMES)
+	LogSanitizeFieldNames bool `yaml:"log_sanitize_field_names"`
+	// LogFieldDotReplacement replaces dots in field names (LOG_FIELD_DOT_REPLACEMENT)
+	LogFieldDotReplacement string `yaml:"log_field_dot_replacement"`
+	// LogAllowedUnderscoreFields keep their leading underscore (LOG_ALLOWED_UNDERSCORE_FIELDS)
+	LogAllowedUnderscoreFields []string `yaml:"log_allowed_underscore_fields"`
+	// LogSnakeCaseFields rewrites field names to snake_case, e.g. userId to
+	// user_id (LOG_SNAKE_CASE_FIELDS)
+	LogSnakeCaseFields bool `yaml:"log_snake_case_fields"`
+	// LogSnakeCaseExceptions keep their name as sent (LOG_SNAKE_CASE_EXCEPTIONS)
+	LogSnakeCaseExceptions []string `yaml:"log_snake_case_exceptions"`
+
+	// IngestAsync acknowledges logs with 202 once queued and indexes them in
+	// the background (INGEST_ASYNC)
+	IngestAsync bool `yaml:"ingest_async"`
+	// AsyncQueueSize is how many logs may wait in the queue (ASYNC_QUEUE_SIZE)
+	AsyncQueueSize int `yaml:"async_queue_size"`
+	// AsyncBatchSize is how many queued logs trigger an early flush (ASYNC_BATCH_SIZE)
+	AsyncBatchSize int `yaml:"async_batch_size"`
+	// AsyncFlushInterval is the longest a queued log waits to be indexed (ASYNC_FLUSH_INTERVAL)
+	AsyncFlushInterval time.Duration `yaml:"async_flush_interval"`
+	// IngestCoalesceWindow is how long a single log waits for others to share
+	// its _bulk call; zero writes every log on its own (INGEST_COALESCE_WINDOW)
+	IngestCoalesceWindow time.Duration `yaml:"ingest_coalesce_window"`
+	// IngestCoalesceMaxBatch flushes a coalesced batch early once this many
+	// logs wait (INGEST_COALESCE_MAX_BATCH)
+	IngestCoalesceMaxBatch int `yaml:"ingest_coalesce_max_batch"`
+	// WALPath is the write-ahead log keeping queued logs across restarts,
+	// empty keeps them in memory only (WAL_PATH)
+	WALPath string `yaml:"wal_path"`
+	// WALFsync syncs the WAL to disk before every acknowledgement (WAL_FSYNC)
+	WALFsync bool `yaml:"wal_fsync"`
+
+	// WSBufferSize is how many streamed logs are buffered before reads pause (WS_BUFFER_SIZE)
+	WSBufferSize int `yaml:"ws_buffer_size"`
+	// WSBatchSize is how many streamed logs are indexed per bulk request (WS_BATCH_SIZE)
+	WSBatchSize int `yaml:"ws_batch_size"`
+	// WSFlushInterval is the longest a streamed log waits to be indexed (WS_FLUSH_INTERVAL)
+	WSFlushInterval time.Duration `yaml:"ws_flush_interval"`
+	// WSMaxMessageBytes caps a single WebSocket message (WS_MAX_MESSAGE_BYTES)
+	WSMaxMessageBytes int64 `yaml:"ws_max_message_bytes"`
+
+	// TLSCertFile and TLSKeyFile enable HTTPS on the public listener; the
+	// pair is reloaded on SIGHUP (TLS_CERT_FILE, TLS_KEY_FILE)
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+	// TLSMinVersion is the lowest TLS version accepted, "1.2" or "1.3" (TLS_MIN_VERSION)
+	TLSMinVersion string `yaml:"tls_min_version"`
+	// TLSCipherSuites restricts TLS 1.2 cipher suites by name, empty uses
+	// the Go defaults (TLS_CIPHER_SUITES)
+	TLSCipherSuites []string `yaml:"tls_cipher_suites"`
+	// TLSClientCA requires clients to present a certificate signed by one of
+	// the CAs in this PEM file (TLS_CLIENT_CA)
+	TLSClientCA string `yaml:"tls_client_ca"`
+	// EnableH2C serves HTTP/2 without TLS to clients that ask for it, next
+	// to HTTP/1.1 (ENABLE_H2C)
+	EnableH2C bool `yaml:"enable_h2c"`
+
+	// ShutdownTimeout bounds how long in-flight requests and streams get to
+	// finish on SIGTERM (SHUTDOWN_TIMEOUT)
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// RawBodySampleRate is the fraction of /logs requests whose raw body is
+	// kept alongside the parsed log for forensics (RAW_BODY_SAMPLE_RATE)
+	RawBodySampleRate float64 `yaml:"raw_body_sample_rate"`
+	// RawBodyMaxBytes caps the captured raw body (RAW_BODY_MAX_BYTES)
+	RawBodyMaxBytes int `yaml:"raw_body_max_bytes"`
+	// RawBodyField is the log field holding the raw body (RAW_BODY_FIELD)
+	RawBodyField string `yaml:"raw_body_field"`
+
+	// SearchPITKeepAlive is how long a paginated search stays open between
+	// pages (SEARCH_PIT_KEEP_ALIVE)
+	SearchPITKeepAlive time.Duration `yaml:"search_pit_keep_alive"`
+	// SearchPITMaxLifetime caps how long a paginated search may be continued (SEARCH_PIT_MAX_LIFETIME)
+	SearchPITMaxLifetime time.Duration `yaml:"search_pit_max_lifetime"`
+
+	// CORSAllowedOrigins lists origins allowed by CORS, "*" allows any (CORS_ALLOWED_ORIGINS)
+	CORSAllowedOrigins []string `yaml:"cors_allowed_origins"`
+	// CORSMaxAge is how long browsers may cache preflight results (CORS_MAX_AGE)
+	CORSMaxAge time.Duration `yaml:"cors_max_age"`
+	// CORSAllowCredentials sets Access-Control-Allow-Credentials (CORS_ALLOW_CREDENTIALS)
+	CORSAllowCredentials bool `yaml:"cors_allow_credentials"`
+	// OptionsAllow answers OPTIONS on every route with 204 and an Allow
+	// header, otherwise only CORS preflights are answered (HTTP_OPTIONS_ALLOW)
+	OptionsAllow bool `yaml:"http_options_allow"`
+
+	// LogMinFields is the minimum number of client fields a log must carry,
+	// the tenant of /logs/{tenant} not counting; zero, the default, allows
+	// empty objects (LOG_MIN_FIELDS)
+	LogMinFields int `yaml:"log_min_fields"`
+	// LogMaxFields caps the number of fields, nested ones included, zero
+	// disables the check (LOG_MAX_FIELDS)
+	LogMaxFields int `yaml:"log_max_fields"`
+	// LogFieldLimitAction is "reject" or "truncate" (LOG_FIELD_LIMIT_ACTION)
+	LogFieldLimitAction string `yaml:"log_field_limit_action"`
+	// LogMaxStringBytes caps string fields, cutting longer ones down with a
+	// <field>_truncated marker, zero disables it (LOG_MAX_STRING_BYTES)
+	LogMaxStringBytes int `yaml:"log_max_string_bytes"`
+	// LogStringOverflowAction is "truncate" to discard the rest of an
+	// oversized string or "split" to keep it in <field>_overflow
+	// (LOG_STRING_OVERFLOW_ACTION)
+	LogStringOverflowAction string `yaml:"log_string_overflow_action"`
+	// ValidationSchemaPath points to a JSON Schema every log must satisfy
+	// (VALIDATION_SCHEMA_PATH)
+	ValidationSchemaPath string `yaml:"validation_schema_path"`
+	// LogStrictFields rejects logs with fields the schema does not declare,
+	// including the tenant field of /logs/{tenant} (LOG_STRICT_FIELDS)
+	LogStrictFields bool `yaml:"log_strict_fields"`
+	// TypeRequiredFields lists the fields each log type must carry, keyed
+	// by the value of LOG_INDEX_FIELD, as a JSON object such as
+	// {"audit": ["actor", "action"]} (LOG_TYPE_REQUIRED_FIELDS)
+	TypeRequiredFields map[string][]string `yaml:"log_type_required_fields"`
+	// FilterRules drop matching documents before indexing (LOG_FILTER_RULES, JSON)
+	FilterRules []FilterRule `yaml:"log_filter_rules"`
+	// SamplingRules keep a fraction of the documents matching each rule, the
+	// first matching rule deciding (LOG_SAMPLING_RULES, JSON)
+	SamplingRules []SamplingRule `yaml:"log_sampling_rules"`
+	// FieldDenylist lists dotted field paths removed from every log before
+	// validation, comma-separated (LOG_FIELD_DENYLIST)
+	FieldDenylist []string `yaml:"log_field_denylist"`
+
+	// FieldCoercions converts string values of fields to "int", "float" or
+	// "bool", as comma-separated field=type pairs (LOG_FIELD_COERCIONS)
+	FieldCoercions map[string]string `yaml:"log_field_coercions"`
+	// FieldRenames moves fields to their canonical name, as comma-separated
+	// from=to pairs of dotted paths (LOG_FIELD_RENAMES)
+	FieldRenames map[string]string `yaml:"log_field_renames"`
+	// FieldRenameConflict decides what happens when the target already
+	// exists: "keep" both fields, "overwrite" the target or "drop" the
+	// source (LOG_FIELD_RENAME_CONFLICT)
+	FieldRenameConflict string `yaml:"log_field_rename_conflict"`
+
+	// LevelFromStatus derives a missing "level" from the status field (LOG_LEVEL_FROM_STATUS)
+	LevelFromStatus bool `yaml:"log_level_from_status"`
+	// LevelStatusField is the field holding the HTTP status (LOG_LEVEL_STATUS_FIELD)
+	LevelStatusField string `yaml:"log_level_status_field"`
+	// LevelStatusMap maps codes ("404") or classes ("5xx") to levels, as
+	// comma-separated key=value pairs (LOG_LEVEL_STATUS_MAP)
+	LevelStatusMap map[string]string `yaml:"log_level_status_map"`
+	// LevelStatusDefault is used for statuses absent from the map (LOG_LEVEL_STATUS_DEFAULT)
+	LevelStatusDefault string `yaml:"log_level_status_default"`
+	// LevelNormalize rewrites "level" to one of trace, debug, info, warn,
+	// error and fatal (LOG_LEVEL_NORMALIZE)
+	LevelNormalize bool `yaml:"log_level_normalize"`
+	// LevelAliases maps lowercase level spellings to canonical levels, as
+	// comma-separated key=value pairs (LOG_LEVEL_ALIASES)
+	LevelAliases map[string]string `yaml:"log_level_aliases"`
+	// LevelUnknown replaces levels that are neither canonical nor aliased (LOG_LEVEL_UNKNOWN)
+	LevelUnknown string `yaml:"log_level_unknown"`
+
+	// RetentionField receives the retention class of each log (LOG_RETENTION_FIELD)
+	RetentionField string `yaml:"log_retention_field"`
+	// RetentionByTenant maps tenants to retention classes, as comma-separated
+	// key=value pairs (LOG_RETENTION_BY_TENANT)
+	RetentionByTenant map[string]string `yaml:"log_retention_by_tenant"`
+	// RetentionByType maps LOG_INDEX_FIELD values to retention classes, as
+	// comma-separated key=value pairs (LOG_RETENTION_BY_TYPE)
+	RetentionByType map[string]string `yaml:"log_retention_by_type"`
+	// RetentionDefault is the class of logs no rule matches, empty leaves
+	// them untagged (LOG_RETENTION_DEFAULT)
+	RetentionDefault string `yaml:"log_retention_default"`
+}
+
+// defaultConfig returns the settings used when nothing is configured
+func defaultConfig() Config {
+	return Config{
+		Addr:               ":8080",
+		ListenSocketMode:   "0660",
+		AdminAddr:          "127.0.0.1:8081",
+		AdminDeleteMaxDocs: 10000,
+
+		OpenSearchURL:             "http://opensearch:9200",
+		OpenSearchIndex:           "logs",
+		OpenSearchUserAgent:       "telyx-backend/" + buildVersion,
+		OpenSearchTimeout:         10 * time.Second,
+		OpenSearchTimeoutMax:      time.Minute,
+		OpenSearchMaxRetries:      2,
+		OpenSearchRetryBackoff:    100 * time.Millisecond,
+		OpenSearchBreakerCooldown: 30 * time.Second,
+		IndexTypeField:            "log_type",
+		IndexPrefix:               "telyx-",
+		IndexDenylist:             []string{".*", "security-auditlog-*"},
+		IndexExistsCacheTTL:       10 * time.Minute,
+
+		IndexTotalFieldsLimit: 1000,
+		IndexFieldWarnRatio:   0.8,
+		MappingCacheTTL:       30 * time.Second,
+
+		DiskCheckInterval:  10 * time.Second,
+		FieldTypeMaxFields: 10000,
+
+		ReadyCacheTTL: 5 * time.Second,
+		ReadyStaleFor: 15 * time.Second,
+
+		HealthErrorWindow:       time.Minute,
+		HealthDegradedErrorRate: 0.05,
+		HealthErrorMinRequests:  10,
+
+		TraceSampleRatio: 0.1,
+		LogInjectTraceID: true,
+
+		RateLimitBurst:      20,
+		RateLimitMaxClients: 10000,
+
+		GlobalRateLimitBurst: 200,
+		TenantRateLimitBurst: 50,
+
+		NonceCacheSize: 100000,
+
+		CompressionMinSize: 1024,
+
+		RequestIDFormat:    "hex",
+		RequestDeadlineMax: time.Minute,
+		LogRequestIDField:  "request_id",
+
+		LogPreserveNumbers:  true,
+		LogMaxDepth:         64,
+		LogTimestampSources: []string{timestampClient, timestampHeader, timestampIngest},
+		LogTimestampHeader:  "X-Log-Timestamp",
+		LogMaxAgeAction:     maxAgeReject,
+
+		RedactMask:             "[REDACTED]",
+		LogFieldDotReplacement: "_",
+		FieldRenameConflict:    renameKeep,
+
+		AsyncQueueSize:     10000,
+		AsyncBatchSize:     500,
+		AsyncFlushInterval: time.Second,
+
+		IngestCoalesceMaxBatch: 500,
+		WALFsync:               true,
+
+		WSBufferSize:      1000,
+		WSBatchSize:       500,
+		WSFlushInterval:   time.Second,
+		WSMaxMessageBytes: 1 << 20,
+
+		TLSMinVersion: "1.2",
+
+		ShutdownTimeout: 15 * time.Second,
+
+		MaxBodyBytes:    10 << 20,
+		UploadMaxBytes:  1 << 30,
+		UploadBatchSize: 1000,
+		EchoMaxBytes:    64 << 10,
+
+		AdaptiveSamplingLow:     0.5,
+		AdaptiveSamplingHigh:    0.9,
+		AdaptiveSamplingMinRate: 0.1,
+
+		RawBodyMaxBytes: 8192,
+		RawBodyField:    "raw_body",
+
+		ErrorRawMaxBytes: 8192,
+
+		SearchPITKeepAlive:   time.Minute,
+		SearchPITMaxLifetime: 10 * time.Minute,
+
+		CORSAllowedOrigins: []string{"*"},
+		OptionsAllow:       true,
+
+		LogFieldLimitAction:     "reject",
+		LogStringOverflowAction: overflowTruncate,
+
+		LevelStatusField:   "status",
+		LevelStatusMap:     map[string]string{"5xx": "error", "4xx": "warn"},
+		LevelStatusDefault: "info",
+
+		// Syslog severities and their usual spellings
+		LevelAliases: map[string]string{
+			"0": "fatal", "1": "fatal", "2": "fatal", "3": "error",
+			"4": "warn", "5": "info", "6": "info", "7": "debug",
+			"emerg": "fatal", "emergency": "fatal", "alert": "fatal", "crit": "fatal",
+			"critical": "fatal", "panic": "fatal", "err": "error", "warning": "warn",
+			"notice": "info", "information": "info", "informational": "info",
+			"dbg": "debug", "verbose": "trace", "trc": "trace",
+		},
+		LevelUnknown: "info",
+
+		RetentionField: "retention_class",
+	}
+}
+
+// loadConfig builds the configuration with the precedence
+// defaults < config file (YAML or JSON) < environment variables
+func loadConfig(path string) (Config, error) {
+	c := defaultConfig()
+	if path != "" {
+		if err := applyFile(&c, path); err != nil {
+			return c, err
+		}
+	}
+	if err := applyEnv(&c); err != nil {
+		return c, err
+	}
+	return c, c.validate()
+}
+
+// applyFile overrides settings with those present in a config file, rejecting
+// unknown keys so typos fail fast
+func applyFile(c *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+	// YAML is a superset of JSON, so one decoder handles both formats
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides settings with the environment variables that are set
+func applyEnv(c *Config) error {
+	envString("ADDR", &c.Addr)
+	envString("LISTEN_SOCKET", &c.ListenSocket)
+	envString("LISTEN_SOCKET_MODE", &c.ListenSocketMode)
+	envString("ADMIN_ADDR", &c.AdminAddr)
+	envList("ADMIN_ALLOWED_CIDRS", &c.AdminAllowedCIDRs)
+	envList("TRUSTED_PROXIES", &c.TrustedProxies)
+	envString("OPENSEARCH_URL", &c.OpenSearchURL)
+	envList("OPENSEARCH_NODES", &c.OpenSearchNodes)
+	envString("OPENSEARCH_INDEX", &c.OpenSearchIndex)
+	envString("OPENSEARCH_WRITE_ALIAS", &c.OpenSearchWriteAlias)
+	envString("OPENSEARCH_REFRESH", &c.OpenSearchRefresh)
+	envString("OPENSEARCH_WAIT_FOR_ACTIVE_SHARDS", &c.OpenSearchWaitForActiveShards)
+	envString("OPENSEARCH_USER_AGENT", &c.OpenSearchUserAgent)
+	envString("LOG_FIELD_LIMIT_ACTION", &c.LogFieldLimitAction)
+	envString("LOG_STRING_OVERFLOW_ACTION", &c.LogStringOverflowAction)
+	envString("DEAD_LETTER_PATH", &c.DeadLetterPath)
+	envString("ERROR_INDEX", &c.ErrorIndex)
+	envList("LOG_BAGGAGE_KEYS", &c.LogBaggageKeys)
+	envList("REDACT_KEYS", &c.RedactKeys)
+	envString("REDACT_MASK", &c.RedactMask)
+	envString("VALIDATION_SCHEMA_PATH", &c.ValidationSchemaPath)
+	envString("LOG_LEVEL_STATUS_FIELD", &c.LevelStatusField)
+	envString("LOG_LEVEL_STATUS_DEFAULT", &c.LevelStatusDefault)
+	envString("LOG_LEVEL_UNKNOWN", &c.LevelUnknown)
+	envString("LOG_RETENTION_FIELD", &c.RetentionField)
+	envString("LOG_RETENTION_DEFAULT", &c.RetentionDefault)
+	envString("INDEX_MAPPINGS_PATH", &c.IndexMappingsPath)
+	envString("LOG_INDEX_FIELD", &c.IndexTypeField)
+	envString("LOG_INDEX_PREFIX", &c.IndexPrefix)
+	envList("LOG_INDEX_TYPES", &c.IndexTypes)
+	envList("INDEX_ALLOWLIST", &c.IndexAllowlist)
+	envList("INDEX_DENYLIST", &c.IndexDenylist)
+	envString("RAW_BODY_FIELD", &c.RawBodyField)
+	envString("REQUEST_ID_FORMAT", &c.RequestIDFormat)
+	envString("LOG_REQUEST_ID_FIELD", &c.LogRequestIDField)
+	envList("LOG_TIMESTAMP_SOURCES", &c.LogTimestampSources)
+	envString("LOG_TIMESTAMP_HEADER", &c.LogTimestampHeader)
+	envString("LOG_MAX_AGE_ACTION", &c.LogMaxAgeAction)
+	envString("LOG_COLD_INDEX", &c.LogColdIndex)
+	envString("WAL_PATH", &c.WALPath)
+	envString("TLS_CERT_FILE", &c.TLSCertFile)
+	envString("TLS_KEY_FILE", &c.TLSKeyFile)
+	envString("TLS_MIN_VERSION", &c.TLSMinVersion)
+	envList("TLS_CIPHER_SUITES", &c.TLSCipherSuites)
+	envString("TLS_CLIENT_CA", &c.TLSClientCA)
+	envString("LOG_FIELD_DOT_REPLACEMENT", &c.LogFieldDotReplacement)
+	envList("LOG_ALLOWED_UNDERSCORE_FIELDS", &c.LogAllowedUnderscoreFields)
+	envList("LOG_SNAKE_CASE_EXCEPTIONS", &c.LogSnakeCaseExceptions)
+	envList("LOG_FIELD_DENYLIST", &c.FieldDenylist)
+	envString("TRACE_SPAN_NAME_FIELD", &c.TraceSpanNameField)
+	envList("TRACE_SPAN_NAME_VALUES", &c.TraceSpanNameValues)
+	envString("LOG_FIELD_RENAME_CONFLICT", &c.FieldRenameConflict)
+	envList("CORS_ALLOWED_ORIGINS", &c.CORSAllowedOrigins)
+	return errors.Join(
+		envInt("ADMIN_DELETE_MAX_DOCS", &c.AdminDeleteMaxDocs),
+		envBool("ADMIN_DELETE_ENABLED", &c.AdminDeleteEnabled),
+		envBool("ADMIN_METRICS_RESET", &c.AdminMetricsReset),
+		envBool("ENABLE_PPROF", &c.EnablePprof),
+		envDuration("OPENSEARCH_TIMEOUT", &c.OpenSearchTimeout),
+		envDuration("OPENSEARCH_TIMEOUT_MAX", &c.OpenSearchTimeoutMax),
+		envInt("OPENSEARCH_MAX_RETRIES", &c.OpenSearchMaxRetries),
+		envDuration("OPENSEARCH_RETRY_BACKOFF", &c.OpenSearchRetryBackoff),
+		envInt("OPENSEARCH_BREAKER_THRESHOLD", &c.OpenSearchBreakerThreshold),
+		envDuration("OPENSEARCH_BREAKER_COOLDOWN", &c.OpenSearchBreakerCooldown),
+		envDuration("INDEX_EXISTS_CACHE_TTL", &c.IndexExistsCacheTTL),
+		envBool("OPENSEARCH_USE_DATA_STREAM", &c.OpenSearchUseDataStream),
+		envBool("LOG_INDEX_PER_TENANT", &c.IndexPerTenant),
+		envMap("OPENSEARCH_HEADERS", &c.OpenSearchHeaders),
+		envTraceExporters(&c.TraceExporters),
+		envDuration("INDEX_FIELD_CHECK_INTERVAL", &c.IndexFieldCheckInterval),
+		envInt("INDEX_TOTAL_FIELDS_LIMIT", &c.IndexTotalFieldsLimit),
+		envFloat("INDEX_FIELD_WARN_RATIO", &c.IndexFieldWarnRatio),
+		envDuration("MAPPING_CACHE_TTL", &c.MappingCacheTTL),
+		envFloat("FIELD_TYPE_SAMPLE_RATE", &c.FieldTypeSampleRate),
+		envInt("FIELD_TYPE_MAX_FIELDS", &c.FieldTypeMaxFields),
+		envDuration("READY_CACHE_TTL", &c.ReadyCacheTTL),
+		envDuration("READY_STALE_FOR", &c.ReadyStaleFor),
+		envDuration("READY_WARMUP", &c.ReadyWarmup),
+		envDuration("SEARCH_PIT_KEEP_ALIVE", &c.SearchPITKeepAlive),
+		envDuration("SEARCH_PIT_MAX_LIFETIME", &c.SearchPITMaxLifetime),
+		envInt("READY_WARMUP_CONNS", &c.ReadyWarmupConns),
+		envDuration("HEALTH_ERROR_WINDOW", &c.HealthErrorWindow),
+		envFloat("HEALTH_DEGRADED_ERROR_RATE", &c.HealthDegradedErrorRate),
+		envInt("HEALTH_ERROR_MIN_REQUESTS", &c.HealthErrorMinRequests),
+		envInt64("MAX_BODY_BYTES", &c.MaxBodyBytes),
+		envInt64("MIN_FREE_DISK_BYTES", &c.MinFreeDiskBytes),
+		envInt("ERROR_RAW_MAX_BYTES", &c.ErrorRawMaxBytes),
+		envDuration("DISK_CHECK_INTERVAL", &c.DiskCheckInterval),
+		envInt("ECHO_MAX_BYTES", &c.EchoMaxBytes),
+		envInt64("UPLOAD_MAX_BYTES", &c.UploadMaxBytes),
+		envMap("ROUTE_MAX_BODY_BYTES", &c.RouteMaxBodyBytes),
+		envInt("UPLOAD_BATCH_SIZE", &c.UploadBatchSize),
+		envInt64("MAX_INFLIGHT_BYTES", &c.MaxInflightBytes),
+		envInt("BULK_MAX_CONCURRENCY", &c.BulkMaxConcurrency),
+		envBool("ADAPTIVE_SAMPLING", &c.AdaptiveSampling),
+		envFloat("ADAPTIVE_SAMPLING_LOW", &c.AdaptiveSamplingLow),
+		envFloat("ADAPTIVE_SAMPLING_HIGH", &c.AdaptiveSamplingHigh),
+		envFloat("ADAPTIVE_SAMPLING_MIN_RATE", &c.AdaptiveSamplingMinRate),
+		envFloat("TRACE_SAMPLE_RATIO", &c.TraceSampleRatio),
+		envBool("LOG_STRICT_FIELDS", &c.LogStrictFields),
+		envBool("LOG_PRESERVE_NUMBERS", &c.LogPreserveNumbers),
+		envInt("LOG_MAX_DEPTH", &c.LogMaxDepth),
+		envBool("LOG_SANITIZE_FIELD_NAMES", &c.LogSanitizeFieldNames),
+		envBool("LOG_SNAKE_CASE_FIELDS", &c.LogSnakeCaseFields),
+		envBool("INGEST_ASYNC", &c.IngestAsync),
+		envInt("ASYNC_QUEUE_SIZE", &c.AsyncQueueSize),
+		envInt("ASYNC_BATCH_SIZE", &c.AsyncBatchSize),
+		envDuration("ASYNC_FLUSH_INTERVAL", &c.AsyncFlushInterval),
+		envDuration("INGEST_COALESCE_WINDOW", &c.IngestCoalesceWindow),
+		envInt("INGEST_COALESCE_MAX_BATCH", &c.IngestCoalesceMaxBatch),
+		envBool("WAL_FSYNC", &c.WALFsync),
+		envInt("WS_BUFFER_SIZE", &c.WSBufferSize),
+		envInt("WS_BATCH_SIZE", &c.WSBatchSize),
+		envDuration("WS_FLUSH_INTERVAL", &c.WSFlushInterval),
+		envInt64("WS_MAX_MESSAGE_BYTES", &c.WSMaxMessageBytes),
+		envBool("ENABLE_H2C", &c.EnableH2C),
+		envDuration("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout),
+		envDuration("LOG_MAX_AGE", &c.LogMaxAge),
+		envFloat("RAW_BODY_SAMPLE_RATE", &c.RawBodySampleRate),
+		envInt("RAW_BODY_MAX_BYTES", &c.RawBodyMaxBytes),
+		envBool("LOG_INJECT_TRACE_ID", &c.LogInjectTraceID),
+		envBool("TRACE_ALLOW_FORCE", &c.TraceAllowForce),
+		envBool("TRACE_RESPONSE_HEADERS", &c.TraceResponseHeaders),
+		envDuration("SLOW_REQUEST_THRESHOLD", &c.SlowRequestThreshold),
+		envDuration("REQUEST_DEADLINE_MAX", &c.RequestDeadlineMax),
+		envFloat("RATE_LIMIT_RPS", &c.RateLimitRPS),
+		envInt("RATE_LIMIT_BURST", &c.RateLimitBurst),
+		envInt("RATE_LIMIT_MAX_CLIENTS", &c.RateLimitMaxClients),
+		envFloat("GLOBAL_RATE_LIMIT_RPS", &c.GlobalRateLimitRPS),
+		envInt("GLOBAL_RATE_LIMIT_BURST", &c.GlobalRateLimitBurst),
+		envMap("TENANT_API_KEYS", &c.TenantAPIKeys),
+		envFloat("TENANT_RATE_LIMIT_RPS", &c.TenantRateLimitRPS),
+		envInt("TENANT_RATE_LIMIT_BURST", &c.TenantRateLimitBurst),
+		envMap("TENANT_RATE_LIMITS", &c.TenantRateLimits),
+		envDuration("NONCE_TTL", &c.NonceTTL),
+		envInt("NONCE_CACHE_SIZE", &c.NonceCacheSize),
+		envBool("NONCE_REQUIRED", &c.NonceRequired),
+		envInt("COMPRESSION_MIN_SIZE", &c.CompressionMinSize),
+		envDuration("CORS_MAX_AGE", &c.CORSMaxAge),
+		envBool("CORS_ALLOW_CREDENTIALS", &c.CORSAllowCredentials),
+		envBool("HTTP_OPTIONS_ALLOW", &c.OptionsAllow),
+		envInt("LOG_MIN_FIELDS", &c.LogMinFields),
+		envInt("LOG_MAX_FIELDS", &c.LogMaxFields),
+		envInt("LOG_MAX_STRING_BYTES", &c.LogMaxStringBytes),
+		envJSON("LOG_FILTER_RULES", &c.FilterRules),
+		envJSON("LOG_SAMPLING_RULES", &c.SamplingRules),
+		envJSON("LOG_TYPE_REQUIRED_FIELDS", &c.TypeRequiredFields),
+		envJSON("REDACT_PATTERNS", &c.RedactPatterns),
+		envMap("LOG_FIELD_COERCIONS", &c.FieldCoercions),
+		envMap("LOG_FIELD_RENAMES", &c.FieldRenames),
+		envBool("LOG_LEVEL_FROM_STATUS", &c.LevelFromStatus),
+		envMap("LOG_LEVEL_STATUS_MAP", &c.LevelStatusMap),
+		envBool("LOG_LEVEL_NORMALIZE", &c.LevelNormalize),
+		envMap("LOG_LEVEL_ALIASES", &c.LevelAliases),
+		envMap("LOG_RETENTION_BY_TENANT", &c.RetentionByTenant),
+		envMap("LOG_RETENTION_BY_TYPE", &c.RetentionByType),
+	)
+}
+
+// validate rejects combinations of settings that cannot work together
+func (c *Config) validate() error {
+	var errs []error
+	if c.OpenSearchURL == "" || c.OpenSearchIndex == "" {
+		errs = append(errs, errors.New("OPENSEARCH_URL and OPENSEARCH_INDEX are required"))
+	}
+	for _, n := range c.OpenSearchNodes {
+		if u, err := url.Parse(n); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("OPENSEARCH_NODES: invalid node URL %q", n))
+		}
+	}
+	if c.OpenSearchUseDataStream && c.OpenSearchWriteAlias != "" {
+		errs = append(errs, errors.New("OPENSEARCH_USE_DATA_STREAM cannot be combined with OPENSEARCH_WRITE_ALIAS"))
+	}
+	for _, p := range append(slices.Clone(c.IndexAllowlist), c.IndexDenylist...) {
+		if _, err := path.Match(p, ""); err != nil {
+			errs = append(errs, fmt.Errorf("INDEX_ALLOWLIST/INDEX_DENYLIST: bad pattern %q", p))
+		}
+	}
+	for _, t := range c.IndexTypes {
+		if t != sanitizeIndexName(t) || sanitizeIndexName(c.IndexPrefix+t) != c.IndexPrefix+t {
+			errs = append(errs, fmt.Errorf("LOG_INDEX_TYPES: %q does not form a valid index name", t))
+		}
+	}
+	for k, v := range c.OpenSearchHeaders {
+		if !httpguts.ValidHeaderFieldName(k) || !httpguts.ValidHeaderFieldValue(v) {
+			errs = append(errs, fmt.Errorf("OPENSEARCH_HEADERS: invalid header %q", k))
+		}
+	}
+	if !httpguts.ValidHeaderFieldValue(c.OpenSearchUserAgent) {
+		errs = append(errs, errors.New("OPENSEARCH_USER_AGENT is not a valid header value"))
+	}
+	if c.IndexFieldCheckInterval > 0 && (c.IndexTotalFieldsLimit <= 0 || !(c.IndexFieldWarnRatio > 0 && c.IndexFieldWarnRatio <= 1)) {
+		errs = append(errs, errors.New("INDEX_TOTAL_FIELDS_LIMIT must be positive and INDEX_FIELD_WARN_RATIO between 0 and 1"))
+	}
+	if c.ErrorIndex != "" && sanitizeIndexName(c.ErrorIndex) != c.ErrorIndex {
+		errs = append(errs, fmt.Errorf("ERROR_INDEX: %q is not a valid index name", c.ErrorIndex))
+	}
+	if c.ErrorRawMaxBytes < 0 {
+		errs = append(errs, errors.New("ERROR_RAW_MAX_BYTES must not be negative"))
+	}
+	if c.MinFreeDiskBytes < 0 {
+		errs = append(errs, errors.New("MIN_FREE_DISK_BYTES must not be negative"))
+	}
+	if c.MinFreeDiskBytes > 0 && (c.DiskCheckInterval <= 0 || len(spoolDirs(*c)) == 0) {
+		errs = append(errs, errors.New("MIN_FREE_DISK_BYTES requires DEAD_LETTER_PATH or WAL_PATH and a positive DISK_CHECK_INTERVAL"))
+	}
+	if c.MappingCacheTTL < 0 {
+		errs = append(errs, errors.New("MAPPING_CACHE_TTL must not be negative"))
+	}
+	if !(c.FieldTypeSampleRate >= 0 && c.FieldTypeSampleRate <= 1) {
+		errs = append(errs, errors.New("FIELD_TYPE_SAMPLE_RATE must be between 0 and 1"))
+	}
+	if c.FieldTypeSampleRate > 0 && c.FieldTypeMaxFields <= 0 {
+		errs = append(errs, errors.New("FIELD_TYPE_MAX_FIELDS must be positive"))
+	}
+	if c.ReadyCacheTTL <= 0 {
+		errs = append(errs, errors.New("READY_CACHE_TTL must be positive"))
+	}
+	for _, e := range c.TraceExporters {
+		if err := e.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if !(c.TraceSampleRatio >= 0 && c.TraceSampleRatio <= 1) {
+		errs = append(errs, errors.New("TRACE_SAMPLE_RATIO must be between 0 and 1"))
+	}
+	if c.TraceSpanNameField != "" && len(c.TraceSpanNameValues) == 0 {
+		errs = append(errs, errors.New("TRACE_SPAN_NAME_FIELD requires TRACE_SPAN_NAME_VALUES"))
+	}
+	if !(c.RawBodySampleRate >= 0 && c.RawBodySampleRate <= 1) || c.RawBodyMaxBytes < 0 {
+		errs = append(errs, errors.New("RAW_BODY_SAMPLE_RATE must be between 0 and 1 and RAW_BODY_MAX_BYTES not negative"))
+	}
+	if c.LogStrictFields && c.ValidationSchemaPath == "" {
+		errs = append(errs, errors.New("LOG_STRICT_FIELDS requires VALIDATION_SCHEMA_PATH"))
+	}
+	if c.MaxBodyBytes < 0 {
+		errs = append(errs, errors.New("MAX_BODY_BYTES must not be negative"))
+	}
+	if c.UploadMaxBytes < 1 || c.UploadBatchSize < 1 {
+		errs = append(errs, errors.New("UPLOAD_MAX_BYTES and UPLOAD_BATCH_SIZE must be positive"))
+	}
+	for route, limit := range c.RouteMaxBodyBytes {
+		if n, err := strconv.ParseInt(limit, 10, 64); err != nil || n < 0 || !strings.HasPrefix(route, "/") {
+			errs = append(errs, fmt.Errorf("ROUTE_MAX_BODY_BYTES: invalid limit %q for route %q", limit, route))
+		}
+	}
+	if c.BulkMaxConcurrency < 0 {
+		errs = append(errs, errors.New("BULK_MAX_CONCURRENCY must not be negative"))
+	}
+	if c.EchoMaxBytes < 0 {
+		errs = append(errs, errors.New("ECHO_MAX_BYTES must not be negative"))
+	}
+	if c.AdaptiveSampling && !(c.AdaptiveSamplingLow >= 0 && c.AdaptiveSamplingLow < c.AdaptiveSamplingHigh && c.AdaptiveSamplingHigh <= 1 &&
+		c.AdaptiveSamplingMinRate >= 0 && c.AdaptiveSamplingMinRate <= 1) {
+		errs = append(errs, errors.New("ADAPTIVE_SAMPLING_LOW must be below ADAPTIVE_SAMPLING_HIGH, both between 0 and 1, and ADAPTIVE_SAMPLING_MIN_RATE between 0 and 1"))
+	}
+	if c.IngestAsync && (c.AsyncQueueSize < 1 || c.AsyncBatchSize < 1 || c.AsyncFlushInterval <= 0) {
+		errs = append(errs, errors.New("ASYNC_QUEUE_SIZE, ASYNC_BATCH_SIZE and ASYNC_FLUSH_INTERVAL must be positive"))
+	}
+	if c.IngestCoalesceWindow < 0 || c.IngestCoalesceMaxBatch < 1 {
+		errs = append(errs, errors.New("INGEST_COALESCE_WINDOW must not be negative and INGEST_COALESCE_MAX_BATCH must be positive"))
+	}
+	if c.WALPath != "" && !c.IngestAsync {
+		errs = append(errs, errors.New("WAL_PATH requires INGEST_ASYNC"))
+	}
+	if c.WSBufferSize < 0 || c.WSBatchSize < 1 || c.WSFlushInterval <= 0 || c.WSMaxMessageBytes < 1 {
+		errs = append(errs, errors.New("WS_BATCH_SIZE, WS_FLUSH_INTERVAL and WS_MAX_MESSAGE_BYTES must be positive and WS_BUFFER_SIZE not negative"))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	if c.TLSClientCA != "" && c.TLSCertFile == "" {
+		errs = append(errs, errors.New("TLS_CLIENT_CA requires TLS_CERT_FILE"))
+	}
+	if _, err := strconv.ParseUint(c.ListenSocketMode, 8, 32); err != nil {
+		errs = append(errs, fmt.Errorf("LISTEN_SOCKET_MODE: invalid octal mode %q", c.ListenSocketMode))
+	}
+	if c.EnableH2C && c.TLSCertFile != "" {
+		errs = append(errs, errors.New("ENABLE_H2C cannot be used with TLS_CERT_FILE, TLS already negotiates HTTP/2"))
+	}
+	if _, ok := tlsVersions[c.TLSMinVersion]; !ok {
+		errs = append(errs, fmt.Errorf("TLS_MIN_VERSION: unsupported version %q", c.TLSMinVersion))
+	}
+	if _, err := cipherSuiteIDs(c.TLSCipherSuites); err != nil {
+		errs = append(errs, fmt.Errorf("TLS_CIPHER_SUITES: %w", err))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("SHUTDOWN_TIMEOUT must be positive"))
+	}
+	if c.LogSanitizeFieldNames && strings.Contains(c.LogFieldDotReplacement, ".") {
+		errs = append(errs, errors.New("LOG_FIELD_DOT_REPLACEMENT must not contain a dot"))
+	}
+	if c.RawBodySampleRate > 0 && c.RawBodyField == "" {
+		errs = append(errs, errors.New("RAW_BODY_FIELD must be set when RAW_BODY_SAMPLE_RATE is enabled"))
+	}
+	if c.GlobalRateLimitRPS > 0 && c.GlobalRateLimitBurst < 1 {
+		errs = append(errs, errors.New("GLOBAL_RATE_LIMIT_BURST must be positive"))
+	}
+	if c.RateLimitRPS > 0 && (c.RateLimitBurst < 1 || c.RateLimitMaxClients < 1) {
+		errs = append(errs, errors.New("RATE_LIMIT_BURST and RATE_LIMIT_MAX_CLIENTS must be positive"))
+	}
+	if c.TenantRateLimitRPS > 0 && c.TenantRateLimitBurst < 1 {
+		errs = append(errs, errors.New("TENANT_RATE_LIMIT_BURST must be positive"))
+	}
+	for tenant, limit := range c.TenantRateLimits {
+		if _, _, err := parseTenantLimit(limit); err != nil {
+			errs = append(errs, fmt.Errorf("TENANT_RATE_LIMITS: tenant %q: %w", tenant, err))
+		}
+	}
+	if (c.TenantRateLimitRPS > 0 || len(c.TenantRateLimits) > 0) && len(c.TenantAPIKeys) == 0 {
+		errs = append(errs, errors.New("TENANT_API_KEYS must be set to limit tenants"))
+	}
+	if c.NonceTTL < 0 || (c.NonceTTL > 0 && c.NonceCacheSize < 1) {
+		errs = append(errs, errors.New("NONCE_TTL must not be negative and NONCE_CACHE_SIZE must be positive"))
+	}
+	if c.NonceRequired && c.NonceTTL == 0 {
+		errs = append(errs, errors.New("NONCE_REQUIRED requires NONCE_TTL"))
+	}
+	if c.CORSAllowCredentials && slices.Contains(c.CORSAllowedOrigins, "*") {
+		errs = append(errs, errors.New("CORS_ALLOW_CREDENTIALS cannot be used with a wildcard origin"))
+	}
+	if c.AdminDeleteMaxDocs < 1 {
+		errs = append(errs, errors.New("ADMIN_DELETE_MAX_DOCS must be positive"))
+	}
+	if _, err := parsePrefixes(c.AdminAllowedCIDRs); err != nil {
+		errs = append(errs, fmt.Errorf("ADMIN_ALLOWED_CIDRS: %w", err))
+	}
+	if _, err := parsePrefixes(c.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("TRUSTED_PROXIES: %w", err))
+	}
+	if c.RetentionField == "" && (len(c.RetentionByTenant) > 0 || len(c.RetentionByType) > 0 || c.RetentionDefault != "") {
+		errs = append(errs, errors.New("LOG_RETENTION_FIELD must be set when retention classes are configured"))
+	}
+	if c.LevelNormalize {
+		if !canonicalLevels[c.LevelUnknown] {
+			errs = append(errs, fmt.Errorf("LOG_LEVEL_UNKNOWN: %q is not a canonical level", c.LevelUnknown))
+		}
+		for alias, level := range c.LevelAliases {
+			if !canonicalLevels[level] {
+				errs = append(errs, fmt.Errorf("LOG_LEVEL_ALIASES: %s maps to %q, which is not a canonical level", alias, level))
+			}
+		}
+	}
+	for _, p := range c.RedactPatterns {
+		if _, err := regexp.Compile(p); err != nil {
+			errs = append(errs, fmt.Errorf("REDACT_PATTERNS: %w", err))
+		}
+	}
+	if (len(c.RedactKeys) > 0 || len(c.RedactPatterns) > 0) && c.RedactMask == "" {
+		errs = append(errs, errors.New("REDACT_MASK must not be empty"))
+	}
+	if c.LogMaxDepth < 0 {
+		errs = append(errs, errors.New("LOG_MAX_DEPTH must not be negative"))
+	}
+	if c.IndexExistsCacheTTL <= 0 {
+		errs = append(errs, errors.New("INDEX_EXISTS_CACHE_TTL must be positive"))
+	}
+	if c.OpenSearchTimeoutMax <= 0 {
+		errs = append(errs, errors.New("OPENSEARCH_TIMEOUT_MAX must be positive"))
+	}
+	if c.RequestDeadlineMax <= 0 {
+		errs = append(errs, errors.New("REQUEST_DEADLINE_MAX must be positive"))
+	}
+	if len(c.LogTimestampSources) == 0 {
+		errs = append(errs, errors.New("LOG_TIMESTAMP_SOURCES must not be empty"))
+	}
+	for _, s := range c.LogTimestampSources {
+		if !timestampSources[s] {
+			errs = append(errs, fmt.Errorf("LOG_TIMESTAMP_SOURCES: unknown source %q", s))
+		}
+	}
+	if c.OpenSearchUseDataStream && !slices.Contains(c.LogTimestampSources, timestampIngest) {
+		errs = append(errs, errors.New("LOG_TIMESTAMP_SOURCES must include ingest when OPENSEARCH_USE_DATA_STREAM is set"))
+	}
+	if c.LogMaxAge < 0 {
+		errs = append(errs, errors.New("LOG_MAX_AGE must not be negative"))
+	}
+	if !maxAgeActions[c.LogMaxAgeAction] {
+		errs = append(errs, fmt.Errorf("LOG_MAX_AGE_ACTION: unknown action %q", c.LogMaxAgeAction))
+	}
+	if c.LogMaxAge > 0 && c.LogMaxAgeAction == maxAgeRoute && c.LogColdIndex == "" {
+		errs = append(errs, errors.New("LOG_COLD_INDEX must be set when LOG_MAX_AGE_ACTION is route"))
+	}
+	if _, ok := requestIDFormats[c.RequestIDFormat]; !ok {
+		errs = append(errs, fmt.Errorf("REQUEST_ID_FORMAT: unknown format %q", c.RequestIDFormat))
+	}
+	if c.CORSMaxAge < 0 {
+		errs = append(errs, errors.New("CORS_MAX_AGE must not be negative"))
+	}
+	if c.OpenSearchRefresh != "" && !refreshModes[c.OpenSearchRefresh] {
+		errs = append(errs, fmt.Errorf("OPENSEARCH_REFRESH: unknown mode %q", c.OpenSearchRefresh))
+	}
+	if v := c.OpenSearchWaitForActiveShards; v != "" && v != "all" {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 {
+			errs = append(errs, fmt.Errorf("OPENSEARCH_WAIT_FOR_ACTIVE_SHARDS must be a positive count or all, got %q", v))
+		}
+	}
+	if c.OpenSearchMaxRetries < 0 || c.OpenSearchRetryBackoff < 0 {
+		errs = append(errs, errors.New("OPENSEARCH_MAX_RETRIES and OPENSEARCH_RETRY_BACKOFF must not be negative"))
+	}
+	if c.OpenSearchBreakerThreshold < 0 || c.OpenSearchBreakerCooldown < 0 {
+		errs = append(errs, errors.New("OPENSEARCH_BREAKER_THRESHOLD and OPENSEARCH_BREAKER_COOLDOWN must not be negative"))
+	}
+	if c.SearchPITKeepAlive < time.Second || c.SearchPITMaxLifetime < c.SearchPITKeepAlive {
+		errs = append(errs, errors.New("SEARCH_PIT_KEEP_ALIVE must be at least 1s and SEARCH_PIT_MAX_LIFETIME at least as long"))
+	}
+	if c.ReadyWarmup < 0 || c.ReadyWarmupConns < 0 {
+		errs = append(errs, errors.New("READY_WARMUP and READY_WARMUP_CONNS must not be negative"))
+	}
+	if c.HealthErrorWindow <= 0 || !(c.HealthDegradedErrorRate >= 0 && c.HealthDegradedErrorRate <= 1) || c.HealthErrorMinRequests < 0 {
+		errs = append(errs, errors.New("HEALTH_ERROR_WINDOW must be positive, HEALTH_DEGRADED_ERROR_RATE between 0 and 1 and HEALTH_ERROR_MIN_REQUESTS not negative"))
+	}
+	if c.LogMinFields < 0 {
+		errs = append(errs, errors.New("LOG_MIN_FIELDS must not be negative"))
+	}
+	for logType, fields := range c.TypeRequiredFields {
+		if slices.Contains(fields, "") {
+			errs = append(errs, fmt.Errorf("LOG_TYPE_REQUIRED_FIELDS: empty field name for type %q", logType))
+		}
+	}
+	if c.LogFieldLimitAction != "reject" && c.LogFieldLimitAction != "truncate" {
+		errs = append(errs, fmt.Errorf("LOG_FIELD_LIMIT_ACTION: unknown action %q", c.LogFieldLimitAction))
+	}
+	if c.LogMaxStringBytes < 0 {
+		errs = append(errs, errors.New("LOG_MAX_STRING_BYTES must not be negative"))
+	}
+	if c.LogStringOverflowAction != overflowTruncate && c.LogStringOverflowAction != overflowSplit {
+		errs = append(errs, fmt.Errorf("LOG_STRING_OVERFLOW_ACTION: unknown action %q", c.LogStringOverflowAction))
+	}
+	if !renameConflictPolicies[c.FieldRenameConflict] {
+		errs = append(errs, fmt.Errorf("LOG_FIELD_RENAME_CONFLICT: unknown policy %q", c.FieldRenameConflict))
+	}
+	for from, to := range c.FieldRenames {
+		if to == "" || from == to {
+			errs = append(errs, fmt.Errorf("LOG_FIELD_RENAMES: invalid rename of %q", from))
+		}
+	}
+	for field, kind := range c.FieldCoercions {
+		if kind != "int" && kind != "float" && kind != "bool" {
+			errs = append(errs, fmt.Errorf("LOG_FIELD_COERCIONS: unknown type %q for %s", kind, field))
+		}
+	}
+	for i := range c.FilterRules {
+		if err := c.FilterRules[i].compile(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i := range c.SamplingRules {
+		if err := c.SamplingRules[i].compile(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func envString(key string, dst *string) {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = v
+	}
+}
+
+// envList splits a comma-separated variable, dropping empty entries
+func envList(key string, dst *[]string) {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return
+	}
+	list := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*dst = list
+}
+
+// envMap parses comma-separated key=value pairs
+func envMap(key string, dst *map[string]string) error {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return nil
+	}
+	m := map[string]string{}
+	for _, pair := range strings.Split(v, ",") {
+		k, val, found := strings.Cut(pair, "=")
+		if k = strings.TrimSpace(k); !found || k == "" {
+			return fmt.Errorf("%s: expected key=value, got %q", key, pair)
+		}
+		m[k] = strings.TrimSpace(val)
+	}
+	*dst = m
+	return nil
+}
+
+func envBool(key string, dst *bool) error {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = b
+	return nil
+}
+
+func envInt(key string, dst *int) error {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = n
+	return nil
+}
+
+func envInt64(key string, dst *int64) error {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = n
+	return nil
+}
+
+func envFloat(key string, dst *float64) error {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = f
+	return nil
+}
+
+func envDuration(key string, dst *time.Duration) error {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	*dst = d
+	return nil
+}
+
+// envJSON decodes a variable holding a JSON document into dst
+func envJSON(key string, dst interface{}) error {
+	v, ok := os.LookupEnv(key)
+	if !ok || v == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(v), dst); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	for _, tc := range []struct {
+		name, content string
+	}{
+		{"config.yaml", "opensearch_index: app-logs\nrate_limit_rps: 25\nopensearch_timeout: 3s\ncors_allowed_origins:\n  - https://dashboard.example\n"},
+		{"config.json", `{"opensearch_index": "app-logs", "rate_limit_rps": 25, "opensearch_timeout": "3s", "cors_allowed_origins": ["https://dashboard.example"]}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := loadConfig(writeConfigFile(t, tc.name, tc.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.OpenSearchIndex != "app-logs" || c.RateLimitRPS != 25 || c.OpenSearchTimeout != 3*time.Second {
+				t.Errorf("file values not applied: index %q, rps %v, timeout %v", c.OpenSearchIndex, c.RateLimitRPS, c.OpenSearchTimeout)
+			}
+			if len(c.CORSAllowedOrigins) != 1 || c.CORSAllowedOrigins[0] != "https://dashboard.example" {
+				t.Errorf("CORSAllowedOrigins = %v", c.CORSAllowedOrigins)
+			}
+			if c.OpenSearchURL != defaultConfig().OpenSearchURL {
+				t.Errorf("unset OpenSearchURL = %q, want the default", c.OpenSearchURL)
+			}
+		})
+	}
+}
+
+func TestLoadConfigEnvOverridesFile(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "opensearch_index: from-file\nrate_limit_rps: 25\n")
+	t.Setenv("OPENSEARCH_INDEX", "from-env")
+
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.OpenSearchIndex != "from-env" {
+		t.Errorf("OpenSearchIndex = %q, want the env value", c.OpenSearchIndex)
+	}
+	if c.RateLimitRPS != 25 {
+		t.Errorf("RateLimitRPS = %v, want the file value", c.RateLimitRPS)
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, content, want string
+	}{
+		{"unknown key", "opensearch_idx: typo\n", "opensearch_idx"},
+		{"bad type", "rate_limit_rps: lots\n", "config file"},
+		{"invalid value", "log_field_limit_action: shrug\n", "LOG_FIELD_LIMIT_ACTION"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfigFile(t, "config.yaml", tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("err = %v, want one mentioning %q", err, tc.want)
+			}
+		})
+	}
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing file loaded")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// timestampField is the field holding the event time of stored logs. Data
+// streams require it to be @timestamp.
+func timestampField() string {
+	if cfg.OpenSearchUseDataStream {
+		return "@timestamp"
+	}
+	return "timestamp"
+}
+
+// bulkAction is the _bulk action used for each document. Data streams are
+// append-only and only accept create.
+func bulkAction() string {
+	if cfg.OpenSearchUseDataStream {
+		return "create"
+	}
+	return "index"
+}
+
+// validateDataStreamTimestamp ensures @timestamp, when supplied by the client,
+// is something OpenSearch can map as a date
+func validateDataStreamTimestamp(logData map[string]interface{}) error {
+	if !cfg.OpenSearchUseDataStream {
+		return nil
+	}
+	v, ok := logData["@timestamp"]
+	if !ok {
+		return nil
+	}
+	switch t := v.(type) {
+	case string:
+		if _, err := time.Parse(time.RFC3339Nano, t); err != nil {
+			return fmt.Errorf("@timestamp must be an RFC 3339 date: %q", t)
+		}
+	case float64, json.Number:
+		// epoch milliseconds
+	default:
+		return fmt.Errorf("@timestamp must be a date string or epoch milliseconds")
+	}
+	return nil
+}
+
+// bootstrapDataStream makes sure the index template enabling data streams
+// and the data stream itself exist
+func bootstrapDataStream(ctx context.Context) error {
+	name := cfg.OpenSearchIndex
+	template := name + "-template"
+
+	// Configured mappings may have changed, so the template is always
+	// updated then; otherwise an existing template is left alone
+	install := indexMappings != nil
+	if !install {
+		status, _, err := osRequest(ctx, "bootstrap", http.MethodHead, osURL("_index_template", template), nil)
+		if err != nil {
+			return fmt.Errorf("check index template %s: %w", template, err)
+		}
+		install = status != http.StatusOK
+	}
+	if install {
+		if err := putIndexTemplate(ctx, template, indexTemplate(true)); err != nil {
+			return err
+		}
+	}
+
+	status, _, err := osRequest(ctx, "bootstrap", http.MethodGet, osURL("_data_stream", name), nil)
+	if err != nil {
+		return fmt.Errorf("check data stream %s: %w", name, err)
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	status, _, err = osRequest(ctx, "bootstrap", http.MethodPut, osURL("_data_stream", name), nil)
+	if err != nil {
+		return fmt.Errorf("create data stream %s: %w", name, err)
+	}
+	if status >= 400 {
+		return fmt.Errorf("create data stream %s: OpenSearch returned %d", name, status)
+	}
+	log.Printf("Created data stream %s", name)
+	return nil
+}
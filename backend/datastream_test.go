@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestDataStreamBootstrap(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.OpenSearchIndex = "logs-app"
+		c.OpenSearchUseDataStream = true
+	})
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		if c.Method == http.MethodHead || c.Method == http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+			return true
+		}
+		return false
+	})
+	if err := bootstrapDataStream(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var template, stream bool
+	for _, c := range fake.requests() {
+		if c.Method != http.MethodPut {
+			continue
+		}
+		switch c.Path {
+		case "/_index_template/logs-app-template":
+			var body struct {
+				DataStream *struct{} `json:"data_stream"`
+			}
+			json.Unmarshal(c.Body, &body)
+			template = body.DataStream != nil
+		case "/_data_stream/logs-app":
+			stream = true
+		}
+	}
+	if !template || !stream {
+		t.Errorf("data stream template installed %v, stream created %v: %+v", template, stream, fake.requests())
+	}
+}
+
+func TestDataStreamUsesCreateAction(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.OpenSearchIndex = "logs-app"
+		c.OpenSearchUseDataStream = true
+	})
+	rec := postJSON("/logs/bulk", `[{"message":"one"},{"message":"two","@timestamp":"2026-10-15T08:00:00Z"}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	writes := fake.writes()
+	if len(writes) != 1 || writes[0].Path != "/_bulk" {
+		t.Fatalf("writes = %+v, want one _bulk call", writes)
+	}
+	actions, sources := bulkLines(writes[0].Body)
+	for i, line := range actions {
+		var action map[string]struct {
+			Index string `json:"_index"`
+		}
+		if err := json.Unmarshal(line, &action); err != nil {
+			t.Fatal(err)
+		}
+		if create, ok := action["create"]; !ok || create.Index != "logs-app" {
+			t.Errorf("action %d = %s, want create on logs-app", i, line)
+		}
+		if _, ok := decodeDoc(t, sources[i])["@timestamp"]; !ok {
+			t.Errorf("document %d has no @timestamp", i)
+		}
+	}
+
+	rec = postJSON("/logs", `{"message":"three"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("single log: status %d: %s", rec.Code, rec.Body)
+	}
+	single := fake.writes()[1]
+	if single.Path != "/logs-app/_doc" || single.Query.Get("op_type") != "create" {
+		t.Errorf("single log written to %s?%s, want create on logs-app", single.Path, single.Query.Encode())
+	}
+}
+
+func TestDataStreamRejectsBadTimestamp(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.OpenSearchUseDataStream = true })
+	if rec := postJSON("/logs", `{"message":"hi","@timestamp":"yesterday"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want 422: %s", rec.Code, rec.Body)
+	}
+	if len(fake.writes()) != 0 {
+		t.Error("log with a bad @timestamp was indexed")
+	}
+}
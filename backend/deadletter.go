@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// deadLetterEntry is one line of the dead-letter file
+type deadLetterEntry struct {
+	FailedAt string                 `json:"failed_at"`
+	Error    string                 `json:"error"`
+	Log      map[string]interface{} `json:"log"`
+	// Meta keeps the client's _id and version, which were taken out of Log
+	Meta *docMeta `json:"meta,omitempty"`
+}
+
+func newDeadLetterEntry(logData map[string]interface{}, meta docMeta, reason string) deadLetterEntry {
+	e := deadLetterEntry{FailedAt: time.Now().Format(time.RFC3339), Error: reason, Log: logData}
+	if meta != (docMeta{}) {
+		e.Meta = &meta
+	}
+	return e
+}
+
+// deadLetterSink appends logs that could not be indexed to an NDJSON file so
+// they can be replayed later. A nil sink discards everything.
+type deadLetterSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+var deadLetters *deadLetterSink
+
+func newDeadLetterSink(path string) *deadLetterSink {
+	return &deadLetterSink{path: path}
+}
+
+// write appends entries to the dead-letter file
+func (d *deadLetterSink) write(entries []deadLetterEntry) {
+	if d == nil || len(entries) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := os.OpenFile(d.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Failed to open dead-letter file: %v", err)
+		return
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			log.Printf("Failed to write dead-letter entry: %v", err)
+			return
+		}
+	}
+}
+
+// replayResult summarises a dead-letter replay
+type replayResult struct {
+	Replayed int `json:"replayed"`
+	Indexed  int `json:"indexed"`
+	Failed   int `json:"failed"`
+}
+
+// replay re-indexes every dead-lettered log. The file is first moved aside so
+// ingestion can keep appending new failures while the replay runs; logs that
+// fail again are appended back to the dead-letter file.
+func (d *deadLetterSink) replay(ctx context.Context) (replayResult, error) {
+	var result replayResult
+	pending := d.path + ".replay"
+
+	d.mu.Lock()
+	// A leftover pending file means a previous replay was interrupted, so it
+	// is processed before taking the current file
+	if _, err := os.Stat(pending); errors.Is(err, fs.ErrNotExist) {
+		if err := os.Rename(d.path, pending); errors.Is(err, fs.ErrNotExist) {
+			d.mu.Unlock()
+			return result, nil
+		} else if err != nil {
+			d.mu.Unlock()
+			return result, err
+		}
+	}
+	d.mu.Unlock()
+
+	f, err := os.Open(pending)
+	if err != nil {
+		return result, err
+	}
+	var docs []bulkDoc
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry deadLetterEntry
+		if err := unmarshalLog(scanner.Bytes(), &entry); err != nil || entry.Log == nil {
+			log.Printf("Skipping unreadable dead-letter entry: %v", err)
+			continue
+		}
+		doc := bulkDoc{pos: len(docs), source: entry.Log}
+		if entry.Meta != nil {
+			doc.meta = *entry.Meta
+		}
+		docs = append(docs, doc)
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+
+	var res bulkResult
+	bulkIndex(ctx, docs, &res)
+	d.write(res.rejected)
+	if err := os.Remove(pending); err != nil {
+		return result, err
+	}
+	result.Replayed = len(docs)
+	result.Indexed = res.Indexed
+	result.Failed = res.Failed
+	return result, nil
+}
+
+// replayHandler re-indexes the dead-letter file on demand
+func replayHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if deadLetters == nil {
+		writeJSONError(w, http.StatusNotFound, "Dead-letter file is not configured")
+		return
+	}
+	result, err := deadLetters.replay(r.Context())
+	if err != nil {
+		log.Printf("Dead-letter replay failed: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Dead-letter replay failed")
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rejectBulkItems answers _bulk calls item by item, failing the sources
+// reject reports true for with a 400
+func rejectBulkItems(reject func(doc map[string]interface{}) bool) func(http.ResponseWriter, osCall) bool {
+	return func(w http.ResponseWriter, c osCall) bool {
+		if !strings.HasSuffix(c.Path, "/_bulk") {
+			return false
+		}
+		_, sources := bulkLines(c.Body)
+		items := make([]interface{}, len(sources))
+		for i, s := range sources {
+			var doc map[string]interface{}
+			json.Unmarshal(s, &doc)
+			item := map[string]interface{}{"status": http.StatusCreated, "result": "created"}
+			if reject(doc) {
+				item = map[string]interface{}{"status": http.StatusBadRequest, "error": map[string]string{"type": "mapper_parsing_exception"}}
+			}
+			items[i] = map[string]interface{}{"index": item}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+		return true
+	}
+}
+
+// readDeadLetters returns the entries of the dead-letter file
+func readDeadLetters(t *testing.T, path string) []deadLetterEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []deadLetterEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e deadLetterEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func postReplay(t *testing.T) replayResult {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	newAdminRouter(cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/replay", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("replay: status %d: %s", rec.Code, rec.Body)
+	}
+	var res replayResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.ndjson")
+	fake := withOpenSearch(t, func(c *Config) { c.DeadLetterPath = path })
+	deadLetters.write([]deadLetterEntry{
+		newDeadLetterEntry(map[string]interface{}{"message": "one"}, docMeta{}, "mapping conflict"),
+		newDeadLetterEntry(map[string]interface{}{"message": "two", "still_bad": true}, docMeta{}, "mapping conflict"),
+		newDeadLetterEntry(map[string]interface{}{"message": "three"}, docMeta{}, "mapping conflict"),
+	})
+	fake.setRespond(rejectBulkItems(func(doc map[string]interface{}) bool { return doc["still_bad"] == true }))
+
+	res := postReplay(t)
+	if res.Replayed != 3 || res.Indexed != 2 || res.Failed != 1 {
+		t.Errorf("replay = %+v, want 3 replayed, 2 indexed, 1 failed", res)
+	}
+	left := readDeadLetters(t, path)
+	if len(left) != 1 || left[0].Log["message"] != "two" {
+		t.Errorf("dead-letter file holds %+v, want only the log failing again", left)
+	}
+
+	// Once the mapping is fixed the rest goes through and the file empties
+	fake.setRespond(nil)
+	if res := postReplay(t); res.Indexed != 1 {
+		t.Errorf("second replay indexed %d, want 1", res.Indexed)
+	}
+	if left := readDeadLetters(t, path); len(left) != 0 {
+		t.Errorf("dead-letter file still holds %d entries", len(left))
+	}
+}
+
+func TestDeadLetterReplayKeepsNewFailures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.ndjson")
+	fake := withOpenSearch(t, func(c *Config) { c.DeadLetterPath = path })
+	deadLetters.write([]deadLetterEntry{newDeadLetterEntry(map[string]interface{}{"message": "old"}, docMeta{}, "x")})
+
+	// A log failing while the replay runs lands in the live file
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		deadLetters.write([]deadLetterEntry{newDeadLetterEntry(map[string]interface{}{"message": "new"}, docMeta{}, "x")})
+		return false
+	})
+	postReplay(t)
+	left := readDeadLetters(t, path)
+	if len(left) != 1 || left[0].Log["message"] != "new" {
+		t.Errorf("dead-letter file holds %+v, want the failure written during the replay", left)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// parseDeadline reads an X-Request-Deadline value, either Unix milliseconds
+// or an RFC 3339 date
+func parseDeadline(v string) (time.Time, bool) {
+	if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if ms <= 0 {
+			return time.Time{}, false
+		}
+		return time.UnixMilli(ms), true
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	return t, err == nil
+}
+
+// deadlineMiddleware derives the request context deadline from the
+// X-Request-Deadline the client gives up at, capped at REQUEST_DEADLINE_MAX
+// from now, so OpenSearch calls are cancelled once nobody waits for them.
+// Requests whose deadline already passed get 504 without being handled.
+func deadlineMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get("X-Request-Deadline")
+		if v == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		deadline, ok := parseDeadline(v)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "X-Request-Deadline must be Unix milliseconds or an RFC 3339 date")
+			return
+		}
+		now := time.Now()
+		if !deadline.After(now) {
+			writeJSONError(w, http.StatusGatewayTimeout, "Request deadline already passed")
+			return
+		}
+		if max := now.Add(cfg.RequestDeadlineMax); deadline.After(max) {
+			deadline = max
+		}
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type osTimeoutKey struct{}
+
+// osTimeoutMiddleware lets a request override OPENSEARCH_TIMEOUT for its own
+// OpenSearch calls with X-OpenSearch-Timeout, a Go duration such as "30s"
+// capped at OPENSEARCH_TIMEOUT_MAX, so heavy operations can wait longer and
+// small writes fail faster. Invalid values are ignored with a warning.
+func osTimeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get("X-OpenSearch-Timeout")
+		if v == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			log.Printf("WARN ignoring invalid X-OpenSearch-Timeout %q", v)
+			next.ServeHTTP(w, r)
+			return
+		}
+		timeout = min(timeout, cfg.OpenSearchTimeoutMax)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), osTimeoutKey{}, timeout)))
+	})
+}
+
+// osTimeout returns the timeout of OpenSearch calls made for ctx
+func osTimeout(ctx context.Context) time.Duration {
+	if timeout, ok := ctx.Value(osTimeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+	return cfg.OpenSearchTimeout
+}
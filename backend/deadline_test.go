@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postWithDeadline posts a log with X-Request-Deadline set to deadline
+func postWithDeadline(deadline string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+	req.Header.Set("X-Request-Deadline", deadline)
+	return do(req)
+}
+
+func TestRequestDeadlineCancelsOpenSearchCall(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+		return false
+	})
+
+	start := time.Now()
+	rec := postWithDeadline(strconv.FormatInt(start.Add(50*time.Millisecond).UnixMilli(), 10))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("request took %v, want it cancelled at the 50ms deadline", elapsed)
+	}
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status %d, want 504: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRequestDeadlineAlreadyPassed(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	past := time.Now().Add(-time.Millisecond)
+	for _, v := range []string{strconv.FormatInt(past.UnixMilli(), 10), past.Format(time.RFC3339Nano)} {
+		if rec := postWithDeadline(v); rec.Code != http.StatusGatewayTimeout {
+			t.Errorf("deadline %s: status %d, want 504", v, rec.Code)
+		}
+	}
+	if len(fake.requests()) != 0 {
+		t.Error("request past its deadline reached OpenSearch")
+	}
+}
+
+func TestRequestDeadlineInvalid(t *testing.T) {
+	withOpenSearch(t, nil)
+	for _, v := range []string{"soon", "0", "-5", "2024-13-01T00:00:00Z"} {
+		if rec := postWithDeadline(v); rec.Code != http.StatusBadRequest {
+			t.Errorf("deadline %q: status %d, want 400", v, rec.Code)
+		}
+	}
+}
+
+func TestRequestDeadlineCapped(t *testing.T) {
+	withConfig(t, func(c *Config) { c.RequestDeadlineMax = time.Second })
+	for _, tc := range []struct {
+		name   string
+		offset time.Duration
+		want   time.Duration
+	}{
+		{"within the cap", 500 * time.Millisecond, 500 * time.Millisecond},
+		{"beyond the cap", time.Hour, time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got time.Duration
+			h := deadlineMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				deadline, ok := r.Context().Deadline()
+				if !ok {
+					t.Fatal("request context has no deadline")
+				}
+				got = time.Until(deadline)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Request-Deadline", time.Now().Add(tc.offset).Format(time.RFC3339Nano))
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if got > tc.want || got < tc.want-100*time.Millisecond {
+				t.Errorf("deadline in %v, want about %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOpenSearchTimeoutHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{"default", "", 10 * time.Second},
+		{"shorter", "250ms", 250 * time.Millisecond},
+		{"longer", "30s", 30 * time.Second},
+		{"capped", "1h", time.Minute},
+		{"invalid", "soon", 10 * time.Second},
+		{"not positive", "-1s", 10 * time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) {
+				c.OpenSearchTimeout = 10 * time.Second
+				c.OpenSearchTimeoutMax = time.Minute
+			})
+			logs := captureLog(t)
+			var got time.Duration
+			h := osTimeoutMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = osTimeout(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/logs", nil)
+			if tc.header != "" {
+				req.Header.Set("X-OpenSearch-Timeout", tc.header)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tc.want {
+				t.Errorf("OpenSearch timeout %v, want %v", got, tc.want)
+			}
+			if warned := strings.Contains(logs.String(), "WARN ignoring invalid X-OpenSearch-Timeout"); warned != (tc.name == "invalid" || tc.name == "not positive") {
+				t.Errorf("warning logged = %v: %q", warned, logs.String())
+			}
+		})
+	}
+}
+
+func TestOpenSearchTimeoutHeaderAppliedToCall(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.OpenSearchTimeout = 50 * time.Millisecond
+		c.OpenSearchMaxRetries = 0
+	})
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		time.Sleep(200 * time.Millisecond)
+		return false
+	})
+	post := func(timeout string) (int, time.Duration) {
+		req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+		req.Header.Set("X-OpenSearch-Timeout", timeout)
+		start := time.Now()
+		return do(req).Code, time.Since(start)
+	}
+
+	// The configured 50ms would cut the 200ms call short
+	if code, _ := post("2s"); code != http.StatusCreated {
+		t.Errorf("longer timeout: status %d, want 201", code)
+	}
+	code, took := post("20ms")
+	if code != http.StatusGatewayTimeout {
+		t.Errorf("shorter timeout: status %d, want 504", code)
+	}
+	if took >= 200*time.Millisecond {
+		t.Errorf("shorter timeout: call took %v, want it cut at 20ms", took)
+	}
+}
+
+func TestOpenSearchTimeoutMaxValidated(t *testing.T) {
+	c := defaultConfig()
+	c.OpenSearchTimeoutMax = 0
+	if err := c.validate(); err == nil {
+		t.Error("zero OPENSEARCH_TIMEOUT_MAX passed validation")
+	}
+}
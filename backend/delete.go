@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// deleteRequest is the constrained query accepted by /admin/delete: exact
+// field matches, all of which must hold, and an optional time range
+type deleteRequest struct {
+	Match   map[string]interface{} `json:"match"`
+	From    string                 `json:"from,omitempty"`
+	To      string                 `json:"to,omitempty"`
+	MaxDocs int                    `json:"max_docs,omitempty"`
+}
+
+// fields returns the matched field names, sorted
+func (d deleteRequest) fields() []string {
+	fields := make([]string, 0, len(d.Match))
+	for f := range d.Match {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// query builds the OpenSearch bool query for the request
+func (d deleteRequest) query() map[string]interface{} {
+	fields := d.fields()
+	filters := make([]interface{}, 0, len(fields)+1)
+	for _, f := range fields {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{f: d.Match[f]},
+		})
+	}
+	if d.From != "" || d.To != "" {
+		r := map[string]string{}
+		if d.From != "" {
+			r["gte"] = d.From
+		}
+		if d.To != "" {
+			r["lte"] = d.To
+		}
+		filters = append(filters, map[string]interface{}{
+			"range": map[string]interface{}{timestampField(): r},
+		})
+	}
+	return map[string]interface{}{"bool": map[string]interface{}{"filter": filters}}
+}
+
+// deleteTargets lists every index logs may have been routed to
+func deleteTargets() string {
+	targets := []string{writeTarget()}
+	for _, t := range cfg.IndexTypes {
+		targets = append(targets, cfg.IndexPrefix+t)
+	}
+	if cfg.IndexPerTenant {
+		for _, t := range slices.Clone(targets) {
+			targets = append(targets, t+"-*")
+		}
+	}
+	return strings.Join(targets, ",")
+}
+
+// deleteHandler removes logs matching a constrained query, for deletion
+// requests such as GDPR erasure. At least one field match is required so a
+// mistake cannot wipe the index, and at most ADMIN_DELETE_MAX_DOCS logs are
+// removed per call. It is only served with ADMIN_DELETE_ENABLED.
+func deleteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req deleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid delete request")
+		return
+	}
+	if len(req.Match) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "At least one field match is required")
+		return
+	}
+	for field, v := range req.Match {
+		switch v.(type) {
+		case string, float64, bool:
+		default:
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Match value for %q must be a string, number or boolean", field))
+			return
+		}
+	}
+	if req.MaxDocs <= 0 || req.MaxDocs > cfg.AdminDeleteMaxDocs {
+		req.MaxDocs = cfg.AdminDeleteMaxDocs
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"query": req.query()})
+	url := osURL(deleteTargets(), "_delete_by_query") +
+		fmt.Sprintf("?max_docs=%d&conflicts=proceed&refresh=true&ignore_unavailable=true", req.MaxDocs)
+	status, resBody, err := osRequest(r.Context(), "delete_by_query", http.MethodPost, url, body)
+	if err != nil || status >= 400 {
+		log.Printf("Delete by query failed: status=%d err=%v", status, err)
+		writeJSONError(w, http.StatusBadGateway, "Failed to delete logs in OpenSearch")
+		return
+	}
+
+	var res struct {
+		Deleted  int           `json:"deleted"`
+		Failures []interface{} `json:"failures"`
+	}
+	if err := json.Unmarshal(resBody, &res); err != nil {
+		writeJSONError(w, http.StatusBadGateway, "Unexpected delete response from OpenSearch")
+		return
+	}
+	// The values identify the data subject, so only the field names are logged
+	log.Printf("Deleted %d logs matching fields %v", res.Deleted, req.fields())
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted":  res.Deleted,
+		"failures": len(res.Failures),
+		"capped":   res.Deleted >= req.MaxDocs,
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postDelete sends body to /admin/delete on the admin router
+func postDelete(body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	newAdminRouter(cfg).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/delete", strings.NewReader(body)))
+	return rec
+}
+
+func deleteCalls(fake *fakeOpenSearch) []osCall {
+	var calls []osCall
+	for _, c := range fake.requests() {
+		if strings.HasSuffix(c.Path, "/_delete_by_query") {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+func TestDeleteByQueryFiltered(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.AdminDeleteEnabled = true
+		c.AdminDeleteMaxDocs = 100
+	})
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		w.Write([]byte(`{"deleted":3,"failures":[]}`))
+		return true
+	})
+
+	rec := postDelete(`{"match":{"user_id":"u-1"},"from":"2026-01-01T00:00:00Z","to":"2026-02-01T00:00:00Z","max_docs":5000}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var res struct {
+		Deleted int  `json:"deleted"`
+		Capped  bool `json:"capped"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &res)
+	if res.Deleted != 3 || res.Capped {
+		t.Errorf("response %s, want 3 deleted and not capped", rec.Body)
+	}
+
+	calls := deleteCalls(fake)
+	if len(calls) != 1 {
+		t.Fatalf("%d delete calls, want 1", len(calls))
+	}
+	if got := calls[0].Query.Get("max_docs"); got != "100" {
+		t.Errorf("max_docs = %s, want the ADMIN_DELETE_MAX_DOCS cap", got)
+	}
+	var body struct {
+		Query struct {
+			Bool struct {
+				Filter []map[string]map[string]interface{} `json:"filter"`
+			} `json:"bool"`
+		} `json:"query"`
+	}
+	if err := json.Unmarshal(calls[0].Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	filters := body.Query.Bool.Filter
+	if len(filters) != 2 || filters[0]["term"]["user_id"] != "u-1" {
+		t.Fatalf("filters %s, want a term on user_id and a range", calls[0].Body)
+	}
+	r, _ := filters[1]["range"]["timestamp"].(map[string]interface{})
+	if r["gte"] != "2026-01-01T00:00:00Z" || r["lte"] != "2026-02-01T00:00:00Z" {
+		t.Errorf("range = %v", filters[1])
+	}
+}
+
+func TestDeleteByQueryRejected(t *testing.T) {
+	for _, tc := range []struct{ name, body string }{
+		{"match all", `{}`},
+		{"empty match", `{"match":{}}`},
+		{"time range only", `{"from":"2026-01-01T00:00:00Z"}`},
+		{"object value", `{"match":{"user":{"match_all":{}}}}`},
+		{"invalid JSON", `{"match":`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) { c.AdminDeleteEnabled = true })
+			if rec := postDelete(tc.body); rec.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want 400: %s", rec.Code, rec.Body)
+			}
+			if n := len(deleteCalls(fake)); n != 0 {
+				t.Errorf("%d delete calls sent to OpenSearch", n)
+			}
+		})
+	}
+}
+
+func TestDeleteByQueryDisabled(t *testing.T) {
+	withOpenSearch(t, nil)
+	if rec := postDelete(`{"match":{"user_id":"u-1"}}`); rec.Code != http.StatusNotFound {
+		t.Errorf("status %d with ADMIN_DELETE_ENABLED off, want 404", rec.Code)
+	}
+}
+
+func TestDeleteTargetsCoverRoutedIndices(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.OpenSearchIndex = "logs"
+		c.IndexPrefix = "telyx-"
+		c.IndexTypes = []string{"audit"}
+		c.IndexPerTenant = true
+	})
+	if got, want := deleteTargets(), "logs,telyx-audit,logs-*,telyx-audit-*"; got != want {
+		t.Errorf("deleteTargets() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var diskSpaceLow = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "disk_space_low",
+		Help: "Whether ingestion is refused because the dead-letter or WAL directory is short of space: 1 yes, 0 no",
+	},
+)
+
+// storageLow is set while a spool directory has less than MIN_FREE_DISK_BYTES free
+var storageLow atomic.Bool
+
+// spoolDirs returns the directories holding the dead-letter file and the WAL
+func spoolDirs(c Config) []string {
+	var dirs []string
+	for _, path := range []string{c.DeadLetterPath, c.WALPath} {
+		if path != "" {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+	}
+	return dirs
+}
+
+// freeBytes returns the space available to the process on the filesystem of dir
+func freeBytes(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// checkDiskSpace updates storageLow from the free space of dirs, logging an
+// alert when ingestion starts being refused and when it resumes. A directory
+// that cannot be inspected keeps the previous state.
+func checkDiskSpace(dirs []string, min uint64) {
+	low := false
+	for _, dir := range dirs {
+		free, err := freeBytes(dir)
+		if err != nil {
+			log.Printf("WARN failed to check free space of %s: %v", dir, err)
+			return
+		}
+		if free < min {
+			if !storageLow.Load() {
+				log.Printf("ALERT only %d bytes free in %s, refusing ingestion until MIN_FREE_DISK_BYTES (%d) are available", free, dir, min)
+			}
+			low = true
+		}
+	}
+	if !low && storageLow.Load() {
+		log.Printf("Disk space recovered, accepting ingestion again")
+	}
+	storageLow.Store(low)
+	if low {
+		diskSpaceLow.Set(1)
+	} else {
+		diskSpaceLow.Set(0)
+	}
+}
+
+// watchDiskSpace checks the spool directories every DISK_CHECK_INTERVAL, so
+// requests only read a flag
+func watchDiskSpace(ctx context.Context, dirs []string, min uint64, interval time.Duration) {
+	checkDiskSpace(dirs, min)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkDiskSpace(dirs, min)
+		}
+	}
+}
+
+// diskSpaceMiddleware refuses ingestion with 507 while the dead-letter or WAL
+// directory is short of space. Logs accepted then could not be spooled if
+// OpenSearch failed, so refusing them lets clients keep them instead of
+// losing them silently.
+func diskSpaceMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if storageLow.Load() {
+			writeJSONError(w, http.StatusInsufficientStorage, "Insufficient storage to accept logs")
+			return
+		}
+		next(w, r)
+	}
+}
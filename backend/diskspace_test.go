@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFullDiskRefusesIngestion(t *testing.T) {
+	dir := t.TempDir()
+	fake := withOpenSearch(t, func(c *Config) {
+		c.DeadLetterPath = filepath.Join(dir, "dead-letters.ndjson")
+		c.MinFreeDiskBytes = 1
+	})
+	logs := captureLog(t)
+
+	// No filesystem has this much room, so the directory counts as full
+	checkDiskSpace([]string{dir}, math.MaxUint64)
+	if got := testutil.ToFloat64(diskSpaceLow); got != 1 {
+		t.Errorf("disk_space_low = %v, want 1", got)
+	}
+	if !strings.Contains(logs.String(), "ALERT") {
+		t.Errorf("no alert logged: %q", logs.String())
+	}
+	for path, body := range map[string]string{
+		"/logs":      `{"message":"hi"}`,
+		"/logs/acme": `{"message":"hi"}`,
+		"/logs/bulk": `[{"message":"hi"}]`,
+	} {
+		if rec := postJSON(path, body); rec.Code != http.StatusInsufficientStorage {
+			t.Errorf("POST %s: status %d, want 507", path, rec.Code)
+		}
+	}
+	if rec, _ := postUpload(t, []byte("{\"message\":\"hi\"}\n")); rec.Code != http.StatusInsufficientStorage {
+		t.Errorf("upload: status %d, want 507", rec.Code)
+	}
+	_, resp, err := openStream(t, streamURL(t), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusInsufficientStorage {
+		t.Errorf("stream opened while the disk is full: %v", err)
+	}
+	if n := len(fake.writes()); n != 0 {
+		t.Errorf("%d writes while the disk is full", n)
+	}
+
+	checkDiskSpace([]string{dir}, 1)
+	if got := testutil.ToFloat64(diskSpaceLow); got != 0 {
+		t.Errorf("disk_space_low = %v after space came back, want 0", got)
+	}
+	if !strings.Contains(logs.String(), "Disk space recovered") {
+		t.Errorf("recovery not logged: %q", logs.String())
+	}
+	if rec := postJSON("/logs", `{"message":"hi"}`); rec.Code != http.StatusCreated {
+		t.Errorf("status %d once space came back, want 201", rec.Code)
+	}
+}
+
+func TestDiskCheckFailureKeepsState(t *testing.T) {
+	withConfig(t, nil)
+	t.Cleanup(func() { diskSpaceLow.Set(0) })
+	checkDiskSpace([]string{t.TempDir()}, math.MaxUint64)
+	checkDiskSpace([]string{filepath.Join(t.TempDir(), "missing")}, 1)
+	if !storageLow.Load() {
+		t.Error("a directory that cannot be inspected lifted the refusal")
+	}
+}
+
+func TestWatchDiskSpaceRechecks(t *testing.T) {
+	withConfig(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storageLow.Store(true)
+	go watchDiskSpace(ctx, []string{t.TempDir()}, 1, 10*time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for storageLow.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("free space never re-checked")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMinFreeDiskNeedsSpoolDir(t *testing.T) {
+	c := defaultConfig()
+	c.MinFreeDiskBytes = 1 << 20
+	if err := c.validate(); err == nil {
+		t.Error("MIN_FREE_DISK_BYTES without a dead-letter or WAL path passed validation")
+	}
+}
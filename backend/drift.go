@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var fieldTypeConflicts = newResettableCounter(
+	prometheus.CounterOpts{
+		Name: "field_type_conflicts_total",
+		Help: "Total number of field type changes seen in sampled logs",
+	},
+)
+
+// fieldTypeSampler remembers the JSON type each field had in the sampled
+// logs. A field arriving with another type is what later turns into a
+// mapping conflict in OpenSearch, so it is reported as soon as it is seen.
+type fieldTypeSampler struct {
+	mu    sync.Mutex
+	types map[string]string
+}
+
+var fieldTypes = &fieldTypeSampler{types: map[string]string{}}
+
+// jsonType names the type of a decoded JSON value; null has none
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	}
+	return ""
+}
+
+// sample records the field types of a FIELD_TYPE_SAMPLE_RATE fraction of logs
+func (s *fieldTypeSampler) sample(logData map[string]interface{}) {
+	if cfg.FieldTypeSampleRate <= 0 || rand.Float64() >= cfg.FieldTypeSampleRate {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observe("", logData)
+}
+
+func (s *fieldTypeSampler) observe(prefix string, logData map[string]interface{}) {
+	for k, v := range logData {
+		s.observeValue(prefix+k, v)
+	}
+}
+
+// observeValue checks v against the type last seen at path. Array elements
+// are checked at the path of the array, the way OpenSearch maps them.
+func (s *fieldTypeSampler) observeValue(path string, v interface{}) {
+	if arr, ok := v.([]interface{}); ok {
+		for _, e := range arr {
+			s.observeValue(path, e)
+		}
+		return
+	}
+	typ := jsonType(v)
+	if typ == "" {
+		return
+	}
+	prev, seen := s.types[path]
+	switch {
+	case !seen:
+		// Bound the memory spent on logs with dynamic keys
+		if len(s.types) < cfg.FieldTypeMaxFields {
+			s.types[path] = typ
+		}
+	case prev != typ:
+		fieldTypeConflicts.Inc()
+		log.Printf("WARN field %s changed type from %s to %s", path, prev, typ)
+		// Track the latest type so continued drift keeps being reported
+		s.types[path] = typ
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		s.observe(path+".", m)
+	}
+}
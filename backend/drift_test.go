@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// resetFieldTypes forgets the field types seen by earlier tests
+func resetFieldTypes(t *testing.T) {
+	t.Helper()
+	fieldTypes = &fieldTypeSampler{types: map[string]string{}}
+	t.Cleanup(func() { fieldTypes = &fieldTypeSampler{types: map[string]string{}} })
+}
+
+func TestFieldTypeDrift(t *testing.T) {
+	withOpenSearch(t, func(c *Config) { c.FieldTypeSampleRate = 1 })
+	resetFieldTypes(t)
+	logs := captureLog(t)
+	conflicts := testutil.ToFloat64(fieldTypeConflicts)
+
+	for _, body := range []string{
+		`{"count":1,"http":{"status":200}}`,
+		`{"count":2,"http":{"status":201},"tags":["a","b"]}`,
+		`{"count":"three","http":{"status":"ok"},"tags":["c",4]}`,
+	} {
+		if rec := postJSON("/logs", body); rec.Code != http.StatusCreated {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	for _, want := range []string{
+		"WARN field count changed type from number to string",
+		"WARN field http.status changed type from number to string",
+		"WARN field tags changed type from string to number",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("no %q in the log: %s", want, logs)
+		}
+	}
+	if got := testutil.ToFloat64(fieldTypeConflicts) - conflicts; got != 3 {
+		t.Errorf("field_type_conflicts_total grew by %v, want 3", got)
+	}
+}
+
+func TestFieldTypeDriftIgnoresNull(t *testing.T) {
+	withConfig(t, func(c *Config) { c.FieldTypeSampleRate = 1 })
+	resetFieldTypes(t)
+	conflicts := testutil.ToFloat64(fieldTypeConflicts)
+	fieldTypes.sample(map[string]interface{}{"user": "ann"})
+	fieldTypes.sample(map[string]interface{}{"user": nil})
+	fieldTypes.sample(map[string]interface{}{"user": "bob"})
+	if got := testutil.ToFloat64(fieldTypeConflicts) - conflicts; got != 0 {
+		t.Errorf("field_type_conflicts_total grew by %v for null values, want 0", got)
+	}
+}
+
+func TestFieldTypeDriftSampling(t *testing.T) {
+	withConfig(t, func(c *Config) { c.FieldTypeSampleRate = 0 })
+	resetFieldTypes(t)
+	fieldTypes.sample(map[string]interface{}{"count": 1.0})
+	if len(fieldTypes.types) != 0 {
+		t.Errorf("tracked %v with sampling off", fieldTypes.types)
+	}
+}
+
+func TestFieldTypeDriftBounded(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.FieldTypeSampleRate = 1
+		c.FieldTypeMaxFields = 2
+	})
+	resetFieldTypes(t)
+	fieldTypes.sample(map[string]interface{}{"a": 1.0, "b": 1.0, "c": 1.0, "d": 1.0})
+	if n := len(fieldTypes.types); n != 2 {
+		t.Errorf("tracking %d fields, want FIELD_TYPE_MAX_FIELDS=2", n)
+	}
+}
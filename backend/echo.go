@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// queryBool reads an optional boolean query parameter, failing when the
+// value is not a boolean
+func queryBool(r *http.Request, name string) (value, ok bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, true
+	}
+	value, err := strconv.ParseBool(v)
+	return value, err == nil
+}
+
+// echoRequested reports whether the client asked with ?echo=true to get the
+// stored document back
+func echoRequested(r *http.Request) (echo, ok bool) {
+	return queryBool(r, "echo")
+}
+
+// dryRunRequested reports whether the client asked with ?dry_run=true to run
+// the ingest pipeline without writing to OpenSearch
+func dryRunRequested(r *http.Request) (dryRun, ok bool) {
+	return queryBool(r, "dry_run")
+}
+
+// writeIngested answers 201 for a stored log. With echo the response carries
+// the document exactly as sent to OpenSearch, after enrichment and redaction,
+// so integrators can check their transforms. Documents over ECHO_MAX_BYTES
+// are left out and flagged instead.
+func writeIngested(w http.ResponseWriter, doc []byte, echo bool) {
+	w.WriteHeader(http.StatusCreated)
+	if !echo {
+		w.Write([]byte(`{"status": "Log successfully ingested"}`))
+		return
+	}
+	response := map[string]interface{}{"status": "Log successfully ingested"}
+	if len(doc) <= cfg.EchoMaxBytes {
+		response["document"] = json.RawMessage(doc)
+	} else {
+		response["document_omitted"] = true
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeDryRun answers 200 rather than 201 for a log that passed the pipeline
+// but was not stored, with dry_run set so tooling cannot mistake it for a
+// real ingest. The response previews the document and its target index.
+func writeDryRun(w http.ResponseWriter, index string, doc []byte) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "Log validated",
+		"dry_run":  true,
+		"index":    index,
+		"document": json.RawMessage(doc),
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// echoResponse is the body of POST /logs?echo=true
+type echoResponse struct {
+	Status          string          `json:"status"`
+	Document        json.RawMessage `json:"document"`
+	DocumentOmitted bool            `json:"document_omitted"`
+}
+
+func TestEchoStoredDocument(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.LevelNormalize = true
+		c.RetentionDefault = "30d"
+		c.RedactKeys = []string{"password"}
+		c.RedactPatterns = []string{`\d{4}-\d{4}-\d{4}-\d{4}`}
+	})
+	rec := postJSON("/logs?echo=true", `{"message":"card 4111-1111-1111-1111 declined","level":"ERR","password":"hunter2"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var res echoResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	doc := decodeDoc(t, res.Document)
+	for field, want := range map[string]interface{}{
+		"message":         "card [REDACTED] declined",
+		"level":           "error",
+		"password":        "[REDACTED]",
+		"retention_class": "30d",
+	} {
+		if doc[field] != want {
+			t.Errorf("echoed %s = %v, want %v", field, doc[field], want)
+		}
+	}
+	if _, ok := doc["timestamp"]; !ok {
+		t.Error("echoed document has no ingest timestamp")
+	}
+	writes := fake.writes()
+	if len(writes) != 1 || string(writes[0].Body) != string(res.Document) {
+		t.Errorf("echoed %s, but stored %v", res.Document, writes)
+	}
+}
+
+func TestEchoOff(t *testing.T) {
+	withOpenSearch(t, nil)
+	for _, path := range []string{"/logs", "/logs?echo=false"} {
+		rec := postJSON(path, `{"message":"hi"}`)
+		if strings.Contains(rec.Body.String(), "document") {
+			t.Errorf("%s: response %s carries the document", path, rec.Body)
+		}
+	}
+}
+
+func TestEchoCapped(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.EchoMaxBytes = 64 })
+	rec := postJSON("/logs?echo=true", `{"message":"`+strings.Repeat("a", 100)+`"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var res echoResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.DocumentOmitted || res.Document != nil {
+		t.Errorf("response %s, want the document omitted", rec.Body)
+	}
+	if len(fake.writes()) != 1 {
+		t.Error("oversized echo kept the log from being stored")
+	}
+}
+
+func TestEchoInvalid(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	if rec := postJSON("/logs?echo=please", `{"message":"hi"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+	if len(fake.writes()) != 0 {
+		t.Error("log with an invalid echo parameter was stored")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		path      string
+		perTenant bool
+		async     bool
+		index     string
+	}{
+		{"default index", "/logs?dry_run=true", false, false, "logs"},
+		{"tenant index", "/logs/acme?dry_run=1", true, false, "logs-acme"},
+		{"async", "/logs?dry_run=true", false, true, "logs"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				c.IndexPerTenant = tc.perTenant
+				c.IngestAsync = tc.async
+				c.RedactKeys = []string{"password"}
+			})
+			if tc.async {
+				startAsync(t)
+			}
+			rec := postJSON(tc.path, `{"message":"hi","password":"hunter2"}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var res struct {
+				DryRun   bool            `json:"dry_run"`
+				Index    string          `json:"index"`
+				Document json.RawMessage `json:"document"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if !res.DryRun || res.Index != tc.index {
+				t.Errorf("dry_run %v, index %q, want true and %q", res.DryRun, res.Index, tc.index)
+			}
+			doc := decodeDoc(t, res.Document)
+			if doc["password"] != "[REDACTED]" || doc["request_id"] == nil {
+				t.Errorf("preview %v did not go through the pipeline", doc)
+			}
+			if calls := fake.requests(); len(calls) != 0 {
+				t.Errorf("dry run reached OpenSearch: %v", calls)
+			}
+			if tc.async && asyncQueue.depth() != 0 {
+				t.Errorf("dry run queued %d logs", asyncQueue.depth())
+			}
+		})
+	}
+}
+
+func TestDryRunVersusIngest(t *testing.T) {
+	withOpenSearch(t, nil)
+	rec := postJSON("/logs", `{"message":"hi"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("real ingest: status %d, want 201", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "dry_run") {
+		t.Errorf("real ingest flagged as a dry run: %s", rec.Body)
+	}
+	for path, want := range map[string]int{
+		"/logs?dry_run=false": http.StatusCreated,
+		"/logs?dry_run=maybe": http.StatusBadRequest,
+	} {
+		if rec := postJSON(path, `{"message":"hi"}`); rec.Code != want {
+			t.Errorf("POST %s: status %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestDryRunRejectsInvalidLog(t *testing.T) {
+	withOpenSearch(t, func(c *Config) { c.LogMinFields = 1 })
+	if rec := postJSON("/logs?dry_run=true", `{}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want 422: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var fieldsStripped = newResettableCounter(
+	prometheus.CounterOpts{
+		Name: "fields_stripped_total",
+		Help: "Total number of fields removed from logs by LOG_FIELD_DENYLIST",
+	},
+)
+
+// stripDeniedFields removes the fields listed in LOG_FIELD_DENYLIST, such as
+// raw payload copies or debug dumps, before the log is validated or stored.
+// Entries are dotted paths, so "debug.dump" reaches into nested objects.
+func stripDeniedFields(logData map[string]interface{}) {
+	for _, field := range cfg.FieldDenylist {
+		if parent, key, ok := fieldParent(logData, field); ok {
+			delete(parent, key)
+			fieldsStripped.Inc()
+		}
+	}
+}
+
+// canonicalLevels is the set LOG_LEVEL_NORMALIZE rewrites "level" into
+var canonicalLevels = map[string]bool{"trace": true, "debug": true, "info": true, "warn": true, "error": true, "fatal": true}
+
+// normalizeLevel rewrites "level" to its canonical lowercase name, so ERROR,
+// err and 3 all become error. Values that are neither canonical nor in
+// LOG_LEVEL_ALIASES become LOG_LEVEL_UNKNOWN, the original value being kept
+// in "level_original".
+func normalizeLevel(logData map[string]interface{}) {
+	if !cfg.LevelNormalize {
+		return
+	}
+	v, ok := logData["level"]
+	if !ok {
+		return
+	}
+	level := strings.ToLower(strings.TrimSpace(fmt.Sprint(v)))
+	if canonicalLevels[level] {
+		logData["level"] = level
+		return
+	}
+	if alias, ok := cfg.LevelAliases[level]; ok {
+		logData["level"] = alias
+		return
+	}
+	logData["level_original"] = v
+	logData["level"] = cfg.LevelUnknown
+}
+
+// injectRetention tags the log with its retention class in
+// LOG_RETENTION_FIELD so lifecycle policies and auditors can rely on it. A
+// tenant rule wins over a log type rule, which wins over
+// LOG_RETENTION_DEFAULT. When a class applies it overwrites any value the
+// client sent, since retention is decided by the server.
+func injectRetention(logData map[string]interface{}) {
+	if cfg.RetentionField == "" {
+		return
+	}
+	class := cfg.RetentionDefault
+	if t, ok := logData[cfg.IndexTypeField].(string); ok && cfg.RetentionByType[t] != "" {
+		class = cfg.RetentionByType[t]
+	}
+	if t, ok := logData["tenant"].(string); ok && cfg.RetentionByTenant[t] != "" {
+		class = cfg.RetentionByTenant[t]
+	}
+	if class == "" {
+		return
+	}
+	logData[cfg.RetentionField] = class
+}
+
+// deriveLevel sets "level" from the HTTP status field of access logs that do
+// not carry one. Exact codes in the mapping ("404") win over classes ("4xx").
+func deriveLevel(logData map[string]interface{}) {
+	if !cfg.LevelFromStatus {
+		return
+	}
+	if _, exists := logData["level"]; exists {
+		return
+	}
+	v, ok := logData[cfg.LevelStatusField]
+	if !ok {
+		return
+	}
+	code, err := strconv.Atoi(fmt.Sprint(v))
+	if err != nil || code < 100 || code > 599 {
+		return
+	}
+	if level, ok := cfg.LevelStatusMap[strconv.Itoa(code)]; ok {
+		logData["level"] = level
+		return
+	}
+	if level, ok := cfg.LevelStatusMap[fmt.Sprintf("%dxx", code/100)]; ok {
+		logData["level"] = level
+		return
+	}
+	logData["level"] = cfg.LevelStatusDefault
+}
+
+// Policies of LOG_FIELD_RENAME_CONFLICT for a rename whose target exists
+const (
+	renameKeep      = "keep"
+	renameOverwrite = "overwrite"
+	renameDrop      = "drop"
+)
+
+var renameConflictPolicies = map[string]bool{renameKeep: true, renameOverwrite: true, renameDrop: true}
+
+// renameFields moves the fields of LOG_FIELD_RENAMES to their canonical
+// name, so msg and lvl from one client land in message and level. When the
+// target already holds a value LOG_FIELD_RENAME_CONFLICT decides: keep both
+// fields untouched, overwrite the target, or drop the source.
+func renameFields(logData map[string]interface{}) {
+	if len(cfg.FieldRenames) == 0 {
+		return
+	}
+	sources := make([]string, 0, len(cfg.FieldRenames))
+	for from := range cfg.FieldRenames {
+		sources = append(sources, from)
+	}
+	sort.Strings(sources)
+
+	for _, from := range sources {
+		parent, key, ok := fieldParent(logData, from)
+		if !ok {
+			continue
+		}
+		to := cfg.FieldRenames[from]
+		if _, _, exists := fieldParent(logData, to); exists {
+			switch cfg.FieldRenameConflict {
+			case renameKeep:
+				continue
+			case renameDrop:
+				delete(parent, key)
+				continue
+			}
+		}
+		if setField(logData, to, parent[key]) {
+			delete(parent, key)
+		}
+	}
+}
+
+// setField stores v under a dotted path, creating the objects on the way.
+// It fails when a segment of the path holds something other than an object.
+func setField(doc map[string]interface{}, path string, v interface{}) bool {
+	parts := strings.Split(path, ".")
+	cur := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, exists := cur[part]
+		if !exists {
+			m := map[string]interface{}{}
+			cur[part] = m
+			cur = m
+			continue
+		}
+		m, ok := next.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		cur = m
+	}
+	cur[parts[len(parts)-1]] = v
+	return true
+}
+
+// coerceFields converts string values of the fields listed in
+// LOG_FIELD_COERCIONS to their configured type. Values that cannot be
+// converted are kept and the field name is added to "coercion_failed".
+func coerceFields(logData map[string]interface{}) {
+	if len(cfg.FieldCoercions) == 0 {
+		return
+	}
+	fields := make([]string, 0, len(cfg.FieldCoercions))
+	for field := range cfg.FieldCoercions {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var failed []interface{}
+	for _, field := range fields {
+		parent, key, ok := fieldParent(logData, field)
+		if !ok {
+			continue
+		}
+		s, ok := parent[key].(string)
+		if !ok {
+			continue
+		}
+		v, err := coerceValue(strings.TrimSpace(s), cfg.FieldCoercions[field])
+		if err != nil {
+			failed = append(failed, field)
+			continue
+		}
+		parent[key] = v
+	}
+	if len(failed) > 0 {
+		logData["coercion_failed"] = failed
+	}
+}
+
+func coerceValue(s, kind string) (interface{}, error) {
+	switch kind {
+	case "int":
+		return strconv.ParseInt(s, 10, 64)
+	case "float":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	}
+	return nil, fmt.Errorf("unknown type %q", kind)
+}
+
+// fieldParent resolves a dotted path to the map holding its last segment
+func fieldParent(doc map[string]interface{}, path string) (map[string]interface{}, string, bool) {
+	if _, ok := doc[path]; ok {
+		return doc, path, true
+	}
+	parts := strings.Split(path, ".")
+	cur := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := cur[part].(map[string]interface{})
+		if !ok {
+			return nil, "", false
+		}
+		cur = next
+	}
+	last := parts[len(parts)-1]
+	if _, ok := cur[last]; !ok {
+		return nil, "", false
+	}
+	return cur, last, true
+}
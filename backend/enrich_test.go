@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDeriveLevelFromStatus(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.LevelFromStatus = true
+		c.LevelStatusMap = map[string]string{"5xx": "error", "4xx": "warn", "404": "info"}
+	})
+	for _, tc := range []struct {
+		status interface{}
+		want   string
+	}{
+		{float64(200), "info"},
+		{float64(302), "info"},
+		{float64(400), "warn"},
+		{float64(404), "info"},
+		{float64(503), "error"},
+		{"500", "error"},
+		{json.Number("429"), "warn"},
+	} {
+		doc := map[string]interface{}{"status": tc.status}
+		deriveLevel(doc)
+		if doc["level"] != tc.want {
+			t.Errorf("status %v: level %v, want %s", tc.status, doc["level"], tc.want)
+		}
+	}
+
+	explicit := map[string]interface{}{"status": float64(500), "level": "debug"}
+	deriveLevel(explicit)
+	if explicit["level"] != "debug" {
+		t.Errorf("explicit level replaced by %v", explicit["level"])
+	}
+	for _, doc := range []map[string]interface{}{{"status": "n/a"}, {"message": "no status"}} {
+		deriveLevel(doc)
+		if level, ok := doc["level"]; ok {
+			t.Errorf("%v: level set to %v", doc, level)
+		}
+	}
+}
+
+func TestDeriveLevelOptIn(t *testing.T) {
+	withConfig(t, nil)
+	doc := map[string]interface{}{"status": float64(500)}
+	deriveLevel(doc)
+	if _, ok := doc["level"]; ok {
+		t.Error("level derived without LOG_LEVEL_FROM_STATUS")
+	}
+}
+
+func TestFieldCoercion(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.FieldCoercions = map[string]string{"count": "int", "ratio": "float", "ok": "bool", "http.status": "int"}
+	})
+	rec := postJSON("/logs", `{"count":"5","ratio":" 0.5 ","ok":"true","http":{"status":"teapot"}}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	writes := fake.writes()
+	if len(writes) != 1 {
+		t.Fatalf("%d writes, want 1", len(writes))
+	}
+	if !bytes.Contains(writes[0].Body, []byte(`"count":5`)) {
+		t.Errorf("count was not indexed as an integer: %s", writes[0].Body)
+	}
+	doc := decodeDoc(t, writes[0].Body)
+	for field, want := range map[string]interface{}{"count": float64(5), "ratio": 0.5, "ok": true} {
+		if doc[field] != want {
+			t.Errorf("%s = %#v, want %#v", field, doc[field], want)
+		}
+	}
+	if status := doc["http"].(map[string]interface{})["status"]; status != "teapot" {
+		t.Errorf("unconvertible value changed to %#v", status)
+	}
+	if got := doc["coercion_failed"]; !reflect.DeepEqual(got, []interface{}{"http.status"}) {
+		t.Errorf("coercion_failed = %#v, want [http.status]", got)
+	}
+}
+
+func TestFieldCoercionUnknownType(t *testing.T) {
+	c := defaultConfig()
+	c.FieldCoercions = map[string]string{"count": "integer"}
+	if err := c.validate(); err == nil {
+		t.Error("unknown coercion type passed validation")
+	}
+}
+
+func TestNormalizeLevel(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.LevelNormalize = true
+		c.LevelAliases["sev"] = "error"
+	})
+	for _, tc := range []struct {
+		level interface{}
+		want  string
+	}{
+		{"ERROR", "error"},
+		{"Error", "error"},
+		{"err", "error"},
+		{" warning ", "warn"},
+		{float64(3), "error"},
+		{json.Number("7"), "debug"},
+		{"SEV", "error"},
+		{"info", "info"},
+	} {
+		doc := map[string]interface{}{"level": tc.level}
+		normalizeLevel(doc)
+		if doc["level"] != tc.want {
+			t.Errorf("level %q: normalized to %v, want %s", tc.level, doc["level"], tc.want)
+		}
+		if _, ok := doc["level_original"]; ok {
+			t.Errorf("level %q: known level kept as level_original", tc.level)
+		}
+	}
+}
+
+func TestNormalizeUnknownLevel(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.LevelNormalize = true
+		c.LevelUnknown = "warn"
+	})
+	if rec := postJSON("/logs", `{"message":"hi","level":"LOUD"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	docs := fake.docs(t)
+	if len(docs) != 1 || docs[0]["level"] != "warn" || docs[0]["level_original"] != "LOUD" {
+		t.Errorf("indexed %v, want level warn with level_original LOUD", docs)
+	}
+}
+
+func TestNormalizeLevelOptIn(t *testing.T) {
+	withConfig(t, nil)
+	doc := map[string]interface{}{"level": "ERROR"}
+	normalizeLevel(doc)
+	if doc["level"] != "ERROR" {
+		t.Errorf("level rewritten to %v without LOG_LEVEL_NORMALIZE", doc["level"])
+	}
+}
+
+func TestLevelAliasesValidated(t *testing.T) {
+	for _, mutate := range []func(*Config){
+		func(c *Config) { c.LevelAliases = map[string]string{"sev": "severe"} },
+		func(c *Config) { c.LevelUnknown = "unknown" },
+	} {
+		c := defaultConfig()
+		c.LevelNormalize = true
+		mutate(&c)
+		if err := c.validate(); err == nil {
+			t.Errorf("aliases %v, unknown %q passed validation", c.LevelAliases, c.LevelUnknown)
+		}
+	}
+}
+
+func TestRetentionClass(t *testing.T) {
+	for _, tc := range []struct {
+		name, path, body string
+		want             interface{}
+	}{
+		{"tenant rule", "/logs", `{"tenant":"acme","log_type":"nginx"}`, "365d"},
+		{"tenant from path", "/logs/acme", `{"message":"hi"}`, "365d"},
+		{"type rule", "/logs", `{"tenant":"globex","log_type":"nginx"}`, "7d"},
+		{"default", "/logs", `{"message":"hi"}`, "30d"},
+		{"client value overwritten", "/logs", `{"log_type":"nginx","retention_class":"forever"}`, "7d"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				c.RetentionByTenant = map[string]string{"acme": "365d"}
+				c.RetentionByType = map[string]string{"nginx": "7d"}
+				c.RetentionDefault = "30d"
+			})
+			if rec := postJSON(tc.path, tc.body); rec.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			docs := fake.docs(t)
+			if len(docs) != 1 || docs[0]["retention_class"] != tc.want {
+				t.Errorf("indexed %v, want retention_class %v", docs, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetentionClassWithoutDefault(t *testing.T) {
+	withConfig(t, func(c *Config) { c.RetentionByType = map[string]string{"nginx": "7d"} })
+	doc := map[string]interface{}{"log_type": "app", "retention_class": "client"}
+	injectRetention(doc)
+	if doc["retention_class"] != "client" {
+		t.Errorf("retention_class = %v, want the client value kept when no rule applies", doc["retention_class"])
+	}
+}
+
+func TestRetentionRulesNeedField(t *testing.T) {
+	c := defaultConfig()
+	c.RetentionField = ""
+	c.RetentionDefault = "30d"
+	if err := c.validate(); err == nil {
+		t.Error("retention rules without LOG_RETENTION_FIELD passed validation")
+	}
+}
+
+func TestFieldDenylist(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.FieldDenylist = []string{"_raw", "debug.dump", "http.request.body", "absent.field"}
+	})
+	stripped := testutil.ToFloat64(fieldsStripped)
+
+	rec := postJSON("/logs", `{
+		"message": "hi",
+		"_raw": "GET / HTTP/1.1 ...",
+		"debug": {"dump": "0xdeadbeef", "level": 2},
+		"http": {"request": {"body": "{...}", "method": "GET"}}
+	}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	docs := fake.docs(t)
+	if len(docs) != 1 {
+		t.Fatalf("indexed %d logs, want 1", len(docs))
+	}
+	want := map[string]interface{}{
+		"message": "hi",
+		"debug":   map[string]interface{}{"level": float64(2)},
+		"http":    map[string]interface{}{"request": map[string]interface{}{"method": "GET"}},
+	}
+	delete(docs[0], "timestamp")
+	delete(docs[0], "request_id")
+	if !reflect.DeepEqual(docs[0], want) {
+		t.Errorf("indexed %v, want %v", docs[0], want)
+	}
+	if got := testutil.ToFloat64(fieldsStripped) - stripped; got != 3 {
+		t.Errorf("fields_stripped_total grew by %v, want 3", got)
+	}
+}
+
+func TestFieldDenylistFlattenedKey(t *testing.T) {
+	withConfig(t, func(c *Config) { c.FieldDenylist = []string{"debug.dump"} })
+	doc := map[string]interface{}{"debug.dump": "x", "debug": map[string]interface{}{"dump": "y"}}
+	stripDeniedFields(doc)
+	if _, ok := doc["debug.dump"]; ok {
+		t.Errorf("dotted key kept: %v", doc)
+	}
+}
+
+func TestFieldRenames(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.FieldRenames = map[string]string{"msg": "message", "lvl": "level", "ctx.uid": "user.id"}
+	})
+	if rec := postJSON("/logs", `{"msg":"hi","lvl":"warn","ctx":{"uid":"u1","host":"web-1"}}`); rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	docs := fake.docs(t)
+	if len(docs) != 1 {
+		t.Fatalf("indexed %d logs, want 1", len(docs))
+	}
+	doc := docs[0]
+	delete(doc, "timestamp")
+	delete(doc, "request_id")
+	want := map[string]interface{}{
+		"message": "hi",
+		"level":   "warn",
+		"ctx":     map[string]interface{}{"host": "web-1"},
+		"user":    map[string]interface{}{"id": "u1"},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("indexed %v, want %v", doc, want)
+	}
+}
+
+func TestFieldRenameConflict(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		want   map[string]interface{}
+	}{
+		{renameKeep, map[string]interface{}{"msg": "from msg", "message": "original"}},
+		{renameOverwrite, map[string]interface{}{"message": "from msg"}},
+		{renameDrop, map[string]interface{}{"message": "original"}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			withConfig(t, func(c *Config) {
+				c.FieldRenames = map[string]string{"msg": "message"}
+				c.FieldRenameConflict = tc.policy
+			})
+			doc := map[string]interface{}{"msg": "from msg", "message": "original"}
+			renameFields(doc)
+			if !reflect.DeepEqual(doc, tc.want) {
+				t.Errorf("renamed to %v, want %v", doc, tc.want)
+			}
+		})
+	}
+}
+
+func TestFieldRenameBlockedPathKeepsSource(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.FieldRenames = map[string]string{"uid": "user.id"}
+	})
+	doc := map[string]interface{}{"uid": "u1", "user": "ann"}
+	renameFields(doc)
+	if want := map[string]interface{}{"uid": "u1", "user": "ann"}; !reflect.DeepEqual(doc, want) {
+		t.Errorf("renamed through a non-object to %v, want %v", doc, want)
+	}
+}
+
+func TestFieldRenamesValidated(t *testing.T) {
+	for name, mutate := range map[string]func(*Config){
+		"unknown policy": func(c *Config) { c.FieldRenameConflict = "merge" },
+		"empty target":   func(c *Config) { c.FieldRenames = map[string]string{"msg": ""} },
+		"onto itself":    func(c *Config) { c.FieldRenames = map[string]string{"msg": "msg"} },
+	} {
+		c := defaultConfig()
+		mutate(&c)
+		if err := c.validate(); err == nil {
+			t.Errorf("%s: passed validation", name)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errorCaptureQueueSize bounds the error documents waiting to be indexed, so
+// a client flooding bad payloads cannot pile up memory
+const errorCaptureQueueSize = 1000
+
+var errorsCaptured = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ingest_errors_captured_total",
+		Help: "Total number of ingestion failures sent to ERROR_INDEX, by result",
+	},
+	[]string{"result"},
+)
+
+// rawPrefix keeps the first max bytes read through it, so a failed payload
+// can be captured without buffering the whole body
+type rawPrefix struct {
+	r         io.Reader
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func (p *rawPrefix) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if room := p.max - len(p.buf); room < n {
+		p.buf = append(p.buf, b[:max(room, 0)]...)
+		p.truncated = true
+	} else {
+		p.buf = append(p.buf, b[:n]...)
+	}
+	return n, err
+}
+
+// recordRaw wraps body to remember its start for error capture; it returns
+// body untouched and a nil prefix when ERROR_INDEX is not set
+func recordRaw(body io.Reader) (io.Reader, *rawPrefix) {
+	if errorCapture == nil {
+		return body, nil
+	}
+	p := &rawPrefix{r: body, max: cfg.ErrorRawMaxBytes}
+	return p, p
+}
+
+// errorDoc is the record indexed for a payload that failed ingestion
+type errorDoc struct {
+	Timestamp string `json:"timestamp"`
+	Reason    string `json:"reason"`
+	Route     string `json:"route"`
+	RequestID string `json:"request_id,omitempty"`
+	// Item is the position of the failed log in a bulk, upload or stream
+	Item         *int   `json:"item,omitempty"`
+	Raw          string `json:"raw,omitempty"`
+	RawTruncated bool   `json:"raw_truncated,omitempty"`
+}
+
+// errorSink indexes ingestion failures into ERROR_INDEX in the background,
+// turning them into data that can be searched. Records are dropped when the
+// queue is full, and those still queued at shutdown are lost: capture is a
+// debugging aid, not a delivery guarantee.
+type errorSink struct {
+	queue chan errorDoc
+}
+
+// errorCapture is nil unless ERROR_INDEX is set
+var errorCapture *errorSink
+
+func newErrorSink(size int) *errorSink {
+	return &errorSink{queue: make(chan errorDoc, size)}
+}
+
+func newErrorDoc(r *http.Request, reason string) errorDoc {
+	return errorDoc{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Reason:    reason,
+		Route:     routeLabel(r),
+		RequestID: requestIDFromContext(r.Context()),
+	}
+}
+
+// capture queues a failed payload. The raw body is redacted like logs and
+// cut to ERROR_RAW_MAX_BYTES; prefix may be nil when nothing was read.
+func (s *errorSink) capture(r *http.Request, reason string, prefix *rawPrefix) {
+	if s == nil {
+		return
+	}
+	doc := newErrorDoc(r, reason)
+	if prefix != nil {
+		doc.Raw = redactor.redactString(strings.ToValidUTF8(string(prefix.buf), "�"))
+		doc.RawTruncated = prefix.truncated
+	}
+	s.enqueue(doc)
+}
+
+// captureItem queues one log of a multi-log request that was refused, with
+// its position. The log is captured as it stood when refused, redacted and
+// cut to ERROR_RAW_MAX_BYTES.
+func (s *errorSink) captureItem(r *http.Request, pos int, reason string, logData map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	doc := newErrorDoc(r, reason)
+	doc.Item = &pos
+	if raw, err := json.Marshal(logData); err == nil {
+		redacted := redactor.redactString(string(raw))
+		doc.Raw = truncateUTF8(redacted, cfg.ErrorRawMaxBytes)
+		doc.RawTruncated = len(doc.Raw) < len(redacted)
+	}
+	s.enqueue(doc)
+}
+
+// enqueue hands doc to the indexing goroutine, dropping it when the queue
+// is full
+func (s *errorSink) enqueue(doc errorDoc) {
+	select {
+	case s.queue <- doc:
+	default:
+		errorsCaptured.WithLabelValues("dropped").Inc()
+	}
+}
+
+// run indexes queued error documents until ctx is cancelled
+func (s *errorSink) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case doc := <-s.queue:
+			s.index(ctx, doc)
+		}
+	}
+}
+
+func (s *errorSink) index(ctx context.Context, doc errorDoc) {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		errorsCaptured.WithLabelValues("failed").Inc()
+		return
+	}
+	status, _, err := osRequest(ctx, "error_capture", http.MethodPost, osURL(cfg.ErrorIndex, "_doc"), body)
+	if err != nil || status >= 400 {
+		errorsCaptured.WithLabelValues("failed").Inc()
+		log.Printf("Failed to index ingestion error into %s: status %d, %v", cfg.ErrorIndex, status, err)
+		return
+	}
+	errorsCaptured.WithLabelValues("indexed").Inc()
+}
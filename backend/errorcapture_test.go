@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// indexErrors indexes the error documents queued so far, as the background
+// loop of main would, and returns those the fake received in ERROR_INDEX
+func indexErrors(t *testing.T, fake *fakeOpenSearch) []errorDoc {
+	t.Helper()
+	for len(errorCapture.queue) > 0 {
+		errorCapture.index(context.Background(), <-errorCapture.queue)
+	}
+	var docs []errorDoc
+	for _, c := range fake.requests() {
+		if c.Path != "/"+cfg.ErrorIndex+"/_doc" {
+			continue
+		}
+		var doc errorDoc
+		if err := json.Unmarshal(c.Body, &doc); err != nil {
+			t.Fatalf("decode %s: %v", c.Body, err)
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+func TestValidationFailureCaptured(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.ErrorIndex = "errors"
+		c.TypeRequiredFields = map[string][]string{"audit": {"actor"}}
+		c.RedactPatterns = []string{`token=[^"]+`}
+	})
+	rec := postJSON("/logs", `{"log_type":"audit","message":"token=s3cret"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422: %s", rec.Code, rec.Body)
+	}
+	docs := indexErrors(t, fake)
+	if len(docs) != 1 {
+		t.Fatalf("%d error documents, want 1", len(docs))
+	}
+	doc := docs[0]
+	if !strings.Contains(doc.Reason, "actor") {
+		t.Errorf("reason %q does not name the missing field", doc.Reason)
+	}
+	if doc.Route != "/logs" || doc.RequestID == "" || doc.Item != nil {
+		t.Errorf("route %q, request_id %q, item %v", doc.Route, doc.RequestID, doc.Item)
+	}
+	if _, err := time.Parse(time.RFC3339, doc.Timestamp); err != nil {
+		t.Errorf("timestamp %q: %v", doc.Timestamp, err)
+	}
+	if !strings.Contains(doc.Raw, `"log_type":"audit"`) || strings.Contains(doc.Raw, "s3cret") || doc.RawTruncated {
+		t.Errorf("raw %q (truncated %v), want the payload with the token masked", doc.Raw, doc.RawTruncated)
+	}
+	for _, c := range fake.writes() {
+		if !strings.HasPrefix(c.Path, "/errors/") {
+			t.Errorf("refused log written to %s", c.Path)
+		}
+	}
+}
+
+func TestParseFailureCapturedTruncated(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.ErrorIndex = "errors"
+		c.ErrorRawMaxBytes = 5
+	})
+	if rec := postJSON("/logs", `{"message":`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", rec.Code, rec.Body)
+	}
+	docs := indexErrors(t, fake)
+	if len(docs) != 1 {
+		t.Fatalf("%d error documents, want 1", len(docs))
+	}
+	if doc := docs[0]; doc.Reason != "Invalid log format" || doc.Raw != `{"mes` || !doc.RawTruncated {
+		t.Errorf("captured %+v, want the first 5 bytes marked truncated", doc)
+	}
+}
+
+func TestRefusedItemsCaptured(t *testing.T) {
+	const bad = `{"log_type":"audit","message":"no actor"}`
+	for _, tc := range []struct {
+		route  string
+		ingest func(t *testing.T)
+	}{
+		{"/logs/bulk", func(t *testing.T) {
+			postJSON("/logs/bulk", `[{"message":"ok"},`+bad+`]`)
+		}},
+		{"/logs/upload", func(t *testing.T) {
+			var file bytes.Buffer
+			gz := gzip.NewWriter(&file)
+			gz.Write([]byte(`{"message":"ok"}` + "\n" + bad + "\n"))
+			gz.Close()
+			postUpload(t, file.Bytes())
+		}},
+		{"/logs/ws", func(t *testing.T) {
+			conn := dialStream(t)
+			for _, msg := range []string{`{"message":"ok"}`, bad} {
+				if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			readAcks(t, conn, 1)
+		}},
+	} {
+		t.Run(tc.route, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				c.ErrorIndex = "errors"
+				c.TypeRequiredFields = map[string][]string{"audit": {"actor"}}
+				c.WSFlushInterval = 20 * time.Millisecond
+			})
+			tc.ingest(t)
+			docs := indexErrors(t, fake)
+			if len(docs) != 1 {
+				t.Fatalf("%d error documents, want 1", len(docs))
+			}
+			doc := docs[0]
+			if doc.Item == nil || *doc.Item != 1 || doc.Route != tc.route {
+				t.Errorf("captured item %v on %q, want item 1 on %s", doc.Item, doc.Route, tc.route)
+			}
+			if !strings.Contains(doc.Raw, "no actor") {
+				t.Errorf("raw %q, want the refused log", doc.Raw)
+			}
+		})
+	}
+}
+
+func TestErrorCaptureDisabledByDefault(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	if errorCapture != nil {
+		t.Fatal("error capture enabled without ERROR_INDEX")
+	}
+	if rec := postJSON("/logs", `{"message":`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", rec.Code, rec.Body)
+	}
+	if n := len(fake.requests()); n != 0 {
+		t.Errorf("%d calls made to OpenSearch, want none", n)
+	}
+}
+
+func TestErrorCaptureOutcomesCounted(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.ErrorIndex = "errors" })
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	})
+	failed := testutil.ToFloat64(errorsCaptured.WithLabelValues("failed"))
+	dropped := testutil.ToFloat64(errorsCaptured.WithLabelValues("dropped"))
+
+	errorCapture = newErrorSink(1)
+	postJSON("/logs", `not json`)
+	postJSON("/logs", `not json either`)
+	indexErrors(t, fake)
+
+	if got := testutil.ToFloat64(errorsCaptured.WithLabelValues("dropped")) - dropped; got != 1 {
+		t.Errorf("dropped grew by %v, want 1 with a full queue", got)
+	}
+	if got := testutil.ToFloat64(errorsCaptured.WithLabelValues("failed")) - failed; got != 1 {
+		t.Errorf("failed grew by %v, want 1 for the 500", got)
+	}
+}
+
+func TestErrorIndexValidated(t *testing.T) {
+	for _, tc := range []struct {
+		index string
+		raw   int
+	}{
+		{"Errors", 100},
+		{"_errors", 100},
+		{"errors", -1},
+	} {
+		c := defaultConfig()
+		c.ErrorIndex, c.ErrorRawMaxBytes = tc.index, tc.raw
+		if err := c.validate(); err == nil {
+			t.Errorf("ERROR_INDEX %q, ERROR_RAW_MAX_BYTES %d passed validation", tc.index, tc.raw)
+		}
+	}
+}
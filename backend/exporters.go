@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+var traceExporterHealthy = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "trace_exporter_healthy",
+		Help: "Whether the last span export of each trace exporter succeeded: 1 yes, 0 no",
+	},
+	[]string{"exporter"},
+)
+
+// defaultExporterLabel names the exporter built from OTEL_EXPORTER_OTLP_*
+const defaultExporterLabel = "default"
+
+// healthTrackingExporter records the outcome of every export in
+// trace_exporter_healthy. The OTLP exporters need no reconnect of their own:
+// HTTP opens a request per batch and gRPC redials in the background, both
+// retrying with backoff, so a restarted collector is picked up on the next
+// batch and the gauge turns back to 1.
+type healthTrackingExporter struct {
+	trace.SpanExporter
+	healthy prometheus.Gauge
+}
+
+func trackExporterHealth(exporter trace.SpanExporter, label string) trace.SpanExporter {
+	healthy := traceExporterHealthy.WithLabelValues(label)
+	// Nothing has failed yet; exporters stay healthy until an export says otherwise
+	healthy.Set(1)
+	return healthTrackingExporter{SpanExporter: exporter, healthy: healthy}
+}
+
+func (e healthTrackingExporter) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil {
+		e.healthy.Set(0)
+	} else {
+		e.healthy.Set(1)
+	}
+	return err
+}
+
+// TraceExporter is one OTLP endpoint spans are sent to
+type TraceExporter struct {
+	// Endpoint is the collector URL, e.g. http://jaeger:4318
+	Endpoint string            `yaml:"endpoint"`
+	Headers  map[string]string `yaml:"headers"`
+	// Protocol is "http/protobuf" (the default) or "grpc"
+	Protocol string `yaml:"protocol"`
+}
+
+// envTraceExporters reads OTEL_EXPORTER_<n>_ENDPOINT, _HEADERS and _PROTOCOL
+// for n = 1, 2, ... until an endpoint is missing
+func envTraceExporters(dst *[]TraceExporter) error {
+	var exporters []TraceExporter
+	for n := 1; ; n++ {
+		prefix := "OTEL_EXPORTER_" + strconv.Itoa(n) + "_"
+		if os.Getenv(prefix+"ENDPOINT") == "" {
+			break
+		}
+		var e TraceExporter
+		envString(prefix+"ENDPOINT", &e.Endpoint)
+		envString(prefix+"PROTOCOL", &e.Protocol)
+		if err := envMap(prefix+"HEADERS", &e.Headers); err != nil {
+			return err
+		}
+		exporters = append(exporters, e)
+	}
+	if len(exporters) > 0 {
+		*dst = exporters
+	}
+	return nil
+}
+
+func (e TraceExporter) validate() error {
+	if e.Endpoint == "" {
+		return fmt.Errorf("trace exporter: endpoint is required")
+	}
+	switch e.Protocol {
+	case "", "http/protobuf", "grpc":
+		return nil
+	}
+	return fmt.Errorf("trace exporter %s: unknown protocol %q", e.Endpoint, e.Protocol)
+}
+
+func (e TraceExporter) build(ctx context.Context) (trace.SpanExporter, error) {
+	if e.Protocol == "grpc" {
+		return otlptracegrpc.New(ctx,
+			otlptracegrpc.WithEndpointURL(e.Endpoint),
+			otlptracegrpc.WithHeaders(e.Headers),
+		)
+	}
+	return otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(e.Endpoint),
+		otlptracehttp.WithHeaders(e.Headers),
+	)
+}
+
+// spanProcessorOptions gives every configured exporter its own batch
+// processor, so a slow or failing endpoint does not hold up the others.
+// Without TRACE_EXPORTERS the standard OTEL_EXPORTER_OTLP_* settings apply.
+func spanProcessorOptions(ctx context.Context, exporters []TraceExporter) ([]trace.TracerProviderOption, error) {
+	if len(exporters) == 0 {
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		}
+		return []trace.TracerProviderOption{trace.WithBatcher(trackExporterHealth(scrubExporter(exporter), defaultExporterLabel))}, nil
+	}
+	opts := make([]trace.TracerProviderOption, 0, len(exporters))
+	for _, e := range exporters {
+		exporter, err := e.build(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create trace exporter for %s: %w", e.Endpoint, err)
+		}
+		opts = append(opts, trace.WithBatcher(trackExporterHealth(scrubExporter(exporter), e.Endpoint)))
+	}
+	return opts, nil
+}
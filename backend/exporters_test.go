@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// fakeCollector counts OTLP/HTTP trace exports carrying the X-Tenant header
+type fakeCollector struct {
+	*httptest.Server
+	exports atomic.Int64
+}
+
+func newFakeCollector(t *testing.T, tenant string) *fakeCollector {
+	t.Helper()
+	c := &fakeCollector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" && r.Header.Get("X-Tenant") == tenant {
+			c.exports.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestSpansFanOutToEveryExporter(t *testing.T) {
+	jaeger, vendor := newFakeCollector(t, "dev"), newFakeCollector(t, "prod")
+	opts, err := spanProcessorOptions(context.Background(), []TraceExporter{
+		{Endpoint: jaeger.URL + "/v1/traces", Headers: map[string]string{"X-Tenant": "dev"}},
+		{Endpoint: vendor.URL + "/v1/traces", Headers: map[string]string{"X-Tenant": "prod"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
+	_, span := tp.Tracer("test").Start(context.Background(), "ingest")
+	span.End()
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if jaeger.exports.Load() != 1 || vendor.exports.Load() != 1 {
+		t.Errorf("exports: jaeger %d, vendor %d, want 1 each", jaeger.exports.Load(), vendor.exports.Load())
+	}
+}
+
+// failingExporter rejects every export
+type failingExporter struct{}
+
+func (failingExporter) ExportSpans(context.Context, []sdktrace.ReadOnlySpan) error {
+	return errors.New("collector unavailable")
+}
+
+func (failingExporter) Shutdown(context.Context) error { return nil }
+
+// countingExporter counts the spans exported to it
+type countingExporter struct{ spans atomic.Int64 }
+
+func (e *countingExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.spans.Add(int64(len(spans)))
+	return nil
+}
+
+func (e *countingExporter) Shutdown(context.Context) error { return nil }
+
+func TestFailingExporterDoesNotAffectOthers(t *testing.T) {
+	working := &countingExporter{}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(trackExporterHealth(failingExporter{}, "broken")),
+		sdktrace.WithBatcher(trackExporterHealth(working, "working")),
+	)
+	_, span := tp.Tracer("test").Start(context.Background(), "ingest")
+	span.End()
+	tp.Shutdown(context.Background())
+
+	if n := working.spans.Load(); n != 1 {
+		t.Errorf("working exporter got %d spans, want 1", n)
+	}
+	if got := testutil.ToFloat64(traceExporterHealthy.WithLabelValues("broken")); got != 0 {
+		t.Errorf("trace_exporter_healthy{exporter=broken} = %v, want 0", got)
+	}
+	if got := testutil.ToFloat64(traceExporterHealthy.WithLabelValues("working")); got != 1 {
+		t.Errorf("trace_exporter_healthy{exporter=working} = %v, want 1", got)
+	}
+}
+
+func TestTraceExportersFromEnv(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_1_ENDPOINT", "http://jaeger:4318/v1/traces")
+	t.Setenv("OTEL_EXPORTER_2_ENDPOINT", "collector.vendor:4317")
+	t.Setenv("OTEL_EXPORTER_2_PROTOCOL", "grpc")
+	t.Setenv("OTEL_EXPORTER_2_HEADERS", "api-key=secret")
+	// A gap ends the list
+	t.Setenv("OTEL_EXPORTER_4_ENDPOINT", "http://ignored:4318")
+
+	var got []TraceExporter
+	if err := envTraceExporters(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []TraceExporter{
+		{Endpoint: "http://jaeger:4318/v1/traces"},
+		{Endpoint: "collector.vendor:4317", Protocol: "grpc", Headers: map[string]string{"api-key": "secret"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("exporters %+v, want %+v", got, want)
+	}
+	if err := (TraceExporter{Endpoint: "x", Protocol: "thrift"}).validate(); err == nil {
+		t.Error("unknown protocol passed validation")
+	}
+}
+
+func TestTraceExporterRecoversWithCollector(t *testing.T) {
+	var down atomic.Bool
+	var exports atomic.Int64
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			// A permanent error, so the exporter gives up on the batch at once
+			// instead of retrying it with backoff
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		exports.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(collector.Close)
+	endpoint := collector.URL + "/v1/traces"
+	opts, err := spanProcessorOptions(context.Background(), []TraceExporter{{Endpoint: endpoint}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	healthy := traceExporterHealthy.WithLabelValues(endpoint)
+
+	export := func() {
+		_, span := tp.Tracer("test").Start(context.Background(), "ingest")
+		span.End()
+		tp.ForceFlush(context.Background())
+	}
+	for _, step := range []struct {
+		name string
+		down bool
+		want float64
+	}{
+		{"collector up", false, 1},
+		{"collector failing", true, 0},
+		{"still failing", true, 0},
+		{"collector back", false, 1},
+	} {
+		down.Store(step.down)
+		export()
+		if got := testutil.ToFloat64(healthy); got != step.want {
+			t.Errorf("%s: trace_exporter_healthy = %v, want %v", step.name, got, step.want)
+		}
+	}
+	if n := exports.Load(); n != 2 {
+		t.Errorf("collector received %d exports, want 2 from the same provider", n)
+	}
+}
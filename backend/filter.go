@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var logsFiltered = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "logs_filtered_total",
+		Help: "Total number of logs dropped by a filter rule",
+	},
+	[]string{"rule"},
+)
+
+// FilterRule drops documents whose field matches the configured value
+type FilterRule struct {
+	Name  string `json:"name" yaml:"name"`
+	Field string `json:"field" yaml:"field"`
+	// Op is one of "equals", "contains" or "regex"
+	Op    string `json:"op" yaml:"op"`
+	Value string `json:"value" yaml:"value"`
+
+	re *regexp.Regexp
+}
+
+// compile validates the rule and prepares its regular expression
+func (f *FilterRule) compile() error {
+	if f.Name == "" || f.Field == "" {
+		return fmt.Errorf("filter rule %q: name and field are required", f.Name)
+	}
+	switch f.Op {
+	case "equals", "contains":
+	case "regex":
+		re, err := regexp.Compile(f.Value)
+		if err != nil {
+			return fmt.Errorf("filter rule %q: %w", f.Name, err)
+		}
+		f.re = re
+	default:
+		return fmt.Errorf("filter rule %q: unknown op %q", f.Name, f.Op)
+	}
+	return nil
+}
+
+// matches reports whether the document satisfies the rule
+func (f *FilterRule) matches(doc map[string]interface{}) bool {
+	v, ok := lookupField(doc, f.Field)
+	if !ok {
+		return false
+	}
+	s := fmt.Sprint(v)
+	switch f.Op {
+	case "equals":
+		return s == f.Value
+	case "contains":
+		return strings.Contains(s, f.Value)
+	case "regex":
+		return f.re.MatchString(s)
+	}
+	return false
+}
+
+// matchFilter returns the name of the first rule matching the document
+func matchFilter(doc map[string]interface{}) (string, bool) {
+	for i := range cfg.FilterRules {
+		if cfg.FilterRules[i].matches(doc) {
+			return cfg.FilterRules[i].Name, true
+		}
+	}
+	return "", false
+}
+
+// lookupField resolves a dotted path such as "http.path" inside a document
+func lookupField(doc map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := doc[path]; ok {
+		return v, true
+	}
+	var cur interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFilterRuleDropsHealthChecks(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.FilterRules = []FilterRule{{Name: "health", Field: "path", Op: "equals", Value: "/health"}}
+	})
+	filtered := testutil.ToFloat64(logsFiltered.WithLabelValues("health"))
+
+	if rec := postJSON("/logs", `{"message":"probe","path":"/health"}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("health log: status %d, body %s", rec.Code, rec.Body)
+	}
+	if len(fake.writes()) != 0 {
+		t.Fatal("filtered log was sent to OpenSearch")
+	}
+	if got := testutil.ToFloat64(logsFiltered.WithLabelValues("health")) - filtered; got != 1 {
+		t.Errorf("logs_filtered_total{rule=health} grew by %v, want 1", got)
+	}
+
+	if rec := postJSON("/logs", `{"message":"order placed","path":"/orders"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("other log: status %d, body %s", rec.Code, rec.Body)
+	}
+	docs := fake.docs(t)
+	if len(docs) != 1 || docs[0]["path"] != "/orders" {
+		t.Errorf("indexed %v, want the /orders log", docs)
+	}
+}
+
+func TestFilterRuleOps(t *testing.T) {
+	doc := map[string]interface{}{"http": map[string]interface{}{"path": "/health/live"}}
+	for _, tc := range []struct {
+		rule FilterRule
+		want bool
+	}{
+		{FilterRule{Name: "eq", Field: "http.path", Op: "equals", Value: "/health/live"}, true},
+		{FilterRule{Name: "eq", Field: "http.path", Op: "equals", Value: "/health"}, false},
+		{FilterRule{Name: "contains", Field: "http.path", Op: "contains", Value: "health"}, true},
+		{FilterRule{Name: "regex", Field: "http.path", Op: "regex", Value: `^/health/(live|ready)$`}, true},
+		{FilterRule{Name: "missing", Field: "path", Op: "contains", Value: "health"}, false},
+	} {
+		if err := tc.rule.compile(); err != nil {
+			t.Fatal(err)
+		}
+		if got := tc.rule.matches(doc); got != tc.want {
+			t.Errorf("%s %q: matches = %v, want %v", tc.rule.Op, tc.rule.Value, got, tc.want)
+		}
+	}
+}
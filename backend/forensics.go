@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"net/http"
+)
+
+// readBody reads the request body once, keeping the raw bytes when this
+// request is sampled for forensic capture
+func readBody(r *http.Request) (io.Reader, []byte, error) {
+	if cfg.RawBodySampleRate <= 0 || rand.Float64() >= cfg.RawBodySampleRate {
+		return r.Body, nil, nil
+	}
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytes.NewReader(raw), raw, nil
+}
+
+// attachRawBody stores the captured body in the configured field, cut down
+// to RAW_BODY_MAX_BYTES
+func attachRawBody(logData map[string]interface{}, raw []byte) {
+	if raw == nil {
+		return
+	}
+	if cfg.RawBodyMaxBytes > 0 && len(raw) > cfg.RawBodyMaxBytes {
+		raw = raw[:cfg.RawBodyMaxBytes]
+		logData[cfg.RawBodyField+"_truncated"] = true
+	}
+	logData[cfg.RawBodyField] = redactor.redactString(string(raw))
+}
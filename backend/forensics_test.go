@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRawBodyCaptured(t *testing.T) {
+	const body = `{ "message" : "paid",  "amount": 12.50 }`
+	for _, tc := range []struct {
+		name      string
+		rate      float64
+		maxBytes  int
+		want      interface{}
+		truncated bool
+	}{
+		{"sampled", 1, 0, body, false},
+		{"truncated", 1, 10, body[:10], true},
+		{"not sampled", 0, 0, nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				c.RawBodySampleRate = tc.rate
+				c.RawBodyMaxBytes = tc.maxBytes
+			})
+			if rec := postJSON("/logs", body); rec.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			docs := fake.docs(t)
+			if len(docs) != 1 {
+				t.Fatalf("indexed %d logs, want 1", len(docs))
+			}
+			if docs[0]["message"] != "paid" || docs[0]["amount"] != 12.5 {
+				t.Errorf("parsed log changed by the capture: %v", docs[0])
+			}
+			if got := docs[0]["raw_body"]; got != tc.want {
+				t.Errorf("raw_body = %#v, want %#v", got, tc.want)
+			}
+			if _, truncated := docs[0]["raw_body_truncated"]; truncated != tc.truncated {
+				t.Errorf("raw_body_truncated set = %v, want %v", truncated, tc.truncated)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+// errorWindowBuckets is how many slots the error rate window is split into;
+// the window slides one slot at a time
+const errorWindowBuckets = 10
+
+type windowBucket struct {
+	slot   int64
+	total  int64
+	errors int64
+}
+
+// errorRateWindow counts responses and 5xx responses over a sliding window
+// made of fixed-width buckets, so old traffic ages out one bucket at a time
+type errorRateWindow struct {
+	mu      sync.Mutex
+	width   time.Duration
+	buckets [errorWindowBuckets]windowBucket
+}
+
+func newErrorRateWindow(window time.Duration) *errorRateWindow {
+	width := window / errorWindowBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return &errorRateWindow{width: width}
+}
+
+var httpErrorWindow = newErrorRateWindow(time.Minute)
+
+// record counts one response; a nil window ignores it
+func (e *errorRateWindow) record(status int, now time.Time) {
+	if e == nil {
+		return
+	}
+	slot := now.UnixNano() / int64(e.width)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	b := &e.buckets[slot%errorWindowBuckets]
+	if b.slot != slot {
+		*b = windowBucket{slot: slot}
+	}
+	b.total++
+	if status >= 500 {
+		b.errors++
+	}
+}
+
+// counts returns the responses and 5xx responses within the window
+func (e *errorRateWindow) counts(now time.Time) (total, errors int64) {
+	if e == nil {
+		return 0, 0
+	}
+	slot := now.UnixNano() / int64(e.width)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, b := range e.buckets {
+		if slot-b.slot < errorWindowBuckets {
+			total += b.total
+			errors += b.errors
+		}
+	}
+	return total, errors
+}
+
+// probeRoute reports whether route is polled by orchestrators, whose
+// responses would dilute the error rate
+func probeRoute(route string) bool {
+	return route == "/health" || route == "/health/detailed" || route == "/ready"
+}
+
+// detailedHealthHandler reports "degraded" while the share of 5xx responses
+// over HEALTH_ERROR_WINDOW exceeds HEALTH_DEGRADED_ERROR_RATE, and "healthy"
+// otherwise. It answers 200 either way since the process is up; the status
+// is for alerting and dashboards. Windows with fewer than
+// HEALTH_ERROR_MIN_REQUESTS requests are too small to judge and count as healthy.
+func detailedHealthHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := otel.Tracer("telyx-backend").Start(r.Context(), "detailedHealthHandler")
+	defer span.End()
+
+	total, errors := httpErrorWindow.counts(time.Now())
+	var rate float64
+	if total > 0 {
+		rate = float64(errors) / float64(total)
+	}
+	status := "healthy"
+	if total >= int64(cfg.HealthErrorMinRequests) && rate > cfg.HealthDegradedErrorRate {
+		status = "degraded"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":         status,
+		"error_rate":     rate,
+		"requests":       total,
+		"errors":         errors,
+		"window_seconds": cfg.HealthErrorWindow.Seconds(),
+		"threshold":      cfg.HealthDegradedErrorRate,
+		"circuit_state":  osBreaker.currentState(),
+		"node_circuits":  osNodes.states(),
+		"time":           time.Now().Format(time.RFC3339),
+	})
+}
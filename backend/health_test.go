@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// detailedHealth fetches /health/detailed
+func detailedHealth(t *testing.T) map[string]interface{} {
+	t.Helper()
+	rec := do(httptest.NewRequest(http.MethodGet, "/health/detailed", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/health/detailed status %d", rec.Code)
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestHealthDegradedOnErrorRate(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.HealthDegradedErrorRate = 0.5
+		c.HealthErrorMinRequests = 4
+	})
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	})
+
+	for i := 0; i < 3; i++ {
+		if rec := postJSON("/logs", `{"message":"hi"}`); rec.Code < 500 {
+			t.Fatalf("status %d, want a 5xx", rec.Code)
+		}
+	}
+	// Too few requests to judge yet, and probes do not count
+	detailedHealth(t)
+	if res := detailedHealth(t); res["status"] != "healthy" || res["requests"] != 3.0 {
+		t.Errorf("after 3 requests: %v, want healthy", res)
+	}
+
+	postJSON("/logs", `{"message":"hi"}`)
+	res := detailedHealth(t)
+	if res["status"] != "degraded" || res["error_rate"] != 1.0 {
+		t.Errorf("after 4 failures: %v, want degraded", res)
+	}
+
+	// Successes bring the rate back under the threshold
+	fake.setRespond(nil)
+	for i := 0; i < 5; i++ {
+		postJSON("/logs", `{"message":"hi"}`)
+	}
+	if res := detailedHealth(t); res["status"] != "healthy" {
+		t.Errorf("after recovering: %v, want healthy", res)
+	}
+}
+
+func TestErrorRateWindowSlides(t *testing.T) {
+	w := newErrorRateWindow(10 * time.Second)
+	start := time.Unix(1_700_000_000, 0)
+	w.record(http.StatusBadGateway, start)
+	w.record(http.StatusOK, start.Add(5*time.Second))
+
+	if total, errors := w.counts(start.Add(9 * time.Second)); total != 2 || errors != 1 {
+		t.Errorf("within the window: %d requests, %d errors, want 2 and 1", total, errors)
+	}
+	if total, errors := w.counts(start.Add(12 * time.Second)); total != 1 || errors != 0 {
+		t.Errorf("after the error aged out: %d requests, %d errors, want 1 and 0", total, errors)
+	}
+	if total, _ := w.counts(start.Add(time.Minute)); total != 0 {
+		t.Errorf("long after: %d requests, want 0", total)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	initMetrics()
+	os.Exit(m.Run())
+}
+
+// withConfig runs the rest of a test against the default config changed by
+// mutate, rebuilding the state main derives from it. The previous config
+// and state are restored when the test ends.
+func withConfig(t *testing.T, mutate func(*Config)) {
+	t.Helper()
+	saved := cfg
+	t.Cleanup(func() {
+		cfg = saved
+		if err := initState(); err != nil {
+			t.Errorf("restore state: %v", err)
+		}
+	})
+	c := defaultConfig()
+	c.OpenSearchRetryBackoff = time.Millisecond
+	if mutate != nil {
+		mutate(&c)
+	}
+	if err := c.validate(); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+	cfg = c
+	if err := initState(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// osCall is one request received by a fakeOpenSearch
+type osCall struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+// fakeOpenSearch stands in for an OpenSearch cluster and records every call.
+// Calls respond does not handle are accepted: writes get 201, _bulk gets a
+// created item per action and anything else 200 with an empty object.
+type fakeOpenSearch struct {
+	*httptest.Server
+	mu      sync.Mutex
+	calls   []osCall
+	respond func(w http.ResponseWriter, c osCall) bool
+}
+
+func newFakeOpenSearch(t *testing.T) *fakeOpenSearch {
+	t.Helper()
+	f := &fakeOpenSearch{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// withOpenSearch is withConfig with OPENSEARCH_URL pointing at a new fake
+func withOpenSearch(t *testing.T, mutate func(*Config)) *fakeOpenSearch {
+	t.Helper()
+	f := newFakeOpenSearch(t)
+	withConfig(t, func(c *Config) {
+		c.OpenSearchURL = f.URL
+		if mutate != nil {
+			mutate(c)
+		}
+	})
+	return f
+}
+
+func (f *fakeOpenSearch) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	c := osCall{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Header: r.Header.Clone(), Body: body}
+	f.mu.Lock()
+	f.calls = append(f.calls, c)
+	respond := f.respond
+	f.mu.Unlock()
+
+	if respond != nil && respond(w, c) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(c.Path, "/_bulk"):
+		w.Write(bulkResponse(body, http.StatusCreated))
+	case strings.Contains(c.Path, "/_doc"):
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"result":"created"}`))
+	default:
+		w.Write([]byte(`{}`))
+	}
+}
+
+// setRespond replaces the responses of the fake; fn returns false to fall
+// back to the default one
+func (f *fakeOpenSearch) setRespond(fn func(w http.ResponseWriter, c osCall) bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.respond = fn
+}
+
+// requests returns the calls received so far
+func (f *fakeOpenSearch) requests() []osCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// writes returns the calls that indexed documents
+func (f *fakeOpenSearch) writes() []osCall {
+	var writes []osCall
+	for _, c := range f.requests() {
+		if strings.HasSuffix(c.Path, "/_bulk") || strings.Contains(c.Path, "/_doc") {
+			writes = append(writes, c)
+		}
+	}
+	return writes
+}
+
+// docs returns every document sent through _doc or _bulk, in order
+func (f *fakeOpenSearch) docs(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	var docs []map[string]interface{}
+	for _, c := range f.writes() {
+		if !strings.HasSuffix(c.Path, "/_bulk") {
+			docs = append(docs, decodeDoc(t, c.Body))
+			continue
+		}
+		_, sources := bulkLines(c.Body)
+		for _, line := range sources {
+			docs = append(docs, decodeDoc(t, line))
+		}
+	}
+	return docs
+}
+
+// bulkLines splits a _bulk body into its action lines and the source line
+// following each of them
+func bulkLines(body []byte) (actions, sources [][]byte) {
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	for i := 0; i+1 < len(lines); i += 2 {
+		actions = append(actions, lines[i])
+		sources = append(sources, lines[i+1])
+	}
+	return actions, sources
+}
+
+// bulkResponse answers every action of a _bulk body with status
+func bulkResponse(body []byte, status int) []byte {
+	n := bytes.Count(bytes.TrimSpace(body), []byte("\n"))/2 + 1
+	result := "created"
+	if status >= 300 {
+		result = ""
+	}
+	items := make([]interface{}, n)
+	for i := range items {
+		item := map[string]interface{}{"status": status, "result": result}
+		if status >= 300 {
+			item["error"] = map[string]interface{}{"type": "mapper_parsing_exception"}
+		}
+		items[i] = map[string]interface{}{"index": item}
+	}
+	out, _ := json.Marshal(map[string]interface{}{"errors": status >= 300, "items": items})
+	return out
+}
+
+func decodeDoc(t *testing.T, data []byte) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+	return doc
+}
+
+// do sends a request through the public handler and records the response
+func do(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	newPublicHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+// postJSON posts body to path through the public handler
+func postJSON(path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return do(req)
+}
+
+// sampleCount returns the number of observations of a histogram
+func sampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// recordSpans installs a tracer provider, built with opts, recording every
+// sampled span ended until the test finishes. The global provider cannot be
+// handed back once replaced, so a non-recording one takes its place after.
+func recordSpans(t *testing.T, opts ...sdktrace.TracerProviderOption) *tracetest.SpanRecorder {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(append(opts, sdktrace.WithSpanProcessor(rec))...))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	return rec
+}
+
+// endedSpan returns the first ended span called name
+func endedSpan(t *testing.T, rec *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, s := range rec.Ended() {
+		if s.Name() == name {
+			return s
+		}
+	}
+	t.Fatalf("no %s span was ended", name)
+	return nil
+}
+
+// spanAttr returns the value of the attribute key of s
+func spanAttr(s sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+// syncBuffer is a bytes.Buffer safe for the log package and the test to share
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog collects the standard logger's output until the test ends
+func captureLog(t *testing.T) *syncBuffer {
+	t.Helper()
+	buf := &syncBuffer{}
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+	return buf
+}
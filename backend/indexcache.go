@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// indexCache remembers routed indices known to exist so they are bootstrapped
+// once rather than checked on every write. Entries expire after
+// INDEX_EXISTS_CACHE_TTL, so an index deleted behind our back is recreated.
+type indexCache struct {
+	mu    sync.Mutex
+	known map[string]time.Time
+	// creating serialises the bootstrap of each index, waiters block on the channel
+	creating map[string]chan struct{}
+}
+
+var knownIndices = &indexCache{known: map[string]time.Time{}, creating: map[string]chan struct{}{}}
+
+// ensureIndex makes sure a routed index exists before writing to it,
+// creating it, or the data stream of that name, when missing. The default
+// write target is bootstrapped at startup and never checked here.
+func (c *indexCache) ensureIndex(ctx context.Context, index string) error {
+	if index == writeTarget() {
+		return nil
+	}
+	for {
+		c.mu.Lock()
+		if checked, ok := c.known[index]; ok && time.Since(checked) < cfg.IndexExistsCacheTTL {
+			c.mu.Unlock()
+			return nil
+		}
+		wait, busy := c.creating[index]
+		if !busy {
+			done := make(chan struct{})
+			c.creating[index] = done
+			c.mu.Unlock()
+
+			err := createIndex(ctx, index)
+			c.mu.Lock()
+			if err == nil {
+				c.known[index] = time.Now()
+			}
+			delete(c.creating, index)
+			c.mu.Unlock()
+			close(done)
+			return err
+		}
+		c.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// createIndex creates index unless it exists; index templates supply its
+// settings and mappings
+func createIndex(ctx context.Context, index string) error {
+	target := osURL(index)
+	if cfg.OpenSearchUseDataStream {
+		target = osURL("_data_stream", index)
+	}
+	status, _, err := osRequest(ctx, "bootstrap", http.MethodHead, osURL(index), nil)
+	if err != nil {
+		return fmt.Errorf("check index %s: %w", index, err)
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	status, body, err := osRequest(ctx, "bootstrap", http.MethodPut, target, nil)
+	if err != nil {
+		return fmt.Errorf("create index %s: %w", index, err)
+	}
+	// Another instance may have created it in the meantime
+	if status >= 400 && !bytes.Contains(body, []byte("already_exists")) {
+		return fmt.Errorf("create index %s: OpenSearch returned %d", index, status)
+	}
+	log.Printf("Created index %s", index)
+	return nil
+}
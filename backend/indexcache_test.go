@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// emulateIndices makes fake answer HEAD with 404 until the index is created
+// with PUT. Creation fails while failCreate is set.
+func emulateIndices(fake *fakeOpenSearch, failCreate *bool) {
+	var mu sync.Mutex
+	created := map[string]bool{}
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		if strings.Contains(c.Path, "/_") {
+			return false
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case c.Method == http.MethodHead && !created[c.Path]:
+			w.WriteHeader(http.StatusNotFound)
+		case c.Method == http.MethodPut && *failCreate:
+			w.WriteHeader(http.StatusInternalServerError)
+		case c.Method == http.MethodPut:
+			created[c.Path] = true
+		}
+		return true
+	})
+}
+
+// indexCalls counts the existence checks and creations of index
+func indexCalls(fake *fakeOpenSearch, index string) (heads, puts int) {
+	for _, c := range fake.requests() {
+		if c.Path != "/"+index {
+			continue
+		}
+		switch c.Method {
+		case http.MethodHead:
+			heads++
+		case http.MethodPut:
+			puts++
+		}
+	}
+	return heads, puts
+}
+
+func TestIndexBootstrappedOnce(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.IndexPerTenant = true })
+	emulateIndices(fake, new(bool))
+
+	for i := 0; i < 3; i++ {
+		if rec := postJSON("/logs", `{"tenant":"acme"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	if heads, puts := indexCalls(fake, "logs-acme"); heads != 1 || puts != 1 {
+		t.Errorf("logs-acme checked %d and created %d times, want once each", heads, puts)
+	}
+	postJSON("/logs/bulk", `[{"tenant":"globex"},{"tenant":"globex"},{"tenant":"acme"}]`)
+	if heads, puts := indexCalls(fake, "logs-globex"); heads != 1 || puts != 1 {
+		t.Errorf("logs-globex checked %d and created %d times, want once each", heads, puts)
+	}
+	if heads, _ := indexCalls(fake, "logs"); heads != 0 {
+		t.Errorf("default write target checked %d times, want 0", heads)
+	}
+}
+
+func TestIndexCacheExpires(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.IndexPerTenant = true
+		c.IndexExistsCacheTTL = 20 * time.Millisecond
+	})
+	emulateIndices(fake, new(bool))
+	postJSON("/logs", `{"tenant":"acme"}`)
+	time.Sleep(30 * time.Millisecond)
+	postJSON("/logs", `{"tenant":"acme"}`)
+	if heads, puts := indexCalls(fake, "logs-acme"); heads != 2 || puts != 1 {
+		t.Errorf("checked %d and created %d times, want 2 checks and 1 creation", heads, puts)
+	}
+}
+
+func TestIndexCacheSkipsFailedCreation(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.IndexPerTenant = true })
+	failing := true
+	emulateIndices(fake, &failing)
+	if err := knownIndices.ensureIndex(context.Background(), "logs-acme"); err == nil {
+		t.Fatal("failed creation reported no error")
+	}
+	failing = false
+	if err := knownIndices.ensureIndex(context.Background(), "logs-acme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, puts := indexCalls(fake, "logs-acme"); puts != 2 {
+		t.Errorf("created %d times, want a retry after the failure", puts)
+	}
+}
+
+func TestIndexBootstrapSharedByConcurrentWrites(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.IndexPerTenant = true })
+	emulateIndices(fake, new(bool))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := knownIndices.ensureIndex(context.Background(), "logs-acme"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if heads, puts := indexCalls(fake, "logs-acme"); heads != 1 || puts != 1 {
+		t.Errorf("checked %d and created %d times, want once each", heads, puts)
+	}
+}
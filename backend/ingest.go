@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reasons a log is not indexed; the set is fixed to keep the label bounded
+const (
+	dropInvalid     = "invalid"
+	dropValidation  = "validation"
+	dropFiltered    = "filtered"
+	dropSampled     = "sampled"
+	dropDuplicate   = "duplicate"
+	dropRateLimited = "rate_limited"
+	dropIndexFailed = "index_failed"
+	dropConflict    = "version_conflict"
+)
+
+var dropReasons = []string{dropInvalid, dropValidation, dropFiltered, dropSampled, dropDuplicate, dropRateLimited, dropIndexFailed, dropConflict}
+
+var logsDropped = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "logs_dropped_total",
+		Help: "Total number of logs that were not indexed, by reason",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	// Export every reason from the start so rate() works on the first drop
+	for _, reason := range dropReasons {
+		logsDropped.WithLabelValues(reason)
+	}
+}
+
+// newLogDecoder returns a JSON decoder for client logs. Numbers are kept as
+// json.Number unless LOG_PRESERVE_NUMBERS is off, so 64-bit IDs survive
+// the round trip instead of becoming float64.
+func newLogDecoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	if cfg.LogPreserveNumbers {
+		dec.UseNumber()
+	}
+	return dec
+}
+
+var errTooDeep = errors.New("log exceeds the maximum nesting depth")
+
+// depthLimitReader fails with errTooDeep as soon as the JSON streaming
+// through it nests objects and arrays deeper than max, before the decoder or
+// any recursive traversal of the log sees it
+type depthLimitReader struct {
+	r        io.Reader
+	max      int
+	depth    int
+	inString bool
+	escaped  bool
+	tooDeep  bool
+}
+
+func (d *depthLimitReader) Read(p []byte) (int, error) {
+	if d.tooDeep {
+		return 0, errTooDeep
+	}
+	n, err := d.r.Read(p)
+	for i, b := range p[:n] {
+		if d.inString {
+			switch {
+			case d.escaped:
+				d.escaped = false
+			case b == '\\':
+				d.escaped = true
+			case b == '"':
+				d.inString = false
+			}
+			continue
+		}
+		switch b {
+		case '"':
+			d.inString = true
+		case '{', '[':
+			if d.depth++; d.depth > d.max {
+				// Withhold the excess so the decoder reports this error
+				d.tooDeep = true
+				return i, errTooDeep
+			}
+		case '}', ']':
+			d.depth--
+		}
+	}
+	return n, err
+}
+
+// limitDepth applies LOG_MAX_DEPTH to client JSON read from r
+func limitDepth(r io.Reader) io.Reader {
+	if cfg.LogMaxDepth <= 0 {
+		return r
+	}
+	return &depthLimitReader{r: r, max: cfg.LogMaxDepth}
+}
+
+// unmarshalLog is json.Unmarshal with the number handling of newLogDecoder
+func unmarshalLog(data []byte, v interface{}) error {
+	return newLogDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// recordDrop counts logs that will not reach OpenSearch
+func recordDrop(reason string, n int) {
+	logsDropped.WithLabelValues(reason).Add(float64(n))
+	droppedTotal[reason].Add(int64(n))
+}
+
+// injectRequestID stores the ID of the ingesting request in
+// LOG_REQUEST_ID_FIELD, keeping any value the client already set
+func injectRequestID(ctx context.Context, logData map[string]interface{}) {
+	if cfg.LogRequestIDField == "" {
+		return
+	}
+	id := requestIDFromContext(ctx)
+	if id == "" {
+		return
+	}
+	if _, exists := logData[cfg.LogRequestIDField]; !exists {
+		logData[cfg.LogRequestIDField] = id
+	}
+}
+
+// prepareLog validates, filters and enriches a decoded log in place. It
+// returns false when the log was dropped by a filter rule and must not be indexed.
+func prepareLog(ctx context.Context, logData map[string]interface{}) (bool, error) {
+	stripDeniedFields(logData)
+	renameFields(logData)
+	if err := validateDocument(ctx, logData); err != nil {
+		recordDrop(dropValidation, 1)
+		return false, err
+	}
+
+	if rule, ok := matchFilter(logData); ok {
+		logsFiltered.WithLabelValues(rule).Inc()
+		recordDrop(dropFiltered, 1)
+		return false, nil
+	}
+
+	if rule, keep := sampleByRule(logData); !keep {
+		logsSampledDropped.WithLabelValues(rule).Inc()
+		recordDrop(dropSampled, 1)
+		return false, nil
+	}
+
+	if !sampleUnderLoad() {
+		recordDrop(dropSampled, 1)
+		return false, nil
+	}
+
+	redactor.redactLog(logData)
+	limitStringFields(logData)
+	coerceFields(logData)
+	deriveLevel(logData)
+	normalizeLevel(logData)
+	injectTraceContext(ctx, logData)
+	injectBaggage(ctx, logData)
+	injectRequestID(ctx, logData)
+	injectRetention(logData)
+	sanitizeFieldNames(logData)
+	resolveTimestamp(ctx, logData)
+	if err := checkLogAge(logData); err != nil {
+		recordDrop(dropValidation, 1)
+		return false, err
+	}
+	fieldTypes.sample(logData)
+	return true, nil
+}
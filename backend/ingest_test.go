@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// dropCounts snapshots logs_dropped_total for every reason
+func dropCounts() map[string]float64 {
+	counts := make(map[string]float64, len(dropReasons))
+	for _, reason := range dropReasons {
+		counts[reason] = testutil.ToFloat64(logsDropped.WithLabelValues(reason))
+	}
+	return counts
+}
+
+// assertDropped checks that since before only reason grew, by n
+func assertDropped(t *testing.T, before map[string]float64, reason string, n float64) {
+	t.Helper()
+	for r, v := range dropCounts() {
+		want := before[r]
+		if r == reason {
+			want += n
+		}
+		if v != want {
+			t.Errorf("logs_dropped_total{reason=%s} = %v, want %v", r, v, want)
+		}
+	}
+}
+
+func TestDroppedByReason(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		mutate func(*Config)
+		path   string
+		body   string
+		status int
+		reason string
+	}{
+		{
+			name:   "validation",
+			mutate: func(c *Config) { c.LogMaxFields = 1 },
+			path:   "/logs",
+			body:   `{"a":1,"b":2}`,
+			status: http.StatusUnprocessableEntity,
+			reason: dropValidation,
+		},
+		{
+			name: "index not allowed",
+			mutate: func(c *Config) {
+				c.IndexPerTenant = true
+				c.IndexAllowlist = []string{"logs-acme"}
+			},
+			path:   "/logs/globex",
+			body:   `{"message":"hi"}`,
+			status: http.StatusForbidden,
+			reason: dropValidation,
+		},
+		{
+			name:   "invalid JSON",
+			path:   "/logs",
+			body:   `{"message":`,
+			status: http.StatusBadRequest,
+			reason: dropInvalid,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withOpenSearch(t, tc.mutate)
+			before := dropCounts()
+			if rec := postJSON(tc.path, tc.body); rec.Code != tc.status {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.status, rec.Body)
+			}
+			assertDropped(t, before, tc.reason, 1)
+		})
+	}
+}
+
+func TestDroppedRateLimited(t *testing.T) {
+	withOpenSearch(t, func(c *Config) {
+		c.RateLimitRPS = 0.001
+		c.RateLimitBurst = 1
+	})
+	if rec := postJSON("/logs", `{"message":"first"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("first request: status %d", rec.Code)
+	}
+	before := dropCounts()
+	if rec := postJSON("/logs", `{"message":"second"}`); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", rec.Code)
+	}
+	assertDropped(t, before, dropRateLimited, 1)
+}
+
+func TestLargeIntegersPreserved(t *testing.T) {
+	const fields = `"id":9007199254740993,"big":12345678901234567890,"ratio":0.1`
+	for _, tc := range []struct {
+		name     string
+		preserve bool
+		path     string
+		body     string
+		want     string
+	}{
+		{"single", true, "/logs", `{` + fields + `}`, fields},
+		{"bulk", true, "/logs/bulk", `[{` + fields + `}]`, fields},
+		{"as float64", false, "/logs", `{` + fields + `}`, `"big":12345678901234567000,"id":9007199254740992`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) { c.LogPreserveNumbers = tc.preserve })
+			if rec := postJSON(tc.path, tc.body); rec.Code >= 300 {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			docs := fake.writes()
+			if len(docs) != 1 {
+				t.Fatalf("%d writes, want 1", len(docs))
+			}
+			for _, field := range strings.Split(tc.want, ",") {
+				if !strings.Contains(string(docs[0].Body), field) {
+					t.Errorf("indexed %s, want %s", docs[0].Body, field)
+				}
+			}
+		})
+	}
+}
+
+// nestedJSON returns a log nesting objects depth levels deep
+func nestedJSON(depth int) string {
+	return strings.Repeat(`{"a":`, depth) + "1" + strings.Repeat("}", depth)
+}
+
+func TestMaxNestingDepth(t *testing.T) {
+	for _, tc := range []struct {
+		name, path, body string
+		want             int
+	}{
+		{"normal log", "/logs", `{"http":{"request":{"headers":{"accept":"*/*"}}}}`, http.StatusCreated},
+		{"at the limit", "/logs", nestedJSON(8), http.StatusCreated},
+		{"one level over", "/logs", nestedJSON(9), http.StatusUnprocessableEntity},
+		{"excessively nested", "/logs", nestedJSON(100000), http.StatusUnprocessableEntity},
+		{"arrays count too", "/logs", `{"a":` + strings.Repeat("[", 100) + strings.Repeat("]", 100) + `}`, http.StatusUnprocessableEntity},
+		{"brackets inside strings", "/logs", `{"message":"` + strings.Repeat(`{[\"`, 50) + `"}`, http.StatusCreated},
+		{"bulk", "/logs/bulk", "[" + nestedJSON(100000) + "]", http.StatusUnprocessableEntity},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) { c.LogMaxDepth = 8 })
+			before := dropCounts()
+			rec := postJSON(tc.path, tc.body)
+			if rec.Code != tc.want {
+				t.Fatalf("status %d, want %d: %.200s", rec.Code, tc.want, rec.Body)
+			}
+			if tc.want != http.StatusUnprocessableEntity {
+				return
+			}
+			assertDropped(t, before, dropValidation, 1)
+			if len(fake.writes()) != 0 {
+				t.Error("log over the depth limit was indexed")
+			}
+		})
+	}
+}
+
+func TestMaxNestingDepthDisabled(t *testing.T) {
+	withOpenSearch(t, func(c *Config) { c.LogMaxDepth = 0 })
+	if rec := postJSON("/logs", nestedJSON(200)); rec.Code != http.StatusCreated {
+		t.Errorf("status %d with LOG_MAX_DEPTH=0, want 201", rec.Code)
+	}
+}
@@ -1,28 +1,35 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"golang.org/x/time/rate"
 )
 
-const osURL = "http://opensearch:9200/logs/_doc"
-const osSearchURL = "http://opensearch:9200/logs/_search"
+var cfg = defaultConfig()
+
+// buildVersion is the release the binary was built from, set with
+// -ldflags "-X main.buildVersion=..."
+var buildVersion = "dev"
 
 // Prometheus metrics
 var (
@@ -44,57 +51,187 @@ var (
 )
 
 func initMetrics() {
-	prometheus.MustRegister(requestCount)
-	prometheus.MustRegister(requestDuration)
+	registerMetrics(requestCount)
+	registerMetrics(requestDuration)
+	registerMetrics(logsFiltered)
+	registerMetrics(opensearchResponseSize)
+	registerMetrics(opensearchTTFB)
+	registerMetrics(logsFieldLimitExceeded)
+	registerMetrics(bulkSplits)
+	registerMetrics(logsDropped)
+	registerMetrics(inflightBytesGauge)
+	registerMetrics(rateLimiterEvictions)
+	registerMetrics(rateLimiterEntries)
+	registerMetrics(indexFieldCount)
+	registerMetrics(opensearchFailures)
+	registerMetrics(opensearchRetries)
+	registerMetrics(samplingRateGauge)
+	registerMetrics(rateLimitRejections)
+	registerMetrics(tenantRateLimited)
+	registerMetrics(requestBodySize)
+	samplingRateGauge.Set(1)
+	registerMetrics(opensearchCircuitTransitions)
+	registerMetrics(asyncQueueWait)
+	registerMetrics(fieldTypeConflicts)
+	registerMetrics(fieldsStripped)
+	registerMetrics(logsTooOld)
+	registerMetrics(bulkActiveGauge)
+	registerMetrics(opensearchNodeCircuitState)
+	registerMetrics(logsSampledDropped)
+	registerMetrics(traceExporterHealthy)
+	registerMetrics(coalescedBatchSize)
+	registerMetrics(diskSpaceLow)
+	registerMetrics(bulkItems)
+	registerMetrics(nonceReplays)
+	registerMetrics(nonceEvictions)
+	registerMetrics(oversizedFields)
+	registerMetrics(errorsCaptured)
 	log.Println("Prometheus metrics initialized")
 }
 
 // initTracer initializes the OpenTelemetry TracerProvider
 func initTracer() (*trace.TracerProvider, error) {
-	exporter, err := otlptracehttp.New(context.Background())
+	opts, err := spanProcessorOptions(context.Background(), cfg.TraceExporters)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		return nil, err
 	}
 
-	tp := trace.NewTracerProvider(
-		trace.WithSampler(trace.ParentBased(trace.TraceIDRatioBased(0.1))),
-		trace.WithBatcher(exporter),
+	tp := trace.NewTracerProvider(append(opts,
+		trace.WithSampler(forceTraceSampler{base: trace.ParentBased(trace.TraceIDRatioBased(cfg.TraceSampleRatio))}),
 		trace.WithResource(resource.NewWithAttributes(
 			semconv.SchemaURL,
 			semconv.ServiceNameKey.String("telyx-backend"),
 		)),
-	)
+	)...)
 
 	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
 	return tp, nil
 }
 
 // logHandler processes log data and sends it to OpenSearch
 func logHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer func() {
-		duration := time.Since(start).Seconds()
-		requestDuration.WithLabelValues("/logs").Observe(duration)
-	}()
-
-	_, span := otel.Tracer("telyx-backend").Start(r.Context(), "logHandler")
+	ctx, span := otel.Tracer("telyx-backend").Start(r.Context(), "logHandler")
 	defer span.End()
 
 	w.Header().Set("Content-Type", "application/json")
 	defer r.Body.Close()
 
+	ctx, ok := withRefresh(ctx, r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "X-Refresh must be false, wait_for or true")
+		return
+	}
+	ctx, ok = withLogTimestamp(ctx, r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, cfg.LogTimestampHeader+" must be an RFC 3339 date")
+		return
+	}
+	echo, ok := echoRequested(r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "echo must be true or false")
+		return
+	}
+	dryRun, ok := dryRunRequested(r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "dry_run must be true or false")
+		return
+	}
+
+	body, raw, err := readBody(r)
 	var logData map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&logData); err != nil {
+	var prefix *rawPrefix
+	if err == nil {
+		body, prefix = recordRaw(body)
+		err = newLogDecoder(limitDepth(body)).Decode(&logData)
+	}
+	if errors.Is(err, errTooDeep) {
+		recordDrop(dropValidation, 1)
+		errorCapture.capture(r, err.Error(), prefix)
+		writeValidationError(w, err)
+		return
+	}
+	if bodyTooLarge(err) {
+		recordDrop(dropInvalid, 1)
+		errorCapture.capture(r, "Request body too large", prefix)
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+	if err != nil || logData == nil {
+		recordDrop(dropInvalid, 1)
+		errorCapture.capture(r, "Invalid log format", prefix)
 		http.Error(w, `{"error": "Invalid log format"}`, http.StatusBadRequest)
-		requestCount.WithLabelValues("/logs").Inc()
 		span.RecordError(err)
 		span.SetAttributes(semconv.ExceptionMessageKey.String("Invalid log format"))
 		return
 	}
 
-	// Add a timestamp if not provided
-	if _, exists := logData["timestamp"]; !exists {
-		logData["timestamp"] = time.Now().Format(time.RFC3339)
+	meta, err := takeDocMeta(logData)
+	if err != nil {
+		recordDrop(dropValidation, 1)
+		errorCapture.capture(r, err.Error(), prefix)
+		writeValidationError(w, err)
+		span.RecordError(err)
+		return
+	}
+
+	span.SetName(ingestSpanName(r, logData))
+
+	// Logs posted to /logs/{tenant} are stamped with the tenant from the path
+	if tenant := r.PathValue("tenant"); tenant != "" {
+		if _, sent := logData["tenant"]; !sent {
+			ctx = withPathTenant(ctx)
+		}
+		logData["tenant"] = tenant
+	}
+
+	keep, err := prepareLog(ctx, logData)
+	if err != nil {
+		errorCapture.capture(r, err.Error(), prefix)
+		writeValidationError(w, err)
+		span.RecordError(err)
+		span.SetAttributes(semconv.ExceptionMessageKey.String("Invalid log content"))
+		return
+	}
+	if !keep {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status": "Log dropped by filter"}`))
+		return
+	}
+	attachRawBody(logData, raw)
+
+	index, err := resolveIndex(logData)
+	if err != nil {
+		recordDrop(dropValidation, 1)
+		span.RecordError(err)
+		writeJSONError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if dryRun {
+		jsonData, err := json.Marshal(logData)
+		if err != nil {
+			http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+			span.RecordError(err)
+			return
+		}
+		writeDryRun(w, index, jsonData)
+		return
+	}
+	if asyncQueue == nil {
+		if err := knownIndices.ensureIndex(ctx, index); err != nil {
+			log.Printf("Failed to bootstrap index %s, relying on auto-creation: %v", index, err)
+		}
+	}
+
+	if asyncQueue != nil {
+		if err := asyncQueue.enqueue(ctx, logData); err != nil {
+			span.RecordError(err)
+			writeEnqueueError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status": "Log queued"}`))
+		return
 	}
 
 	// Convert log data to JSON
@@ -106,29 +243,69 @@ func logHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Concurrent single logs share one _bulk call when coalescing is enabled
+	if ingestCoalescer != nil && coalescable(ctx) {
+		out := ingestCoalescer.submit(ctx, bulkDoc{source: logData, meta: meta})
+		switch {
+		case out.conflict:
+			writeJSONError(w, http.StatusConflict, "Version conflict with the stored document")
+		case !out.indexed:
+			writeOpenSearchError(w, span, "bulk", out.sendErr, "Failed to send log to OpenSearch")
+		default:
+			writeIngested(w, jsonData, echo)
+		}
+		return
+	}
+
 	// Send log data to OpenSearch
-	res, err := http.Post(osURL, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil || res.StatusCode >= 400 {
-		http.Error(w, `{"error": "Failed to send log to OpenSearch"}`, http.StatusInternalServerError)
-		span.RecordError(err)
-		span.SetAttributes(semconv.ExceptionMessageKey.String("Failed to send log to OpenSearch"))
+	method, target := http.MethodPost, osURL(index, "_doc")
+	if meta.ID != "" {
+		method, target = http.MethodPut, target+"/"+url.PathEscape(meta.ID)
+	}
+	params := meta.params()
+	params.Set("op_type", bulkAction())
+	status, _, err := osRequest(ctx, "index", method, target+refreshQuery(ctx, params), jsonData)
+	if err == nil && status == http.StatusConflict {
+		recordDrop(dropConflict, 1)
+		writeJSONError(w, http.StatusConflict, "Version conflict with the stored document")
+		return
+	}
+	if err != nil || status >= 400 {
+		recordDrop(dropIndexFailed, 1)
+		deadLetters.write([]deadLetterEntry{newDeadLetterEntry(logData, meta, "Failed to send log to OpenSearch")})
+		writeOpenSearchError(w, span, "index", err, "Failed to send log to OpenSearch")
 		return
 	}
-	defer res.Body.Close()
 
 	// Respond to the client
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(`{"status": "Log successfully ingested"}`))
-	requestCount.WithLabelValues("/logs").Inc()
+	recordIngested(1)
+	writeIngested(w, jsonData, echo)
 }
 
 // corsMiddleware adds CORS headers for the frontend
 func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if origin := allowedOrigin(r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if origin != "*" {
+				w.Header().Add("Vary", "Origin")
+				// Credentials are only valid alongside an explicit origin
+				if cfg.CORSAllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Refresh, X-Request-Deadline, "+apiKeyHeader+", "+nonceHeader+", X-OpenSearch-Timeout, "+cfg.LogTimestampHeader)
+		expose := searchExposedHeaders
+		if cfg.TraceResponseHeaders {
+			expose = "X-Trace-Sampled, X-Trace-Id, " + expose
+		}
+		w.Header().Set("Access-Control-Expose-Headers", expose)
 		if r.Method == http.MethodOptions {
+			if cfg.CORSMaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.CORSMaxAge.Seconds())))
+			}
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -136,12 +313,27 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// allowedOrigin returns the Access-Control-Allow-Origin value for a request origin
+func allowedOrigin(origin string) string {
+	for _, o := range cfg.CORSAllowedOrigins {
+		if o == "*" {
+			return "*"
+		}
+		if origin != "" && o == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
 // logsSearchHandler queries logs from OpenSearch
 func logsSearchHandler(w http.ResponseWriter, r *http.Request) {
-	_, span := otel.Tracer("telyx-backend").Start(r.Context(), "logsSearchHandler")
+	ctx, span := otel.Tracer("telyx-backend").Start(r.Context(), "logsSearchHandler")
 	defer span.End()
 
 	w.Header().Set("Content-Type", "application/json")
+	// The format follows the Accept header, see wantsNDJSON
+	w.Header().Add("Vary", "Accept")
 
 	q := r.URL.Query().Get("q")
 	limit := 50
@@ -151,12 +343,49 @@ func logsSearchHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Clients asking for pagination get a point-in-time view so pages stay
+	// consistent while new logs are ingested
+	var cursor searchCursor
+	paginate := r.URL.Query().Get("paginate") == "true"
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		var err error
+		if cursor, err = decodeCursor(c); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+		if !pits.alive(cursor.PIT) {
+			writeJSONError(w, http.StatusBadRequest, "Cursor expired")
+			return
+		}
+		q, paginate = cursor.Query, true
+	} else if paginate {
+		id, err := pits.open(ctx)
+		if err != nil {
+			writeOpenSearchError(w, span, "pit_open", err, "Failed to query OpenSearch")
+			return
+		}
+		cursor = searchCursor{PIT: id, Query: q}
+	}
+
 	query := map[string]interface{}{
 		"size": limit,
 		"sort": []map[string]interface{}{
-			{"timestamp": map[string]string{"order": "desc"}},
+			{timestampField(): map[string]string{"order": "desc"}},
 		},
 	}
+	searchURL := osURL(writeTarget(), "_search")
+	if paginate {
+		// Tie-break on _id so search_after never skips logs sharing a timestamp
+		query["sort"] = []map[string]interface{}{
+			{timestampField(): map[string]string{"order": "desc"}},
+			{"_id": map[string]string{"order": "asc"}},
+		}
+		query["pit"] = map[string]string{"id": cursor.PIT, "keep_alive": pitKeepAlive()}
+		if cursor.After != nil {
+			query["search_after"] = cursor.After
+		}
+		searchURL = osURL("_search")
+	}
 
 	if q != "" {
 		query["query"] = map[string]interface{}{
@@ -168,20 +397,19 @@ func logsSearchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	queryJSON, _ := json.Marshal(query)
-	res, err := http.Post(osSearchURL, "application/json", bytes.NewBuffer(queryJSON))
-	if err != nil || res.StatusCode >= 400 {
-		http.Error(w, `{"error": "Failed to query OpenSearch"}`, http.StatusInternalServerError)
+	status, body, err := osRequest(ctx, "search", http.MethodPost, searchURL, queryJSON)
+	if err != nil || status >= 400 {
+		writeOpenSearchError(w, span, "search", err, "Failed to query OpenSearch")
 		return
 	}
-	defer res.Body.Close()
-
-	body, _ := io.ReadAll(res.Body)
 
 	// Parse and flatten hits
 	var searchRes struct {
-		Hits struct {
+		PITID string `json:"pit_id"`
+		Hits  struct {
 			Hits []struct {
 				Source map[string]interface{} `json:"_source"`
+				Sort   json.RawMessage        `json:"sort"`
 			} `json:"hits"`
 			Total struct {
 				Value int `json:"value"`
@@ -189,7 +417,7 @@ func logsSearchHandler(w http.ResponseWriter, r *http.Request) {
 		} `json:"hits"`
 	}
 
-	if err := json.Unmarshal(body, &searchRes); err != nil {
+	if err := unmarshalLog(body, &searchRes); err != nil {
 		w.Write(body) // return raw if parse fails
 		return
 	}
@@ -199,21 +427,37 @@ func logsSearchHandler(w http.ResponseWriter, r *http.Request) {
 		logs = append(logs, h.Source)
 	}
 
+	var next string
+	if paginate {
+		hits := searchRes.Hits.Hits
+		if len(hits) == limit {
+			pits.rename(cursor.PIT, searchRes.PITID)
+			if searchRes.PITID != "" {
+				cursor.PIT = searchRes.PITID
+			}
+			cursor.After = hits[len(hits)-1].Sort
+			next = cursor.encode()
+		} else {
+			pits.close(ctx, cursor.PIT)
+		}
+	}
+
+	if wantsNDJSON(r) {
+		streamSearchHits(w, logs, searchRes.Hits.Total.Value, next)
+		return
+	}
 	response := map[string]interface{}{
 		"total": searchRes.Hits.Total.Value,
 		"logs":  logs,
 	}
+	if next != "" {
+		response["cursor"] = next
+	}
 	json.NewEncoder(w).Encode(response)
 }
 
 // healthCheck responds with the service's health status
 func healthCheck(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	defer func() {
-		duration := time.Since(start).Seconds()
-		requestDuration.WithLabelValues("/health").Observe(duration)
-	}()
-
 	_, span := otel.Tracer("telyx-backend").Start(r.Context(), "healthCheck")
 	defer span.End()
 
@@ -230,10 +474,111 @@ func healthCheck(w http.ResponseWriter, r *http.Request) {
 		span.SetAttributes(semconv.ExceptionMessageKey.String("Failed to encode health response"))
 		return
 	}
-	requestCount.WithLabelValues("/health").Inc()
+}
+
+// newPublicRouter builds the handler served on the public listener
+func newPublicRouter() *router {
+	ingest := func(h http.HandlerFunc) http.HandlerFunc {
+		return instrument(corsMiddleware(rateLimitMiddleware(nonceMiddleware(diskSpaceMiddleware(bodyLimitMiddleware(inflightBytesMiddleware(h)))))))
+	}
+	preflight := instrument(corsMiddleware(func(http.ResponseWriter, *http.Request) {}))
+
+	rt := newRouter()
+	// /ready stays open to the kubelet, which probes from outside the monitoring network
+	rt.handle(http.MethodGet, "/metrics", adminAccessMiddleware(promhttp.Handler()))
+	rt.handle(http.MethodGet, "/health", adminAccessMiddleware(instrument(corsMiddleware(healthCheck))))
+	rt.handle(http.MethodGet, "/health/detailed", adminAccessMiddleware(instrument(detailedHealthHandler)))
+	rt.handleFunc(http.MethodGet, "/ready", instrument(readyHandler))
+	rt.handle(http.MethodGet, "/stats", adminAccessMiddleware(instrument(statsHandler)))
+	rt.handleFunc(http.MethodPost, "/logs", ingest(logHandler))
+	rt.handleFunc(http.MethodPost, "/logs/{tenant}", ingest(logHandler))
+	rt.handleFunc(http.MethodPost, "/logs/bulk", instrument(corsMiddleware(rateLimitMiddleware(nonceMiddleware(diskSpaceMiddleware(bulkConcurrencyMiddleware(bodyLimitMiddleware(inflightBytesMiddleware(bulkHandler)))))))))
+	rt.handleFunc(http.MethodPost, "/logs/upload", instrument(corsMiddleware(rateLimitMiddleware(nonceMiddleware(diskSpaceMiddleware(uploadHandler))))))
+	rt.handleFunc(http.MethodGet, "/logs/ws", instrument(rateLimitMiddleware(nonceMiddleware(diskSpaceMiddleware(wsHandler)))))
+	rt.handleFunc(http.MethodGet, "/logs/search", instrument(corsMiddleware(gzipMiddleware(logsSearchHandler))))
+	rt.handleFunc(http.MethodGet, "/logs/mapping", instrument(corsMiddleware(mappingHandler)))
+
+	// Browsers send CORS preflights to the routes the dashboard calls
+	for _, path := range []string{"/health", "/logs", "/logs/{tenant}", "/logs/bulk", "/logs/search"} {
+		rt.handleFunc(http.MethodOptions, path, preflight)
+	}
+	return rt
+}
+
+// newPublicHandler wraps the public router in the middleware every request
+// goes through
+func newPublicHandler() http.Handler {
+	return requestIDMiddleware(clientCertMiddleware(propagationMiddleware(forceTraceMiddleware(deadlineMiddleware(osTimeoutMiddleware(newPublicRouter()))))))
+}
+
+// initState builds the globals derived from cfg, replacing any built before.
+// Background goroutines and calls to OpenSearch are left to main, and the
+// async queue, which needs the WAL replayed, is built there too.
+func initState() error {
+	var err error
+	if redactor, err = newRedactRules(cfg); err != nil {
+		return fmt.Errorf("invalid redaction rules: %w", err)
+	}
+	osBreaker = newCircuitBreaker(cfg.OpenSearchBreakerThreshold, cfg.OpenSearchBreakerCooldown)
+	osNodes = nil
+	if len(cfg.OpenSearchNodes) > 0 {
+		osNodes = newNodePool(cfg.OpenSearchNodes, cfg.OpenSearchBreakerThreshold, cfg.OpenSearchBreakerCooldown)
+	}
+	httpErrorWindow = newErrorRateWindow(cfg.HealthErrorWindow)
+	knownIndices = &indexCache{known: map[string]time.Time{}, creating: map[string]chan struct{}{}}
+
+	indexMappings, logSchema = nil, nil
+	if cfg.IndexMappingsPath != "" {
+		if indexMappings, err = loadIndexMappings(cfg.IndexMappingsPath); err != nil {
+			return fmt.Errorf("load index mappings: %w", err)
+		}
+	}
+	if cfg.ValidationSchemaPath != "" {
+		if logSchema, err = compileLogSchema(cfg.ValidationSchemaPath); err != nil {
+			return fmt.Errorf("load validation schema: %w", err)
+		}
+	}
+
+	deadLetters, asyncQueue, errorCapture = nil, nil, nil
+	if cfg.DeadLetterPath != "" {
+		deadLetters = newDeadLetterSink(cfg.DeadLetterPath)
+	}
+	if cfg.ErrorIndex != "" {
+		errorCapture = newErrorSink(errorCaptureQueueSize)
+	}
+
+	ipLimiters, globalLimiter = nil, nil
+	if cfg.RateLimitRPS > 0 {
+		ipLimiters = newLimiterLRU(cfg.RateLimitMaxClients, cfg.RateLimitRPS, cfg.RateLimitBurst)
+	}
+	if cfg.GlobalRateLimitRPS > 0 {
+		globalLimiter = rate.NewLimiter(rate.Limit(cfg.GlobalRateLimitRPS), cfg.GlobalRateLimitBurst)
+	}
+	tenantLimiters = newTenantLimiters(cfg)
+	routeBodyLimits = newRouteBodyLimits(cfg)
+	adminNets, _ = parsePrefixes(cfg.AdminAllowedCIDRs)
+	trustedProxies, _ = parsePrefixes(cfg.TrustedProxies)
+	nonces = nil
+	if cfg.NonceTTL > 0 {
+		nonces = newNonceCache(cfg.NonceTTL, cfg.NonceCacheSize)
+	}
+	// INGEST_ASYNC already batches, so coalescing is left off with it
+	ingestCoalescer = nil
+	if cfg.IngestCoalesceWindow > 0 && !cfg.IngestAsync {
+		ingestCoalescer = newCoalescer(cfg.IngestCoalesceWindow, cfg.IngestCoalesceMaxBatch)
+	}
+	bulkSlots = nil
+	if cfg.BulkMaxConcurrency > 0 {
+		bulkSlots = make(chan struct{}, cfg.BulkMaxConcurrency)
+	}
+	storageLow.Store(false)
+	return nil
 }
 
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_PATH"), "path to a YAML or JSON config file")
+	flag.Parse()
+
 	// Configure logging
 	logFile := "backend.log"
 	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
@@ -245,9 +590,18 @@ func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 	log.Println("Logger initialized")
 
+	cfg, err = loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	// Initialize Prometheus metrics
 	initMetrics()
 
+	if err := initState(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	// Initialize OpenTelemetry
 	tp, err := initTracer()
 	if err != nil {
@@ -255,14 +609,150 @@ func main() {
 	}
 	defer func() { _ = tp.Shutdown(context.Background()) }()
 
-	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/health", corsMiddleware(healthCheck))
-	http.HandleFunc("/logs", corsMiddleware(logHandler))
-	http.HandleFunc("/logs/search", corsMiddleware(logsSearchHandler))
+	if cfg.OpenSearchRefresh == "true" {
+		log.Printf("WARN OPENSEARCH_REFRESH=true refreshes shards on every write and is expensive, prefer wait_for")
+	}
+	if cfg.OpenSearchWaitForActiveShards != "" {
+		log.Printf("WARN OPENSEARCH_WAIT_FOR_ACTIVE_SHARDS=%s makes writes wait for replicas, adding latency and failing them while too few shard copies are up", cfg.OpenSearchWaitForActiveShards)
+	}
+	if cfg.AdminMetricsReset {
+		log.Printf("WARN ADMIN_METRICS_RESET is enabled, metrics can be zeroed from the admin listener")
+	}
+	if cfg.IngestCoalesceWindow > 0 && cfg.IngestAsync {
+		log.Printf("WARN INGEST_COALESCE_WINDOW has no effect with INGEST_ASYNC, which already batches")
+	}
+
+	if cfg.OpenSearchUseDataStream {
+		if err := bootstrapDataStream(context.Background()); err != nil {
+			log.Fatalf("Failed to bootstrap data stream: %v", err)
+		}
+	} else if indexMappings != nil {
+		if err := putIndexTemplate(context.Background(), cfg.OpenSearchIndex+"-template", indexTemplate(false)); err != nil {
+			log.Fatalf("Failed to install index template: %v", err)
+		}
+	}
+	if cfg.OpenSearchWriteAlias != "" {
+		if err := bootstrapWriteAlias(context.Background()); err != nil {
+			log.Fatalf("Failed to bootstrap write alias: %v", err)
+		}
+	}
+
+	queueCtx, stopQueue := context.WithCancel(context.Background())
+	queueDone := make(chan struct{})
+	if cfg.IngestAsync {
+		var wal *writeAheadLog
+		var pending []map[string]interface{}
+		if cfg.WALPath != "" {
+			if wal, pending, err = openWAL(cfg.WALPath, cfg.WALFsync); err != nil {
+				log.Fatalf("Failed to open WAL: %v", err)
+			}
+			if len(pending) > 0 {
+				log.Printf("Replaying %d unflushed logs from the WAL", len(pending))
+			}
+		}
+		asyncQueue = newIngestQueue(cfg.AsyncQueueSize, wal, pending)
+		go func() {
+			defer close(queueDone)
+			asyncQueue.run(queueCtx)
+		}()
+	} else {
+		close(queueDone)
+	}
+
+	if cfg.IndexFieldCheckInterval > 0 {
+		go watchIndexFieldCount(context.Background(), cfg.IndexFieldCheckInterval)
+	}
+	if errorCapture != nil {
+		go errorCapture.run(context.Background())
+	}
+	if cfg.MinFreeDiskBytes > 0 {
+		go watchDiskSpace(context.Background(), spoolDirs(cfg), uint64(cfg.MinFreeDiskBytes), cfg.DiskCheckInterval)
+	}
+
+	readiness = newReadinessProbe(cfg.ReadyCacheTTL, cfg.ReadyStaleFor, checkClusterHealth)
+	if cfg.ReadyWarmup > 0 || cfg.ReadyWarmupConns > 0 {
+		readiness.warmUp(context.Background(), cfg.ReadyWarmup, cfg.ReadyWarmupConns)
+	}
+	go readiness.run(context.Background())
+	go pits.reap(context.Background(), time.Minute)
+
+	if cfg.AdminAddr != "" {
+		go func() {
+			log.Printf("Admin server is running on %s...", cfg.AdminAddr)
+			if err := http.ListenAndServe(cfg.AdminAddr, adminAccessMiddleware(newAdminRouter(cfg))); err != nil {
+				log.Fatalf("Failed to start admin server: %v", err)
+			}
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: newPublicHandler(),
+	}
+	srv.RegisterOnShutdown(closeStreams)
+	if srv.TLSConfig, err = serverTLSConfig(cfg); err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
+	}
+	if cfg.EnableH2C {
+		if err := serveH2C(srv); err != nil {
+			log.Fatalf("Failed to configure h2c: %v", err)
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		log.Printf("Shutting down...")
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Server shutdown: %v", err)
+		}
+		// Streams were hijacked from the server, so wait for them separately
+		streamsDone := make(chan struct{})
+		go func() {
+			streamsWG.Wait()
+			close(streamsDone)
+		}()
+		select {
+		case <-streamsDone:
+		case <-ctx.Done():
+			log.Printf("Timed out waiting for log streams to close")
+		}
+		// Flush what the async queue still holds once nothing can add to it
+		stopQueue()
+		select {
+		case <-queueDone:
+		case <-ctx.Done():
+			log.Printf("Timed out flushing the ingest queue")
+		}
+	}()
 
-	port := ":8080"
-	log.Printf("Server is running on port %s...", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
+	if cfg.ListenSocket != "" {
+		ln, err := listenSocket(cfg.ListenSocket, cfg.ListenSocketMode)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", cfg.ListenSocket, err)
+		}
+		go func() {
+			log.Printf("Server is listening on %s...", cfg.ListenSocket)
+			// Sidecars share the host, so the socket is served without TLS
+			if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Failed to serve on %s: %v", cfg.ListenSocket, err)
+			}
+		}()
+	}
+
+	log.Printf("Server is running on port %s...", cfg.Addr)
+	serve := srv.ListenAndServe
+	if srv.TLSConfig != nil {
+		// The certificate comes from TLSConfig.GetCertificate
+		serve = func() error { return srv.ListenAndServeTLS("", "") }
+	}
+	if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	<-stopped
 }
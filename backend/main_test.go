@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSPreflightMaxAge(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.CORSAllowedOrigins = []string{"https://dashboard.example"}
+		c.CORSMaxAge = 10 * time.Minute
+		c.CORSAllowCredentials = true
+	})
+	h := corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("preflight reached the handler")
+	})
+	req := httptest.NewRequest(http.MethodOptions, "/logs", nil)
+	req.Header.Set("Origin", "https://dashboard.example")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec := httptest.NewRecorder()
+	h(rec, req)
+
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("Access-Control-Max-Age = %q, want 600", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://dashboard.example" {
+		t.Errorf("Access-Control-Allow-Origin = %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+	}
+}
+
+func TestCORSWildcardWithCredentialsRejected(t *testing.T) {
+	c := defaultConfig()
+	c.CORSAllowedOrigins = []string{"*"}
+	c.CORSAllowCredentials = true
+	if err := c.validate(); err == nil {
+		t.Error("wildcard origin with credentials passed validation")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+)
+
+var indexFieldCount = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "opensearch_index_field_count",
+		Help: "Number of mapped fields in the target index",
+	},
+)
+
+// mappingProperty is the part of an OpenSearch field mapping that matters
+// for counting fields
+type mappingProperty struct {
+	Type       string                     `json:"type"`
+	Properties map[string]mappingProperty `json:"properties"`
+	Fields     map[string]mappingProperty `json:"fields"`
+}
+
+// countMappedFields counts fields the way index.mapping.total_fields.limit
+// does: objects and multi-fields count as fields too
+func countMappedFields(props map[string]mappingProperty) int {
+	n := 0
+	for _, p := range props {
+		n += 1 + countMappedFields(p.Properties) + countMappedFields(p.Fields)
+	}
+	return n
+}
+
+// fetchIndexFieldCount returns the largest field count among the indices
+// behind the write target
+func fetchIndexFieldCount(ctx context.Context) (int, error) {
+	status, body, err := osRequest(ctx, "mapping", http.MethodGet, osURL(writeTarget(), "_mapping"), nil)
+	if err != nil {
+		return 0, err
+	}
+	if status >= 400 {
+		return 0, fmt.Errorf("mapping request returned status %d", status)
+	}
+	var indices map[string]struct {
+		Mappings mappingProperty `json:"mappings"`
+	}
+	if err := json.Unmarshal(body, &indices); err != nil {
+		return 0, fmt.Errorf("invalid mapping response: %w", err)
+	}
+	count := 0
+	for _, idx := range indices {
+		count = max(count, countMappedFields(idx.Mappings.Properties))
+	}
+	return count, nil
+}
+
+// checkIndexFieldCount updates the field-count gauge and warns when the
+// index approaches its total-fields limit
+func checkIndexFieldCount(ctx context.Context) {
+	count, err := fetchIndexFieldCount(ctx)
+	if err != nil {
+		log.Printf("Failed to check index field count: %v", err)
+		return
+	}
+	indexFieldCount.Set(float64(count))
+	threshold := int(float64(cfg.IndexTotalFieldsLimit) * cfg.IndexFieldWarnRatio)
+	if count >= threshold {
+		log.Printf("WARN index %s has %d mapped fields, limit is %d", writeTarget(), count, cfg.IndexTotalFieldsLimit)
+	}
+}
+
+// watchIndexFieldCount runs checkIndexFieldCount every interval until ctx is done
+func watchIndexFieldCount(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		checkIndexFieldCount(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// mappingConflict is the type reported for a field mapped differently by
+// the indices behind the write target
+const mappingConflict = "conflict"
+
+// flattenMapping adds the dotted path and type of every field under props
+// to fields. Objects without an explicit type are reported as "object" and
+// multi-fields as their own path, e.g. message.keyword.
+func flattenMapping(prefix string, props map[string]mappingProperty, fields map[string]string) {
+	for name, p := range props {
+		path := prefix + name
+		typ := p.Type
+		if typ == "" {
+			typ = "object"
+		}
+		if prev, ok := fields[path]; ok && prev != typ {
+			typ = mappingConflict
+		}
+		fields[path] = typ
+		flattenMapping(path+".", p.Properties, fields)
+		flattenMapping(path+".", p.Fields, fields)
+	}
+}
+
+// mappingView caches the simplified mapping so onboarding tools polling
+// /logs/mapping do not hit OpenSearch on every request
+type mappingView struct {
+	mu        sync.Mutex
+	fields    map[string]string
+	fetchedAt time.Time
+}
+
+var indexMapping mappingView
+
+// get returns the field→type view, refreshing it once MAPPING_CACHE_TTL has
+// expired. A missing index yields no fields rather than an error, since the
+// index is only created by the first write.
+func (v *mappingView) get(ctx context.Context) (map[string]string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.fields != nil && time.Since(v.fetchedAt) < cfg.MappingCacheTTL {
+		return v.fields, nil
+	}
+	status, body, err := osRequest(ctx, "mapping", http.MethodGet, osURL(writeTarget(), "_mapping"), nil)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{}
+	switch {
+	case status == http.StatusNotFound:
+	case status >= 400:
+		return nil, fmt.Errorf("mapping request returned status %d", status)
+	default:
+		var indices map[string]struct {
+			Mappings mappingProperty `json:"mappings"`
+		}
+		if err := json.Unmarshal(body, &indices); err != nil {
+			return nil, fmt.Errorf("invalid mapping response: %w", err)
+		}
+		for _, idx := range indices {
+			flattenMapping("", idx.Mappings.Properties, fields)
+		}
+	}
+	v.fields, v.fetchedAt = fields, time.Now()
+	return fields, nil
+}
+
+// mappingHandler returns the fields of the target index and their types, so
+// client integrators can align their payloads with what is already mapped.
+// With several backing indices, fields whose types differ are reported as
+// "conflict".
+func mappingHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("telyx-backend").Start(r.Context(), "mappingHandler")
+	defer span.End()
+
+	fields, err := indexMapping.get(ctx)
+	if err != nil {
+		writeOpenSearchError(w, span, "mapping", err, "Failed to fetch the index mapping")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"index":  writeTarget(),
+		"fields": fields,
+	})
+}
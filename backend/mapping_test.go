@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testMapping maps 6 fields in logs-000002, counting the message.keyword
+// multi-field and the http object, and 2 in logs-000001
+const testMapping = `{
+	"logs-000001": {"mappings": {"properties": {"message": {"type": "text"}, "level": {"type": "keyword"}}}},
+	"logs-000002": {"mappings": {"properties": {
+		"message": {"type": "text", "fields": {"keyword": {"type": "keyword"}}},
+		"level": {"type": "keyword"},
+		"http": {"properties": {"status": {"type": "integer"}, "path": {"type": "keyword"}}}
+	}}}
+}`
+
+func TestIndexFieldCountGauge(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		limit    int
+		wantWarn bool
+	}{
+		{"below the warning ratio", 100, false},
+		{"approaching the limit", 7, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) { c.IndexTotalFieldsLimit = tc.limit })
+			fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+				if !strings.HasSuffix(c.Path, "/_mapping") {
+					return false
+				}
+				w.Write([]byte(testMapping))
+				return true
+			})
+			logs := captureLog(t)
+
+			checkIndexFieldCount(context.Background())
+			if got := testutil.ToFloat64(indexFieldCount); got != 6 {
+				t.Errorf("opensearch_index_field_count = %v, want 6", got)
+			}
+			if warned := strings.Contains(logs.String(), "WARN index"); warned != tc.wantWarn {
+				t.Errorf("warned = %v, want %v: %s", warned, tc.wantWarn, logs)
+			}
+		})
+	}
+}
+
+func TestIndexFieldCountError(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	})
+	indexFieldCount.Set(42)
+	checkIndexFieldCount(context.Background())
+	if got := testutil.ToFloat64(indexFieldCount); got != 42 {
+		t.Errorf("failed check changed the gauge to %v", got)
+	}
+}
+
+// serveMapping makes fake answer _mapping calls with status and body
+func serveMapping(fake *fakeOpenSearch, status int, body string) {
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		if !strings.HasSuffix(c.Path, "/_mapping") {
+			return false
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+		return true
+	})
+}
+
+// getMapping calls GET /logs/mapping
+func getMapping(t *testing.T) (int, map[string]string) {
+	t.Helper()
+	rec := do(httptest.NewRequest(http.MethodGet, "/logs/mapping", nil))
+	var res struct {
+		Index  string            `json:"index"`
+		Fields map[string]string `json:"fields"`
+	}
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Index != writeTarget() {
+			t.Errorf("index = %q, want %q", res.Index, writeTarget())
+		}
+	}
+	return rec.Code, res.Fields
+}
+
+// resetMappingCache empties the /logs/mapping cache now and after the test
+func resetMappingCache(t *testing.T) {
+	t.Helper()
+	indexMapping = mappingView{}
+	t.Cleanup(func() { indexMapping = mappingView{} })
+}
+
+func TestMappingEndpoint(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	resetMappingCache(t)
+	serveMapping(fake, http.StatusOK, testMapping)
+
+	code, fields := getMapping(t)
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	want := map[string]string{
+		"message":         "text",
+		"message.keyword": "keyword",
+		"level":           "keyword",
+		"http":            "object",
+		"http.status":     "integer",
+		"http.path":       "keyword",
+	}
+	if !maps.Equal(fields, want) {
+		t.Errorf("fields %v, want %v", fields, want)
+	}
+}
+
+func TestMappingConflict(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	resetMappingCache(t)
+	serveMapping(fake, http.StatusOK, `{
+		"logs-000001": {"mappings": {"properties": {"status": {"type": "keyword"}, "level": {"type": "keyword"}}}},
+		"logs-000002": {"mappings": {"properties": {"status": {"type": "integer"}, "level": {"type": "keyword"}}}}
+	}`)
+	_, fields := getMapping(t)
+	if fields["status"] != mappingConflict || fields["level"] != "keyword" {
+		t.Errorf("fields %v, want status reported as a conflict", fields)
+	}
+}
+
+func TestMappingCached(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.MappingCacheTTL = 50 * time.Millisecond })
+	resetMappingCache(t)
+	serveMapping(fake, http.StatusOK, testMapping)
+	mappingCalls := func() int {
+		n := 0
+		for _, c := range fake.requests() {
+			if strings.HasSuffix(c.Path, "/_mapping") {
+				n++
+			}
+		}
+		return n
+	}
+
+	getMapping(t)
+	getMapping(t)
+	if n := mappingCalls(); n != 1 {
+		t.Errorf("%d _mapping calls within the TTL, want 1", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+	getMapping(t)
+	if n := mappingCalls(); n != 2 {
+		t.Errorf("%d _mapping calls after the TTL, want 2", n)
+	}
+}
+
+func TestMappingMissingIndex(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	resetMappingCache(t)
+	serveMapping(fake, http.StatusNotFound, `{"error":{"type":"index_not_found_exception"}}`)
+	code, fields := getMapping(t)
+	if code != http.StatusOK || len(fields) != 0 {
+		t.Errorf("status %d, fields %v, want 200 with no fields", code, fields)
+	}
+}
+
+func TestMappingOpenSearchError(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	resetMappingCache(t)
+	serveMapping(fake, http.StatusInternalServerError, `{}`)
+	if code, _ := getMapping(t); code < 500 {
+		t.Errorf("status %d, want a 5xx", code)
+	}
+	// Errors are not cached
+	serveMapping(fake, http.StatusOK, testMapping)
+	if code, fields := getMapping(t); code != http.StatusOK || len(fields) != 6 {
+		t.Errorf("after recovery: status %d, %d fields, want 200 with 6", code, len(fields))
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resettableCounter is a prometheus.Counter that can be rebuilt from zero,
+// since plain counters, unlike vectors, have no Reset
+type resettableCounter struct {
+	mu   sync.RWMutex
+	opts prometheus.CounterOpts
+	c    prometheus.Counter
+}
+
+func newResettableCounter(opts prometheus.CounterOpts) *resettableCounter {
+	return &resettableCounter{opts: opts, c: prometheus.NewCounter(opts)}
+}
+
+func (r *resettableCounter) Inc() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.c.Inc()
+}
+
+func (r *resettableCounter) Describe(ch chan<- *prometheus.Desc) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.c.Describe(ch)
+}
+
+func (r *resettableCounter) Collect(ch chan<- prometheus.Metric) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.c.Collect(ch)
+}
+
+func (r *resettableCounter) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.c = prometheus.NewCounter(r.opts)
+}
+
+// resettableHistogram is the prometheus.Histogram counterpart of resettableCounter
+type resettableHistogram struct {
+	mu   sync.RWMutex
+	opts prometheus.HistogramOpts
+	h    prometheus.Histogram
+}
+
+func newResettableHistogram(opts prometheus.HistogramOpts) *resettableHistogram {
+	return &resettableHistogram{opts: opts, h: prometheus.NewHistogram(opts)}
+}
+
+func (r *resettableHistogram) Observe(v float64) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.h.Observe(v)
+}
+
+func (r *resettableHistogram) Describe(ch chan<- *prometheus.Desc) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.h.Describe(ch)
+}
+
+func (r *resettableHistogram) Collect(ch chan<- prometheus.Metric) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.h.Collect(ch)
+}
+
+func (r *resettableHistogram) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.h = prometheus.NewHistogram(r.opts)
+}
+
+// registerMetrics is prometheus.MustRegister tolerating collectors that are
+// already registered, so initMetrics can run more than once in one process,
+// as it does in tests. Other registration errors still panic.
+func registerMetrics(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := prometheus.Register(c); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if !errors.As(err, &are) {
+				panic(err)
+			}
+		}
+	}
+}
+
+// resetMetrics zeroes the counters and histograms along with the /stats
+// totals. Gauges describe current state and are left alone.
+func resetMetrics() {
+	for _, v := range []interface{ Reset() }{
+		requestCount, requestDuration, logsFiltered, opensearchResponseSize, opensearchTTFB,
+		logsFieldLimitExceeded, logsDropped, opensearchFailures, opensearchRetries,
+		rateLimitRejections, tenantRateLimited, requestBodySize, opensearchCircuitTransitions,
+		logsTooOld, logsSampledDropped, bulkItems, errorsCaptured,
+	} {
+		v.Reset()
+	}
+	bulkSplits.reset()
+	rateLimiterEvictions.reset()
+	asyncQueueWait.reset()
+	coalescedBatchSize.reset()
+	fieldTypeConflicts.reset()
+	fieldsStripped.reset()
+	nonceReplays.reset()
+	nonceEvictions.reset()
+	oversizedFields.reset()
+	for _, reason := range dropReasons {
+		logsDropped.WithLabelValues(reason)
+	}
+	for _, outcome := range bulkItemOutcomes {
+		bulkItems.WithLabelValues(outcome)
+	}
+
+	ingestedTotal.Store(0)
+	for _, n := range droppedTotal {
+		n.Store(0)
+	}
+}
+
+// metricsResetHandler zeroes the metrics between load test runs. It is only
+// routed when ADMIN_METRICS_RESET is enabled.
+func metricsResetHandler(w http.ResponseWriter, r *http.Request) {
+	resetMetrics()
+	log.Printf("Metrics reset from %s", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status": "Metrics reset"}`))
+}
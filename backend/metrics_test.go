@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestInitMetricsTwice(t *testing.T) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("registering metrics again panicked: %v", r)
+		}
+	}()
+	// TestMain already registered them once
+	initMetrics()
+	initMetrics()
+}
+
+func TestRegisterMetricsDuplicateCollector(t *testing.T) {
+	opts := prometheus.CounterOpts{Name: "telyx_test_duplicate_total", Help: "Test counter"}
+	registerMetrics(prometheus.NewCounter(opts))
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("an equal collector panicked: %v", r)
+		}
+	}()
+	registerMetrics(prometheus.NewCounter(opts))
+}
+
+func TestRegisterMetricsConflictPanics(t *testing.T) {
+	registerMetrics(prometheus.NewCounter(prometheus.CounterOpts{Name: "telyx_test_conflict_total", Help: "Test counter"}))
+	defer func() {
+		if recover() == nil {
+			t.Error("a conflicting collector registered without panicking")
+		}
+	}()
+	registerMetrics(prometheus.NewCounterVec(prometheus.CounterOpts{Name: "telyx_test_conflict_total", Help: "Test counter"}, []string{"reason"}))
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+type requestIDKey struct{}
+
+// requestIDFromContext returns the ID assigned to the current request
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Request ID generators selectable with REQUEST_ID_FORMAT
+var requestIDFormats = map[string]func() string{
+	"hex":  newHexID,
+	"uuid": newUUID,
+	"ulid": newULID,
+}
+
+// newRequestID generates an ID in the configured format
+func newRequestID() string {
+	if gen, ok := requestIDFormats[cfg.RequestIDFormat]; ok {
+		return gen()
+	}
+	return newHexID()
+}
+
+// newHexID generates a random 128-bit hex identifier
+func newHexID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newUUID generates a random version 4 UUID
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID generates a ULID: a 48-bit millisecond timestamp followed by 80
+// random bits in Crockford base32, so IDs sort by creation time
+func newULID() string {
+	var b [16]byte
+	ms := uint64(time.Now().UnixMilli())
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+	rand.Read(b[6:])
+
+	// 128 bits encode to 26 characters, the first holding the top 3 bits
+	var out [26]byte
+	hi := uint64(b[0])<<56 | uint64(b[1])<<48 | uint64(b[2])<<40 | uint64(b[3])<<32 |
+		uint64(b[4])<<24 | uint64(b[5])<<16 | uint64(b[6])<<8 | uint64(b[7])
+	lo := uint64(b[8])<<56 | uint64(b[9])<<48 | uint64(b[10])<<40 | uint64(b[11])<<32 |
+		uint64(b[12])<<24 | uint64(b[13])<<16 | uint64(b[14])<<8 | uint64(b[15])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out[:])
+}
+
+// validRequestID reports whether a caller's request ID is safe to store in
+// documents and logs: 1 to 128 ASCII letters, digits, '-', '_' or '.', which
+// covers the hex, UUID and ULID formats and those of common proxies
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware propagates the caller's X-Request-ID or assigns a new
+// one when it is missing or not a valid ID, echoing it on the response
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Hijack lets WebSocket upgrades through the recorder
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return http.NewResponseController(s.ResponseWriter).Hijack()
+}
+
+// routeLabel returns the pattern that matched the request, such as
+// "/logs/{tenant}", so metrics stay low-cardinality for dynamic paths
+func routeLabel(r *http.Request) string {
+	if r.Pattern == "" {
+		return "unmatched"
+	}
+	pattern := r.Pattern
+	// Drop the method of patterns like "POST /logs"
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		pattern = pattern[i+1:]
+	}
+	return pattern
+}
+
+// instrument records request metrics under the matched route template and
+// logs requests slower than SLOW_REQUEST_THRESHOLD
+func instrument(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := routeLabel(r)
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		if cfg.TraceResponseHeaders {
+			var span oteltrace.Span
+			r, span = startServerSpan(rec, r, route)
+			defer span.End()
+		}
+		next(rec, r)
+
+		duration := time.Since(start)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		requestDuration.WithLabelValues(route).Observe(duration.Seconds())
+		requestCount.WithLabelValues(route).Inc()
+		if !probeRoute(route) {
+			httpErrorWindow.record(rec.status, time.Now())
+		}
+
+		if cfg.SlowRequestThreshold > 0 && duration > cfg.SlowRequestThreshold {
+			log.Printf("WARN slow request method=%s path=%s status=%d duration=%s request_id=%s client=%s",
+				r.Method, route, rec.status, duration, requestIDFromContext(r.Context()), clientIdentityFromContext(r.Context()))
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsUseRouteTemplate(t *testing.T) {
+	withOpenSearch(t, nil)
+	before := testutil.ToFloat64(requestCount.WithLabelValues("/logs/{tenant}"))
+
+	for _, path := range []string{"/logs/acme", "/logs/globex"} {
+		if rec := postJSON(path, `{"message":"hi"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("POST %s: status %d: %s", path, rec.Code, rec.Body)
+		}
+	}
+	if got := testutil.ToFloat64(requestCount.WithLabelValues("/logs/{tenant}")) - before; got != 2 {
+		t.Errorf("/logs/{tenant} count grew by %v, want 2", got)
+	}
+	for _, path := range []string{"/logs/acme", "/logs/globex"} {
+		if got := testutil.ToFloat64(requestCount.WithLabelValues(path)); got != 0 {
+			t.Errorf("concrete path %s has its own series: %v", path, got)
+		}
+	}
+}
+
+func TestSlowRequestLogged(t *testing.T) {
+	withConfig(t, func(c *Config) { c.SlowRequestThreshold = 10 * time.Millisecond })
+	logs := captureLog(t)
+
+	rt := newRouter()
+	rt.handleFunc(http.MethodGet, "/slow", instrument(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	slow := requestIDMiddleware(rt)
+	req := httptest.NewRequest(http.MethodGet, "/slow", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	slow.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := logs.String()
+	for _, want := range []string{"WARN slow request", "method=GET", "path=/slow", "status=418", "duration=", "request_id=req-123"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("slow log %q lacks %q", line, want)
+		}
+	}
+}
+
+func TestFastRequestNotLogged(t *testing.T) {
+	withConfig(t, func(c *Config) { c.SlowRequestThreshold = time.Second })
+	logs := captureLog(t)
+	instrument(func(w http.ResponseWriter, r *http.Request) {})(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if strings.Contains(logs.String(), "slow request") {
+		t.Errorf("fast request logged as slow: %s", logs)
+	}
+}
+
+func TestRequestIDFormats(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		match  *regexp.Regexp
+	}{
+		{"hex", regexp.MustCompile(`^[0-9a-f]{32}$`)},
+		{"uuid", regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+		{"ulid", regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				c.RequestIDFormat = tc.format
+				c.LogRequestIDField = "trace.request"
+			})
+			rec := postJSON("/logs", `{"message":"hi"}`)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			id := rec.Header().Get("X-Request-ID")
+			if !tc.match.MatchString(id) {
+				t.Errorf("X-Request-ID %q is not a %s", id, tc.format)
+			}
+			docs := fake.docs(t)
+			if len(docs) != 1 || docs[0]["trace.request"] != id {
+				t.Errorf("indexed %v, want trace.request = %s", docs, id)
+			}
+		})
+	}
+}
+
+func TestULIDsSortByTime(t *testing.T) {
+	first := newULID()
+	time.Sleep(2 * time.Millisecond)
+	if second := newULID(); second <= first {
+		t.Errorf("ULID %s generated after %s sorts before it", second, first)
+	}
+}
+
+func TestRequestIDFromCallerKept(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.RequestIDFormat = "ulid" })
+	req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+	req.Header.Set("X-Request-ID", "caller-42")
+	if rec := do(req); rec.Header().Get("X-Request-ID") != "caller-42" {
+		t.Errorf("X-Request-ID = %q, want the caller's", rec.Header().Get("X-Request-ID"))
+	}
+	if docs := fake.docs(t); len(docs) != 1 || docs[0]["request_id"] != "caller-42" {
+		t.Errorf("indexed %v, want request_id caller-42", docs)
+	}
+}
+
+func TestUnsafeRequestIDReplaced(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.RequestIDFormat = "ulid" })
+	ulid := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	for _, id := range []string{
+		`x" onmouseover="alert(1)`,
+		"id with spaces",
+		"line\tbreak",
+		"ünïcode",
+		strings.Repeat("a", 129),
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+		req.Header.Set("X-Request-ID", id)
+		if got := do(req).Header().Get("X-Request-ID"); !ulid.MatchString(got) {
+			t.Errorf("X-Request-ID %q answered with %q, want a new ULID", id, got)
+		}
+	}
+	for _, doc := range fake.docs(t) {
+		if id, _ := doc["request_id"].(string); !ulid.MatchString(id) {
+			t.Errorf("indexed request_id %q, want a generated ULID", id)
+		}
+	}
+}
+
+func TestUnknownRequestIDFormatRejected(t *testing.T) {
+	c := defaultConfig()
+	c.RequestIDFormat = "snowflake"
+	if err := c.validate(); err == nil {
+		t.Error("unknown request ID format passed validation")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var opensearchNodeCircuitState = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "opensearch_node_circuit_state",
+		Help: "Circuit breaker state of each OpenSearch node: 0 closed, 1 half-open, 2 open",
+	},
+	[]string{"node"},
+)
+
+// circuitStateValues maps breaker states to opensearch_node_circuit_state values
+var circuitStateValues = map[string]float64{circuitClosed: 0, circuitHalfOpen: 1, circuitOpen: 2}
+
+// osNode is one OpenSearch node with its own circuit breaker
+type osNode struct {
+	base    string
+	breaker *circuitBreaker
+}
+
+// nodePool spreads OpenSearch calls round-robin over OPENSEARCH_NODES. Each
+// node has its own breaker, so a failing node is ejected for the cooldown
+// while the others keep serving, and a retry lands on the next node.
+type nodePool struct {
+	nodes []*osNode
+	next  atomic.Uint64
+}
+
+// osNodes is nil unless OPENSEARCH_NODES is set, in which case it replaces
+// the single OPENSEARCH_URL and the global osBreaker
+var osNodes *nodePool
+
+func newNodePool(urls []string, threshold int, cooldown time.Duration) *nodePool {
+	p := &nodePool{}
+	for _, u := range urls {
+		base := strings.TrimRight(u, "/")
+		b := newCircuitBreaker(threshold, cooldown)
+		b.node = base
+		opensearchNodeCircuitState.WithLabelValues(base).Set(0)
+		p.nodes = append(p.nodes, &osNode{base: base, breaker: b})
+	}
+	return p
+}
+
+// pick returns the next node whose breaker lets a call through, or false
+// when every node is ejected
+func (p *nodePool) pick() (*osNode, bool) {
+	start := p.next.Add(1) - 1
+	for i := range p.nodes {
+		n := p.nodes[(start+uint64(i))%uint64(len(p.nodes))]
+		if n.breaker.allow() {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+// rebase points a URL built by osURL at node n
+func (n *osNode) rebase(url string) string {
+	if rest, ok := strings.CutPrefix(url, strings.TrimRight(cfg.OpenSearchURL, "/")); ok {
+		return n.base + rest
+	}
+	return url
+}
+
+// states returns the breaker state of every node for reporting
+func (p *nodePool) states() map[string]string {
+	if p == nil {
+		return nil
+	}
+	states := make(map[string]string, len(p.nodes))
+	for _, n := range p.nodes {
+		states[n.base] = n.breaker.currentState()
+	}
+	return states
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// nonceHeader carries the single-use value that makes a request unique
+const nonceHeader = "X-Nonce"
+
+// nonceMaxLen bounds the memory a single cached nonce may take
+const nonceMaxLen = 256
+
+var (
+	nonceReplays = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "nonce_replays_total",
+			Help: "Total number of requests rejected for reusing a nonce",
+		},
+	)
+	nonceEvictions = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "nonce_cache_evictions_total",
+			Help: "Total number of unexpired nonces evicted because the cache was full",
+		},
+	)
+)
+
+type nonceEntry struct {
+	nonce   string
+	expires time.Time
+}
+
+// nonceCache remembers the nonces seen within NONCE_TTL. Every entry lives
+// for the same TTL, so insertion order is expiry order and expired entries
+// are dropped from the back as new ones arrive. Once NONCE_CACHE_SIZE
+// entries are held the oldest is evicted early, which reopens a replay
+// window for it: size the cache above the TTL times the request rate.
+type nonceCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+// nonces is nil unless NONCE_TTL is set
+var nonces *nonceCache
+
+func newNonceCache(ttl time.Duration, capacity int) *nonceCache {
+	return &nonceCache{
+		ttl:      ttl,
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// seen records nonce and reports whether it was already used within the TTL
+func (c *nonceCache) seen(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.order.Back(); el != nil && !now.Before(el.Value.(*nonceEntry).expires); el = c.order.Back() {
+		c.order.Remove(el)
+		delete(c.entries, el.Value.(*nonceEntry).nonce)
+	}
+	if _, ok := c.entries[nonce]; ok {
+		return true
+	}
+	if c.order.Len() >= c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*nonceEntry).nonce)
+		nonceEvictions.Inc()
+	}
+	c.entries[nonce] = c.order.PushFront(&nonceEntry{nonce: nonce, expires: now.Add(c.ttl)})
+	return false
+}
+
+// nonceScope names the client a nonce belongs to: the tenant of its API
+// key, else its verified client certificate, else its address. Each client
+// has its own nonces, so one cannot use up another's by sending them first.
+func nonceScope(r *http.Request) string {
+	if tenant := requestTenant(r); tenant != "" {
+		return "tenant:" + tenant
+	}
+	if id := clientIdentityFromContext(r.Context()); id != "" {
+		return "cert:" + id
+	}
+	return "ip:" + clientIP(r)
+}
+
+// nonceMiddleware rejects with 409 a request repeating the X-Nonce its
+// client sent within NONCE_TTL. Requests without a nonce pass unless
+// NONCE_REQUIRED is set. The nonce itself is not signed or authenticated:
+// this only stops an identical request from being accepted twice, such as a
+// client retrying a write that already succeeded or a captured request being
+// replayed while its nonce is remembered.
+func nonceMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if nonces == nil {
+			next(w, r)
+			return
+		}
+		nonce := r.Header.Get(nonceHeader)
+		switch {
+		case nonce == "" && cfg.NonceRequired:
+			writeJSONError(w, http.StatusBadRequest, nonceHeader+" is required")
+			return
+		case len(nonce) > nonceMaxLen:
+			writeJSONError(w, http.StatusBadRequest, nonceHeader+" is too long")
+			return
+		case nonce != "" && nonces.seen(nonceScope(r)+"\x00"+nonce, time.Now()):
+			nonceReplays.Inc()
+			recordDrop(dropDuplicate, 1)
+			writeJSONError(w, http.StatusConflict, "Nonce was already used")
+			return
+		}
+		next(w, r)
+	}
+}
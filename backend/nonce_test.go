@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// postNonce posts body to path with nonce in X-Nonce, if not empty
+func postNonce(path, body, nonce string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if nonce != "" {
+		req.Header.Set(nonceHeader, nonce)
+	}
+	return do(req)
+}
+
+func TestNonceReplayRejected(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.NonceTTL = time.Minute })
+	replays := testutil.ToFloat64(nonceReplays)
+	drops := dropCounts()
+
+	if rec := postNonce("/logs", `{"message":"hi"}`, "n-1"); rec.Code != http.StatusCreated {
+		t.Fatalf("first use: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := postNonce("/logs", `{"message":"hi"}`, "n-1"); rec.Code != http.StatusConflict {
+		t.Errorf("replay: status %d, want 409", rec.Code)
+	}
+	// Nonces are shared by every ingest route
+	if rec := postNonce("/logs/bulk", `[{"message":"hi"}]`, "n-1"); rec.Code != http.StatusConflict {
+		t.Errorf("replay on /logs/bulk: status %d, want 409", rec.Code)
+	}
+	if rec := postNonce("/logs", `{"message":"hi"}`, "n-2"); rec.Code != http.StatusCreated {
+		t.Errorf("fresh nonce: status %d, want 201", rec.Code)
+	}
+	if rec := postNonce("/logs", `{"message":"hi"}`, ""); rec.Code != http.StatusCreated {
+		t.Errorf("no nonce: status %d, want 201", rec.Code)
+	}
+
+	if got := testutil.ToFloat64(nonceReplays) - replays; got != 2 {
+		t.Errorf("nonce_replays_total grew by %v, want 2", got)
+	}
+	assertDropped(t, drops, dropDuplicate, 2)
+	if n := len(fake.writes()); n != 3 {
+		t.Errorf("%d writes, want 3", n)
+	}
+}
+
+func TestNonceScopedPerClient(t *testing.T) {
+	withOpenSearch(t, func(c *Config) {
+		c.NonceTTL = time.Minute
+		c.TenantAPIKeys = map[string]string{"key-a": "acme", "key-b": "globex"}
+	})
+	post := func(header, value string) int {
+		req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(nonceHeader, "shared")
+		if header == "" {
+			req.RemoteAddr = value
+		} else {
+			req.Header.Set(header, value)
+		}
+		return do(req).Code
+	}
+	for _, tc := range []struct {
+		name, header, value string
+		want                int
+	}{
+		{"first tenant", apiKeyHeader, "key-a", http.StatusCreated},
+		{"second tenant", apiKeyHeader, "key-b", http.StatusCreated},
+		{"first tenant again", apiKeyHeader, "key-a", http.StatusConflict},
+		{"anonymous client", "", "192.0.2.1:1234", http.StatusCreated},
+		{"other anonymous client", "", "192.0.2.2:1234", http.StatusCreated},
+		{"anonymous client again", "", "192.0.2.1:5678", http.StatusConflict},
+	} {
+		if code := post(tc.header, tc.value); code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, code, tc.want)
+		}
+	}
+}
+
+func TestNonceOnWebSocket(t *testing.T) {
+	withOpenSearch(t, func(c *Config) { c.NonceTTL = time.Minute })
+	url := streamURL(t)
+	header := http.Header{nonceHeader: {"stream-1"}}
+
+	if _, _, err := openStream(t, url, header); err != nil {
+		t.Fatal(err)
+	}
+	_, resp, err := openStream(t, url, header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusConflict {
+		t.Errorf("replayed stream opened: %v", err)
+	}
+}
+
+func TestNonceHeaderChecks(t *testing.T) {
+	withOpenSearch(t, func(c *Config) {
+		c.NonceTTL = time.Minute
+		c.NonceRequired = true
+	})
+	for name, nonce := range map[string]string{
+		"missing":  "",
+		"too long": strings.Repeat("n", nonceMaxLen+1),
+	} {
+		if rec := postNonce("/logs", `{"message":"hi"}`, nonce); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s nonce: status %d, want 400", name, rec.Code)
+		}
+	}
+}
+
+func TestNonceCacheExpires(t *testing.T) {
+	c := newNonceCache(time.Second, 10)
+	now := time.Now()
+	if c.seen("n", now) {
+		t.Fatal("new nonce reported as seen")
+	}
+	if !c.seen("n", now.Add(500*time.Millisecond)) {
+		t.Error("nonce forgotten within the TTL")
+	}
+	if c.seen("n", now.Add(time.Second)) {
+		t.Error("nonce still remembered after the TTL")
+	}
+}
+
+func TestNonceCacheBounded(t *testing.T) {
+	c := newNonceCache(time.Hour, 2)
+	evictions := testutil.ToFloat64(nonceEvictions)
+	now := time.Now()
+	for _, n := range []string{"a", "b", "c"} {
+		c.seen(n, now)
+	}
+	if len(c.entries) != 2 || c.order.Len() != 2 {
+		t.Errorf("cache holds %d nonces, want 2", len(c.entries))
+	}
+	if got := testutil.ToFloat64(nonceEvictions) - evictions; got != 1 {
+		t.Errorf("nonce_cache_evictions_total grew by %v, want 1", got)
+	}
+	if !c.seen("c", now) {
+		t.Error("newest nonce was evicted")
+	}
+}
+
+func TestNonceConfigValidated(t *testing.T) {
+	for name, mutate := range map[string]func(*Config){
+		"required without TTL": func(c *Config) { c.NonceRequired = true },
+		"empty cache":          func(c *Config) { c.NonceTTL = time.Minute; c.NonceCacheSize = 0 },
+		"negative TTL":         func(c *Config) { c.NonceTTL = -time.Minute },
+	} {
+		c := defaultConfig()
+		mutate(&c)
+		if err := c.validate(); err == nil {
+			t.Errorf("%s: passed validation", name)
+		}
+	}
+}
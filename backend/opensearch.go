@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var opensearchResponseSize = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "opensearch_response_size_bytes",
+		Help:    "Size of OpenSearch response bodies",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+	},
+	[]string{"operation"},
+)
+
+var opensearchTTFB = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "opensearch_time_to_first_byte_seconds",
+		Help:    "Time from sending an OpenSearch request to the first response byte, connection setup included",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"operation"},
+)
+
+var opensearchFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "opensearch_request_failures_total",
+		Help: "Total number of failed OpenSearch calls made for clients, by kind",
+	},
+	[]string{"operation", "kind"},
+)
+
+// osClient is the HTTP client used for all OpenSearch calls
+var osClient = &http.Client{}
+
+// Kinds of OpenSearch failures, surfaced to clients as the error code
+const (
+	failureTimeout  = "opensearch_timeout"
+	failureUpstream = "opensearch_error"
+)
+
+// classifyFailure distinguishes calls that ran out of time from calls that
+// OpenSearch answered with an error or that failed to connect
+func classifyFailure(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return failureTimeout
+	}
+	return failureUpstream
+}
+
+// writeOpenSearchError answers 504 when OpenSearch timed out and 502
+// otherwise, with a machine-readable code so clients can pick a retry policy
+func writeOpenSearchError(w http.ResponseWriter, span trace.Span, operation string, err error, msg string) {
+	kind := classifyFailure(err)
+	opensearchFailures.WithLabelValues(operation, kind).Inc()
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.SetAttributes(
+		semconv.ExceptionMessageKey.String(msg),
+		attribute.String("error.kind", kind),
+	)
+	status := http.StatusBadGateway
+	if kind == failureTimeout {
+		status = http.StatusGatewayTimeout
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg, "code": kind})
+}
+
+// osRequest sends a request to OpenSearch and returns the status code and
+// response body, retrying transient failures with exponential backoff.
+// Writes that let OpenSearch pick the document ID are only retried on 429,
+// which OpenSearch returns before indexing anything.
+func osRequest(ctx context.Context, operation, method, url string, body []byte) (int, []byte, error) {
+	ctx, span := otel.Tracer("telyx-backend").Start(ctx, "opensearch."+operation)
+	defer span.End()
+
+	if timeout := osTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var (
+		status  int
+		resBody []byte
+		err     error
+	)
+	idempotent := idempotentRequest(method, url, body)
+	attempt := 0
+	for {
+		breaker, target := osBreaker, url
+		if osNodes != nil {
+			node, ok := osNodes.pick()
+			if !ok {
+				err = errCircuitOpen
+				break
+			}
+			breaker, target = node.breaker, node.rebase(url)
+		} else if !osBreaker.allow() {
+			err = errCircuitOpen
+			break
+		}
+		status, resBody, err = osAttempt(ctx, operation, method, target, body)
+		if ctx.Err() != nil {
+			// The caller went away or ran out of time, which is no sign of
+			// the node failing
+			breaker.release()
+		} else {
+			breaker.record(err == nil && status < 500)
+		}
+		if !retryable(status, err, idempotent) || attempt >= cfg.OpenSearchMaxRetries {
+			break
+		}
+		attempt++
+		select {
+		case <-ctx.Done():
+		case <-time.After(cfg.OpenSearchRetryBackoff << (attempt - 1)):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if attempt > 0 {
+		outcome := "failed"
+		switch {
+		case err == nil && status < 400:
+			outcome = "succeeded"
+		case retryable(status, err, idempotent):
+			outcome = "exhausted"
+		}
+		opensearchRetries.WithLabelValues(outcome).Add(float64(attempt))
+		span.SetAttributes(attribute.Int("opensearch.retries", attempt))
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		return status, nil, err
+	}
+	span.SetAttributes(
+		attribute.Int("http.status_code", status),
+		attribute.Int("opensearch.response_size", len(resBody)),
+	)
+	return status, resBody, nil
+}
+
+// retryable reports whether a call failed in a way worth trying again. A
+// request that is not idempotent may have been applied before failing, so
+// only an explicit rejection is retried.
+func retryable(status int, err error, idempotent bool) bool {
+	if !idempotent {
+		return err == nil && status == http.StatusTooManyRequests
+	}
+	if err != nil {
+		return !errors.Is(err, errCircuitOpen) && !errors.Is(err, context.Canceled)
+	}
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// idempotentRequest reports whether sending the request twice has the same
+// effect as sending it once. A POST to _doc, or a _bulk action without _id,
+// lets OpenSearch generate the ID, so an attempt that timed out after
+// indexing would be indexed again under a new ID by a retry.
+func idempotentRequest(method, rawURL string, body []byte) bool {
+	if method != http.MethodPost {
+		return true
+	}
+	path := rawURL
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	switch {
+	case strings.HasSuffix(path, "/_doc"):
+		return false
+	case strings.HasSuffix(path, "/_bulk"):
+		return bulkHasIDs(body)
+	}
+	return true
+}
+
+// bulkHasIDs reports whether every action of a _bulk body names its document
+func bulkHasIDs(body []byte) bool {
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	for i := 0; i < len(lines); i++ {
+		var action map[string]struct {
+			ID string `json:"_id"`
+		}
+		if err := json.Unmarshal(lines[i], &action); err != nil {
+			return false
+		}
+		for op, meta := range action {
+			if meta.ID == "" {
+				return false
+			}
+			if op != "delete" {
+				i++ // skip the source line
+			}
+		}
+	}
+	return true
+}
+
+// osAttempt makes a single HTTP call to OpenSearch
+func osAttempt(ctx context.Context, operation, method, url string, body []byte) (int, []byte, error) {
+	// Time to first byte covers connecting and OpenSearch processing but not
+	// the transfer of the body, telling slow queries from slow downloads
+	start := time.Now()
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			opensearchTTFB.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	// An empty value keeps Go from sending its own default User-Agent
+	req.Header.Set("User-Agent", cfg.OpenSearchUserAgent)
+	for k, v := range cfg.OpenSearchHeaders {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := osClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	opensearchResponseSize.WithLabelValues(operation).Observe(float64(len(resBody)))
+	if err != nil {
+		return res.StatusCode, nil, err
+	}
+	return res.StatusCode, resBody, nil
+}
+
+// writeTarget returns the alias or index that documents are written to
+func writeTarget() string {
+	if cfg.OpenSearchWriteAlias != "" {
+		return cfg.OpenSearchWriteAlias
+	}
+	return cfg.OpenSearchIndex
+}
+
+// osURL builds an OpenSearch URL from path segments
+func osURL(segments ...string) string {
+	u := strings.TrimRight(cfg.OpenSearchURL, "/")
+	for _, s := range segments {
+		if strings.HasPrefix(s, "_") {
+			u += "/" + s
+		} else {
+			u += "/" + url.PathEscape(s)
+		}
+	}
+	return u
+}
+
+// bootstrapWriteAlias makes sure the write alias exists, pointing it at the
+// configured index when it is missing
+func bootstrapWriteAlias(ctx context.Context) error {
+	alias, index := cfg.OpenSearchWriteAlias, cfg.OpenSearchIndex
+	status, _, err := osRequest(ctx, "bootstrap", http.MethodHead, osURL("_alias", alias), nil)
+	if err != nil {
+		return fmt.Errorf("check alias %s: %w", alias, err)
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+
+	status, _, err = osRequest(ctx, "bootstrap", http.MethodHead, osURL(index), nil)
+	if err != nil {
+		return fmt.Errorf("check index %s: %w", index, err)
+	}
+
+	var body []byte
+	if status == http.StatusOK {
+		body, _ = json.Marshal(map[string]interface{}{
+			"actions": []map[string]interface{}{
+				{"add": map[string]interface{}{"index": index, "alias": alias, "is_write_index": true}},
+			},
+		})
+		status, _, err = osRequest(ctx, "bootstrap", http.MethodPost, osURL("_aliases"), body)
+	} else {
+		body, _ = json.Marshal(map[string]interface{}{
+			"aliases": map[string]interface{}{alias: map[string]interface{}{"is_write_index": true}},
+		})
+		status, _, err = osRequest(ctx, "bootstrap", http.MethodPut, osURL(index), body)
+	}
+	if err != nil {
+		return fmt.Errorf("create alias %s: %w", alias, err)
+	}
+	if status >= 400 {
+		return fmt.Errorf("create alias %s: OpenSearch returned %d", alias, status)
+	}
+	log.Printf("Created write alias %s -> %s", alias, index)
+	return nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOpenSearchResponseSizeObserved(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	body := `{"hits":"` + strings.Repeat("x", 990) + `"}`
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		w.Write([]byte(body))
+		return true
+	})
+	spans := recordSpans(t)
+	before := sampleCount(t, opensearchResponseSize.WithLabelValues("search"))
+
+	status, _, err := osRequest(context.Background(), "search", http.MethodGet, osURL("_search"), nil)
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("osRequest = %d, %v", status, err)
+	}
+	if got := sampleCount(t, opensearchResponseSize.WithLabelValues("search")) - before; got != 1 {
+		t.Errorf("response size observed %d times, want 1", got)
+	}
+	size, ok := spanAttr(endedSpan(t, spans, "opensearch.search"), "opensearch.response_size")
+	if !ok || size.AsInt64() != int64(len(body)) {
+		t.Errorf("opensearch.response_size = %v, want %d", size.Emit(), len(body))
+	}
+}
+
+func TestOpenSearchTTFBObserved(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	for _, tc := range []struct {
+		operation string
+		// headerDelay holds the response back, bodyDelay only its body
+		headerDelay, bodyDelay time.Duration
+	}{
+		{"ttfb_slow_processing", delay, 0},
+		{"ttfb_slow_body", 0, delay},
+	} {
+		t.Run(tc.operation, func(t *testing.T) {
+			fake := withOpenSearch(t, nil)
+			fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+				time.Sleep(tc.headerDelay)
+				w.WriteHeader(http.StatusOK)
+				http.NewResponseController(w).Flush()
+				time.Sleep(tc.bodyDelay)
+				w.Write([]byte(`{}`))
+				return true
+			})
+			before := histogramOf(t, opensearchTTFB.WithLabelValues(tc.operation).(prometheus.Histogram))
+
+			start := time.Now()
+			if _, _, err := osRequest(context.Background(), tc.operation, http.MethodGet, osURL("_search"), nil); err != nil {
+				t.Fatal(err)
+			}
+			total := time.Since(start)
+			after := histogramOf(t, opensearchTTFB.WithLabelValues(tc.operation).(prometheus.Histogram))
+			if n := after.GetSampleCount() - before.GetSampleCount(); n != 1 {
+				t.Fatalf("%d observations, want 1", n)
+			}
+			ttfb := time.Duration((after.GetSampleSum() - before.GetSampleSum()) * float64(time.Second))
+			if tc.headerDelay > 0 && ttfb < tc.headerDelay {
+				t.Errorf("TTFB %v, want at least the %v before the first byte", ttfb, tc.headerDelay)
+			}
+			if tc.bodyDelay > 0 && ttfb >= tc.bodyDelay {
+				t.Errorf("TTFB %v includes the %v body transfer (round trip %v)", ttfb, tc.bodyDelay, total)
+			}
+		})
+	}
+}
+
+func TestWriteAliasCreatedAndWrittenTo(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		indexExists bool
+		wantMethod  string
+		wantPath    string
+	}{
+		{"new index", false, http.MethodPut, "/logs-000001"},
+		{"existing index", true, http.MethodPost, "/_aliases"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				c.OpenSearchIndex = "logs-000001"
+				c.OpenSearchWriteAlias = "logs-write"
+			})
+			fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+				if c.Method != http.MethodHead {
+					return false
+				}
+				if c.Path == "/logs-000001" && tc.indexExists {
+					return false
+				}
+				w.WriteHeader(http.StatusNotFound)
+				return true
+			})
+
+			if err := bootstrapWriteAlias(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			var created *osCall
+			for _, c := range fake.requests() {
+				if c.Method == tc.wantMethod && c.Path == tc.wantPath {
+					created = &c
+				}
+			}
+			if created == nil || !strings.Contains(string(created.Body), `"logs-write"`) {
+				t.Fatalf("alias not created with %s %s: %+v", tc.wantMethod, tc.wantPath, fake.requests())
+			}
+			if !strings.Contains(string(created.Body), `"is_write_index":true`) {
+				t.Errorf("alias is not the write index: %s", created.Body)
+			}
+
+			if rec := postJSON("/logs", `{"message":"hi"}`); rec.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			writes := fake.writes()
+			if len(writes) != 1 || writes[0].Path != "/logs-write/_doc" {
+				t.Errorf("writes = %+v, want one to /logs-write/_doc", writes)
+			}
+		})
+	}
+}
+
+func TestWriteAliasKeptWhenPresent(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.OpenSearchWriteAlias = "logs-write" })
+	if err := bootstrapWriteAlias(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls := fake.requests(); len(calls) != 1 || calls[0].Path != "/_alias/logs-write" {
+		t.Errorf("calls = %+v, want only the alias check", calls)
+	}
+}
+
+func TestOpenSearchHeadersForwarded(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.OpenSearchHeaders = map[string]string{"X-Gateway-Key": "secret", "X-Tenant": "acme"}
+	})
+	if rec := postJSON("/logs", `{"message":"hi"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	writes := fake.writes()
+	if len(writes) != 1 {
+		t.Fatalf("%d writes, want 1", len(writes))
+	}
+	for k, v := range map[string]string{"X-Gateway-Key": "secret", "X-Tenant": "acme"} {
+		if got := writes[0].Header.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestOpenSearchHeadersFromEnv(t *testing.T) {
+	t.Setenv("OPENSEARCH_HEADERS", "X-Gateway-Key=secret,X-Tenant=acme")
+	c, err := loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.OpenSearchHeaders["X-Gateway-Key"] != "secret" || c.OpenSearchHeaders["X-Tenant"] != "acme" {
+		t.Errorf("OpenSearchHeaders = %v", c.OpenSearchHeaders)
+	}
+}
+
+func TestOpenSearchHeadersValidated(t *testing.T) {
+	for _, headers := range []map[string]string{
+		{"Bad Name": "v"},
+		{"X-Ok": "line\nbreak"},
+	} {
+		c := defaultConfig()
+		c.OpenSearchHeaders = headers
+		if err := c.validate(); err == nil {
+			t.Errorf("headers %q passed validation", headers)
+		}
+	}
+}
+
+func TestOpenSearchTimeoutVersusError(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		respond  func(w http.ResponseWriter, c osCall) bool
+		want     int
+		wantCode string
+	}{
+		{"timeout", func(w http.ResponseWriter, c osCall) bool {
+			time.Sleep(200 * time.Millisecond)
+			return false
+		}, http.StatusGatewayTimeout, failureTimeout},
+		{"server error", func(w http.ResponseWriter, c osCall) bool {
+			w.WriteHeader(http.StatusInternalServerError)
+			return true
+		}, http.StatusBadGateway, failureUpstream},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) { c.OpenSearchTimeout = 20 * time.Millisecond })
+			fake.setRespond(tc.respond)
+			spans := recordSpans(t)
+			failures := testutil.ToFloat64(opensearchFailures.WithLabelValues("search", tc.wantCode))
+
+			rec := do(httptest.NewRequest(http.MethodGet, "/logs/search", nil))
+			if rec.Code != tc.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+			var res struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || res.Code != tc.wantCode {
+				t.Errorf("code = %q, want %q (%v)", res.Code, tc.wantCode, err)
+			}
+			if got := testutil.ToFloat64(opensearchFailures.WithLabelValues("search", tc.wantCode)) - failures; got != 1 {
+				t.Errorf("failure counter grew by %v, want 1", got)
+			}
+			kind, _ := spanAttr(endedSpan(t, spans, "logsSearchHandler"), "error.kind")
+			if kind.AsString() != tc.wantCode {
+				t.Errorf("span error.kind = %q, want %q", kind.AsString(), tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestOpenSearchUserAgent(t *testing.T) {
+	for _, tc := range []struct {
+		name, config, want string
+		useDefault         bool
+	}{
+		{"default", "", "telyx-backend/" + buildVersion, true},
+		{"configured", "acme-shipper/2.1", "acme-shipper/2.1", false},
+		{"empty", "", "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				if !tc.useDefault {
+					c.OpenSearchUserAgent = tc.config
+				}
+			})
+			resetMappingCache(t)
+			postJSON("/logs", `{"message":"hi"}`)
+			postJSON("/logs/bulk", `[{"message":"hi"}]`)
+			do(httptest.NewRequest(http.MethodGet, "/logs/mapping", nil))
+
+			calls := fake.requests()
+			if len(calls) != 3 {
+				t.Fatalf("%d OpenSearch calls, want 3", len(calls))
+			}
+			for _, c := range calls {
+				if got := c.Header.Get("User-Agent"); got != tc.want {
+					t.Errorf("%s %s: User-Agent = %q, want %q", c.Method, c.Path, got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenSearchUserAgentValidated(t *testing.T) {
+	c := defaultConfig()
+	c.OpenSearchUserAgent = "telyx\r\nX-Injected: 1"
+	if err := c.validate(); err == nil {
+		t.Error("User-Agent with a line break passed validation")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Actions of LOG_STRING_OVERFLOW_ACTION
+const (
+	overflowTruncate = "truncate"
+	overflowSplit    = "split"
+)
+
+var oversizedFields = newResettableCounter(
+	prometheus.CounterOpts{
+		Name: "log_oversized_fields_total",
+		Help: "Total number of string fields cut down to LOG_MAX_STRING_BYTES",
+	},
+)
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// limitStringFields cuts string fields longer than LOG_MAX_STRING_BYTES, such
+// as a stack trace in message, which OpenSearch would otherwise reject or
+// leave unsearchable. The field is marked with <field>_truncated; under the
+// split action the cut-off rest is kept in <field>_overflow, so nothing is
+// lost. For arrays the overflow is an array of the rests, position for
+// position. A client field already using one of these names is kept, and
+// the generated one gets a numeric suffix.
+func limitStringFields(logData map[string]interface{}) {
+	if cfg.LogMaxStringBytes <= 0 {
+		return
+	}
+	// Collect first so marker and overflow fields are not visited themselves
+	var oversized []string
+	cutArrays := map[string][]interface{}{}
+	for k, v := range logData {
+		switch t := v.(type) {
+		case string:
+			if len(t) > cfg.LogMaxStringBytes {
+				oversized = append(oversized, k)
+			}
+		case map[string]interface{}:
+			limitStringFields(t)
+		case []interface{}:
+			if rest, cut := limitStringArray(t); cut {
+				cutArrays[k] = rest
+			}
+		}
+	}
+	for k, rest := range cutArrays {
+		markOversized(logData, k, rest)
+	}
+	for _, k := range oversized {
+		s := logData[k].(string)
+		head := truncateUTF8(s, cfg.LogMaxStringBytes)
+		logData[k] = head
+		oversizedFields.Inc()
+		markOversized(logData, k, s[len(head):])
+	}
+}
+
+// limitStringArray cuts the oversized strings of arr in place and returns
+// the cut-off rests, aligned with arr, and whether anything was cut
+func limitStringArray(arr []interface{}) ([]interface{}, bool) {
+	rest := make([]interface{}, len(arr))
+	cut := false
+	for i, v := range arr {
+		switch t := v.(type) {
+		case string:
+			if len(t) > cfg.LogMaxStringBytes {
+				head := truncateUTF8(t, cfg.LogMaxStringBytes)
+				arr[i], rest[i] = head, t[len(head):]
+				cut = true
+				oversizedFields.Inc()
+			}
+		case map[string]interface{}:
+			limitStringFields(t)
+		case []interface{}:
+			if r, c := limitStringArray(t); c {
+				rest[i], cut = r, true
+			}
+		}
+	}
+	return rest, cut
+}
+
+// markOversized adds the marker and, under the split action, the overflow
+// of field k
+func markOversized(logData map[string]interface{}, k string, rest interface{}) {
+	logData[freeField(logData, k+"_truncated")] = true
+	if cfg.LogStringOverflowAction == overflowSplit {
+		logData[freeField(logData, k+"_overflow")] = rest
+	}
+}
+
+// freeField returns name, or name with the first numeric suffix not yet
+// used in logData
+func freeField(logData map[string]interface{}, name string) string {
+	if _, taken := logData[name]; !taken {
+		return name
+	}
+	for i := 1; ; i++ {
+		if _, taken := logData[name+"_"+strconv.Itoa(i)]; !taken {
+			return name + "_" + strconv.Itoa(i)
+		}
+	}
+}
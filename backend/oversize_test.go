@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOversizedMessage(t *testing.T) {
+	stack := "panic: boom\n" + strings.Repeat("goroutine 1 [running]\n", 5)
+	for _, tc := range []struct {
+		action   string
+		overflow bool
+	}{
+		{overflowTruncate, false},
+		{overflowSplit, true},
+	} {
+		t.Run(tc.action, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				c.LogMaxStringBytes = 16
+				c.LogStringOverflowAction = tc.action
+			})
+			cut := testutil.ToFloat64(oversizedFields)
+			if rec := postJSON("/logs", `{"message":`+strconv.Quote(stack)+`,"level":"error"}`); rec.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			docs := fake.docs(t)
+			if len(docs) != 1 {
+				t.Fatalf("indexed %d logs, want 1", len(docs))
+			}
+			doc := docs[0]
+			if doc["message"] != stack[:16] || doc["message_truncated"] != true {
+				t.Errorf("message %q, message_truncated %v, want the first 16 bytes and a marker", doc["message"], doc["message_truncated"])
+			}
+			if doc["level"] != "error" {
+				t.Errorf("short field changed: %v", doc["level"])
+			}
+			overflow, ok := doc["message_overflow"]
+			if ok != tc.overflow {
+				t.Fatalf("message_overflow present = %v, want %v", ok, tc.overflow)
+			}
+			if ok && doc["message"].(string)+overflow.(string) != stack {
+				t.Error("message and message_overflow do not add up to the original")
+			}
+			if got := testutil.ToFloat64(oversizedFields) - cut; got != 1 {
+				t.Errorf("log_oversized_fields_total grew by %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestOversizedFieldsNested(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.LogMaxStringBytes = 4
+		c.LogStringOverflowAction = overflowSplit
+	})
+	doc := map[string]interface{}{
+		"error": map[string]interface{}{"stack": "abcdefgh"},
+		"lines": []interface{}{"ok", "abcdefgh", []interface{}{"xyz", "123456"}, map[string]interface{}{"text": "abcdef"}},
+	}
+	limitStringFields(doc)
+	want := map[string]interface{}{
+		"error": map[string]interface{}{"stack": "abcd", "stack_truncated": true, "stack_overflow": "efgh"},
+		"lines": []interface{}{"ok", "abcd", []interface{}{"xyz", "1234"}, map[string]interface{}{"text": "abcd", "text_truncated": true, "text_overflow": "ef"}},
+		// The overflow of an array lines up with its elements
+		"lines_truncated": true,
+		"lines_overflow":  []interface{}{nil, "efgh", []interface{}{nil, "56"}, nil},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("limited to\n%v\nwant\n%v", doc, want)
+	}
+}
+
+func TestOversizedMarkersKeepClientFields(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.LogMaxStringBytes = 4
+		c.LogStringOverflowAction = overflowSplit
+	})
+	doc := map[string]interface{}{
+		"message":            "abcdefgh",
+		"message_truncated":  "mine",
+		"message_overflow":   "mine",
+		"message_overflow_1": "mine",
+	}
+	limitStringFields(doc)
+	for field, want := range map[string]interface{}{
+		"message_truncated":   "mine",
+		"message_overflow":    "mine",
+		"message_overflow_1":  "mine",
+		"message_truncated_1": true,
+		"message_overflow_2":  "efgh",
+	} {
+		if doc[field] != want {
+			t.Errorf("%s = %v, want %v", field, doc[field], want)
+		}
+	}
+}
+
+func TestOversizedCutKeepsUTF8(t *testing.T) {
+	withConfig(t, func(c *Config) { c.LogMaxStringBytes = 5 })
+	// "ö" takes bytes 5 and 6, so a cut at 5 falls back before it
+	doc := map[string]interface{}{"message": "hellöworld"}
+	limitStringFields(doc)
+	msg := doc["message"].(string)
+	if msg != "hell" || !utf8.ValidString(msg) {
+		t.Errorf("cut to %q, want %q", msg, "hell")
+	}
+}
+
+func TestOversizedFieldsDisabled(t *testing.T) {
+	withConfig(t, nil)
+	long := strings.Repeat("x", 100000)
+	doc := map[string]interface{}{"message": long}
+	limitStringFields(doc)
+	if want := map[string]interface{}{"message": long}; !reflect.DeepEqual(doc, want) {
+		t.Error("log changed with LOG_MAX_STRING_BYTES unset")
+	}
+}
+
+func TestOversizedConfigValidated(t *testing.T) {
+	for name, mutate := range map[string]func(*Config){
+		"negative limit": func(c *Config) { c.LogMaxStringBytes = -1 },
+		"unknown action": func(c *Config) { c.LogStringOverflowAction = "drop" },
+	} {
+		c := defaultConfig()
+		mutate(&c)
+		if err := c.validate(); err == nil {
+			t.Errorf("%s: passed validation", name)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// searchCursor is handed to clients to fetch the next page of a
+// point-in-time search
+type searchCursor struct {
+	PIT   string          `json:"p"`
+	After json.RawMessage `json:"a"`
+	Query string          `json:"q,omitempty"`
+}
+
+func (c searchCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (searchCursor, error) {
+	var c searchCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, err
+	}
+	if c.PIT == "" {
+		return c, errors.New("cursor has no point in time")
+	}
+	return c, nil
+}
+
+// pitTracker remembers the point-in-time searches this instance opened so
+// they can be closed once they outlive SEARCH_PIT_MAX_LIFETIME
+type pitTracker struct {
+	mu     sync.Mutex
+	opened map[string]time.Time
+}
+
+var pits = &pitTracker{opened: make(map[string]time.Time)}
+
+// open creates a point in time on the write target
+func (t *pitTracker) open(ctx context.Context) (string, error) {
+	url := osURL(writeTarget(), "_search", "point_in_time") + "?keep_alive=" + pitKeepAlive()
+	status, body, err := osRequest(ctx, "pit_open", http.MethodPost, url, nil)
+	if err != nil {
+		return "", err
+	}
+	if status >= 400 {
+		return "", fmt.Errorf("open point in time returned status %d", status)
+	}
+	var res struct {
+		PITID string `json:"pit_id"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil || res.PITID == "" {
+		return "", fmt.Errorf("invalid point in time response")
+	}
+	t.mu.Lock()
+	t.opened[res.PITID] = time.Now()
+	t.mu.Unlock()
+	return res.PITID, nil
+}
+
+// alive reports whether a point in time is still within its lifetime.
+// Cursors from other instances are trusted and left to OpenSearch to expire.
+func (t *pitTracker) alive(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	openedAt, ok := t.opened[id]
+	return !ok || time.Since(openedAt) < cfg.SearchPITMaxLifetime
+}
+
+// rename moves tracking to the id OpenSearch returned with the latest page
+func (t *pitTracker) rename(from, to string) {
+	if from == to || to == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if openedAt, ok := t.opened[from]; ok {
+		delete(t.opened, from)
+		t.opened[to] = openedAt
+	}
+}
+
+// close deletes a point in time once the last page has been served
+func (t *pitTracker) close(ctx context.Context, ids ...string) {
+	t.mu.Lock()
+	for _, id := range ids {
+		delete(t.opened, id)
+	}
+	t.mu.Unlock()
+	body, _ := json.Marshal(map[string][]string{"pit_id": ids})
+	if _, _, err := osRequest(ctx, "pit_close", http.MethodDelete, osURL("_search", "point_in_time"), body); err != nil {
+		log.Printf("Failed to close point in time: %v", err)
+	}
+}
+
+// reap closes point-in-time searches older than SEARCH_PIT_MAX_LIFETIME
+// every interval until ctx is cancelled
+func (t *pitTracker) reap(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var expired []string
+			t.mu.Lock()
+			for id, openedAt := range t.opened {
+				if time.Since(openedAt) >= cfg.SearchPITMaxLifetime {
+					expired = append(expired, id)
+				}
+			}
+			t.mu.Unlock()
+			if len(expired) > 0 {
+				t.close(ctx, expired...)
+			}
+		}
+	}
+}
+
+// pitKeepAlive formats SEARCH_PIT_KEEP_ALIVE as an OpenSearch time value
+func pitKeepAlive() string {
+	return fmt.Sprintf("%ds", int(cfg.SearchPITKeepAlive.Seconds()))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pitIndex emulates an index serving point-in-time searches sorted by
+// timestamp descending with _id as tie-breaker. Documents indexed after a
+// point in time was opened are not visible through it.
+type pitIndex struct {
+	mu   sync.Mutex
+	docs map[string]map[string]interface{}
+	pits map[string][]string
+}
+
+func newPITIndex(fake *fakeOpenSearch, docs map[string]map[string]interface{}) *pitIndex {
+	idx := &pitIndex{docs: docs, pits: map[string][]string{}}
+	fake.setRespond(idx.respond)
+	return idx
+}
+
+func (idx *pitIndex) respond(w http.ResponseWriter, c osCall) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	switch {
+	case strings.HasSuffix(c.Path, "/_doc"):
+		var doc map[string]interface{}
+		json.Unmarshal(c.Body, &doc)
+		idx.docs[fmt.Sprintf("new-%d", len(idx.docs))] = doc
+		return false
+	case c.Method == http.MethodPost && strings.HasSuffix(c.Path, "/_search/point_in_time"):
+		id := fmt.Sprintf("pit-%d", len(idx.pits))
+		idx.pits[id] = sortedIDs(idx.docs)
+		json.NewEncoder(w).Encode(map[string]string{"pit_id": id})
+		return true
+	case c.Path == "/_search":
+		var req struct {
+			Size        int               `json:"size"`
+			PIT         map[string]string `json:"pit"`
+			SearchAfter []string          `json:"search_after"`
+		}
+		json.Unmarshal(c.Body, &req)
+		ids := idx.pits[req.PIT["id"]]
+		if len(req.SearchAfter) == 2 {
+			ids = ids[slices.Index(ids, req.SearchAfter[1])+1:]
+		}
+		ids = ids[:min(req.Size, len(ids))]
+		hits := []map[string]interface{}{}
+		for _, id := range ids {
+			hits = append(hits, map[string]interface{}{
+				"_source": idx.docs[id],
+				"sort":    []string{idx.docs[id]["timestamp"].(string), id},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"pit_id": req.PIT["id"],
+			"hits":   map[string]interface{}{"hits": hits, "total": map[string]int{"value": len(idx.pits[req.PIT["id"]])}},
+		})
+		return true
+	}
+	return false
+}
+
+// sortedIDs orders documents by timestamp descending, then _id ascending
+func sortedIDs(docs map[string]map[string]interface{}) []string {
+	ids := make([]string, 0, len(docs))
+	for id := range docs {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		if c := strings.Compare(docs[b]["timestamp"].(string), docs[a]["timestamp"].(string)); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	return ids
+}
+
+type searchPage struct {
+	Logs   []map[string]interface{} `json:"logs"`
+	Cursor string                   `json:"cursor"`
+}
+
+func getPage(t *testing.T, query string) searchPage {
+	t.Helper()
+	rec := do(httptest.NewRequest(http.MethodGet, "/logs/search?"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /logs/search?%s: status %d: %s", query, rec.Code, rec.Body)
+	}
+	var page searchPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestPITPaginationConsistent(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	idx := newPITIndex(fake, map[string]map[string]interface{}{
+		"a": {"message": "one", "timestamp": "2026-01-01T00:00:01Z"},
+		"b": {"message": "two", "timestamp": "2026-01-01T00:00:02Z"},
+		"c": {"message": "three", "timestamp": "2026-01-01T00:00:02Z"},
+	})
+
+	first := getPage(t, "paginate=true&limit=2")
+	if first.Cursor == "" {
+		t.Fatal("first page has no cursor")
+	}
+	// Logs ingested between pages sort before everything already served
+	for i := 0; i < 2; i++ {
+		if rec := postJSON("/logs", `{"message":"late"}`); rec.Code != http.StatusCreated {
+			t.Fatalf("ingest: status %d", rec.Code)
+		}
+	}
+	if n := len(idx.docs); n != 5 {
+		t.Fatalf("index holds %d logs, want 5", n)
+	}
+	second := getPage(t, "limit=2&cursor="+url.QueryEscape(first.Cursor))
+	if second.Cursor != "" {
+		t.Error("last page has a cursor")
+	}
+
+	var got []string
+	for _, l := range append(first.Logs, second.Logs...) {
+		got = append(got, l["message"].(string))
+	}
+	if want := []string{"two", "three", "one"}; !slices.Equal(got, want) {
+		t.Errorf("paginated %v, want %v", got, want)
+	}
+	closed := slices.ContainsFunc(fake.requests(), func(c osCall) bool {
+		return c.Method == http.MethodDelete && c.Path == "/_search/point_in_time"
+	})
+	if !closed {
+		t.Error("point in time not closed after the last page")
+	}
+}
+
+func TestPITCursorExpired(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	newPITIndex(fake, map[string]map[string]interface{}{
+		"a": {"message": "one", "timestamp": "2026-01-01T00:00:01Z"},
+		"b": {"message": "two", "timestamp": "2026-01-01T00:00:02Z"},
+	})
+	page := getPage(t, "paginate=true&limit=1")
+	cursor, err := decodeCursor(page.Cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pits.mu.Lock()
+	pits.opened[cursor.PIT] = time.Now().Add(-time.Hour)
+	pits.mu.Unlock()
+
+	rec := do(httptest.NewRequest(http.MethodGet, "/logs/search?cursor="+url.QueryEscape(page.Cursor), nil))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Cursor expired") {
+		t.Errorf("expired cursor: status %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+var (
+	rateLimiterEvictions = newResettableCounter(
+		prometheus.CounterOpts{
+			Name: "rate_limiter_evictions_total",
+			Help: "Total number of per-client rate limiters evicted from the LRU",
+		},
+	)
+	rateLimitRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limit_rejections_total",
+			Help: "Total number of requests rejected by a rate limiter, by scope",
+		},
+		[]string{"scope"},
+	)
+	tenantRateLimited = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tenant_rate_limited_total",
+			Help: "Total number of requests rejected by the tenant rate limiter, by tenant",
+		},
+		[]string{"tenant"},
+	)
+	rateLimiterEntries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rate_limiter_active_entries",
+			Help: "Number of per-client rate limiters currently tracked",
+		},
+	)
+)
+
+type limiterEntry struct {
+	key     string
+	limiter *rate.Limiter
+}
+
+// limiterLRU holds one token bucket per key, evicting the least recently used
+// once capacity is reached. An evicted key starts over with a full bucket.
+type limiterLRU struct {
+	mu       sync.Mutex
+	capacity int
+	limit    rate.Limit
+	burst    int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+func newLimiterLRU(capacity int, rps float64, burst int) *limiterLRU {
+	return &limiterLRU{
+		capacity: capacity,
+		limit:    rate.Limit(rps),
+		burst:    burst,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the limiter for key, creating it if needed
+func (l *limiterLRU) get(key string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.entries[key]; ok {
+		l.order.MoveToFront(el)
+		return el.Value.(*limiterEntry).limiter
+	}
+	if l.order.Len() >= l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*limiterEntry).key)
+		rateLimiterEvictions.Inc()
+	}
+	entry := &limiterEntry{key: key, limiter: rate.NewLimiter(l.limit, l.burst)}
+	l.entries[key] = l.order.PushFront(entry)
+	rateLimiterEntries.Set(float64(l.order.Len()))
+	return entry.limiter
+}
+
+var ipLimiters *limiterLRU
+
+// apiKeyHeader carries the API key identifying the tenant of a request
+const apiKeyHeader = "X-API-Key"
+
+// tenantLimiters holds one token bucket per tenant named in TENANT_API_KEYS.
+// The set is fixed at startup, which also bounds the tenant metric label.
+// Tenants without a limit of their own are absent and not limited.
+var tenantLimiters map[string]*rate.Limiter
+
+// newTenantLimiters builds the tenant buckets from TENANT_RATE_LIMIT_RPS and
+// the TENANT_RATE_LIMITS overrides
+func newTenantLimiters(c Config) map[string]*rate.Limiter {
+	limiters := map[string]*rate.Limiter{}
+	for _, tenant := range c.TenantAPIKeys {
+		rps, burst := c.TenantRateLimitRPS, c.TenantRateLimitBurst
+		if limit, ok := c.TenantRateLimits[tenant]; ok {
+			rps, burst, _ = parseTenantLimit(limit)
+		}
+		if rps > 0 {
+			limiters[tenant] = rate.NewLimiter(rate.Limit(rps), burst)
+		}
+	}
+	return limiters
+}
+
+// parseTenantLimit parses a TENANT_RATE_LIMITS value such as "50:100"
+func parseTenantLimit(s string) (float64, int, error) {
+	rpsStr, burstStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, errors.New("expected rps:burst")
+	}
+	rps, err := strconv.ParseFloat(rpsStr, 64)
+	if err != nil || !(rps > 0) {
+		return 0, 0, errors.New("rps must be a positive number")
+	}
+	burst, err := strconv.Atoi(burstStr)
+	if err != nil || burst < 1 {
+		return 0, 0, errors.New("burst must be a positive integer")
+	}
+	return rps, burst, nil
+}
+
+// requestTenant returns the tenant owning the request's API key, if any
+func requestTenant(r *http.Request) string {
+	key := r.Header.Get(apiKeyHeader)
+	if key == "" {
+		return ""
+	}
+	return cfg.TenantAPIKeys[key]
+}
+
+// globalLimiter caps the combined request rate of all clients, nil when
+// GLOBAL_RATE_LIMIT_RPS is unset
+var globalLimiter *rate.Limiter
+
+// rateLimitMiddleware applies the per-client-IP, per-tenant and global
+// token buckets in that order. A bucket is only charged for requests the
+// previous ones let through, and a rejection refunds the earlier tokens.
+func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var client *rate.Reservation
+		if ipLimiters != nil {
+			client = ipLimiters.get(clientIP(r)).Reserve()
+			if !client.OK() || client.Delay() > 0 {
+				client.Cancel()
+				rateLimitRejections.WithLabelValues("client").Inc()
+				rejectRateLimited(w, client.Delay())
+				return
+			}
+		}
+		var tenant *rate.Reservation
+		if name := requestTenant(r); tenantLimiters[name] != nil {
+			tenant = tenantLimiters[name].Reserve()
+			if !tenant.OK() || tenant.Delay() > 0 {
+				tenant.Cancel()
+				if client != nil {
+					client.Cancel()
+				}
+				rateLimitRejections.WithLabelValues("tenant").Inc()
+				tenantRateLimited.WithLabelValues(name).Inc()
+				rejectRateLimited(w, tenant.Delay())
+				return
+			}
+		}
+		if globalLimiter != nil {
+			if res := globalLimiter.Reserve(); !res.OK() || res.Delay() > 0 {
+				res.Cancel()
+				if client != nil {
+					client.Cancel()
+				}
+				if tenant != nil {
+					tenant.Cancel()
+				}
+				rateLimitRejections.WithLabelValues("global").Inc()
+				rejectRateLimited(w, res.Delay())
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// rejectRateLimited answers 429 with a Retry-After rounded up to whole seconds
+func rejectRateLimited(w http.ResponseWriter, delay time.Duration) {
+	recordDrop(dropRateLimited, 1)
+	retry := int(math.Ceil(delay.Seconds()))
+	if retry < 1 {
+		retry = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	writeJSONError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+}
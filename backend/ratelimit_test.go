@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLimiterLRUBounded(t *testing.T) {
+	const capacity = 10
+	l := newLimiterLRU(capacity, 1, 1)
+	evictions := testutil.ToFloat64(rateLimiterEvictions)
+
+	for i := 0; i < 3*capacity; i++ {
+		l.get(fmt.Sprintf("10.0.0.%d", i))
+	}
+	if n := l.order.Len(); n != capacity || len(l.entries) != capacity {
+		t.Errorf("%d entries in the list, %d in the map, want %d", n, len(l.entries), capacity)
+	}
+	if got := testutil.ToFloat64(rateLimiterEvictions) - evictions; got != 2*capacity {
+		t.Errorf("%v evictions, want %d", got, 2*capacity)
+	}
+	if got := testutil.ToFloat64(rateLimiterEntries); got != capacity {
+		t.Errorf("active entries gauge = %v, want %d", got, capacity)
+	}
+	if _, ok := l.entries["10.0.0.0"]; ok {
+		t.Error("the least recently used client was kept")
+	}
+}
+
+func TestLimiterLRUKeepsRecentlyUsed(t *testing.T) {
+	l := newLimiterLRU(2, 1, 1)
+	a := l.get("a")
+	l.get("b")
+	l.get("a") // a is now more recent than b
+	l.get("c")
+	if _, ok := l.entries["b"]; ok {
+		t.Error("b should have been evicted")
+	}
+	if l.get("a") != a {
+		t.Error("a lost its limiter")
+	}
+}
+
+func TestLimiterLRUEvictionResetsBudget(t *testing.T) {
+	l := newLimiterLRU(1, 0.001, 1)
+	if !l.get("a").Allow() || l.get("a").Allow() {
+		t.Fatal("burst of 1 not enforced")
+	}
+	l.get("b")
+	if !l.get("a").Allow() {
+		t.Error("an evicted client should start over with a full bucket")
+	}
+}
+
+// postFrom posts a log to /logs as if sent from ip
+func postFrom(ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+	req.RemoteAddr = ip + ":4000"
+	return do(req)
+}
+
+func TestGlobalRateLimit(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.GlobalRateLimitRPS = 0.001
+		c.GlobalRateLimitBurst = 3
+	})
+	rejected := testutil.ToFloat64(rateLimitRejections.WithLabelValues("global"))
+
+	var codes []int
+	for i := 0; i < 6; i++ {
+		rec := postFrom(fmt.Sprintf("10.0.0.%d", i))
+		codes = append(codes, rec.Code)
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Error("429 without Retry-After")
+		}
+	}
+	want := []int{201, 201, 201, 429, 429, 429}
+	if !slices.Equal(codes, want) {
+		t.Errorf("statuses %v, want %v", codes, want)
+	}
+	if got := testutil.ToFloat64(rateLimitRejections.WithLabelValues("global")) - rejected; got != 3 {
+		t.Errorf("global rejections grew by %v, want 3", got)
+	}
+	if n := len(fake.writes()); n != 3 {
+		t.Errorf("%d writes, want 3", n)
+	}
+}
+
+func TestRateLimitersCompose(t *testing.T) {
+	withOpenSearch(t, func(c *Config) {
+		c.RateLimitRPS = 0.001
+		c.RateLimitBurst = 1
+		c.GlobalRateLimitRPS = 0.001
+		c.GlobalRateLimitBurst = 3
+	})
+	client := testutil.ToFloat64(rateLimitRejections.WithLabelValues("client"))
+
+	// The client-limited request must not use up a global token
+	for i, tc := range []struct {
+		ip   string
+		want int
+	}{
+		{"10.0.0.1", 201},
+		{"10.0.0.1", 429},
+		{"10.0.0.2", 201},
+		{"10.0.0.3", 201},
+		{"10.0.0.4", 429},
+	} {
+		if rec := postFrom(tc.ip); rec.Code != tc.want {
+			t.Errorf("request %d from %s: status %d, want %d", i, tc.ip, rec.Code, tc.want)
+		}
+	}
+	if got := testutil.ToFloat64(rateLimitRejections.WithLabelValues("client")) - client; got != 1 {
+		t.Errorf("client rejections grew by %v, want 1", got)
+	}
+}
+
+// postAs posts a log to /logs with the API key of a tenant
+func postAs(key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+	if key != "" {
+		req.Header.Set(apiKeyHeader, key)
+	}
+	return do(req)
+}
+
+func TestTenantRateLimit(t *testing.T) {
+	withOpenSearch(t, func(c *Config) {
+		c.TenantAPIKeys = map[string]string{"key-a": "acme", "key-a2": "acme", "key-g": "globex", "key-i": "initech"}
+		c.TenantRateLimitRPS = 0.001
+		c.TenantRateLimitBurst = 2
+		c.TenantRateLimits = map[string]string{"initech": "0.001:4"}
+	})
+	limited := testutil.ToFloat64(tenantRateLimited.WithLabelValues("acme"))
+
+	// acme spends its burst across both of its keys
+	for i, tc := range []struct {
+		key  string
+		want int
+	}{
+		{"key-a", http.StatusCreated},
+		{"key-a2", http.StatusCreated},
+		{"key-a", http.StatusTooManyRequests},
+		{"key-g", http.StatusCreated},
+		{"key-g", http.StatusCreated},
+		{"key-g", http.StatusTooManyRequests},
+		{"", http.StatusCreated},
+		{"unknown", http.StatusCreated},
+	} {
+		rec := postAs(tc.key)
+		if rec.Code != tc.want {
+			t.Fatalf("request %d with %q: status %d, want %d", i, tc.key, rec.Code, tc.want)
+		}
+		if tc.want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("request %d: 429 without Retry-After", i)
+		}
+	}
+	if got := testutil.ToFloat64(tenantRateLimited.WithLabelValues("acme")) - limited; got != 1 {
+		t.Errorf("tenant_rate_limited_total{tenant=acme} grew by %v, want 1", got)
+	}
+
+	// The override gives initech a burst of 4
+	for i := 0; i < 4; i++ {
+		if rec := postAs("key-i"); rec.Code != http.StatusCreated {
+			t.Fatalf("initech request %d: status %d", i, rec.Code)
+		}
+	}
+	if rec := postAs("key-i"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("initech beyond its burst: status %d, want 429", rec.Code)
+	}
+}
+
+func TestTenantRateLimitsValidated(t *testing.T) {
+	for _, mutate := range []func(*Config){
+		func(c *Config) { c.TenantRateLimitRPS = 5 },
+		func(c *Config) {
+			c.TenantAPIKeys = map[string]string{"k": "acme"}
+			c.TenantRateLimits = map[string]string{"acme": "fast"}
+		},
+	} {
+		c := defaultConfig()
+		mutate(&c)
+		if err := c.validate(); err == nil {
+			t.Errorf("tenant limits %v with keys %v passed validation", c.TenantRateLimits, c.TenantAPIKeys)
+		}
+	}
+}
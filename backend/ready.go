@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readinessProbe caches the outcome of an upstream readiness check so that
+// frequent probes do not translate into constant OpenSearch load
+type readinessProbe struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	staleFor time.Duration
+	check    func(context.Context) error
+	// warmUntil holds readiness back after startup so pools can fill first
+	warmUntil time.Time
+
+	ready     bool
+	err       error
+	checkedAt time.Time
+	lastGood  time.Time
+}
+
+func newReadinessProbe(ttl, staleFor time.Duration, check func(context.Context) error) *readinessProbe {
+	return &readinessProbe{ttl: ttl, staleFor: staleFor, check: check}
+}
+
+// status returns the cached result, refreshing it once the TTL has expired
+func (p *readinessProbe) status(ctx context.Context) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.checkedAt.IsZero() || time.Since(p.checkedAt) >= p.ttl {
+		p.refreshLocked(ctx)
+	}
+	if p.ready && time.Now().Before(p.warmUntil) {
+		return false, errWarmingUp
+	}
+	return p.ready, p.err
+}
+
+var errWarmingUp = errors.New("warming up")
+
+// warmUp delays readiness for d and pre-opens conns connections to OpenSearch
+// so the first requests after a rollout do not pay for cold pools and DNS.
+// The probe still needs a successful check before it reports ready.
+func (p *readinessProbe) warmUp(ctx context.Context, d time.Duration, conns int) {
+	p.mu.Lock()
+	p.warmUntil = time.Now().Add(d)
+	p.mu.Unlock()
+
+	for i := 0; i < conns; i++ {
+		go func() {
+			if _, _, err := osRequest(ctx, "warmup", http.MethodHead, osURL(), nil); err != nil {
+				log.Printf("Warm-up connection to OpenSearch failed: %v", err)
+			}
+		}()
+	}
+}
+
+func (p *readinessProbe) refreshLocked(ctx context.Context) {
+	err := p.check(ctx)
+	now := time.Now()
+	p.checkedAt = now
+	p.err = err
+	if err == nil {
+		p.ready = true
+		p.lastGood = now
+		return
+	}
+	// Keep reporting the last good value for a short while so a single
+	// failed check does not flap the pod out of the load balancer
+	p.ready = !p.lastGood.IsZero() && now.Sub(p.lastGood) <= p.staleFor
+}
+
+// run refreshes the cached value every TTL until ctx is cancelled
+func (p *readinessProbe) run(ctx context.Context) {
+	ticker := time.NewTicker(p.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			p.refreshLocked(ctx)
+			p.mu.Unlock()
+		}
+	}
+}
+
+var readiness = newReadinessProbe(5*time.Second, 15*time.Second, checkClusterHealth)
+
+// checkClusterHealth succeeds when the OpenSearch cluster is green or yellow
+func checkClusterHealth(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	status, body, err := osRequest(ctx, "health", http.MethodGet, osURL("_cluster", "health"), nil)
+	if err != nil {
+		return err
+	}
+	if status >= 400 {
+		return fmt.Errorf("cluster health returned status %d", status)
+	}
+	var health struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &health); err != nil {
+		return fmt.Errorf("invalid cluster health response: %w", err)
+	}
+	if health.Status == "red" {
+		return fmt.Errorf("cluster status is %s", health.Status)
+	}
+	return nil
+}
+
+// readyHandler reports whether the service can accept traffic
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ready, err := readiness.status(r.Context())
+	response := map[string]string{"status": "ready"}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+	if !ready {
+		response["status"] = "not ready"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useReadiness replaces the readiness probe for the rest of the test
+func useReadiness(t *testing.T, p *readinessProbe) {
+	t.Helper()
+	saved := readiness
+	readiness = p
+	t.Cleanup(func() { readiness = saved })
+}
+
+func probeReady() int {
+	rec := httptest.NewRecorder()
+	newPublicHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	return rec.Code
+}
+
+func healthCalls(fake *fakeOpenSearch) int {
+	n := 0
+	for _, c := range fake.requests() {
+		if c.Path == "/_cluster/health" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestReadinessCached(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		w.Write([]byte(`{"status":"green"}`))
+		return true
+	})
+	const ttl = 100 * time.Millisecond
+	useReadiness(t, newReadinessProbe(ttl, time.Second, checkClusterHealth))
+
+	for i := 0; i < 3; i++ {
+		if code := probeReady(); code != http.StatusOK {
+			t.Fatalf("probe %d: status %d", i, code)
+		}
+	}
+	if n := healthCalls(fake); n != 1 {
+		t.Errorf("%d cluster health calls within the TTL, want 1", n)
+	}
+
+	time.Sleep(ttl + 10*time.Millisecond)
+	probeReady()
+	if n := healthCalls(fake); n != 2 {
+		t.Errorf("%d cluster health calls after the TTL, want 2", n)
+	}
+}
+
+func TestReadinessStaleOnError(t *testing.T) {
+	var fail bool
+	p := newReadinessProbe(0, 50*time.Millisecond, func(context.Context) error {
+		if fail {
+			return errors.New("cluster unreachable")
+		}
+		return nil
+	})
+	ctx := context.Background()
+	if ready, _ := p.status(ctx); !ready {
+		t.Fatal("not ready after a good check")
+	}
+	fail = true
+	if ready, err := p.status(ctx); !ready || err == nil {
+		t.Errorf("status = %v, %v, want the last good value kept with the error", ready, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if ready, _ := p.status(ctx); ready {
+		t.Error("still ready once the last good value is too old")
+	}
+}
+
+func TestReadinessRedCluster(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		w.Write([]byte(`{"status":"red"}`))
+		return true
+	})
+	err := checkClusterHealth(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "red") {
+		t.Errorf("err = %v, want the red status reported", err)
+	}
+}
+
+func TestReadinessWarmUp(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	var healthy atomic.Bool
+	healthy.Store(true)
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		if c.Path != "/_cluster/health" {
+			return false
+		}
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		}
+		w.Write([]byte(`{"status":"green"}`))
+		return true
+	})
+	const warmup = 100 * time.Millisecond
+	p := newReadinessProbe(time.Nanosecond, 0, checkClusterHealth)
+	useReadiness(t, p)
+	p.warmUp(context.Background(), warmup, 2)
+
+	if code := probeReady(); code != http.StatusServiceUnavailable {
+		t.Errorf("during warm-up: status %d, want 503", code)
+	}
+	time.Sleep(warmup + 10*time.Millisecond)
+	if code := probeReady(); code != http.StatusOK {
+		t.Errorf("after warm-up: status %d, want 200", code)
+	}
+	healthy.Store(false)
+	if code := probeReady(); code != http.StatusServiceUnavailable {
+		t.Errorf("after warm-up with OpenSearch down: status %d, want 503", code)
+	}
+
+	warmed := 0
+	for _, c := range fake.requests() {
+		if c.Method == http.MethodHead && c.Path == "/" {
+			warmed++
+		}
+	}
+	if warmed != 2 {
+		t.Errorf("%d warm-up connections, want 2", warmed)
+	}
+}
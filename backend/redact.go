@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// redactRules masks sensitive data: whole values of fields named in
+// REDACT_KEYS, and every match of REDACT_PATTERNS inside strings
+type redactRules struct {
+	keys     map[string]bool
+	patterns []*regexp.Regexp
+	mask     string
+}
+
+// redactor is nil when no redaction is configured
+var redactor *redactRules
+
+func newRedactRules(c Config) (*redactRules, error) {
+	if len(c.RedactKeys) == 0 && len(c.RedactPatterns) == 0 {
+		return nil, nil
+	}
+	r := &redactRules{keys: make(map[string]bool, len(c.RedactKeys)), mask: c.RedactMask}
+	for _, k := range c.RedactKeys {
+		r.keys[strings.ToLower(k)] = true
+	}
+	for _, p := range c.RedactPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// sensitive reports whether values under key are masked entirely
+func (r *redactRules) sensitive(key string) bool {
+	return r.keys[strings.ToLower(key)]
+}
+
+// redactString masks the pattern matches in s
+func (r *redactRules) redactString(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, r.mask)
+	}
+	return s
+}
+
+// redactValue masks the strings nested anywhere in a decoded JSON value
+func (r *redactRules) redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		return r.redactString(t)
+	case map[string]interface{}:
+		r.redactLog(t)
+	case []interface{}:
+		for i := range t {
+			t[i] = r.redactValue(t[i])
+		}
+	}
+	return v
+}
+
+// redactLog masks sensitive fields and pattern matches of a log in place
+func (r *redactRules) redactLog(logData map[string]interface{}) {
+	if r == nil {
+		return
+	}
+	for k, v := range logData {
+		if r.sensitive(k) {
+			logData[k] = r.mask
+			continue
+		}
+		logData[k] = r.redactValue(v)
+	}
+}
+
+// redactAttributes returns attrs with the same rules applied to string values
+func (r *redactRules) redactAttributes(attrs []attribute.KeyValue) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, len(attrs))
+	for i, kv := range attrs {
+		switch {
+		case r.sensitive(string(kv.Key)):
+			out[i] = kv.Key.String(r.mask)
+		case kv.Value.Type() == attribute.STRING:
+			out[i] = kv.Key.String(r.redactString(kv.Value.AsString()))
+		case kv.Value.Type() == attribute.STRINGSLICE:
+			values := kv.Value.AsStringSlice()
+			for j := range values {
+				values[j] = r.redactString(values[j])
+			}
+			out[i] = kv.Key.StringSlice(values)
+		default:
+			out[i] = kv
+		}
+	}
+	return out
+}
+
+// scrubbedSpan presents a finished span with its attributes, events and
+// status description redacted
+type scrubbedSpan struct {
+	trace.ReadOnlySpan
+	rules *redactRules
+}
+
+func (s scrubbedSpan) Attributes() []attribute.KeyValue {
+	return s.rules.redactAttributes(s.ReadOnlySpan.Attributes())
+}
+
+func (s scrubbedSpan) Events() []trace.Event {
+	events := s.ReadOnlySpan.Events()
+	out := make([]trace.Event, len(events))
+	for i, e := range events {
+		e.Attributes = s.rules.redactAttributes(e.Attributes)
+		out[i] = e
+	}
+	return out
+}
+
+func (s scrubbedSpan) Status() trace.Status {
+	status := s.ReadOnlySpan.Status()
+	if status.Code == codes.Error {
+		status.Description = s.rules.redactString(status.Description)
+	}
+	return status
+}
+
+// scrubbingExporter redacts spans before handing them to the wrapped
+// exporter, so error messages echoing a payload never reach the backend
+type scrubbingExporter struct {
+	trace.SpanExporter
+	rules *redactRules
+}
+
+func (e scrubbingExporter) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	scrubbed := make([]trace.ReadOnlySpan, len(spans))
+	for i, s := range spans {
+		scrubbed[i] = scrubbedSpan{ReadOnlySpan: s, rules: e.rules}
+	}
+	return e.SpanExporter.ExportSpans(ctx, scrubbed)
+}
+
+// scrubExporter wraps exporter with the configured redaction, if any
+func scrubExporter(exporter trace.SpanExporter) trace.SpanExporter {
+	if redactor == nil {
+		return exporter
+	}
+	return scrubbingExporter{SpanExporter: exporter, rules: redactor}
+}
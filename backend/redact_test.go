@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSpanAttributesScrubbed(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.RedactKeys = []string{"api_key"}
+		c.RedactPatterns = []string{`token=\S+`}
+		c.RedactMask = "***"
+	})
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(scrubExporter(exporter)))
+	defer tp.Shutdown(context.Background())
+
+	_, span := tp.Tracer("test").Start(context.Background(), "logHandler")
+	span.SetAttributes(
+		attribute.String("exception.message", "invalid payload: token=s3cret"),
+		attribute.String("API_KEY", "k-123"),
+		attribute.StringSlice("urls", []string{"/a?token=s3cret", "/b"}),
+		attribute.Int("http.status_code", 400),
+	)
+	span.RecordError(errors.New("bad log token=s3cret"))
+	span.SetStatus(codes.Error, "rejected token=s3cret")
+	span.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("%d spans exported, want 1", len(spans))
+	}
+	got := map[attribute.Key]attribute.Value{}
+	for _, kv := range spans[0].Attributes {
+		got[kv.Key] = kv.Value
+	}
+	if v := got["exception.message"].AsString(); v != "invalid payload: ***" {
+		t.Errorf("exception.message = %q, want the token masked", v)
+	}
+	if v := got["API_KEY"].AsString(); v != "***" {
+		t.Errorf("API_KEY = %q, want it masked", v)
+	}
+	if v := got["urls"].AsStringSlice(); v[0] != "/a?***" || v[1] != "/b" {
+		t.Errorf("urls = %q, want the token masked", v)
+	}
+	if v := got["http.status_code"].AsInt64(); v != 400 {
+		t.Errorf("http.status_code = %d, want it untouched", v)
+	}
+	var recorded string
+	for _, e := range spans[0].Events {
+		for _, kv := range e.Attributes {
+			if kv.Key == "exception.message" {
+				recorded = kv.Value.AsString()
+			}
+		}
+	}
+	if recorded != "bad log ***" {
+		t.Errorf("recorded error %q, want the token masked", recorded)
+	}
+	if d := spans[0].Status.Description; d != "rejected ***" {
+		t.Errorf("status description = %q, want the token masked", d)
+	}
+}
+
+func TestScrubExporterWithoutRules(t *testing.T) {
+	withConfig(t, nil)
+	exporter := tracetest.NewInMemoryExporter()
+	if got := scrubExporter(exporter); got != sdktrace.SpanExporter(exporter) {
+		t.Errorf("exporter wrapped as %T without redaction rules", got)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Values accepted for the OpenSearch refresh parameter
+var refreshModes = map[string]bool{"false": true, "wait_for": true, "true": true}
+
+type refreshKey struct{}
+
+// withRefresh stores the refresh mode for the writes of this request: the
+// X-Refresh header when set, OPENSEARCH_REFRESH otherwise
+func withRefresh(ctx context.Context, r *http.Request) (context.Context, bool) {
+	mode := r.Header.Get("X-Refresh")
+	if mode == "" {
+		return ctx, true
+	}
+	if !refreshModes[mode] {
+		return ctx, false
+	}
+	return context.WithValue(ctx, refreshKey{}, mode), true
+}
+
+// refreshQuery returns the query string for a write, including refresh
+// when one was requested and OPENSEARCH_WAIT_FOR_ACTIVE_SHARDS when set
+func refreshQuery(ctx context.Context, params url.Values) string {
+	if cfg.OpenSearchWaitForActiveShards != "" {
+		params.Set("wait_for_active_shards", cfg.OpenSearchWaitForActiveShards)
+	}
+	mode, ok := ctx.Value(refreshKey{}).(string)
+	if !ok {
+		mode = cfg.OpenSearchRefresh
+	}
+	if mode != "" {
+		params.Set("refresh", mode)
+	}
+	if len(params) == 0 {
+		return ""
+	}
+	return "?" + params.Encode()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRefreshParam(t *testing.T) {
+	for _, tc := range []struct {
+		name, config, header, path, body string
+		want                             string
+		wantSet                          bool
+	}{
+		{"unset", "", "", "/logs", `{"message":"hi"}`, "", false},
+		{"from config", "wait_for", "", "/logs", `{"message":"hi"}`, "wait_for", true},
+		{"header overrides config", "false", "true", "/logs", `{"message":"hi"}`, "true", true},
+		{"bulk from config", "wait_for", "", "/logs/bulk", `[{"message":"hi"}]`, "wait_for", true},
+		{"bulk header", "", "false", "/logs/bulk", `[{"message":"hi"}]`, "false", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) { c.OpenSearchRefresh = tc.config })
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			if tc.header != "" {
+				req.Header.Set("X-Refresh", tc.header)
+			}
+			if rec := do(req); rec.Code >= 300 {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			writes := fake.writes()
+			if len(writes) != 1 {
+				t.Fatalf("%d writes, want 1", len(writes))
+			}
+			if got, set := writes[0].Query.Get("refresh"), writes[0].Query.Has("refresh"); got != tc.want || set != tc.wantSet {
+				t.Errorf("refresh = %q (set %v), want %q (set %v)", got, set, tc.want, tc.wantSet)
+			}
+		})
+	}
+}
+
+func TestRefreshInvalid(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+	req.Header.Set("X-Refresh", "always")
+	if rec := do(req); rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+	if len(fake.writes()) != 0 {
+		t.Error("log written with an invalid X-Refresh")
+	}
+
+	c := defaultConfig()
+	c.OpenSearchRefresh = "always"
+	if err := c.validate(); err == nil {
+		t.Error("unknown OPENSEARCH_REFRESH passed validation")
+	}
+}
+
+func TestWaitForActiveShardsParam(t *testing.T) {
+	for _, tc := range []struct {
+		name, config, path, body string
+	}{
+		{"unset", "", "/logs", `{"message":"hi"}`},
+		{"index", "2", "/logs", `{"message":"hi"}`},
+		{"bulk", "all", "/logs/bulk", `[{"message":"hi"}]`},
+		{"versioned index", "all", "/logs", `{"_id":"a","_version":1}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) { c.OpenSearchWaitForActiveShards = tc.config })
+			if rec := postJSON(tc.path, tc.body); rec.Code >= 300 {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			writes := fake.writes()
+			if len(writes) != 1 {
+				t.Fatalf("%d writes, want 1", len(writes))
+			}
+			q := writes[0].Query
+			if got := q.Get("wait_for_active_shards"); got != tc.config || q.Has("wait_for_active_shards") != (tc.config != "") {
+				t.Errorf("wait_for_active_shards = %q, want %q", got, tc.config)
+			}
+		})
+	}
+}
+
+func TestWaitForActiveShardsValidated(t *testing.T) {
+	for _, v := range []string{"0", "-1", "quorum", "1.5"} {
+		c := defaultConfig()
+		c.OpenSearchWaitForActiveShards = v
+		if err := c.validate(); err == nil {
+			t.Errorf("OPENSEARCH_WAIT_FOR_ACTIVE_SHARDS=%s passed validation", v)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// router dispatches requests on method and path pattern, such as
+// "POST /logs/{tenant}", on top of http.ServeMux. Requests whose path matches
+// but whose method does not get 405 with an Allow header, and path
+// parameters are available through r.PathValue.
+//
+// Every path also answers OPTIONS with 204 and an Allow header listing its
+// methods, without invoking any handler. A handler registered for OPTIONS
+// only receives CORS preflights.
+type router struct {
+	mux *http.ServeMux
+	// methods lists the methods registered for each path pattern
+	methods map[string][]string
+	// preflight holds the OPTIONS handler registered for a path pattern
+	preflight map[string]http.Handler
+}
+
+func newRouter() *router {
+	return &router{
+		mux:       http.NewServeMux(),
+		methods:   make(map[string][]string),
+		preflight: make(map[string]http.Handler),
+	}
+}
+
+// handle registers h for method requests matching the path pattern
+func (rt *router) handle(method, path string, h http.Handler) {
+	if _, ok := rt.methods[path]; !ok {
+		rt.methods[path] = nil
+		rt.mux.Handle(http.MethodOptions+" "+path, rt.options(path))
+	}
+	if method == http.MethodOptions {
+		rt.preflight[path] = h
+		return
+	}
+	rt.methods[path] = append(rt.methods[path], method)
+	rt.mux.Handle(method+" "+path, h)
+}
+
+// handleFunc registers h for method requests matching the path pattern
+func (rt *router) handleFunc(method, path string, h http.HandlerFunc) {
+	rt.handle(method, path, h)
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// options answers OPTIONS requests for path, handing CORS preflights to the
+// handler registered for them
+func (rt *router) options(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		preflight := rt.preflight[path]
+		if preflight != nil && (isPreflight(r) || !cfg.OptionsAllow) {
+			preflight.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Allow", rt.allow(path))
+		if !cfg.OptionsAllow {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// allow returns the Allow header value for path
+func (rt *router) allow(path string) string {
+	methods := rt.methods[path]
+	allow := make([]string, 0, len(methods)+2)
+	for _, m := range methods {
+		allow = append(allow, m)
+		// ServeMux also serves HEAD on GET routes
+		if m == http.MethodGet {
+			allow = append(allow, http.MethodHead)
+		}
+	}
+	return strings.Join(append(allow, http.MethodOptions), ", ")
+}
+
+// isPreflight reports whether r is a CORS preflight rather than a plain
+// OPTIONS request
+func isPreflight(r *http.Request) bool {
+	return r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterMethodMismatch(t *testing.T) {
+	withConfig(t, nil)
+	rt := newRouter()
+	rt.handleFunc(http.MethodPost, "/logs", func(w http.ResponseWriter, r *http.Request) {})
+	rt.handleFunc(http.MethodGet, "/logs/search", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tc := range []struct {
+		method, path string
+		want         int
+		allow        string
+	}{
+		{http.MethodPost, "/logs", http.StatusOK, ""},
+		{http.MethodGet, "/logs", http.StatusMethodNotAllowed, "OPTIONS, POST"},
+		{http.MethodGet, "/logs/search", http.StatusOK, ""},
+		{http.MethodDelete, "/logs/search", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{http.MethodGet, "/nowhere", http.StatusNotFound, ""},
+	} {
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.path, rec.Code, tc.want)
+		}
+		if got := rec.Header().Get("Allow"); got != tc.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tc.method, tc.path, got, tc.allow)
+		}
+	}
+}
+
+func TestRouterPathParams(t *testing.T) {
+	withConfig(t, nil)
+	rt := newRouter()
+	var tenant string
+	rt.handleFunc(http.MethodPost, "/logs/{tenant}", func(w http.ResponseWriter, r *http.Request) {
+		tenant = r.PathValue("tenant")
+	})
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/logs/acme", nil))
+	if tenant != "acme" {
+		t.Errorf("tenant = %q, want acme", tenant)
+	}
+}
+
+func TestTenantFromPathIndexed(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	if rec := postJSON("/logs/acme", `{"message":"hi"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	docs := fake.docs(t)
+	if len(docs) != 1 || docs[0]["tenant"] != "acme" {
+		t.Errorf("indexed %v, want the log stamped with tenant acme", docs)
+	}
+}
+
+func TestOptionsAllow(t *testing.T) {
+	for _, tc := range []struct {
+		path, allow string
+	}{
+		{"/logs", "POST, OPTIONS"},
+		{"/logs/search", "GET, HEAD, OPTIONS"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				c.CORSAllowedOrigins = []string{"https://dashboard.example"}
+			})
+			rec := do(httptest.NewRequest(http.MethodOptions, tc.path, nil))
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("status %d, want 204", rec.Code)
+			}
+			if got := rec.Header().Get("Allow"); got != tc.allow {
+				t.Errorf("Allow = %q, want %q", got, tc.allow)
+			}
+			if calls := fake.requests(); len(calls) != 0 {
+				t.Errorf("OPTIONS reached OpenSearch: %v", calls)
+			}
+		})
+	}
+}
+
+func TestOptionsAllowKeepsPreflight(t *testing.T) {
+	withOpenSearch(t, func(c *Config) {
+		c.CORSAllowedOrigins = []string{"https://dashboard.example"}
+	})
+	req := httptest.NewRequest(http.MethodOptions, "/logs", nil)
+	req.Header.Set("Origin", "https://dashboard.example")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec := do(req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://dashboard.example" {
+		t.Errorf("preflight: Access-Control-Allow-Origin = %q", got)
+	}
+	if got := rec.Header().Get("Allow"); got != "" {
+		t.Errorf("preflight answered with Allow %q", got)
+	}
+}
+
+func TestOptionsAllowDisabled(t *testing.T) {
+	withConfig(t, func(c *Config) { c.OptionsAllow = false })
+	rt := newRouter()
+	rt.handleFunc(http.MethodPost, "/logs", func(w http.ResponseWriter, r *http.Request) {})
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/logs", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status %d, want 405", rec.Code)
+	}
+}
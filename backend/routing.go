@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// sanitizeIndexName lowercases a value and replaces characters OpenSearch
+// does not allow in index names
+func sanitizeIndexName(v string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(v) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	return strings.TrimLeft(b.String(), "-_")
+}
+
+// resolveIndex picks the index a log is written to. When LOG_INDEX_TYPES is
+// set, logs whose type field holds one of those types go to
+// LOG_INDEX_PREFIX followed by the type; anything else uses the default target.
+// With LOG_INDEX_PER_TENANT the tenant is appended, e.g. telyx-nginx-acme.
+// Routed indices are checked against INDEX_ALLOWLIST and INDEX_DENYLIST.
+// Logs older than LOG_MAX_AGE go to LOG_COLD_INDEX under the route action.
+func resolveIndex(logData map[string]interface{}) (string, error) {
+	// The cold index is set by the operator, like the default target
+	if cfg.LogMaxAgeAction == maxAgeRoute && tooOld(logData) {
+		return cfg.LogColdIndex, nil
+	}
+	index, routed := writeTarget(), false
+	if v, ok := logData[cfg.IndexTypeField]; ok && len(cfg.IndexTypes) > 0 {
+		logType := sanitizeIndexName(fmt.Sprint(v))
+		if logType != "" && slices.Contains(cfg.IndexTypes, logType) {
+			index, routed = cfg.IndexPrefix+logType, true
+		}
+	}
+	if v, ok := logData["tenant"]; ok && cfg.IndexPerTenant {
+		if tenant := sanitizeIndexName(fmt.Sprint(v)); tenant != "" {
+			index, routed = index+"-"+tenant, true
+		}
+	}
+	if !routed {
+		return index, nil
+	}
+	if err := checkIndexAllowed(index); err != nil {
+		return "", err
+	}
+	return index, nil
+}
+
+var errIndexDenied = errors.New("target index is not allowed")
+
+// checkIndexAllowed rejects indices matching INDEX_DENYLIST, which covers
+// dot-prefixed system indices by default, and, when INDEX_ALLOWLIST is set,
+// indices matching none of its patterns
+func checkIndexAllowed(index string) error {
+	for _, p := range cfg.IndexDenylist {
+		if ok, _ := path.Match(p, index); ok {
+			return fmt.Errorf("%w: %s", errIndexDenied, index)
+		}
+	}
+	if len(cfg.IndexAllowlist) == 0 {
+		return nil
+	}
+	for _, p := range cfg.IndexAllowlist {
+		if ok, _ := path.Match(p, index); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", errIndexDenied, index)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIndexPerLogType(t *testing.T) {
+	for _, tc := range []struct {
+		name, body, want string
+	}{
+		{"audit", `{"log_type":"audit"}`, "telyx-audit"},
+		{"app", `{"log_type":"app"}`, "telyx-app"},
+		{"sanitized", `{"log_type":"Audit"}`, "telyx-audit"},
+		{"unknown type", `{"log_type":"nginx"}`, "logs"},
+		{"path characters stripped", `{"log_type":"../audit"}`, "telyx-audit"},
+		{"unsafe type", `{"log_type":"audit/../app"}`, "logs"},
+		{"missing type", `{"message":"hi"}`, "logs"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				c.OpenSearchIndex = "logs"
+				c.IndexPrefix = "telyx-"
+				c.IndexTypes = []string{"audit", "app"}
+			})
+			if rec := postJSON("/logs", tc.body); rec.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			writes := fake.writes()
+			if len(writes) != 1 {
+				t.Fatalf("%d writes, want 1", len(writes))
+			}
+			if index := strings.Split(strings.TrimPrefix(writes[0].Path, "/"), "/")[0]; index != tc.want {
+				t.Errorf("written to %s, want %s", index, tc.want)
+			}
+		})
+	}
+}
+
+func TestIndexTypesValidated(t *testing.T) {
+	c := defaultConfig()
+	c.IndexTypes = []string{"Audit Logs"}
+	if err := c.validate(); err == nil {
+		t.Error("type forming an invalid index name passed validation")
+	}
+}
+
+func TestCheckIndexAllowed(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		allowlist []string
+		index     string
+		allowed   bool
+	}{
+		{"dot-prefixed", nil, ".kibana", false},
+		{"security plugin", nil, ".opendistro_security", false},
+		{"audit log", nil, "security-auditlog-2026.01.01", false},
+		{"regular index", nil, "telyx-audit", true},
+		{"allowlisted", []string{"telyx-*"}, "telyx-audit", true},
+		{"not allowlisted", []string{"telyx-*"}, "logs-acme", false},
+		{"denylist wins over allowlist", []string{"*"}, ".kibana", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.IndexAllowlist = tc.allowlist })
+			err := checkIndexAllowed(tc.index)
+			if allowed := err == nil; allowed != tc.allowed {
+				t.Errorf("%s allowed = %v, want %v (%v)", tc.index, allowed, tc.allowed, err)
+			}
+			if err != nil && !errors.Is(err, errIndexDenied) {
+				t.Errorf("err = %v, want errIndexDenied", err)
+			}
+		})
+	}
+}
+
+func TestRoutedIndexAllowlist(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.IndexPrefix = "telyx-"
+		c.IndexTypes = []string{"audit", "app"}
+		c.IndexAllowlist = []string{"telyx-audit"}
+	})
+	if rec := postJSON("/logs", `{"log_type":"audit"}`); rec.Code != http.StatusCreated {
+		t.Errorf("allowlisted index: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := postJSON("/logs", `{"log_type":"app"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("index outside the allowlist: status %d, want 403", rec.Code)
+	}
+	// The operator's default target is not subject to the lists
+	if rec := postJSON("/logs", `{"message":"hi"}`); rec.Code != http.StatusCreated {
+		t.Errorf("default target: status %d: %s", rec.Code, rec.Body)
+	}
+	if n := len(fake.writes()); n != 2 {
+		t.Errorf("%d writes, want 2", n)
+	}
+}
+
+func TestIndexListPatternsValidated(t *testing.T) {
+	c := defaultConfig()
+	c.IndexAllowlist = []string{"telyx-["}
+	if err := c.validate(); err == nil {
+		t.Error("malformed allowlist pattern passed validation")
+	}
+}
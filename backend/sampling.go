@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var samplingRateGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "ingest_effective_sampling_rate",
+		Help: "Fraction of incoming logs currently kept by adaptive sampling",
+	},
+)
+
+var logsSampledDropped = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "logs_sampled_dropped_total",
+		Help: "Total number of logs dropped by a sampling rule",
+	},
+	[]string{"rule"},
+)
+
+// SamplingRule keeps a Rate fraction of the documents matching its filter.
+// A rule without field and op matches every document, which makes it the
+// default when listed last.
+type SamplingRule struct {
+	FilterRule `yaml:",inline"`
+	Rate       float64 `json:"rate" yaml:"rate"`
+}
+
+// compile validates the rule and prepares its matcher
+func (s *SamplingRule) compile() error {
+	if !(s.Rate >= 0 && s.Rate <= 1) {
+		return fmt.Errorf("sampling rule %q: rate must be between 0 and 1", s.Name)
+	}
+	if s.Field == "" && s.Op == "" {
+		if s.Name == "" {
+			return fmt.Errorf("sampling rule: name is required")
+		}
+		return nil
+	}
+	return s.FilterRule.compile()
+}
+
+func (s *SamplingRule) matches(doc map[string]interface{}) bool {
+	return s.Field == "" || s.FilterRule.matches(doc)
+}
+
+// sampleByRule applies the first rule of LOG_SAMPLING_RULES matching the
+// document, so "keep every vip tenant log, 10% of the rest" is a rule for
+// the vip tenant followed by a default rule. It returns the rule name and
+// whether the document is kept; documents matching no rule are kept.
+func sampleByRule(doc map[string]interface{}) (string, bool) {
+	for i := range cfg.SamplingRules {
+		rule := &cfg.SamplingRules[i]
+		if rule.matches(doc) {
+			return rule.Name, rule.Rate >= 1 || rand.Float64() < rule.Rate
+		}
+	}
+	return "", true
+}
+
+// ingestLoad returns how full the ingest buffers are, from 0 to 1: the
+// largest of the in-flight byte budget, the async queue and the WebSocket
+// stream buffers
+func ingestLoad() float64 {
+	var load float64
+	if cfg.MaxInflightBytes > 0 {
+		load = float64(inflightBytes.Load()) / float64(cfg.MaxInflightBytes)
+	}
+	if asyncQueue != nil {
+		load = max(load, float64(asyncQueue.depth())/float64(cfg.AsyncQueueSize))
+	}
+	if n := openStreams.Load(); n > 0 && cfg.WSBufferSize > 0 {
+		load = max(load, float64(streamBacklog.Load())/float64(n*int64(cfg.WSBufferSize)))
+	}
+	return min(load, 1)
+}
+
+// effectiveSamplingRate keeps every log below ADAPTIVE_SAMPLING_LOW load and
+// lowers the kept fraction linearly to ADAPTIVE_SAMPLING_MIN_RATE at
+// ADAPTIVE_SAMPLING_HIGH, so the rate relaxes again as load drops
+func effectiveSamplingRate(load float64) float64 {
+	low, high, floor := cfg.AdaptiveSamplingLow, cfg.AdaptiveSamplingHigh, cfg.AdaptiveSamplingMinRate
+	switch {
+	case load <= low:
+		return 1
+	case load >= high:
+		return floor
+	}
+	return 1 - (load-low)/(high-low)*(1-floor)
+}
+
+// sampleUnderLoad reports whether a log should be kept at the current load
+func sampleUnderLoad() bool {
+	if !cfg.AdaptiveSampling {
+		return true
+	}
+	rate := effectiveSamplingRate(ingestLoad())
+	samplingRateGauge.Set(rate)
+	return rate >= 1 || rand.Float64() < rate
+}
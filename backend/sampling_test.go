@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAdaptiveSamplingFollowsLoad(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.AdaptiveSampling = true
+		c.MaxInflightBytes = 1000
+		c.AdaptiveSamplingLow = 0.5
+		c.AdaptiveSamplingHigh = 0.9
+		c.AdaptiveSamplingMinRate = 0.1
+	})
+	saved := inflightBytes.Load()
+	t.Cleanup(func() { inflightBytes.Store(saved) })
+
+	for _, tc := range []struct {
+		inflight int64
+		want     float64
+	}{
+		{200, 1},
+		{700, 0.55},
+		{950, 0.1},
+		// The rate relaxes again as the buffers drain
+		{300, 1},
+	} {
+		inflightBytes.Store(tc.inflight)
+		sampleUnderLoad()
+		if got := testutil.ToFloat64(samplingRateGauge); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("%d of 1000 bytes in flight: effective rate %v, want %v", tc.inflight, got, tc.want)
+		}
+	}
+}
+
+func TestAdaptiveSamplingDropsUnderLoad(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.AdaptiveSampling = true
+		c.MaxInflightBytes = 1000
+		c.AdaptiveSamplingMinRate = 0
+	})
+	saved := inflightBytes.Load()
+	t.Cleanup(func() { inflightBytes.Store(saved) })
+
+	inflightBytes.Store(1000)
+	for i := 0; i < 100; i++ {
+		if sampleUnderLoad() {
+			t.Fatal("log kept at full load with a zero minimum rate")
+		}
+	}
+	inflightBytes.Store(0)
+	if !sampleUnderLoad() {
+		t.Error("log dropped without load")
+	}
+}
+
+func TestSamplingRulesByField(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.SamplingRules = []SamplingRule{
+			{FilterRule: FilterRule{Name: "vip", Field: "tenant", Op: "equals", Value: "vip"}, Rate: 1},
+			{FilterRule: FilterRule{Name: "default"}, Rate: 0.1},
+		}
+	})
+	dropped := testutil.ToFloat64(logsSampledDropped.WithLabelValues("default"))
+	vipDropped := testutil.ToFloat64(logsSampledDropped.WithLabelValues("vip"))
+
+	const n = 200
+	logs := make([]map[string]interface{}, 0, 2*n)
+	for i := 0; i < n; i++ {
+		logs = append(logs,
+			map[string]interface{}{"tenant": "vip", "message": "kept"},
+			map[string]interface{}{"tenant": "free", "message": "sampled"},
+		)
+	}
+	body, _ := json.Marshal(logs)
+	if rec := postJSON("/logs/bulk", string(body)); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	kept := map[interface{}]int{}
+	for _, doc := range fake.docs(t) {
+		kept[doc["tenant"]]++
+	}
+	if kept["vip"] != n {
+		t.Errorf("kept %d of %d vip logs, want all", kept["vip"], n)
+	}
+	// 10% of 200 is 20; 60 would be far out of reach of a 0.1 rate
+	if kept["free"] == 0 || kept["free"] > 60 {
+		t.Errorf("kept %d of %d other logs, want about a tenth", kept["free"], n)
+	}
+	if got := testutil.ToFloat64(logsSampledDropped.WithLabelValues("default")) - dropped; got != float64(n-kept["free"]) {
+		t.Errorf("logs_sampled_dropped_total{rule=default} grew by %v, want %d", got, n-kept["free"])
+	}
+	if got := testutil.ToFloat64(logsSampledDropped.WithLabelValues("vip")) - vipDropped; got != 0 {
+		t.Errorf("logs_sampled_dropped_total{rule=vip} grew by %v, want 0", got)
+	}
+}
+
+func TestSamplingRulesValidated(t *testing.T) {
+	for name, rule := range map[string]SamplingRule{
+		"rate above 1":         {FilterRule: FilterRule{Name: "r"}, Rate: 1.5},
+		"negative rate":        {FilterRule: FilterRule{Name: "r"}, Rate: -0.1},
+		"default without name": {Rate: 0.5},
+		"unknown op":           {FilterRule: FilterRule{Name: "r", Field: "tenant", Op: "like", Value: "v"}, Rate: 0.5},
+	} {
+		c := defaultConfig()
+		c.SamplingRules = []SamplingRule{rule}
+		if err := c.validate(); err == nil {
+			t.Errorf("%s: passed validation", name)
+		}
+	}
+}
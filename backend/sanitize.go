@@ -0,0 +1,84 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// sanitizeFieldNames rewrites field names OpenSearch handles badly: dots
+// are replaced by LOG_FIELD_DOT_REPLACEMENT and leading underscores are
+// stripped unless the name is in LOG_ALLOWED_UNDERSCORE_FIELDS. With
+// LOG_SNAKE_CASE_FIELDS names are also converted to snake_case, so clients
+// sending userId and user_id fill the same field. Renamed fields are listed
+// in "renamed_fields" as {"from": original path, "to": new path} pairs; an
+// array keeps the original, possibly dotted, paths out of the field names.
+func sanitizeFieldNames(logData map[string]interface{}) {
+	if !cfg.LogSanitizeFieldNames && !cfg.LogSnakeCaseFields {
+		return
+	}
+	var renamed []interface{}
+	sanitizeObject(logData, "", "", &renamed)
+	if len(renamed) > 0 {
+		logData["renamed_fields"] = renamed
+	}
+}
+
+func sanitizeObject(obj map[string]interface{}, from, to string, renamed *[]interface{}) {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := obj[k]
+		name := sanitizeFieldName(k)
+		if name != k {
+			// Never clobber a field that already uses the sanitized name
+			if _, taken := obj[name]; !taken && name != "" {
+				delete(obj, k)
+				obj[name] = v
+				*renamed = append(*renamed, map[string]interface{}{"from": from + k, "to": to + name})
+			} else {
+				name = k
+			}
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			sanitizeObject(m, from+k+".", to+name+".", renamed)
+		}
+	}
+}
+
+func sanitizeFieldName(name string) string {
+	if cfg.LogSnakeCaseFields && !slices.Contains(cfg.LogSnakeCaseExceptions, name) {
+		name = snakeCase(name)
+	}
+	if !cfg.LogSanitizeFieldNames || slices.Contains(cfg.LogAllowedUnderscoreFields, name) {
+		return name
+	}
+	name = strings.TrimLeft(name, "_")
+	return strings.ReplaceAll(name, ".", cfg.LogFieldDotReplacement)
+}
+
+// snakeCase converts camelCase and PascalCase to snake_case, keeping
+// acronyms together: userId, UserID and HTTPStatus become user_id, user_id
+// and http_status. Names already in snake_case are returned unchanged.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && runes[i-1] != '_' {
+			prev := runes[i-1]
+			// An upper case letter starts a word after a lower case letter or
+			// digit, or ends an acronym when a lower case letter follows
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				(unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
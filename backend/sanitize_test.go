@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestFieldNameSanitizer(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.LogSanitizeFieldNames = true
+		c.LogAllowedUnderscoreFields = []string{"_source_app"}
+	})
+	rec := postJSON("/logs", `{"user.name":"ann","_internal":true,"_source_app":"web","ctx":{"__k.v":1},"user_name":"kept"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	docs := fake.docs(t)
+	if len(docs) != 1 {
+		t.Fatalf("indexed %d logs, want 1", len(docs))
+	}
+	doc := docs[0]
+	if doc["internal"] != true {
+		t.Errorf("_internal not renamed to internal: %v", doc)
+	}
+	if doc["_source_app"] != "web" {
+		t.Errorf("allowed underscore field renamed: %v", doc)
+	}
+	if doc["user_name"] != "kept" || doc["user.name"] != "ann" {
+		t.Errorf("user.name clobbered the existing user_name: %v", doc)
+	}
+	if ctx := doc["ctx"].(map[string]interface{}); ctx["k_v"] != float64(1) {
+		t.Errorf("nested field not sanitized: %v", ctx)
+	}
+	want := []interface{}{
+		map[string]interface{}{"from": "_internal", "to": "internal"},
+		map[string]interface{}{"from": "ctx.__k.v", "to": "ctx.k_v"},
+	}
+	if !reflect.DeepEqual(doc["renamed_fields"], want) {
+		t.Errorf("renamed_fields = %v, want %v", doc["renamed_fields"], want)
+	}
+}
+
+func TestFieldNameSanitizerDots(t *testing.T) {
+	withConfig(t, func(c *Config) { c.LogSanitizeFieldNames = true })
+	doc := map[string]interface{}{"user.name": "ann"}
+	sanitizeFieldNames(doc)
+	if doc["user_name"] != "ann" {
+		t.Errorf("user.name not renamed to user_name: %v", doc)
+	}
+	want := []interface{}{map[string]interface{}{"from": "user.name", "to": "user_name"}}
+	if !reflect.DeepEqual(doc["renamed_fields"], want) {
+		t.Errorf("renamed_fields = %v, want %v", doc["renamed_fields"], want)
+	}
+}
+
+func TestFieldDotReplacementValidated(t *testing.T) {
+	c := defaultConfig()
+	c.LogSanitizeFieldNames = true
+	c.LogFieldDotReplacement = "."
+	if err := c.validate(); err == nil {
+		t.Error("dot replacement containing a dot passed validation")
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for in, want := range map[string]string{
+		"userId":      "user_id",
+		"UserID":      "user_id",
+		"HTTPStatus":  "http_status",
+		"parseHTTP2":  "parse_http2",
+		"ipv4Addr":    "ipv4_addr",
+		"user_id":     "user_id",
+		"already_Set": "already_set",
+		"message":     "message",
+	} {
+		if got := snakeCase(in); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSnakeCaseFields(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.LogSnakeCaseFields = true
+		c.LogSnakeCaseExceptions = []string{"traceID"}
+	})
+	rec := postJSON("/logs", `{"userId":"ann","traceID":"abc","request":{"remoteAddr":"10.0.0.1","statusCode":200},"log_level":"info"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	docs := fake.docs(t)
+	if len(docs) != 1 {
+		t.Fatalf("indexed %d logs, want 1", len(docs))
+	}
+	doc := docs[0]
+	if doc["user_id"] != "ann" {
+		t.Errorf("userId not renamed to user_id: %v", doc)
+	}
+	if doc["traceID"] != "abc" {
+		t.Errorf("exception traceID was renamed: %v", doc)
+	}
+	if doc["log_level"] != "info" {
+		t.Errorf("snake_case field changed: %v", doc)
+	}
+	want := map[string]interface{}{"remote_addr": "10.0.0.1", "status_code": float64(200)}
+	if !reflect.DeepEqual(doc["request"], want) {
+		t.Errorf("nested fields = %v, want %v", doc["request"], want)
+	}
+	renamed := []interface{}{
+		map[string]interface{}{"from": "request.remoteAddr", "to": "request.remote_addr"},
+		map[string]interface{}{"from": "request.statusCode", "to": "request.status_code"},
+		map[string]interface{}{"from": "userId", "to": "user_id"},
+	}
+	if !reflect.DeepEqual(doc["renamed_fields"], renamed) {
+		t.Errorf("renamed_fields = %v, want %v", doc["renamed_fields"], renamed)
+	}
+}
+
+func TestSnakeCaseKeepsExistingField(t *testing.T) {
+	withConfig(t, func(c *Config) { c.LogSnakeCaseFields = true })
+	doc := map[string]interface{}{"userId": "camel", "user_id": "snake"}
+	sanitizeFieldNames(doc)
+	if doc["user_id"] != "snake" || doc["userId"] != "camel" {
+		t.Errorf("userId clobbered the existing user_id: %v", doc)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// logSchema is the compiled VALIDATION_SCHEMA_PATH document, nil when unset
+var logSchema *jsonschema.Schema
+
+// compileLogSchema loads and compiles the JSON Schema once at startup
+func compileLogSchema(file string) (*jsonschema.Schema, error) {
+	schema, err := jsonschema.Compile(file)
+	if err != nil {
+		return nil, fmt.Errorf("compile %s: %w", file, err)
+	}
+	return schema, nil
+}
+
+// validateSchema checks a log against the configured JSON Schema
+func validateSchema(doc map[string]interface{}) error {
+	if logSchema == nil {
+		return nil
+	}
+	verr := &validationError{}
+	err := logSchema.Validate(doc)
+	var ve *jsonschema.ValidationError
+	if errors.As(err, &ve) {
+		collectSchemaViolations(ve, verr)
+	} else if err != nil {
+		return err
+	}
+	if cfg.LogStrictFields {
+		collectUnknownFields(logSchema, doc, "", verr)
+	}
+	if len(verr.Violations) == 0 {
+		return nil
+	}
+	return verr
+}
+
+// collectUnknownFields reports fields the schema does not declare, the way
+// json.Decoder.DisallowUnknownFields does for structs. Objects whose schema
+// lists no properties accept anything.
+func collectUnknownFields(schema *jsonschema.Schema, doc map[string]interface{}, prefix string, verr *validationError) {
+	for schema.Ref != nil {
+		schema = schema.Ref
+	}
+	if len(schema.Properties) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		prop, ok := schema.Properties[k]
+		if !ok {
+			verr.add(prefix+k, "unknown_field", fmt.Sprintf("field %q is not declared in the schema", k))
+			continue
+		}
+		if nested, ok := doc[k].(map[string]interface{}); ok {
+			collectUnknownFields(prop, nested, prefix+k+".", verr)
+		}
+	}
+}
+
+// collectSchemaViolations flattens the error tree into its leaf causes, which
+// are the ones naming the failing field and keyword
+func collectSchemaViolations(ve *jsonschema.ValidationError, verr *validationError) {
+	if len(ve.Causes) > 0 {
+		for _, cause := range ve.Causes {
+			collectSchemaViolations(cause, verr)
+		}
+		return
+	}
+	field := strings.TrimPrefix(strings.ReplaceAll(ve.InstanceLocation, "/", "."), ".")
+	verr.add(field, "schema:"+path.Base(ve.KeywordLocation), ve.Message)
+}
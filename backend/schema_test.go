@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["service", "message"],
+	"properties": {
+		"service": {"type": "string"},
+		"message": {"type": "string"},
+		"http": {"type": "object", "properties": {"status": {"type": "integer"}}}
+	}
+}`
+
+// withSchema loads schema as VALIDATION_SCHEMA_PATH
+func withSchema(t *testing.T, schema string, mutate func(*Config)) *fakeOpenSearch {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(schema), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return withOpenSearch(t, func(c *Config) {
+		c.ValidationSchemaPath = path
+		if mutate != nil {
+			mutate(c)
+		}
+	})
+}
+
+// violationsOf decodes the violations of a 422 response
+func violationsOf(t *testing.T, body []byte) []violation {
+	t.Helper()
+	var res struct {
+		Violations []violation `json:"violations"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatalf("decode %s: %v", body, err)
+	}
+	return res.Violations
+}
+
+func TestSchemaValidation(t *testing.T) {
+	fake := withSchema(t, testSchema, nil)
+
+	if rec := postJSON("/logs", `{"service":"checkout","message":"paid","http":{"status":200}}`); rec.Code != http.StatusCreated {
+		t.Fatalf("valid log: status %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := postJSON("/logs", `{"message":42,"http":{"status":"ok"}}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid log: status %d, want 422: %s", rec.Code, rec.Body)
+	}
+	violations := violationsOf(t, rec.Body.Bytes())
+	for _, want := range []violation{
+		{Field: "", Rule: "schema:required", Message: "missing properties: 'service'"},
+		{Field: "message", Rule: "schema:type", Message: "expected string, but got number"},
+		{Field: "http.status", Rule: "schema:type", Message: "expected integer, but got string"},
+	} {
+		if !slices.Contains(violations, want) {
+			t.Errorf("no violation %+v in %+v", want, violations)
+		}
+	}
+	if len(fake.writes()) != 1 {
+		t.Errorf("%d writes, want only the valid log", len(fake.writes()))
+	}
+}
+
+func TestStrictFields(t *testing.T) {
+	const body = `{"service":"checkout","message":"paid","extra":1,"http":{"status":200,"verb":"GET"}}`
+	for _, tc := range []struct {
+		name   string
+		strict bool
+		want   int
+	}{
+		{"lenient", false, http.StatusCreated},
+		{"strict", true, http.StatusUnprocessableEntity},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withSchema(t, testSchema, func(c *Config) { c.LogStrictFields = tc.strict })
+			rec := postJSON("/logs", body)
+			if rec.Code != tc.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+			if !tc.strict {
+				return
+			}
+			violations := violationsOf(t, rec.Body.Bytes())
+			var fields []string
+			for _, v := range violations {
+				if v.Rule == "unknown_field" {
+					fields = append(fields, v.Field)
+				}
+			}
+			if !slices.Equal(fields, []string{"extra", "http.verb"}) {
+				t.Errorf("unknown fields reported %v, want [extra http.verb]: %+v", fields, violations)
+			}
+		})
+	}
+}
+
+func TestStrictFieldsNeedsSchema(t *testing.T) {
+	c := defaultConfig()
+	c.LogStrictFields = true
+	if err := c.validate(); err == nil {
+		t.Error("strict fields without a schema passed validation")
+	}
+}
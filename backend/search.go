@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// searchExposedHeaders carry the result metadata of streamed searches, which
+// has no place in the NDJSON body
+const searchExposedHeaders = "X-Total-Count, X-Search-Cursor"
+
+// searchFlushEvery is how many streamed hits are written between flushes
+const searchFlushEvery = 25
+
+// wantsNDJSON reports whether the client asked for search hits as NDJSON,
+// with ?format=ndjson or an Accept header naming application/x-ndjson
+func wantsNDJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "ndjson" {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), "application/x-ndjson") {
+			return true
+		}
+	}
+	return false
+}
+
+// streamSearchHits writes one log per line, flushing as it goes so
+// dashboards can render the first hits while the rest is still being
+// encoded. The total and the next page cursor travel in headers.
+func streamSearchHits(w http.ResponseWriter, logs []map[string]interface{}, total int, cursor string) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if cursor != "" {
+		w.Header().Set("X-Search-Cursor", cursor)
+	}
+	enc := json.NewEncoder(w)
+	rc := http.NewResponseController(w)
+	for i, l := range logs {
+		if err := enc.Encode(l); err != nil {
+			return
+		}
+		if (i+1)%searchFlushEvery == 0 {
+			rc.Flush()
+		}
+	}
+	rc.Flush()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// serveHits answers searches of the logs index with n hits numbered from 0
+func serveHits(fake *fakeOpenSearch, n, total int) {
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		if c.Path != "/logs/_search" {
+			return false
+		}
+		hits := make([]map[string]interface{}, n)
+		for i := range hits {
+			hits[i] = map[string]interface{}{"_source": map[string]interface{}{"message": fmt.Sprintf("hit %d", i)}}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"hits": map[string]interface{}{"hits": hits, "total": map[string]int{"value": total}},
+		})
+		return true
+	})
+}
+
+func TestSearchStreamsNDJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		query  string
+		accept string
+	}{
+		{"accept header", "", "application/json;q=0.5, application/x-ndjson"},
+		{"format parameter", "?format=ndjson", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, nil)
+			// More hits than searchFlushEvery so the stream is flushed midway
+			const n = searchFlushEvery*2 + 3
+			serveHits(fake, n, 500)
+
+			req := httptest.NewRequest(http.MethodGet, "/logs/search"+tc.query, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rec := do(req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+			}
+			if got := rec.Header().Get("X-Total-Count"); got != "500" {
+				t.Errorf("X-Total-Count = %q, want 500", got)
+			}
+			if !rec.Flushed {
+				t.Error("stream was never flushed")
+			}
+
+			lines := 0
+			sc := bufio.NewScanner(bytes.NewReader(rec.Body.Bytes()))
+			for sc.Scan() {
+				var hit map[string]interface{}
+				if err := json.Unmarshal(sc.Bytes(), &hit); err != nil {
+					t.Fatalf("line %d is not one JSON object: %q", lines, sc.Text())
+				}
+				if want := fmt.Sprintf("hit %d", lines); hit["message"] != want {
+					t.Errorf("line %d = %v, want message %q", lines, hit, want)
+				}
+				lines++
+			}
+			if lines != n {
+				t.Errorf("streamed %d lines, want %d", lines, n)
+			}
+		})
+	}
+}
+
+func TestSearchStreamCursorHeader(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	newPITIndex(fake, map[string]map[string]interface{}{
+		"a": {"message": "one", "timestamp": "2026-01-01T00:00:01Z"},
+		"b": {"message": "two", "timestamp": "2026-01-01T00:00:02Z"},
+		"c": {"message": "three", "timestamp": "2026-01-01T00:00:03Z"},
+	})
+	rec := do(httptest.NewRequest(http.MethodGet, "/logs/search?paginate=true&limit=2&format=ndjson", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	cursor := rec.Header().Get("X-Search-Cursor")
+	if cursor == "" {
+		t.Fatal("first page has no X-Search-Cursor")
+	}
+	if lines := bytes.Count(rec.Body.Bytes(), []byte("\n")); lines != 2 {
+		t.Errorf("streamed %d lines, want 2", lines)
+	}
+	if _, err := decodeCursor(cursor); err != nil {
+		t.Errorf("X-Search-Cursor does not decode: %v", err)
+	}
+}
+
+func TestSearchJSONByDefault(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	serveHits(fake, 2, 2)
+	req := httptest.NewRequest(http.MethodGet, "/logs/search", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := do(req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if vary := rec.Header().Values("Vary"); !slices.Contains(vary, "Accept") {
+		t.Errorf("Vary = %q, want Accept among them", vary)
+	}
+	var res struct {
+		Total int                      `json:"total"`
+		Logs  []map[string]interface{} `json:"logs"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("response is not one JSON object: %v: %s", err, rec.Body)
+	}
+	if res.Total != 2 || len(res.Logs) != 2 {
+		t.Errorf("total %d with %d logs, want 2 and 2", res.Total, len(res.Logs))
+	}
+}
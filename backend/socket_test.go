@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// unixClient sends every request to the Unix socket at path
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestIngestOverUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telyx.sock")
+	fake := withOpenSearch(t, nil)
+	ln, err := listenSocket(path, "0600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: newPublicHandler()}
+	go srv.Serve(ln)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket mode %o, want 600", perm)
+	}
+
+	res, err := unixClient(path).Post("http://telyx/logs", "application/json", strings.NewReader(`{"message":"from the sidecar"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("status %d, want 201", res.StatusCode)
+	}
+	if docs := fake.docs(t); len(docs) != 1 || docs[0]["message"] != "from the sidecar" {
+		t.Errorf("indexed %v, want the sidecar log", docs)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket file left after shutdown: %v", err)
+	}
+}
+
+func TestListenSocketReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telyx.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Simulate an unclean exit: the file stays behind
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := listenSocket(path, "0660")
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	ln.Close()
+}
+
+func TestListenSocketKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telyx.sock")
+	if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if ln, err := listenSocket(path, "0660"); err == nil {
+		ln.Close()
+		t.Fatal("regular file at the socket path was replaced")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "data" {
+		t.Error("regular file at the socket path was modified")
+	}
+}
+
+func TestListenSocketModeValidated(t *testing.T) {
+	c := defaultConfig()
+	c.ListenSocketMode = "rw-rw----"
+	if err := c.validate(); err == nil {
+		t.Error("non-octal LISTEN_SOCKET_MODE passed validation")
+	}
+}
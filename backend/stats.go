@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// startedAt is when the process started, for uptime reporting
+var startedAt = time.Now()
+
+// Plain counters mirroring the Prometheus ones so /stats can report them
+// without going through the registry
+var (
+	ingestedTotal atomic.Int64
+	droppedTotal  = map[string]*atomic.Int64{}
+)
+
+func init() {
+	for _, reason := range dropReasons {
+		droppedTotal[reason] = new(atomic.Int64)
+	}
+}
+
+// recordIngested counts logs OpenSearch accepted
+func recordIngested(n int) {
+	ingestedTotal.Add(int64(n))
+}
+
+// statsHandler returns a JSON snapshot of ingest throughput for operators
+// without a Prometheus setup
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	dropped := make(map[string]int64, len(droppedTotal))
+	for reason, n := range droppedTotal {
+		dropped[reason] = n.Load()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ingested":       ingestedTotal.Load(),
+		"dropped":        dropped,
+		"inflight_bytes": inflightBytes.Load(),
+		"queue_depth":    asyncQueue.depth(),
+		"stream_backlog": streamBacklog.Load(),
+		"open_streams":   openStreams.Load(),
+		"circuit_state":  osBreaker.currentState(),
+		"uptime_seconds": int64(time.Since(startedAt).Seconds()),
+	})
+}
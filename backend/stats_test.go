@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type statsSnapshot struct {
+	Ingested      int64            `json:"ingested"`
+	Dropped       map[string]int64 `json:"dropped"`
+	QueueDepth    int              `json:"queue_depth"`
+	CircuitState  string           `json:"circuit_state"`
+	UptimeSeconds *int64           `json:"uptime_seconds"`
+}
+
+func getStats(t *testing.T) statsSnapshot {
+	t.Helper()
+	rec := do(httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /stats: status %d: %s", rec.Code, rec.Body)
+	}
+	var s statsSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStatsReflectIngestion(t *testing.T) {
+	withOpenSearch(t, func(c *Config) { c.LogMinFields = 1 })
+	before := getStats(t)
+
+	postJSON("/logs", `{"message":"one"}`)
+	postJSON("/logs/bulk", `[{"message":"two"},{"message":"three"},{}]`)
+	postJSON("/logs", `{}`)
+	postJSON("/logs", `not json`)
+
+	after := getStats(t)
+	if got := after.Ingested - before.Ingested; got != 3 {
+		t.Errorf("ingested grew by %d, want 3", got)
+	}
+	for reason, want := range map[string]int64{dropValidation: 2, dropInvalid: 1, dropFiltered: 0} {
+		if got := after.Dropped[reason] - before.Dropped[reason]; got != want {
+			t.Errorf("dropped[%s] grew by %d, want %d", reason, got, want)
+		}
+	}
+	if after.CircuitState != circuitClosed {
+		t.Errorf("circuit_state = %q", after.CircuitState)
+	}
+	if after.UptimeSeconds == nil {
+		t.Error("uptime_seconds missing")
+	}
+}
+
+func TestStatsAdminOnly(t *testing.T) {
+	withConfig(t, func(c *Config) { c.AdminAllowedCIDRs = []string{"10.0.0.0/8"} })
+	if rec := do(httptest.NewRequest(http.MethodGet, "/stats", nil)); rec.Code != http.StatusForbidden {
+		t.Errorf("GET /stats from outside the admin networks: status %d, want 403", rec.Code)
+	}
+}
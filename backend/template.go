@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// indexMappings maps field names, dotted for nested objects, to their
+// OpenSearch mapping as loaded from INDEX_MAPPINGS_PATH; nil when unset
+var indexMappings map[string]map[string]interface{}
+
+// Mapping types accepted in INDEX_MAPPINGS_PATH
+var mappingTypes = map[string]bool{
+	"text": true, "keyword": true, "match_only_text": true, "wildcard": true,
+	"long": true, "integer": true, "short": true, "byte": true,
+	"double": true, "float": true, "half_float": true, "scaled_float": true,
+	"date": true, "date_nanos": true, "boolean": true, "ip": true,
+	"object": true, "nested": true, "flat_object": true, "geo_point": true,
+}
+
+// loadIndexMappings reads a YAML or JSON file of per-field mappings, e.g.
+//
+//	message: {type: text, analyzer: standard}
+//	service: {type: keyword}
+func loadIndexMappings(path string) (map[string]map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("index mappings: %w", err)
+	}
+	defer f.Close()
+	var m map[string]map[string]interface{}
+	if err := yaml.NewDecoder(f).Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("index mappings %s: %w", path, err)
+	}
+	if err := validateIndexMappings(m); err != nil {
+		return nil, fmt.Errorf("index mappings %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// validateIndexMappings catches mistakes OpenSearch would only report when
+// the template is installed
+func validateIndexMappings(m map[string]map[string]interface{}) error {
+	var errs []error
+	for field, mapping := range m {
+		t, _ := mapping["type"].(string)
+		if !mappingTypes[t] {
+			errs = append(errs, fmt.Errorf("field %s: unsupported type %q", field, mapping["type"]))
+			continue
+		}
+		_, hasAnalyzer := mapping["analyzer"]
+		if hasAnalyzer && t != "text" && t != "match_only_text" {
+			errs = append(errs, fmt.Errorf("field %s: analyzer only applies to text fields", field))
+		}
+		if strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			errs = append(errs, fmt.Errorf("field %s: invalid field name", field))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// templateProperties turns the flat field list into nested mapping
+// properties, so "http.path" lands under http.properties.path
+func templateProperties(m map[string]map[string]interface{}) map[string]interface{} {
+	fields := make([]string, 0, len(m))
+	for f := range m {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	root := map[string]interface{}{}
+	for _, field := range fields {
+		props := root
+		parts := strings.Split(field, ".")
+		for _, part := range parts[:len(parts)-1] {
+			parent, ok := props[part].(map[string]interface{})
+			if !ok {
+				parent = map[string]interface{}{}
+				props[part] = parent
+			}
+			children, ok := parent["properties"].(map[string]interface{})
+			if !ok {
+				children = map[string]interface{}{}
+				parent["properties"] = children
+			}
+			props = children
+		}
+		leaf, ok := props[parts[len(parts)-1]].(map[string]interface{})
+		if !ok {
+			leaf = map[string]interface{}{}
+			props[parts[len(parts)-1]] = leaf
+		}
+		for k, v := range m[field] {
+			leaf[k] = v
+		}
+	}
+	return root
+}
+
+// indexTemplate builds the index template body covering the configured
+// index and every per-type index
+func indexTemplate(dataStream bool) map[string]interface{} {
+	patterns := []string{cfg.OpenSearchIndex + "*"}
+	if len(cfg.IndexTypes) > 0 && !strings.HasPrefix(cfg.IndexPrefix, cfg.OpenSearchIndex) {
+		patterns = append(patterns, cfg.IndexPrefix+"*")
+	}
+	tmpl := map[string]interface{}{
+		"index_patterns": patterns,
+		"priority":       100,
+	}
+	if dataStream {
+		tmpl["data_stream"] = map[string]interface{}{}
+	}
+	if indexMappings != nil {
+		tmpl["template"] = map[string]interface{}{
+			"mappings": map[string]interface{}{"properties": templateProperties(indexMappings)},
+		}
+	}
+	return tmpl
+}
+
+// putIndexTemplate has OpenSearch simulate the template first so an invalid
+// mapping fails startup with a clear error, then installs it
+func putIndexTemplate(ctx context.Context, name string, tmpl map[string]interface{}) error {
+	body, _ := json.Marshal(tmpl)
+	status, resBody, err := osRequest(ctx, "bootstrap", http.MethodPost, osURL("_index_template", "_simulate"), body)
+	if err != nil {
+		return fmt.Errorf("validate index template %s: %w", name, err)
+	}
+	if status >= 400 {
+		return fmt.Errorf("validate index template %s: OpenSearch returned %d: %s", name, status, resBody)
+	}
+	status, _, err = osRequest(ctx, "bootstrap", http.MethodPut, osURL("_index_template", name), body)
+	if err != nil {
+		return fmt.Errorf("create index template %s: %w", name, err)
+	}
+	if status >= 400 {
+		return fmt.Errorf("create index template %s: OpenSearch returned %d", name, status)
+	}
+	log.Printf("Installed index template %s", name)
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeMappings writes an INDEX_MAPPINGS_PATH file
+func writeMappings(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mappings.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestIndexTemplateFromMappings(t *testing.T) {
+	path := writeMappings(t, `
+message: {type: text, analyzer: english}
+service: {type: keyword}
+http.status: {type: integer}
+http.path: {type: keyword, ignore_above: 256}
+`)
+	fake := withOpenSearch(t, func(c *Config) {
+		c.OpenSearchIndex = "logs"
+		c.IndexMappingsPath = path
+	})
+	if err := putIndexTemplate(context.Background(), "logs-template", indexTemplate(false)); err != nil {
+		t.Fatal(err)
+	}
+
+	var simulated, installed []byte
+	for _, c := range fake.requests() {
+		switch {
+		case c.Method == http.MethodPost && c.Path == "/_index_template/_simulate":
+			simulated = c.Body
+		case c.Method == http.MethodPut && c.Path == "/_index_template/logs-template":
+			installed = c.Body
+		}
+	}
+	if simulated == nil || !reflect.DeepEqual(simulated, installed) {
+		t.Fatalf("template not simulated before being installed: simulated %s, installed %s", simulated, installed)
+	}
+	var tmpl struct {
+		IndexPatterns []string `json:"index_patterns"`
+		Template      struct {
+			Mappings struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"mappings"`
+		} `json:"template"`
+	}
+	if err := json.Unmarshal(installed, &tmpl); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"message": map[string]interface{}{"type": "text", "analyzer": "english"},
+		"service": map[string]interface{}{"type": "keyword"},
+		"http": map[string]interface{}{"properties": map[string]interface{}{
+			"status": map[string]interface{}{"type": "integer"},
+			"path":   map[string]interface{}{"type": "keyword", "ignore_above": float64(256)},
+		}},
+	}
+	if !reflect.DeepEqual(tmpl.Template.Mappings.Properties, want) {
+		t.Errorf("template properties %v, want %v", tmpl.Template.Mappings.Properties, want)
+	}
+	if !slices.Equal(tmpl.IndexPatterns, []string{"logs*"}) {
+		t.Errorf("index_patterns = %v", tmpl.IndexPatterns)
+	}
+}
+
+func TestIndexTemplateRejectedBySimulation(t *testing.T) {
+	path := writeMappings(t, "message: {type: text}\n")
+	fake := withOpenSearch(t, func(c *Config) { c.IndexMappingsPath = path })
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		if c.Path != "/_index_template/_simulate" {
+			return false
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"mapper_parsing_exception"}`))
+		return true
+	})
+	err := putIndexTemplate(context.Background(), "logs-template", indexTemplate(false))
+	if err == nil || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Errorf("err = %v, want the simulation error", err)
+	}
+	for _, c := range fake.requests() {
+		if c.Method == http.MethodPut {
+			t.Errorf("invalid template installed: %s", c.Path)
+		}
+	}
+}
+
+func TestIndexMappingsValidated(t *testing.T) {
+	for _, tc := range []struct{ name, content string }{
+		{"unknown type", "message: {type: txt}\n"},
+		{"analyzer on keyword", "service: {type: keyword, analyzer: english}\n"},
+		{"bad field name", "http..path: {type: keyword}\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := loadIndexMappings(writeMappings(t, tc.content)); err == nil {
+				t.Error("invalid mappings loaded")
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sources LOG_TIMESTAMP_SOURCES picks the event time of a log from
+const (
+	timestampClient = "client"
+	timestampHeader = "header"
+	timestampIngest = "ingest"
+)
+
+var timestampSources = map[string]bool{timestampClient: true, timestampHeader: true, timestampIngest: true}
+
+type logTimestampKey struct{}
+
+// withLogTimestamp stores the time sent in LOG_TIMESTAMP_HEADER for the logs
+// of this request, failing when it is not an RFC 3339 date
+func withLogTimestamp(ctx context.Context, r *http.Request) (context.Context, bool) {
+	v := r.Header.Get(cfg.LogTimestampHeader)
+	if v == "" {
+		return ctx, true
+	}
+	if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+		return ctx, false
+	}
+	return context.WithValue(ctx, logTimestampKey{}, v), true
+}
+
+// resolveTimestamp sets the event time of a log from the first source of
+// LOG_TIMESTAMP_SOURCES that has one:
+//
+//   - client: the timestamp field sent in the log itself
+//   - header: the LOG_TIMESTAMP_HEADER of the request
+//   - ingest: the time the server received the log, always available
+//
+// The default "client,header,ingest" keeps original times for backfills,
+// while "ingest" alone stamps every log with the time it arrived and
+// overwrites whatever the client sent. When no listed source has a time the
+// log is stored without one.
+func resolveTimestamp(ctx context.Context, logData map[string]interface{}) {
+	field := timestampField()
+	for _, source := range cfg.LogTimestampSources {
+		switch source {
+		case timestampClient:
+			if _, exists := logData[field]; exists {
+				return
+			}
+		case timestampHeader:
+			if v, ok := ctx.Value(logTimestampKey{}).(string); ok {
+				logData[field] = v
+				return
+			}
+		case timestampIngest:
+			logData[field] = time.Now().Format(time.RFC3339)
+			return
+		}
+	}
+	delete(logData, field)
+}
+
+// Actions LOG_MAX_AGE_ACTION takes on logs older than LOG_MAX_AGE
+const (
+	maxAgeReject = "reject"
+	maxAgeRoute  = "route"
+	maxAgeTag    = "tag"
+)
+
+var maxAgeActions = map[string]bool{maxAgeReject: true, maxAgeRoute: true, maxAgeTag: true}
+
+// staleField marks logs older than LOG_MAX_AGE under the tag action
+const staleField = "stale"
+
+var logsTooOld = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "logs_too_old_total",
+		Help: "Total number of logs older than LOG_MAX_AGE, by action taken",
+	},
+	[]string{"action"},
+)
+
+// logTime parses the event time of a log: an RFC 3339 string or epoch
+// milliseconds, the formats the timestamp field is mapped with
+func logTime(logData map[string]interface{}) (time.Time, bool) {
+	switch t := logData[timestampField()].(type) {
+	case string:
+		ts, err := time.Parse(time.RFC3339Nano, t)
+		return ts, err == nil
+	case json.Number:
+		ms, err := t.Float64()
+		return time.UnixMilli(int64(ms)), err == nil
+	case float64:
+		return time.UnixMilli(int64(t)), true
+	}
+	return time.Time{}, false
+}
+
+// tooOld reports whether the event time of a log is older than LOG_MAX_AGE.
+// Logs without a readable time are never too old.
+func tooOld(logData map[string]interface{}) bool {
+	if cfg.LogMaxAge <= 0 {
+		return false
+	}
+	ts, ok := logTime(logData)
+	return ok && time.Since(ts) > cfg.LogMaxAge
+}
+
+// checkLogAge applies LOG_MAX_AGE_ACTION to a log whose resolved event time
+// is older than LOG_MAX_AGE. Such logs usually come from a misbehaving or
+// replaying client and should not land in live indices: they are rejected,
+// left for resolveIndex to route to LOG_COLD_INDEX, or tagged as stale.
+func checkLogAge(logData map[string]interface{}) error {
+	if !tooOld(logData) {
+		return nil
+	}
+	logsTooOld.WithLabelValues(cfg.LogMaxAgeAction).Inc()
+	switch cfg.LogMaxAgeAction {
+	case maxAgeReject:
+		verr := &validationError{}
+		verr.add(timestampField(), "max_age", fmt.Sprintf("log is older than %s", cfg.LogMaxAge))
+		return verr
+	case maxAgeTag:
+		logData[staleField] = true
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTimestampSourcePrecedence(t *testing.T) {
+	const (
+		clientTime = "2020-01-01T00:00:00Z"
+		headerTime = "2021-06-01T12:00:00Z"
+	)
+	for _, tc := range []struct {
+		name    string
+		sources []string
+		client  bool
+		header  bool
+		want    string
+	}{
+		{"client first", []string{"client", "header", "ingest"}, true, true, "client"},
+		{"header when client missing", []string{"client", "header", "ingest"}, false, true, "header"},
+		{"ingest when nothing sent", []string{"client", "header", "ingest"}, false, false, "ingest"},
+		{"header first", []string{"header", "client", "ingest"}, true, true, "header"},
+		{"ingest overwrites client", []string{"ingest"}, true, true, "ingest"},
+		{"no source has a time", []string{"header"}, true, false, "none"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) { c.LogTimestampSources = tc.sources })
+			body := `{"message":"hi"}`
+			if tc.client {
+				body = `{"message":"hi","timestamp":"` + clientTime + `"}`
+			}
+			req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(body))
+			if tc.header {
+				req.Header.Set("X-Log-Timestamp", headerTime)
+			}
+			start := time.Now().Add(-time.Second)
+			if rec := do(req); rec.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			docs := fake.docs(t)
+			if len(docs) != 1 {
+				t.Fatalf("indexed %d logs, want 1", len(docs))
+			}
+			ts, ok := docs[0]["timestamp"].(string)
+			var got string
+			switch {
+			case !ok:
+				got = "none"
+			case ts == clientTime:
+				got = "client"
+			case ts == headerTime:
+				got = "header"
+			default:
+				if parsed, err := time.Parse(time.RFC3339, ts); err == nil && !parsed.Before(start.Truncate(time.Second)) {
+					got = "ingest"
+				}
+			}
+			if got != tc.want {
+				t.Errorf("timestamp %v came from %q, want %s", docs[0]["timestamp"], got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTimestampHeaderMustBeRFC3339(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+	req.Header.Set("X-Log-Timestamp", "yesterday")
+	if rec := do(req); rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+	if len(fake.writes()) != 0 {
+		t.Error("log with a bad timestamp header was indexed")
+	}
+}
+
+func TestTimestampSourcesValidated(t *testing.T) {
+	for _, sources := range [][]string{nil, {"client", "clock"}} {
+		c := defaultConfig()
+		c.LogTimestampSources = sources
+		if err := c.validate(); err == nil {
+			t.Errorf("LOG_TIMESTAMP_SOURCES %q passed validation", sources)
+		}
+	}
+}
+
+func TestLogMaxAge(t *testing.T) {
+	old := time.Now().Add(-90 * 24 * time.Hour).Format(time.RFC3339)
+	recent := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	for _, tc := range []struct {
+		action, ts string
+		want       int
+		wantIndex  string
+		wantStale  bool
+	}{
+		{"reject", old, http.StatusUnprocessableEntity, "", false},
+		{"reject", recent, http.StatusCreated, "logs", false},
+		{"route", old, http.StatusCreated, "logs-cold", false},
+		{"route", recent, http.StatusCreated, "logs", false},
+		{"tag", old, http.StatusCreated, "logs", true},
+	} {
+		t.Run(tc.action+" "+tc.ts, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				c.LogMaxAge = 30 * 24 * time.Hour
+				c.LogMaxAgeAction = tc.action
+				c.LogColdIndex = "logs-cold"
+			})
+			before := testutil.ToFloat64(logsTooOld.WithLabelValues(tc.action))
+
+			rec := postJSON("/logs", `{"message":"replayed","timestamp":"`+tc.ts+`"}`)
+			if rec.Code != tc.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+			wantCount := 0.0
+			if tc.ts == old {
+				wantCount = 1
+			}
+			if got := testutil.ToFloat64(logsTooOld.WithLabelValues(tc.action)) - before; got != wantCount {
+				t.Errorf("logs_too_old_total{action=%s} grew by %v, want %v", tc.action, got, wantCount)
+			}
+			writes := fake.writes()
+			if tc.wantIndex == "" {
+				if len(writes) != 0 {
+					t.Error("rejected log was indexed")
+				}
+				return
+			}
+			if len(writes) != 1 || !strings.HasPrefix(writes[0].Path, "/"+tc.wantIndex+"/") {
+				t.Fatalf("writes %v, want one to %s", writes, tc.wantIndex)
+			}
+			if stale := decodeDoc(t, writes[0].Body)["stale"] == true; stale != tc.wantStale {
+				t.Errorf("stale = %v, want %v", stale, tc.wantStale)
+			}
+		})
+	}
+}
+
+func TestLogMaxAgeEpochMillis(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.LogMaxAge = time.Hour })
+	old := time.Now().Add(-2 * time.Hour).UnixMilli()
+	if rec := postJSON("/logs", fmt.Sprintf(`{"timestamp":%d}`, old)); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want 422", rec.Code)
+	}
+	// Logs without a readable time are never too old
+	if rec := postJSON("/logs", `{"timestamp":"last tuesday"}`); rec.Code != http.StatusCreated {
+		t.Errorf("unreadable time: status %d, want 201", rec.Code)
+	}
+	if n := len(fake.writes()); n != 1 {
+		t.Errorf("%d writes, want 1", n)
+	}
+}
+
+func TestLogColdIndexRequiredToRoute(t *testing.T) {
+	c := defaultConfig()
+	c.LogMaxAge = time.Hour
+	c.LogMaxAgeAction = "route"
+	if err := c.validate(); err == nil {
+		t.Error("route without LOG_COLD_INDEX passed validation")
+	}
+}
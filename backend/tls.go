@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// certReloader serves the certificate from TLS_CERT_FILE and TLS_KEY_FILE,
+// reloading both on SIGHUP so rotated certificates need no restart
+type certReloader struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// watchSIGHUP reloads the certificate on every SIGHUP, keeping the old one
+// when the new files are invalid
+func (c *certReloader) watchSIGHUP() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		if err := c.reload(); err != nil {
+			log.Printf("Keeping current TLS certificate: %v", err)
+			continue
+		}
+		log.Printf("Reloaded TLS certificate from %s", c.certFile)
+	}
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// cipherSuiteIDs resolves cipher suite names such as
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 to their IDs
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// serverTLSConfig builds the listener TLS configuration, or returns nil when
+// no certificate is configured and the server should speak plain HTTP
+func serverTLSConfig(c Config) (*tls.Config, error) {
+	if c.TLSCertFile == "" {
+		return nil, nil
+	}
+	reloader, err := newCertReloader(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.watchSIGHUP()
+
+	suites, err := cipherSuiteIDs(c.TLSCipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{
+		GetCertificate: reloader.getCertificate,
+		MinVersion:     tlsVersions[c.TLSMinVersion],
+		CipherSuites:   suites,
+	}
+	if c.TLSClientCA != "" {
+		pem, err := os.ReadFile(c.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.TLSClientCA)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+type clientIdentityKey struct{}
+
+// clientIdentityFromContext returns the verified client certificate
+// identity of the current request, empty without mTLS
+func clientIdentityFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(clientIdentityKey{}).(string)
+	return id
+}
+
+// clientIdentity names a client certificate by its common name, falling
+// back to the first DNS, email or URI SAN
+func clientIdentity(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
+
+// clientCertMiddleware stores the identity of a verified client certificate
+// in the request context
+func clientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			id := clientIdentity(r.TLS.VerifiedChains[0][0])
+			r = r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveH2C lets srv speak HTTP/2 over cleartext connections, by prior
+// knowledge or an h2c upgrade, so one connection can carry many concurrent
+// requests. HTTP/1.1 requests, WebSocket upgrades included, are unaffected.
+func serveH2C(srv *http.Server) error {
+	h2s := &http2.Server{}
+	// ConfigureServer hooks HTTP/2 connections into srv.Shutdown, but also
+	// sets up a TLSConfig the plain listener must not use
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		return err
+	}
+	srv.TLSConfig = nil
+	srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+	return nil
+}
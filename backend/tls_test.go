@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// writeSelfSignedCert writes a certificate for 127.0.0.1 named cn and its
+// key to dir
+func writeSelfSignedCert(t *testing.T, dir, cn string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	return certFile, keyFile, cert
+}
+
+// serveTLS runs the public handler over TLS configured from cfg and returns
+// its address
+func serveTLS(t *testing.T) string {
+	t.Helper()
+	tc, err := serverTLSConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: newPublicHandler(), TLSConfig: tc}
+	go srv.ServeTLS(ln, "", "")
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// tlsClient trusts ca and uses tc as a base for its TLS settings
+func tlsClient(ca *x509.Certificate, tc *tls.Config) *http.Client {
+	if tc == nil {
+		tc = &tls.Config{}
+	}
+	tc.RootCAs = x509.NewCertPool()
+	tc.RootCAs.AddCert(ca)
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tc}}
+}
+
+func TestServeHTTPS(t *testing.T) {
+	certFile, keyFile, cert := writeSelfSignedCert(t, t.TempDir(), "telyx")
+	withConfig(t, func(c *Config) {
+		c.TLSCertFile = certFile
+		c.TLSKeyFile = keyFile
+		c.TLSMinVersion = "1.3"
+	})
+	addr := serveTLS(t)
+
+	res, err := tlsClient(cert, nil).Get("https://" + addr + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("GET /health over HTTPS: status %d", res.StatusCode)
+	}
+
+	old := tlsClient(cert, &tls.Config{MaxVersion: tls.VersionTLS12})
+	if _, err := old.Get("https://" + addr + "/health"); err == nil {
+		t.Error("TLS 1.2 client accepted with TLS_MIN_VERSION=1.3")
+	}
+}
+
+func TestNoTLSConfigWithoutCert(t *testing.T) {
+	withConfig(t, nil)
+	if tc, err := serverTLSConfig(cfg); tc != nil || err != nil {
+		t.Errorf("serverTLSConfig = %v, %v without a certificate, want plain HTTP", tc, err)
+	}
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, first := writeSelfSignedCert(t, dir, "first")
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := func() string {
+		c, _ := r.getCertificate(nil)
+		leaf, err := x509.ParseCertificate(c.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+	if cn := served(); cn != first.Subject.CommonName {
+		t.Fatalf("serving %s", cn)
+	}
+
+	writeSelfSignedCert(t, dir, "rotated")
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cn := served(); cn != "rotated" {
+		t.Errorf("serving %s after reload, want rotated", cn)
+	}
+
+	os.WriteFile(keyFile, []byte("garbage"), 0600)
+	if err := r.reload(); err == nil {
+		t.Error("invalid key reloaded")
+	}
+	if cn := served(); cn != "rotated" {
+		t.Errorf("serving %s after a failed reload, want the previous certificate", cn)
+	}
+}
+
+func TestCipherSuitesValidated(t *testing.T) {
+	if _, err := cipherSuiteIDs([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := cipherSuiteIDs([]string{"TLS_RSA_WITH_RC4_128_SHA"}); err == nil {
+		t.Error("insecure cipher suite accepted")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	certFile, keyFile, serverCert := writeSelfSignedCert(t, t.TempDir(), "telyx")
+	caFile, caKeyFile, _ := writeSelfSignedCert(t, t.TempDir(), "ingest-agent")
+	rogueFile, rogueKeyFile, _ := writeSelfSignedCert(t, t.TempDir(), "rogue")
+	withConfig(t, func(c *Config) {
+		c.TLSCertFile = certFile
+		c.TLSKeyFile = keyFile
+		c.TLSClientCA = caFile
+		c.SlowRequestThreshold = time.Nanosecond
+	})
+	logs := captureLog(t)
+	addr := serveTLS(t)
+
+	get := func(certs ...tls.Certificate) error {
+		res, err := tlsClient(serverCert, &tls.Config{Certificates: certs}).Get("https://" + addr + "/health")
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		return nil
+	}
+	valid, err := tls.LoadX509KeyPair(caFile, caKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(valid); err != nil {
+		t.Fatalf("client with a trusted certificate rejected: %v", err)
+	}
+	if !strings.Contains(logs.String(), "client=ingest-agent") {
+		t.Errorf("client identity not in the request context: %s", logs)
+	}
+
+	rogue, err := tls.LoadX509KeyPair(rogueFile, rogueKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(rogue); err == nil {
+		t.Error("client with an untrusted certificate accepted")
+	}
+	if err := get(); err == nil {
+		t.Error("client without a certificate accepted")
+	}
+}
+
+func TestClientCANeedsServerCert(t *testing.T) {
+	c := defaultConfig()
+	c.TLSClientCA = "ca.pem"
+	if err := c.validate(); err == nil {
+		t.Error("TLS_CLIENT_CA without a server certificate passed validation")
+	}
+}
+
+// serveH2CPublic serves the public handler with h2c enabled and counts the
+// connections it accepts
+func serveH2CPublic(t *testing.T) (url string, conns *atomic.Int32) {
+	t.Helper()
+	conns = &atomic.Int32{}
+	srv := &http.Server{
+		Handler: newPublicHandler(),
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				conns.Add(1)
+			}
+		},
+	}
+	if err := serveH2C(srv); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return "http://" + ln.Addr().String(), conns
+}
+
+func TestH2CMultiplexedPosts(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.EnableH2C = true })
+	url, conns := serveH2CPublic(t)
+	// Prior knowledge: HTTP/2 frames straight over TCP
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := client.Post(url+"/logs", "application/json", strings.NewReader(fmt.Sprintf(`{"n":%d}`, i)))
+			if err != nil {
+				errs <- err
+				return
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusCreated || res.ProtoMajor != 2 {
+				errs <- fmt.Errorf("log %d: status %d over %s", i, res.StatusCode, res.Proto)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if got := conns.Load(); got != 1 {
+		t.Errorf("%d connections, want all requests on 1", got)
+	}
+	if got := len(fake.docs(t)); got != n {
+		t.Errorf("%d logs indexed, want %d", got, n)
+	}
+}
+
+func TestH2CKeepsHTTP1(t *testing.T) {
+	withOpenSearch(t, func(c *Config) { c.EnableH2C = true })
+	url, _ := serveH2CPublic(t)
+	res, err := http.Post(url+"/logs", "application/json", strings.NewReader(`{"message":"hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusCreated || res.ProtoMajor != 1 {
+		t.Errorf("status %d over %s, want 201 over HTTP/1.1", res.StatusCode, res.Proto)
+	}
+}
+
+func TestH2CWithTLSRejected(t *testing.T) {
+	c := defaultConfig()
+	c.EnableH2C = true
+	c.TLSCertFile, c.TLSKeyFile = "cert.pem", "key.pem"
+	if err := c.validate(); err == nil {
+		t.Error("ENABLE_H2C with TLS passed validation")
+	}
+}
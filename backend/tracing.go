@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+type forceTraceKey struct{}
+
+// forceTraceSampler samples every span started under a request that asked
+// for a forced trace and defers to the wrapped sampler otherwise
+type forceTraceSampler struct {
+	base trace.Sampler
+}
+
+func (s forceTraceSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	if forced, _ := p.ParentContext.Value(forceTraceKey{}).(bool); forced {
+		return trace.SamplingResult{
+			Decision:   trace.RecordAndSample,
+			Tracestate: oteltrace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+	return s.base.ShouldSample(p)
+}
+
+func (s forceTraceSampler) Description() string {
+	return "ForceTrace{" + s.base.Description() + "}"
+}
+
+// forceTraceMiddleware honours X-Force-Trace (or ?force_trace=true) when
+// TRACE_ALLOW_FORCE is enabled, so a single request can be traced
+// regardless of the sampling ratio
+func forceTraceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.TraceAllowForce && wantsForcedTrace(r) {
+			r = r.WithContext(context.WithValue(r.Context(), forceTraceKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func wantsForcedTrace(r *http.Request) bool {
+	v := r.Header.Get("X-Force-Trace")
+	if v == "" {
+		v = r.URL.Query().Get("force_trace")
+	}
+	forced, _ := strconv.ParseBool(v)
+	return forced
+}
+
+// startServerSpan starts a span covering the whole request so its sampling
+// decision is known before the handler writes the response, and reports it
+// in X-Trace-Sampled, with the trace ID in X-Trace-Id when sampled. Handler
+// spans become its children and share the decision.
+func startServerSpan(w http.ResponseWriter, r *http.Request, route string) (*http.Request, oteltrace.Span) {
+	ctx, span := otel.Tracer("telyx-backend").Start(r.Context(), r.Method+" "+route,
+		oteltrace.WithSpanKind(oteltrace.SpanKindServer))
+	sc := span.SpanContext()
+	w.Header().Set("X-Trace-Sampled", strconv.FormatBool(sc.IsSampled()))
+	if sc.IsSampled() {
+		w.Header().Set("X-Trace-Id", sc.TraceID().String())
+	}
+	return r.WithContext(ctx), span
+}
+
+// ingestSpanName names the span of a single-log ingest after the
+// TRACE_SPAN_NAME_FIELD of the log, such as "ingest checkout.completed", so
+// spans can be told apart in the trace UI. Values missing from
+// TRACE_SPAN_NAME_VALUES fall back to the route, since span names must stay
+// few for trace backends to index them.
+func ingestSpanName(r *http.Request, logData map[string]interface{}) string {
+	if cfg.TraceSpanNameField == "" {
+		return "logHandler"
+	}
+	if parent, key, ok := fieldParent(logData, cfg.TraceSpanNameField); ok {
+		if v, ok := parent[key].(string); ok && slices.Contains(cfg.TraceSpanNameValues, v) {
+			return "ingest " + v
+		}
+	}
+	return "ingest " + routeLabel(r)
+}
+
+// injectTraceContext stamps the ingesting request's trace and span IDs onto
+// the log so it can be joined with its trace. Logs ingested outside a
+// recording span, or already carrying a trace_id, are left untouched.
+func injectTraceContext(ctx context.Context, logData map[string]interface{}) {
+	if !cfg.LogInjectTraceID {
+		return
+	}
+	span := oteltrace.SpanFromContext(ctx)
+	sc := span.SpanContext()
+	if !span.IsRecording() || !sc.IsValid() {
+		return
+	}
+	if _, exists := logData["trace_id"]; exists {
+		return
+	}
+	logData["trace_id"] = sc.TraceID().String()
+	logData["span_id"] = sc.SpanID().String()
+}
+
+// propagationMiddleware extracts the W3C trace context and baggage sent by
+// the caller so spans join the caller's trace and baggage reaches the logs
+func propagationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// injectBaggage copies the allowlisted baggage members of the request onto
+// the log, without overwriting fields the client set itself
+func injectBaggage(ctx context.Context, logData map[string]interface{}) {
+	if len(cfg.LogBaggageKeys) == 0 {
+		return
+	}
+	bag := baggage.FromContext(ctx)
+	for _, key := range cfg.LogBaggageKeys {
+		member := bag.Member(key)
+		if member.Key() == "" {
+			continue
+		}
+		if _, exists := logData[key]; !exists {
+			logData[key] = member.Value()
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// usePropagator installs the propagator main sets up until the test ends
+func usePropagator(t *testing.T) {
+	t.Helper()
+	saved := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	t.Cleanup(func() { otel.SetTextMapPropagator(saved) })
+}
+
+// neverSampled records spans with the production sampler at ratio 0
+func neverSampled(t *testing.T) func() int {
+	t.Helper()
+	rec := recordSpans(t, sdktrace.WithSampler(forceTraceSampler{base: sdktrace.ParentBased(sdktrace.TraceIDRatioBased(0))}))
+	return func() int { return len(rec.Ended()) }
+}
+
+func TestForcedTraceSampledAtRatioZero(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		allowForce  bool
+		header      string
+		query       string
+		wantSampled bool
+	}{
+		{"header", true, "true", "", true},
+		{"query parameter", true, "", "?force_trace=true", true},
+		{"not asked", true, "", "", false},
+		{"not allowed", false, "true", "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withOpenSearch(t, func(c *Config) { c.TraceAllowForce = tc.allowForce })
+			ended := neverSampled(t)
+
+			req := httptest.NewRequest(http.MethodPost, "/logs"+tc.query, strings.NewReader(`{"message":"hi"}`))
+			if tc.header != "" {
+				req.Header.Set("X-Force-Trace", tc.header)
+			}
+			rec := do(req)
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if sampled := ended() > 0; sampled != tc.wantSampled {
+				t.Errorf("sampled = %v, want %v", sampled, tc.wantSampled)
+			}
+		})
+	}
+}
+
+func TestTraceIDInjected(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	for _, tc := range []struct {
+		name      string
+		inject    bool
+		recording bool
+	}{
+		{"injected", true, true},
+		{"disabled", false, true},
+		{"no recording span", true, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) { c.LogInjectTraceID = tc.inject })
+			usePropagator(t)
+			var rec *tracetest.SpanRecorder
+			if tc.recording {
+				rec = recordSpans(t)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+			req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+			if res := do(req); res.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", res.Code, res.Body)
+			}
+			docs := fake.docs(t)
+			if len(docs) != 1 {
+				t.Fatalf("indexed %d logs, want 1", len(docs))
+			}
+			if !tc.inject || !tc.recording {
+				if _, ok := docs[0]["trace_id"]; ok {
+					t.Errorf("trace_id injected: %v", docs[0])
+				}
+				return
+			}
+			if docs[0]["trace_id"] != traceID {
+				t.Errorf("trace_id = %v, want %s", docs[0]["trace_id"], traceID)
+			}
+			var spanIDs []string
+			for _, s := range rec.Ended() {
+				spanIDs = append(spanIDs, s.SpanContext().SpanID().String())
+			}
+			if !slices.Contains(spanIDs, docs[0]["span_id"].(string)) {
+				t.Errorf("span_id %v is not one of the request spans %v", docs[0]["span_id"], spanIDs)
+			}
+		})
+	}
+}
+
+func TestBaggageInjected(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.LogBaggageKeys = []string{"user_id", "region"} })
+	usePropagator(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi","region":"client-set"}`))
+	req.Header.Set("baggage", "user_id=u-42,region=eu-west-1,session_token=secret")
+	if rec := do(req); rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	docs := fake.docs(t)
+	if len(docs) != 1 {
+		t.Fatalf("indexed %d logs, want 1", len(docs))
+	}
+	if docs[0]["user_id"] != "u-42" {
+		t.Errorf("user_id = %v, want u-42", docs[0]["user_id"])
+	}
+	if docs[0]["region"] != "client-set" {
+		t.Errorf("baggage overwrote the client's region: %v", docs[0]["region"])
+	}
+	if _, ok := docs[0]["session_token"]; ok {
+		t.Error("baggage key outside the allowlist was injected")
+	}
+}
+
+func TestTraceResponseHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		enabled     bool
+		ratio       float64
+		wantSampled string
+	}{
+		{"sampled", true, 1, "true"},
+		{"not sampled", true, 0, "false"},
+		{"disabled", false, 1, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withOpenSearch(t, func(c *Config) {
+				c.TraceResponseHeaders = tc.enabled
+				c.CORSAllowedOrigins = []string{"https://dashboard.example"}
+			})
+			rec := recordSpans(t, sdktrace.WithSampler(sdktrace.TraceIDRatioBased(tc.ratio)))
+
+			req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(`{"message":"hi"}`))
+			req.Header.Set("Origin", "https://dashboard.example")
+			res := do(req)
+			if res.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", res.Code, res.Body)
+			}
+			if got := res.Header().Get("X-Trace-Sampled"); got != tc.wantSampled {
+				t.Errorf("X-Trace-Sampled = %q, want %q", got, tc.wantSampled)
+			}
+			traceID := res.Header().Get("X-Trace-Id")
+			if tc.wantSampled != "true" {
+				if traceID != "" {
+					t.Errorf("X-Trace-Id = %q on an unsampled request", traceID)
+				}
+			} else if span := endedSpan(t, rec, "POST /logs"); traceID != span.SpanContext().TraceID().String() {
+				t.Errorf("X-Trace-Id = %q, want the server span's %s", traceID, span.SpanContext().TraceID())
+			}
+			exposed := strings.Contains(res.Header().Get("Access-Control-Expose-Headers"), "X-Trace-Id")
+			if exposed != tc.enabled {
+				t.Errorf("X-Trace-Id exposed to CORS = %v, want %v", exposed, tc.enabled)
+			}
+		})
+	}
+}
+
+func TestIngestSpanNamedAfterField(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		field string
+		path  string
+		body  string
+		want  string
+	}{
+		{"allowlisted value", "event_type", "/logs", `{"event_type":"checkout.completed"}`, "ingest checkout.completed"},
+		{"nested field", "event.type", "/logs", `{"event":{"type":"login"}}`, "ingest login"},
+		{"value not allowlisted", "event_type", "/logs", `{"event_type":"user-4711"}`, "ingest /logs"},
+		{"field missing", "event_type", "/logs/acme", `{"message":"hi"}`, "ingest /logs/{tenant}"},
+		{"not a string", "event_type", "/logs", `{"event_type":42}`, "ingest /logs"},
+		{"disabled", "", "/logs", `{"event_type":"login"}`, "logHandler"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withOpenSearch(t, func(c *Config) {
+				c.TraceSpanNameField = tc.field
+				if tc.field != "" {
+					c.TraceSpanNameValues = []string{"checkout.completed", "login"}
+				}
+			})
+			rec := recordSpans(t)
+			if res := postJSON(tc.path, tc.body); res.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", res.Code, res.Body)
+			}
+			endedSpan(t, rec, tc.want)
+		})
+	}
+}
+
+func TestSpanNameValuesRequired(t *testing.T) {
+	c := defaultConfig()
+	c.TraceSpanNameField = "event_type"
+	if err := c.validate(); err == nil {
+		t.Error("TRACE_SPAN_NAME_FIELD without an allowlist passed validation")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var errUploadTooLarge = errors.New("upload too large")
+
+// uploadLimitReader fails once more than n bytes were read from r, bounding
+// the decompressed size of an upload the way MaxBytesReader bounds the body
+type uploadLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *uploadLimitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, errUploadTooLarge
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errUploadTooLarge
+	}
+	return n, err
+}
+
+// uploadProgress is one line of the /logs/upload response, written after
+// each batch. Totals are cumulative, errors only cover the batch.
+type uploadProgress struct {
+	Lines    int             `json:"lines"`
+	Indexed  int             `json:"indexed"`
+	Filtered int             `json:"filtered"`
+	Failed   int             `json:"failed"`
+	Errors   []bulkItemError `json:"errors,omitempty"`
+	Done     bool            `json:"done,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// uploadFile returns the reader of the "file" part of a multipart upload,
+// transparently decompressing gzip content
+func uploadFile(r *http.Request) (io.Reader, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	var part *multipart.Part
+	for {
+		part, err = mr.NextPart()
+		if err != nil {
+			return nil, fmt.Errorf("no file part: %w", err)
+		}
+		if part.FormName() == "file" {
+			break
+		}
+	}
+	br := bufio.NewReader(part)
+	// Sniff the gzip magic number rather than trusting the file name
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return gz, nil
+	}
+	return br, nil
+}
+
+// uploadHandler backfills logs from a multipart NDJSON file, optionally
+// gzip-compressed. The file is streamed through the bulk pipeline in batches
+// of UPLOAD_BATCH_SIZE, reporting progress as one NDJSON line per batch, so
+// uploads far larger than MAX_BODY_BYTES never sit in memory. Logs OpenSearch
+// rejects go to the dead-letter file. Uploads are indexed synchronously even
+// with INGEST_ASYNC, which paces them to what OpenSearch absorbs.
+func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("telyx-backend").Start(r.Context(), "uploadHandler")
+	defer span.End()
+
+	defer r.Body.Close()
+	limit := bodyLimit(r, cfg.UploadMaxBytes)
+	if limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	ctx, ok := withRefresh(ctx, r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "X-Refresh must be false, wait_for or true")
+		return
+	}
+	ctx, ok = withLogTimestamp(ctx, r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, cfg.LogTimestampHeader+" must be an RFC 3339 date")
+		return
+	}
+	file, err := uploadFile(r)
+	if bodyTooLarge(err) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		writeJSONError(w, http.StatusBadRequest, "Expected a multipart upload with a file part")
+		return
+	}
+
+	// A single log may not exceed what POST /logs would accept
+	maxLine := int(cfg.UploadMaxBytes)
+	if cfg.MaxBodyBytes > 0 && cfg.MaxBodyBytes < cfg.UploadMaxBytes {
+		maxLine = int(cfg.MaxBodyBytes)
+	}
+	sc := bufio.NewScanner(&uploadLimitReader{r: file, n: cfg.UploadMaxBytes})
+	sc.Buffer(make([]byte, 0, 64*1024), maxLine)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	rc := http.NewResponseController(w)
+	var progress uploadProgress
+	docs := make([]bulkDoc, 0, cfg.UploadBatchSize)
+	var res bulkResult
+	pending := 0
+
+	flush := func() {
+		bulkIndex(ctx, docs, &res)
+		deadLetters.write(res.rejected)
+		progress.Indexed += res.Indexed
+		progress.Filtered += res.Filtered
+		progress.Failed += res.Failed
+		progress.Errors = res.Errors
+		enc.Encode(progress)
+		rc.Flush()
+		docs, res, pending = docs[:0], bulkResult{}, 0
+	}
+
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		pos := progress.Lines
+		progress.Lines++
+		if len(line) == 0 {
+			continue
+		}
+		pending++
+		var logData map[string]interface{}
+		err := newLogDecoder(limitDepth(bytes.NewReader(line))).Decode(&logData)
+		if errors.Is(err, errTooDeep) {
+			recordDrop(dropValidation, 1)
+			res.fail(pos, err.Error())
+		} else if err != nil || logData == nil {
+			recordDrop(dropInvalid, 1)
+			res.fail(pos, "Invalid log format")
+		} else if meta, err := takeDocMeta(logData); err != nil {
+			recordDrop(dropValidation, 1)
+			errorCapture.captureItem(r, pos, err.Error(), logData)
+			res.fail(pos, err.Error())
+		} else if keep, err := prepareLog(ctx, logData); err != nil {
+			errorCapture.captureItem(r, pos, err.Error(), logData)
+			res.fail(pos, err.Error())
+		} else if !keep {
+			res.Filtered++
+		} else {
+			docs = append(docs, bulkDoc{pos: pos, source: logData, meta: meta})
+		}
+		if pending >= cfg.UploadBatchSize {
+			flush()
+		}
+	}
+	if pending > 0 {
+		flush()
+	}
+
+	progress.Errors = nil
+	err = sc.Err()
+	switch {
+	case bodyTooLarge(err):
+		progress.Error = fmt.Sprintf("Upload exceeds %d bytes", limit)
+	case errors.Is(err, errUploadTooLarge):
+		progress.Error = fmt.Sprintf("Upload exceeds %d bytes", cfg.UploadMaxBytes)
+	case errors.Is(err, bufio.ErrTooLong):
+		progress.Error = fmt.Sprintf("Log at index %d exceeds %d bytes", progress.Lines, maxLine)
+	case err != nil:
+		progress.Error = "Failed to read upload"
+	default:
+		progress.Done = true
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.SetAttributes(
+		attribute.Int("upload.lines", progress.Lines),
+		attribute.Int("upload.indexed", progress.Indexed),
+		attribute.Int("upload.failed", progress.Failed),
+	)
+	enc.Encode(progress)
+}
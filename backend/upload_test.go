@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gzipNDJSON compresses n logs, one per line, numbered from 0
+func gzipNDJSON(t *testing.T, n int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(gz, `{"n":%d,"message":"backfill"}`+"\n", i)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// postUpload sends file as the file part of a multipart upload and returns
+// the progress lines of the response
+func postUpload(t *testing.T, file []byte) (*httptest.ResponseRecorder, []uploadProgress) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "logs.ndjson.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(file)
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, "/logs/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := do(req)
+
+	var progress []uploadProgress
+	sc := bufio.NewScanner(strings.NewReader(rec.Body.String()))
+	for sc.Scan() {
+		var p uploadProgress
+		if err := json.Unmarshal(sc.Bytes(), &p); err != nil {
+			t.Fatalf("decode %s: %v", sc.Bytes(), err)
+		}
+		progress = append(progress, p)
+	}
+	return rec, progress
+}
+
+func TestUploadGzippedNDJSON(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.UploadBatchSize = 2 })
+	rec, progress := postUpload(t, gzipNDJSON(t, 5))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	// Three batches, then the summary
+	if len(progress) != 4 {
+		t.Fatalf("%d progress lines, want 4: %s", len(progress), rec.Body)
+	}
+	if last := progress[3]; !last.Done || last.Lines != 5 || last.Indexed != 5 || last.Failed != 0 {
+		t.Errorf("summary %+v, want all 5 indexed", last)
+	}
+	if got := len(fake.writes()); got != 3 {
+		t.Errorf("%d bulk calls, want 3", got)
+	}
+	seen := map[float64]bool{}
+	for _, doc := range fake.docs(t) {
+		seen[doc["n"].(float64)] = true
+	}
+	if len(seen) != 5 {
+		t.Errorf("indexed logs %v, want all 5", seen)
+	}
+}
+
+func TestUploadPlainNDJSON(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	_, progress := postUpload(t, []byte("{\"n\":1}\n\n{\"n\":2}\n"))
+	if last := progress[len(progress)-1]; !last.Done || last.Indexed != 2 {
+		t.Errorf("summary %+v, want 2 indexed", last)
+	}
+	if got := len(fake.docs(t)); got != 2 {
+		t.Errorf("%d logs indexed, want 2", got)
+	}
+}
+
+func TestUploadPartialFailureDeadLettered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.ndjson")
+	fake := withOpenSearch(t, func(c *Config) { c.DeadLetterPath = path })
+	fake.setRespond(rejectBulkItems(func(doc map[string]interface{}) bool { return doc["n"] == 3.0 }))
+
+	_, progress := postUpload(t, []byte("{\"n\":1}\nnot json\n{\"n\":3}\n"))
+	last := progress[len(progress)-1]
+	if !last.Done || last.Indexed != 1 || last.Failed != 2 {
+		t.Errorf("summary %+v, want 1 indexed and 2 failed", last)
+	}
+	entries := readDeadLetters(t, path)
+	if len(entries) != 1 || entries[0].Log["n"] != 3.0 {
+		t.Errorf("dead letters %+v, want the rejected log", entries)
+	}
+}
+
+func TestUploadSizeCapped(t *testing.T) {
+	// Compressed, the file fits; decompressed, it does not
+	withOpenSearch(t, func(c *Config) { c.UploadMaxBytes = 200 })
+	_, progress := postUpload(t, gzipNDJSON(t, 50))
+	last := progress[len(progress)-1]
+	if last.Done || !strings.Contains(last.Error, "exceeds 200 bytes") {
+		t.Errorf("summary %+v, want the size error", last)
+	}
+}
+
+func TestUploadWithoutFile(t *testing.T) {
+	withOpenSearch(t, nil)
+	rec := postJSON("/logs/upload", `{"n":1}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// violation describes one way a log breaks the validation rules
+type violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// validationError reports the violations found in a log
+type validationError struct {
+	Violations []violation
+}
+
+func (e *validationError) add(field, rule, msg string) {
+	e.Violations = append(e.Violations, violation{Field: field, Rule: rule, Message: msg})
+}
+
+func (e *validationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Message
+		if v.Field != "" {
+			msgs[i] = v.Field + ": " + v.Message
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type pathTenantKey struct{}
+
+// withPathTenant marks that the tenant field of the log was stamped from the
+// /logs/{tenant} path rather than sent by the client
+func withPathTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, pathTenantKey{}, true)
+}
+
+// clientFieldCount counts the top-level fields the client sent, leaving out
+// a tenant stamped from the path so /logs and /logs/{tenant} judge the same
+// body alike
+func clientFieldCount(ctx context.Context, doc map[string]interface{}) int {
+	if stamped, _ := ctx.Value(pathTenantKey{}).(bool); stamped {
+		return len(doc) - 1
+	}
+	return len(doc)
+}
+
+// validateDocument checks an incoming document before any enrichment is
+// applied, running every rule so all violations are reported together
+func validateDocument(ctx context.Context, doc map[string]interface{}) error {
+	verr := &validationError{}
+	if clientFieldCount(ctx, doc) < cfg.LogMinFields {
+		verr.add("", "min_fields", fmt.Sprintf("log must contain at least %d field(s)", cfg.LogMinFields))
+	}
+	if err := validateSchema(doc); err != nil {
+		var schemaErr *validationError
+		if !errors.As(err, &schemaErr) {
+			return err
+		}
+		verr.Violations = append(verr.Violations, schemaErr.Violations...)
+	}
+	validateRequiredFields(doc, verr)
+	if err := validateDataStreamTimestamp(doc); err != nil {
+		verr.add("@timestamp", "timestamp", err.Error())
+	}
+	if err := enforceFieldLimit(doc); err != nil {
+		verr.add("", "max_fields", err.Error())
+	}
+	if len(verr.Violations) == 0 {
+		return nil
+	}
+	return verr
+}
+
+// validateRequiredFields reports the LOG_TYPE_REQUIRED_FIELDS of the log's
+// type that are missing or null. Logs without a type, or of a type with no
+// rules, have nothing required.
+func validateRequiredFields(doc map[string]interface{}, verr *validationError) {
+	if len(cfg.TypeRequiredFields) == 0 {
+		return
+	}
+	logType, ok := doc[cfg.IndexTypeField].(string)
+	if !ok {
+		return
+	}
+	for _, field := range cfg.TypeRequiredFields[logType] {
+		if parent, key, ok := fieldParent(doc, field); ok && parent[key] != nil {
+			continue
+		}
+		verr.add(field, "required", fmt.Sprintf("field is required for %s logs", logType))
+	}
+}
+
+// writeValidationError answers 422, listing every violation when known
+func writeValidationError(w http.ResponseWriter, err error) {
+	var verr *validationError
+	if !errors.As(err, &verr) {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      "Log failed validation",
+		"violations": verr.Violations,
+	})
+}
+
+// writeJSONError writes an error response with a JSON body
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+var logsFieldLimitExceeded = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "logs_field_limit_exceeded_total",
+		Help: "Total number of logs exceeding the maximum field count",
+	},
+	[]string{"action"},
+)
+
+// countFields counts the fields of a document, including nested objects
+func countFields(v interface{}) int {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		n := len(t)
+		for _, child := range t {
+			n += countFields(child)
+		}
+		return n
+	case []interface{}:
+		n := 0
+		for _, child := range t {
+			n += countFields(child)
+		}
+		return n
+	}
+	return 0
+}
+
+// truncateFields keeps at most budget fields, visiting keys in sorted order so
+// the result is deterministic, and returns the remaining budget. Objects
+// inside arrays draw on the same budget, as countFields counts them.
+func truncateFields(doc map[string]interface{}, budget int) int {
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if budget <= 0 {
+			delete(doc, k)
+			continue
+		}
+		budget = truncateValue(doc[k], budget-1)
+	}
+	return budget
+}
+
+// truncateValue applies the remaining budget to the objects nested in v
+func truncateValue(v interface{}, budget int) int {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return truncateFields(t, budget)
+	case []interface{}:
+		for _, child := range t {
+			budget = truncateValue(child, budget)
+		}
+	}
+	return budget
+}
+
+// enforceFieldLimit applies LOG_MAX_FIELDS to the document, either rejecting
+// it or dropping the fields beyond the limit
+func enforceFieldLimit(doc map[string]interface{}) error {
+	if cfg.LogMaxFields <= 0 {
+		return nil
+	}
+	n := countFields(doc)
+	if n <= cfg.LogMaxFields {
+		return nil
+	}
+	logsFieldLimitExceeded.WithLabelValues(cfg.LogFieldLimitAction).Inc()
+	if cfg.LogFieldLimitAction == "truncate" {
+		truncateFields(doc, cfg.LogMaxFields)
+		return nil
+	}
+	return fmt.Errorf("log has %d fields, exceeding the limit of %d", n, cfg.LogMaxFields)
+}
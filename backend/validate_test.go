@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEmptyLogPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		minFields int
+		path      string
+		body      string
+		want      int
+	}{
+		{"allowed by default", 0, "/logs", `{}`, http.StatusCreated},
+		{"rejected", 1, "/logs", `{}`, http.StatusUnprocessableEntity},
+		{"path tenant is not a field", 1, "/logs/acme", `{}`, http.StatusUnprocessableEntity},
+		{"one field is enough", 1, "/logs", `{"message":"hi"}`, http.StatusCreated},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) { c.LogMinFields = tc.minFields })
+			rec := postJSON(tc.path, tc.body)
+			if rec.Code != tc.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+			if indexed := len(fake.writes()) > 0; indexed != (tc.want == http.StatusCreated) {
+				t.Errorf("indexed = %v", indexed)
+			}
+		})
+	}
+}
+
+func TestFieldLimitPolicy(t *testing.T) {
+	const body = `{"a":1,"b":2,"c":3,"d":4,"e":5}`
+	for _, tc := range []struct {
+		action string
+		want   int
+	}{
+		{"reject", http.StatusUnprocessableEntity},
+		{"truncate", http.StatusCreated},
+	} {
+		t.Run(tc.action, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				c.LogMaxFields = 3
+				c.LogFieldLimitAction = tc.action
+			})
+			exceeded := testutil.ToFloat64(logsFieldLimitExceeded.WithLabelValues(tc.action))
+
+			rec := postJSON("/logs", body)
+			if rec.Code != tc.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+			if got := testutil.ToFloat64(logsFieldLimitExceeded.WithLabelValues(tc.action)) - exceeded; got != 1 {
+				t.Errorf("field limit counter grew by %v, want 1", got)
+			}
+			docs := fake.docs(t)
+			if tc.action == "reject" {
+				if len(docs) != 0 {
+					t.Errorf("rejected log was indexed: %v", docs)
+				}
+				return
+			}
+			if len(docs) != 1 {
+				t.Fatalf("indexed %d logs, want 1", len(docs))
+			}
+			for _, k := range []string{"a", "b", "c"} {
+				if _, ok := docs[0][k]; !ok {
+					t.Errorf("kept field %q is missing", k)
+				}
+			}
+			for _, k := range []string{"d", "e"} {
+				if _, ok := docs[0][k]; ok {
+					t.Errorf("field %q beyond the limit was kept", k)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncateFieldsInsideArrays(t *testing.T) {
+	doc := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"x": 1, "y": 2},
+			map[string]interface{}{"z": 3},
+		},
+	}
+	truncateFields(doc, 3)
+	if n := countFields(doc); n != 3 {
+		t.Errorf("%d fields left, want 3: %v", n, doc)
+	}
+	items := doc["items"].([]interface{})
+	if len(items[1].(map[string]interface{})) != 0 {
+		t.Errorf("object past the budget kept fields: %v", items[1])
+	}
+}
+
+func TestValidationReportsEveryViolation(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.OpenSearchUseDataStream = true
+		c.LogMaxFields = 2
+		c.TypeRequiredFields = map[string][]string{"nginx": {"http.status"}}
+	})
+	rec := postJSON("/logs", `{"log_type":"nginx","@timestamp":"yesterday","message":"GET /"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422: %s", rec.Code, rec.Body)
+	}
+	var res struct {
+		Violations []violation `json:"violations"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	rules := make([]string, len(res.Violations))
+	for i, v := range res.Violations {
+		rules[i] = v.Field + " " + v.Rule
+	}
+	slices.Sort(rules)
+	if want := []string{" max_fields", "@timestamp timestamp", "http.status required"}; !slices.Equal(rules, want) {
+		t.Errorf("violations %q, want %q: %s", rules, want, rec.Body)
+	}
+	if len(fake.writes()) != 0 {
+		t.Error("invalid log was indexed")
+	}
+}
+
+func TestUnparseableLogNotValidated(t *testing.T) {
+	withOpenSearch(t, func(c *Config) { c.LogMinFields = 1 })
+	rec := postJSON("/logs", `{"message":`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400: %s", rec.Code, rec.Body)
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res["violations"]; ok {
+		t.Errorf("unparseable log was run through the rules: %s", rec.Body)
+	}
+}
+
+func TestTypeRequiredFields(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		body    string
+		missing []string
+	}{
+		{"audit missing actor", `{"log_type":"audit","action":"delete"}`, []string{"actor"}},
+		{"audit with null actor", `{"log_type":"audit","actor":null,"action":"delete"}`, []string{"actor"}},
+		{"complete audit", `{"log_type":"audit","actor":"ann","action":"delete"}`, nil},
+		{"access missing both", `{"log_type":"access","path":"/"}`, []string{"http.method", "http.status"}},
+		{"complete access", `{"log_type":"access","http":{"method":"GET","status":200}}`, nil},
+		{"type without rules", `{"log_type":"debug"}`, nil},
+		{"no type", `{"message":"hi"}`, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, func(c *Config) {
+				c.TypeRequiredFields = map[string][]string{
+					"audit":  {"actor", "action"},
+					"access": {"http.method", "http.status"},
+				}
+			})
+			rec := postJSON("/logs", tc.body)
+			if tc.missing == nil {
+				if rec.Code != http.StatusCreated {
+					t.Errorf("status %d, want 201: %s", rec.Code, rec.Body)
+				}
+				return
+			}
+			if rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status %d, want 422: %s", rec.Code, rec.Body)
+			}
+			var res struct {
+				Violations []violation `json:"violations"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			var missing []string
+			for _, v := range res.Violations {
+				if v.Rule == "required" {
+					missing = append(missing, v.Field)
+				}
+			}
+			slices.Sort(missing)
+			if !slices.Equal(missing, tc.missing) {
+				t.Errorf("missing fields %q, want %q", missing, tc.missing)
+			}
+			if len(fake.writes()) != 0 {
+				t.Error("incomplete log was indexed")
+			}
+		})
+	}
+}
+
+func TestTypeRequiredFieldsInBulk(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.TypeRequiredFields = map[string][]string{"audit": {"actor"}}
+	})
+	rec := postJSON("/logs/bulk", `[{"log_type":"audit","actor":"ann"},{"log_type":"audit"}]`)
+	res := decodeBulkResult(t, rec.Body.String())
+	if res.Indexed != 1 || res.Failed != 1 || len(res.Errors) != 1 || res.Errors[0].Index != 1 {
+		t.Errorf("result %+v, want the second log rejected", res)
+	}
+	if n := len(fake.docs(t)); n != 1 {
+		t.Errorf("indexed %d logs, want 1", n)
+	}
+}
+
+func TestTypeRequiredFieldsValidated(t *testing.T) {
+	c := defaultConfig()
+	c.TypeRequiredFields = map[string][]string{"audit": {"actor", ""}}
+	if err := c.validate(); err == nil {
+		t.Error("empty required field name passed validation")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+// Reserved log fields carrying OpenSearch document metadata. They are taken
+// out of the log before validation and never stored.
+const (
+	metaIDField          = "_id"
+	metaVersionField     = "_version"
+	metaVersionTypeField = "_version_type"
+)
+
+// Version types accepted in _version_type; internal versioning is what
+// OpenSearch does when no version is given
+var versionTypes = map[string]bool{"external": true, "external_gte": true}
+
+// docMeta is the OpenSearch metadata a client sent along with a log
+type docMeta struct {
+	ID          string `json:"id,omitempty"`
+	Version     int64  `json:"version,omitempty"`
+	VersionType string `json:"version_type,omitempty"`
+}
+
+// action returns the _bulk action line metadata for a document with m
+func (m docMeta) action(index string) map[string]interface{} {
+	action := map[string]interface{}{"_index": index}
+	if m.ID != "" {
+		action["_id"] = m.ID
+	}
+	if m.Version > 0 {
+		action["version"] = m.Version
+		action["version_type"] = m.VersionType
+	}
+	return action
+}
+
+// params returns the query parameters for indexing a document with m
+func (m docMeta) params() url.Values {
+	params := url.Values{}
+	if m.Version > 0 {
+		params.Set("version", strconv.FormatInt(m.Version, 10))
+		params.Set("version_type", m.VersionType)
+	}
+	return params
+}
+
+// takeDocMeta removes the metadata fields from a log and validates them.
+// With _version the client owns the document version, so a write older than
+// the stored document fails with a conflict instead of overwriting it.
+func takeDocMeta(logData map[string]interface{}) (docMeta, error) {
+	var m docMeta
+	id, hasID := logData[metaIDField]
+	version, hasVersion := logData[metaVersionField]
+	versionType, hasType := logData[metaVersionTypeField]
+	delete(logData, metaIDField)
+	delete(logData, metaVersionField)
+	delete(logData, metaVersionTypeField)
+
+	verr := &validationError{}
+	if hasID {
+		if s, ok := id.(string); ok && s != "" {
+			m.ID = s
+		} else {
+			verr.add(metaIDField, "id", "_id must be a non-empty string")
+		}
+	}
+	if hasVersion {
+		if v, ok := positiveInt(version); ok {
+			m.Version = v
+		} else {
+			verr.add(metaVersionField, "version", "_version must be a positive integer")
+		}
+		if !hasID {
+			verr.add(metaIDField, "id", "_version requires _id")
+		}
+		if cfg.OpenSearchUseDataStream {
+			verr.add(metaVersionField, "version", "data streams do not support external versioning")
+		}
+	}
+	// Conflicts could not be reported once the log is queued
+	if (hasID || hasVersion) && asyncQueue != nil {
+		verr.add(metaIDField, "id", "document metadata is not supported with INGEST_ASYNC")
+	}
+	if hasType {
+		m.VersionType, _ = versionType.(string)
+		if !versionTypes[m.VersionType] {
+			verr.add(metaVersionTypeField, "version_type", "_version_type must be external or external_gte")
+		}
+		if !hasVersion {
+			verr.add(metaVersionTypeField, "version_type", "_version_type requires _version")
+		}
+	} else {
+		m.VersionType = "external"
+	}
+	if len(verr.Violations) > 0 {
+		return docMeta{}, verr
+	}
+	return m, nil
+}
+
+// positiveInt converts a decoded JSON number to an int64 above zero
+func positiveInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil && i > 0
+	case float64:
+		if n != math.Trunc(n) || n < 1 || n >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(n), true
+	}
+	return 0, false
+}
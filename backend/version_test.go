@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestExternalVersionedWrite(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	rec := postJSON("/logs", `{"_id":"order-1","_version":3,"message":"paid"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	writes := fake.writes()
+	if len(writes) != 1 {
+		t.Fatalf("%d writes, want 1", len(writes))
+	}
+	w := writes[0]
+	if w.Method != http.MethodPut || w.Path != "/logs/_doc/order-1" {
+		t.Errorf("wrote with %s %s, want PUT /logs/_doc/order-1", w.Method, w.Path)
+	}
+	if w.Query.Get("version") != "3" || w.Query.Get("version_type") != "external" {
+		t.Errorf("query %v, want version=3&version_type=external", w.Query)
+	}
+	doc := decodeDoc(t, w.Body)
+	for _, field := range []string{"_id", "_version", "_version_type"} {
+		if _, ok := doc[field]; ok {
+			t.Errorf("metadata field %s was stored in the log", field)
+		}
+	}
+}
+
+func TestExternalVersionConflict(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.ndjson")
+	fake := withOpenSearch(t, func(c *Config) { c.DeadLetterPath = path })
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		if c.Method != http.MethodPut {
+			return false
+		}
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error":{"type":"version_conflict_engine_exception"},"status":409}`))
+		return true
+	})
+	conflicts := testutil.ToFloat64(logsDropped.WithLabelValues(dropConflict))
+
+	rec := postJSON("/logs", `{"_id":"order-1","_version":2,"_version_type":"external_gte","message":"stale"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status %d, want 409: %s", rec.Code, rec.Body)
+	}
+	if got := testutil.ToFloat64(logsDropped.WithLabelValues(dropConflict)) - conflicts; got != 1 {
+		t.Errorf("version_conflict drops grew by %v, want 1", got)
+	}
+	// A conflict is the client's answer, not a write to retry
+	if entries := readDeadLetters(t, path); len(entries) != 0 {
+		t.Errorf("conflict was dead-lettered: %+v", entries)
+	}
+}
+
+func TestDocMetaValidated(t *testing.T) {
+	for _, tc := range []struct {
+		name, body string
+	}{
+		{"zero version", `{"_id":"a","_version":0}`},
+		{"negative version", `{"_id":"a","_version":-1}`},
+		{"fractional version", `{"_id":"a","_version":1.5}`},
+		{"string version", `{"_id":"a","_version":"3"}`},
+		{"version without id", `{"_version":3}`},
+		{"empty id", `{"_id":""}`},
+		{"unknown version type", `{"_id":"a","_version":3,"_version_type":"internal"}`},
+		{"version type without version", `{"_id":"a","_version_type":"external"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := withOpenSearch(t, nil)
+			if rec := postJSON("/logs", tc.body); rec.Code != http.StatusUnprocessableEntity {
+				t.Errorf("status %d, want 422: %s", rec.Code, rec.Body)
+			}
+			if len(fake.writes()) != 0 {
+				t.Error("invalid metadata reached OpenSearch")
+			}
+		})
+	}
+}
+
+// bulkActionMeta decodes the action line of each document of a _bulk body
+func bulkActionMeta(t *testing.T, body []byte) []map[string]interface{} {
+	t.Helper()
+	actions, _ := bulkLines(body)
+	metas := make([]map[string]interface{}, len(actions))
+	for i, line := range actions {
+		var action map[string]map[string]interface{}
+		if err := json.Unmarshal(line, &action); err != nil {
+			t.Fatal(err)
+		}
+		metas[i] = action["index"]
+	}
+	return metas
+}
+
+func TestBulkVersionConflict(t *testing.T) {
+	fake := withOpenSearch(t, nil)
+	fake.setRespond(func(w http.ResponseWriter, c osCall) bool {
+		if !strings.HasSuffix(c.Path, "/_bulk") {
+			return false
+		}
+		w.Write([]byte(`{"errors":true,"items":[{"index":{"status":409,"error":{"type":"version_conflict_engine_exception"}}}]}`))
+		return true
+	})
+	rec := postJSON("/logs/bulk", `[{"_id":"a","_version":1}]`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status %d, want 409: %s", rec.Code, rec.Body)
+	}
+	meta := bulkActionMeta(t, fake.writes()[0].Body)[0]
+	if meta["_id"] != "a" || meta["version"] != 1.0 || meta["version_type"] != "external" {
+		t.Errorf("action %v, want _id a at external version 1", meta)
+	}
+}
+
+func TestDocMetaKeptInDeadLetters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.ndjson")
+	fake := withOpenSearch(t, func(c *Config) { c.DeadLetterPath = path })
+	fake.setRespond(rejectBulkItems(func(map[string]interface{}) bool { return true }))
+
+	postJSON("/logs/bulk", `[{"_id":"a","_version":4,"message":"hi"}]`)
+	entries := readDeadLetters(t, path)
+	if len(entries) != 1 {
+		t.Fatalf("%d dead letters, want 1", len(entries))
+	}
+	want := docMeta{ID: "a", Version: 4, VersionType: "external"}
+	if e := entries[0]; e.Meta == nil || *e.Meta != want {
+		t.Errorf("dead letter meta %+v, want %+v", e.Meta, want)
+	}
+	if _, ok := entries[0].Log["_id"]; ok {
+		t.Error("dead letter log still carries _id")
+	}
+}
+
+func TestDocMetaOverWebSocket(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) { c.WSFlushInterval = 20 * time.Millisecond })
+	conn := dialStream(t)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"_id":"a","_version":7,"message":"hi"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if ack, _ := readAcks(t, conn, 0); ack.Indexed != 1 {
+		t.Fatalf("ack %+v, want 1 indexed", ack)
+	}
+	meta := bulkActionMeta(t, fake.writes()[0].Body)[0]
+	if meta["_id"] != "a" || meta["version"] != 7.0 {
+		t.Errorf("action %v, want _id a at version 7", meta)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+)
+
+// writeAheadLog persists accepted logs to an NDJSON file before they are
+// acknowledged, so async ingestion survives a crash. Each flush moves the
+// current file aside and removes it once OpenSearch has the batch.
+type writeAheadLog struct {
+	path string
+	sync bool
+	f    *os.File
+	enc  *json.Encoder
+}
+
+// openWAL opens the log at path and returns the entries a previous run did
+// not flush. Those entries are rewritten into a fresh file so they stay
+// durable until the next flush.
+func openWAL(path string, sync bool) (*writeAheadLog, []map[string]interface{}, error) {
+	var pending []map[string]interface{}
+	for _, p := range []string{path + ".flushing", path} {
+		docs, err := readWALFile(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		pending = append(pending, docs...)
+	}
+
+	w := &writeAheadLog{path: path, sync: sync}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open WAL: %w", err)
+	}
+	w.f, w.enc = f, json.NewEncoder(f)
+	for _, doc := range pending {
+		if err := w.enc.Encode(doc); err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("rewrite WAL: %w", err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("rewrite WAL: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("rewrite WAL: %w", err)
+	}
+	if err := os.Remove(path + ".flushing"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("remove flushed WAL: %w", err)
+	}
+	return w, pending, nil
+}
+
+func readWALFile(path string) ([]map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read WAL: %w", err)
+	}
+	defer f.Close()
+
+	var docs []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var doc map[string]interface{}
+		// A torn last line from a crash mid-write is skipped
+		if err := unmarshalLog(scanner.Bytes(), &doc); err != nil || doc == nil {
+			log.Printf("Skipping unreadable WAL entry in %s", path)
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	return docs, scanner.Err()
+}
+
+// errWALClosed is returned by append once the WAL was closed at shutdown
+var errWALClosed = errors.New("WAL is closed")
+
+// append writes docs to the log, syncing to disk when WAL_FSYNC is set.
+// Callers serialise access.
+func (w *writeAheadLog) append(docs ...map[string]interface{}) error {
+	if w.f == nil {
+		return errWALClosed
+	}
+	for _, doc := range docs {
+		if err := w.enc.Encode(doc); err != nil {
+			return err
+		}
+	}
+	if w.sync {
+		return w.f.Sync()
+	}
+	return nil
+}
+
+// rotate moves the current file aside for a flush and starts a new one.
+// When a file from an earlier flush is still there, because its logs could
+// not be written back, the current file is added to it rather than renamed
+// over it. On failure the current file stays open and in place.
+func (w *writeAheadLog) rotate() error {
+	flushing := w.path + ".flushing"
+	if _, err := os.Stat(flushing); err == nil {
+		return w.mergeInto(flushing)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	// The open handle follows the renamed file, so it can still be
+	// renamed back if the new file cannot be created
+	if err := os.Rename(w.path, flushing); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		if rerr := os.Rename(flushing, w.path); rerr != nil {
+			return errors.Join(err, rerr)
+		}
+		return err
+	}
+	old := w.f
+	w.f, w.enc = f, json.NewEncoder(f)
+	if err := old.Close(); err != nil {
+		log.Printf("Failed to close rotated WAL: %v", err)
+	}
+	return nil
+}
+
+// mergeInto appends the current file to dst and empties it
+func (w *writeAheadLog) mergeInto(dst string) error {
+	src, err := os.Open(w.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	out, err := os.OpenFile(dst, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return w.f.Truncate(0)
+}
+
+// flushed drops the file moved aside by rotate once its logs are indexed
+func (w *writeAheadLog) flushed() error {
+	return os.Remove(w.path + ".flushing")
+}
+
+// close releases the file; later appends fail with errWALClosed
+func (w *writeAheadLog) close() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f, w.enc = nil, nil
+	return err
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// startQueue opens the WAL at path as startup does and installs an async
+// queue replaying what it holds. The returned function simulates a crash by
+// dropping the queue without flushing it.
+func startQueue(t *testing.T, path string) (pending int, crash func()) {
+	t.Helper()
+	wal, docs, err := openWAL(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asyncQueue = newIngestQueue(cfg.AsyncQueueSize, wal, docs)
+	return len(docs), func() {
+		wal.f.Close()
+		asyncQueue = nil
+	}
+}
+
+// indexedMessages returns the message of every log sent to OpenSearch
+func indexedMessages(t *testing.T, fake *fakeOpenSearch) []string {
+	t.Helper()
+	var msgs []string
+	for _, doc := range fake.docs(t) {
+		msgs = append(msgs, doc["message"].(string))
+	}
+	return msgs
+}
+
+func TestWALReplayAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ingest.wal")
+	fake := withOpenSearch(t, func(c *Config) {
+		c.IngestAsync = true
+		c.WALPath = path
+	})
+
+	_, crash := startQueue(t, path)
+	for _, msg := range []string{"one", "two"} {
+		if rec := postJSON("/logs", `{"message":"`+msg+`"}`); rec.Code != http.StatusAccepted {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	crash()
+	if len(fake.writes()) != 0 {
+		t.Fatal("logs indexed before the crash")
+	}
+
+	pending, crash := startQueue(t, path)
+	if pending != 2 {
+		t.Fatalf("%d logs recovered from the WAL, want 2", pending)
+	}
+	asyncQueue.flush(context.Background())
+	if got := indexedMessages(t, fake); !slices.Equal(got, []string{"one", "two"}) {
+		t.Errorf("replayed %v, want [one two]", got)
+	}
+	crash()
+
+	if pending, _ := startQueue(t, path); pending != 0 {
+		t.Errorf("%d logs left in the WAL after a successful flush", pending)
+	}
+}
+
+func TestWALKeepsLogsOpenSearchDidNotTake(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ingest.wal")
+	fake := withOpenSearch(t, func(c *Config) {
+		c.IngestAsync = true
+		c.WALPath = path
+	})
+	down := newFakeOpenSearch(t)
+	down.Close()
+	cfg.OpenSearchURL = down.URL
+
+	_, crash := startQueue(t, path)
+	postJSON("/logs", `{"message":"one"}`)
+	asyncQueue.flush(context.Background())
+	postJSON("/logs", `{"message":"two"}`)
+	asyncQueue.flush(context.Background())
+	crash()
+
+	cfg.OpenSearchURL = fake.URL
+	pending, _ := startQueue(t, path)
+	if pending != 2 {
+		t.Fatalf("%d logs recovered after failed flushes, want 2", pending)
+	}
+	asyncQueue.flush(context.Background())
+	if got := indexedMessages(t, fake); !slices.Equal(got, []string{"one", "two"}) {
+		t.Errorf("replayed %v, want [one two] once each", got)
+	}
+}
+
+func TestWALRotateMergesUnflushedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ingest.wal")
+	w, _, err := openWAL(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.append(map[string]interface{}{"message": "one"})
+	if err := w.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	// The first flush never finished, so its file is still there
+	w.append(map[string]interface{}{"message": "two"})
+	if err := w.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	w.append(map[string]interface{}{"message": "three"})
+	w.close()
+
+	flushing, _ := readWALFile(path + ".flushing")
+	current, _ := readWALFile(path)
+	if len(flushing) != 2 || len(current) != 1 {
+		t.Fatalf("%d logs being flushed and %d current, want 2 and 1", len(flushing), len(current))
+	}
+	_, pending, err := openWAL(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, doc := range pending {
+		got = append(got, doc["message"].(string))
+	}
+	if !slices.Equal(got, []string{"one", "two", "three"}) {
+		t.Errorf("recovered %v, want [one two three]", got)
+	}
+	if _, err := os.Stat(path + ".flushing"); !os.IsNotExist(err) {
+		t.Error("flushing file left after recovery")
+	}
+}
+
+func TestWALSkipsTornEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ingest.wal")
+	content := `{"message":"one"}` + "\n" + `{"message":"tw`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	docs, err := readWALFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0]["message"] != "one" {
+		t.Errorf("read %v, want only the complete entry", docs)
+	}
+}
+
+func TestWALClosedRefusesLogs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ingest.wal")
+	withOpenSearch(t, func(c *Config) {
+		c.IngestAsync = true
+		c.WALPath = path
+	})
+	startQueue(t, path)
+	asyncQueue.closeWAL()
+	if rec := postJSON("/logs", `{"message":"late"}`); rec.Code != http.StatusInternalServerError {
+		t.Errorf("status %d after the WAL closed, want 500", rec.Code)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || allowedOrigin(origin) != ""
+	},
+}
+
+// streamsClosing is closed when the server shuts down so open streams
+// flush what they have buffered and disconnect
+var (
+	streamsClosing = make(chan struct{})
+	closeOnce      sync.Once
+	streamsWG      sync.WaitGroup
+)
+
+// closeStreams tells every open stream to finish; hijacked connections are
+// not covered by http.Server.Shutdown
+func closeStreams() {
+	closeOnce.Do(func() { close(streamsClosing) })
+}
+
+// openStreams and streamBacklog track the buffer depth of open streams
+var openStreams, streamBacklog atomic.Int64
+
+// wsMessage is one log read from a stream, or the reason it was not kept
+type wsMessage struct {
+	doc      bulkDoc
+	err      string
+	filtered bool
+}
+
+// wsAck reports what happened to the logs received since the previous ack
+type wsAck struct {
+	Type string `json:"type"`
+	// Through is the position of the last log covered by this ack
+	Through int `json:"through"`
+	bulkResult
+}
+
+// wsHandler accepts a stream of JSON logs over a WebSocket. Logs are
+// buffered and bulk-indexed in batches, and an ack with counts is sent after
+// every flush. Reads pause while the buffer is full.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer("telyx-backend").Start(r.Context(), "wsHandler")
+	defer span.End()
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+	defer conn.Close()
+	streamsWG.Add(1)
+	defer streamsWG.Done()
+	openStreams.Add(1)
+	defer openStreams.Add(-1)
+	conn.SetReadLimit(cfg.WSMaxMessageBytes)
+	// The client's close is answered by wsFlushLoop once the last ack is out
+	conn.SetCloseHandler(func(int, string) error { return nil })
+
+	msgs := make(chan wsMessage, cfg.WSBufferSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wsFlushLoop(ctx, conn, msgs)
+	}()
+
+	// Unblock the read below when the server shuts down
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-streamsClosing:
+			conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	pos := 0
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		logs, err := decodeBulkBody(bytes.NewReader(data))
+		if errors.Is(err, errTooDeep) {
+			recordDrop(dropValidation, 1)
+			streamBacklog.Add(1)
+			msgs <- wsMessage{doc: bulkDoc{pos: pos}, err: err.Error()}
+			pos++
+			continue
+		}
+		if err != nil || len(logs) == 0 {
+			recordDrop(dropInvalid, 1)
+			streamBacklog.Add(1)
+			msgs <- wsMessage{doc: bulkDoc{pos: pos}, err: "Invalid log format"}
+			pos++
+			continue
+		}
+		for _, logData := range logs {
+			streamBacklog.Add(1)
+			msgs <- wsPrepare(ctx, r, pos, logData)
+			pos++
+		}
+	}
+	close(msgs)
+	<-done
+}
+
+func wsPrepare(ctx context.Context, r *http.Request, pos int, logData map[string]interface{}) wsMessage {
+	if logData == nil {
+		recordDrop(dropInvalid, 1)
+		return wsMessage{doc: bulkDoc{pos: pos}, err: "Invalid log format"}
+	}
+	meta, err := takeDocMeta(logData)
+	if err != nil {
+		recordDrop(dropValidation, 1)
+		errorCapture.captureItem(r, pos, err.Error(), logData)
+		return wsMessage{doc: bulkDoc{pos: pos}, err: err.Error()}
+	}
+	keep, err := prepareLog(ctx, logData)
+	if err != nil {
+		errorCapture.captureItem(r, pos, err.Error(), logData)
+		return wsMessage{doc: bulkDoc{pos: pos}, err: err.Error()}
+	}
+	return wsMessage{doc: bulkDoc{pos: pos, source: logData, meta: meta}, filtered: !keep}
+}
+
+// wsFlushLoop indexes buffered logs once a batch fills up or the flush
+// interval passes, and acks each flush to the client
+func wsFlushLoop(ctx context.Context, conn *websocket.Conn, msgs <-chan wsMessage) {
+	ticker := time.NewTicker(cfg.WSFlushInterval)
+	defer ticker.Stop()
+
+	var (
+		res     bulkResult
+		batch   []bulkDoc
+		through = -1
+	)
+	flush := func() {
+		if through < 0 {
+			return
+		}
+		bulkIndex(ctx, batch, &res)
+		deadLetters.write(res.rejected)
+		ack := wsAck{Type: "ack", Through: through, bulkResult: res}
+		conn.SetWriteDeadline(time.Now().Add(cfg.WSFlushInterval))
+		conn.WriteJSON(ack)
+		res, batch, through = bulkResult{}, batch[:0], -1
+	}
+
+	for {
+		select {
+		case m, ok := <-msgs:
+			if !ok {
+				flush()
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+				return
+			}
+			streamBacklog.Add(-1)
+			through = m.doc.pos
+			switch {
+			case m.err != "":
+				res.fail(m.doc.pos, m.err)
+			case m.filtered:
+				res.Filtered++
+			default:
+				batch = append(batch, m.doc)
+				if len(batch) >= cfg.WSBatchSize {
+					flush()
+				}
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
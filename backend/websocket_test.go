@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// streamURL starts a server running the public handler and returns the URL
+// of its /logs/ws route. httptest.Server.Close does not wait for hijacked
+// connections, so the test waits for every request, WebSocket or not, to
+// leave the handler chain before the config they read is restored.
+func streamURL(t *testing.T) string {
+	t.Helper()
+	var served sync.WaitGroup
+	h := newPublicHandler()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+		defer served.Done()
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(served.Wait)
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/logs/ws"
+}
+
+// openStream dials url with header. A stream that opens is closed with a
+// handshake when the test ends.
+func openStream(t *testing.T, url string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err == nil {
+		t.Cleanup(func() { closeStream(conn) })
+	}
+	return conn, resp, err
+}
+
+// closeStream sends a close frame and reads until the server answers it,
+// which wsHandler does once its last ack is out
+func closeStream(conn *websocket.Conn) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			break
+		}
+	}
+	conn.Close()
+}
+
+// dialStream opens a WebSocket to /logs/ws on a server running the public
+// handler
+func dialStream(t *testing.T) *websocket.Conn {
+	t.Helper()
+	conn, _, err := openStream(t, streamURL(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// readAcks reads acks until one covers position through and sums them up
+func readAcks(t *testing.T, conn *websocket.Conn, through int) (total wsAck, acks int) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	total.Through = -1
+	for total.Through < through {
+		var ack wsAck
+		if err := conn.ReadJSON(&ack); err != nil {
+			t.Fatalf("read ack: %v", err)
+		}
+		if ack.Type != "ack" {
+			t.Fatalf("unexpected message %+v", ack)
+		}
+		acks++
+		total.Through = ack.Through
+		total.Indexed += ack.Indexed
+		total.Filtered += ack.Filtered
+		total.Failed += ack.Failed
+		total.Errors = append(total.Errors, ack.Errors...)
+	}
+	return total, acks
+}
+
+func TestWebSocketStreamIndexedAndAcked(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.WSBatchSize = 2
+		c.WSFlushInterval = 50 * time.Millisecond
+	})
+	conn := dialStream(t)
+
+	for _, msg := range []string{
+		`{"message":"one"}`,
+		`[{"message":"two"},{"message":"three"}]`,
+		`not json`,
+		`{"message":"four"}`,
+	} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	total, acks := readAcks(t, conn, 4)
+	if total.Indexed != 4 || total.Failed != 1 {
+		t.Errorf("acked %d indexed and %d failed, want 4 and 1", total.Indexed, total.Failed)
+	}
+	if len(total.Errors) != 1 || total.Errors[0].Index != 3 {
+		t.Errorf("errors = %+v, want the invalid message at position 3", total.Errors)
+	}
+	if acks < 2 {
+		t.Errorf("%d acks, want one per flush", acks)
+	}
+
+	var got []string
+	for _, doc := range fake.docs(t) {
+		got = append(got, doc["message"].(string))
+	}
+	if strings.Join(got, ",") != "one,two,three,four" {
+		t.Errorf("indexed %v", got)
+	}
+}
+
+func TestWebSocketFlushesOnClose(t *testing.T) {
+	fake := withOpenSearch(t, func(c *Config) {
+		c.WSBatchSize = 100
+		c.WSFlushInterval = time.Hour
+	})
+	conn := dialStream(t)
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"message":"buffered"}`))
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+
+	total, _ := readAcks(t, conn, 0)
+	if total.Indexed != 1 {
+		t.Errorf("acked %d indexed, want the buffered log", total.Indexed)
+	}
+	if docs := fake.docs(t); len(docs) != 1 {
+		t.Errorf("indexed %d logs, want 1", len(docs))
+	}
+}